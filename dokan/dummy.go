@@ -22,6 +22,7 @@ func loadDokanDLL(fullpath string) error { return errNotWindows }
 type FileInfo struct {
 	ptr *struct {
 		DeleteOnClose int
+		ProcessId     uint32
 		DokanOptions  struct {
 			GlobalContext uint64
 		}
@@ -279,6 +279,8 @@ const (
 	ErrNotADirectory = NtStatus(0xC0000103)
 	// ErrFileAlreadyExists - file already exists - fatal.
 	ErrFileAlreadyExists = NtStatus(0xC0000035)
+	// ErrLockNotGranted - a conflicting byte-range lock is held (EAGAIN).
+	ErrLockNotGranted = NtStatus(0xC0000055)
 	// ErrNotSameDevice - MoveFile is denied, please use copy+delete.
 	ErrNotSameDevice = NtStatus(0xC00000D4)
 	// StatusBufferOverflow - buffer space too short for return value.
@@ -0,0 +1,34 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package ioutil
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// syncDir makes sure that changes to the entries of the given
+// directory, like a file that was just renamed into it, are on disk.
+func syncDir(dir string) (err error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open directory %q", dir)
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil && closeErr != nil {
+			err = errors.Wrapf(
+				closeErr, "failed to close directory %q", dir)
+		}
+	}()
+	err = f.Sync()
+	if err != nil {
+		return errors.Wrapf(err, "failed to sync directory %q", dir)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package ioutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	ioutil_base "io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// atomicTempInfix follows the base name of the file being written by
+// WriteFileAtomic in the name of its temporary file, which is then
+// followed by the random digits that TempFile adds.
+const atomicTempInfix = ".tmp"
+
+var atomicTempFileRegexp = regexp.MustCompile(
+	`^.+` + regexp.QuoteMeta(atomicTempInfix) + `[0-9]+$`)
+
+// IsAtomicTempFile returns whether the given base name could be that
+// of a temporary file left behind by WriteFileAtomic or ReplaceFile
+// after a crash.
+func IsAtomicTempFile(name string) bool {
+	return atomicTempFileRegexp.MatchString(name)
+}
+
+// writeAndClose writes data to f, syncing it first if sync is true,
+// and closes it.  f is left open on error.
+func writeAndClose(f *os.File, data []byte, sync bool) error {
+	n, err := f.Write(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write %q", f.Name())
+	} else if n < len(data) {
+		return errors.Wrapf(
+			io.ErrShortWrite, "failed to write %q", f.Name())
+	}
+	if sync {
+		err = f.Sync()
+		if err != nil {
+			return errors.Wrapf(err, "failed to sync %q", f.Name())
+		}
+	}
+	err = f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "failed to close %q", f.Name())
+	}
+	return nil
+}
+
+// WriteFileSync is like WriteFile, except that it syncs the file
+// before closing it, so that data is on disk by the time it returns.
+// The file may still be torn by a crash during the write, so it's
+// meant for files that are only written once, and have a way to tell
+// if they're incomplete, like a checksum.
+func WriteFileSync(filename string, data []byte, perm os.FileMode) (
+	err error) {
+	f, err := OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = writeAndClose(f, data, true)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return nil
+}
+
+// WriteFileAtomic is like WriteFile, except that it writes data to a
+// new temporary file in the same directory as filename, syncs it,
+// renames it to filename, and syncs the directory.  So even after a
+// crash, filename holds either its old contents or all of data, and
+// never a mix of the two.  A crash may leave the temporary file
+// behind, though; IsAtomicTempFile returns true for its name.
+//
+// All of that makes it a lot slower than WriteFile, so it's meant
+// for small files that are overwritten in place.  Files that are
+// only written once are better off with WriteFileSync and a way to
+// tell if they're incomplete, like a checksum.  Files that are
+// overwritten often, and can be rebuilt if they're lost, are better
+// off with ReplaceFile.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return writeFileViaTemp(filename, data, perm, true)
+}
+
+// ReplaceFile is like WriteFileAtomic, except that it doesn't sync
+// the file or the directory.  So it never tears filename in place
+// either, but after a crash, filename may hold its old contents, or,
+// on filesystems that don't write the data of a file before a rename
+// over another file, nothing at all.
+func ReplaceFile(filename string, data []byte, perm os.FileMode) error {
+	return writeFileViaTemp(filename, data, perm, false)
+}
+
+func writeFileViaTemp(filename string, data []byte, perm os.FileMode,
+	sync bool) (err error) {
+	f, err := ioutil_base.TempFile(
+		filepath.Dir(filename), filepath.Base(filename)+atomicTempInfix)
+	if err != nil {
+		return errors.Wrapf(
+			err, "failed to make temp file for %q", filename)
+	}
+	tempName := f.Name()
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(tempName)
+		}
+	}()
+
+	if perm != 0600 {
+		err = f.Chmod(perm)
+		if err != nil {
+			return errors.Wrapf(err, "failed to chmod %q", tempName)
+		}
+	}
+	err = writeAndClose(f, data, sync)
+	if err != nil {
+		return err
+	}
+
+	err = Rename(tempName, filename)
+	if err != nil {
+		return err
+	}
+	if !sync {
+		return nil
+	}
+	return syncDir(filepath.Dir(filename))
+}
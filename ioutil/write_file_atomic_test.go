@@ -0,0 +1,123 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package ioutil
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	ioutil_base "io/ioutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWriteFileViaTemp(t *testing.T,
+	writeFile func(string, []byte, os.FileMode) error) {
+	dir, err := TempDir(os.TempDir(), "write_file_atomic")
+	require.NoError(t, err)
+	defer func() {
+		err := RemoveAll(dir)
+		assert.NoError(t, err)
+	}()
+
+	p := filepath.Join(dir, "file")
+	err = writeFile(p, []byte("old"), 0600)
+	require.NoError(t, err)
+	buf, err := ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte("old"), buf)
+
+	// Overwriting replaces the whole file, even with less data.
+	err = writeFile(p, []byte("x"), 0644)
+	require.NoError(t, err)
+	buf, err = ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte("x"), buf)
+	if runtime.GOOS != "windows" {
+		fi, err := Stat(p)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+	}
+
+	// No temporary files are left behind.
+	fileInfos, err := ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, fileInfos, 1)
+	require.Equal(t, "file", fileInfos[0].Name())
+
+	// Writing into a missing directory fails without leaving
+	// anything behind.
+	err = writeFile(
+		filepath.Join(dir, "missing", "file"), []byte("x"), 0600)
+	require.Error(t, err)
+	fileInfos, err = ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, fileInfos, 1)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	testWriteFileViaTemp(t, WriteFileAtomic)
+}
+
+func TestReplaceFile(t *testing.T) {
+	testWriteFileViaTemp(t, ReplaceFile)
+}
+
+func TestWriteFileSync(t *testing.T) {
+	dir, err := TempDir(os.TempDir(), "write_file_sync")
+	require.NoError(t, err)
+	defer func() {
+		err := RemoveAll(dir)
+		assert.NoError(t, err)
+	}()
+
+	p := filepath.Join(dir, "file")
+	err = WriteFileSync(p, []byte("old"), 0600)
+	require.NoError(t, err)
+	err = WriteFileSync(p, []byte("x"), 0600)
+	require.NoError(t, err)
+	buf, err := ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, []byte("x"), buf)
+
+	err = WriteFileSync(
+		filepath.Join(dir, "missing", "file"), []byte("x"), 0600)
+	require.Error(t, err)
+}
+
+func TestIsAtomicTempFile(t *testing.T) {
+	dir, err := TempDir(os.TempDir(), "write_file_atomic")
+	require.NoError(t, err)
+	defer func() {
+		err := RemoveAll(dir)
+		assert.NoError(t, err)
+	}()
+
+	// Make a temporary file the same way WriteFileAtomic does.
+	f, err := ioutil_base.TempFile(dir, "0000000000000001"+atomicTempInfix)
+	require.NoError(t, err)
+	name := filepath.Base(f.Name())
+	err = f.Close()
+	require.NoError(t, err)
+	require.True(t, IsAtomicTempFile(name), name)
+
+	for _, name := range []string{
+		"0000000000000001",
+		"EARLIEST",
+		".tmp123",
+		"file.tmp",
+		"file.tmp12x",
+		"file.tmp123.bak",
+		"file.tmpl",
+		"data.tmp-1",
+	} {
+		require.False(t, IsAtomicTempFile(name), name)
+	}
+	require.True(t, IsAtomicTempFile("file.tmp123"))
+	require.True(t, IsAtomicTempFile("file.tmp.tmp4"))
+}
@@ -0,0 +1,174 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const cacheUsageStr = `Usage:
+  kbfstool cache [<subcommand>] [<args>]
+
+These commands work on the disk block cache under -storage-root,
+which must not be in use by a running KBFS instance.  Encrypted
+caches can't be inspected this way.
+
+The possible subcommands are:
+  stats		Show how many blocks and bytes each folder has in the cache
+  ls <tlf>	List the cached blocks of the folder with the given TLF ID
+  verify	Check every cached block against its block ID
+  clear <tlf>	Remove the unpinned blocks of the folder with the given TLF ID
+`
+
+func cacheStats(ctx context.Context, cache *libkbfs.DiskBlockCacheStandard,
+	args []string) (exitStatus int) {
+	if len(args) != 0 {
+		fmt.Print(cacheUsageStr)
+		return 1
+	}
+
+	statuses, err := cache.TLFStatuses(ctx)
+	if err != nil {
+		printError("cache stats", err)
+		return 1
+	}
+	tlfIDs := make(map[string]tlf.ID, len(statuses))
+	tlfIDStrs := make([]string, 0, len(statuses))
+	for tlfID := range statuses {
+		tlfIDs[tlfID.String()] = tlfID
+		tlfIDStrs = append(tlfIDStrs, tlfID.String())
+	}
+	sort.Strings(tlfIDStrs)
+
+	numBlocks := 0
+	for _, tlfIDStr := range tlfIDStrs {
+		tlfID := tlfIDs[tlfIDStr]
+		status := statuses[tlfID]
+		numBlocks += status.NumBlocks
+		fmt.Printf("%s\t%d blocks\t%d bytes", tlfID, status.NumBlocks,
+			status.Bytes)
+		if status.LimitBytes > 0 {
+			fmt.Printf("\tlimit %d bytes", status.LimitBytes)
+		}
+		fmt.Printf("\n")
+	}
+	fmt.Printf("Total: %d folders, %d blocks, %d bytes\n",
+		len(tlfIDs), numBlocks, cache.Size())
+	return 0
+}
+
+func cacheLs(ctx context.Context, cache *libkbfs.DiskBlockCacheStandard,
+	args []string) (exitStatus int) {
+	if len(args) != 1 {
+		fmt.Print(cacheUsageStr)
+		return 1
+	}
+
+	tlfID, err := tlf.ParseID(args[0])
+	if err != nil {
+		printError("cache ls", err)
+		return 1
+	}
+	infos, err := cache.ListTLF(ctx, tlfID)
+	if err != nil {
+		printError("cache ls", err)
+		return 1
+	}
+	for _, info := range infos {
+		pinned := ""
+		if info.Pinned {
+			pinned = "\tpinned"
+		}
+		fmt.Printf("%s\t%s\tlast used %s\t%d uses%s\n", info.ID,
+			byteCountStr(int(info.Size)), info.LRUTime, info.Hits, pinned)
+	}
+	return 0
+}
+
+func cacheVerify(ctx context.Context, cache *libkbfs.DiskBlockCacheStandard,
+	args []string) (exitStatus int) {
+	if len(args) != 0 {
+		fmt.Print(cacheUsageStr)
+		return 1
+	}
+
+	numChecked, badIDs, err := cache.Verify(ctx)
+	for _, id := range badIDs {
+		fmt.Printf("%s failed verification\n", id)
+	}
+	if err != nil {
+		printError("cache verify", err)
+		return 1
+	}
+	fmt.Printf("Checked %d blocks; %d failed verification\n",
+		numChecked, len(badIDs))
+	if len(badIDs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func cacheClear(ctx context.Context, cache *libkbfs.DiskBlockCacheStandard,
+	args []string) (exitStatus int) {
+	if len(args) != 1 {
+		fmt.Print(cacheUsageStr)
+		return 1
+	}
+
+	tlfID, err := tlf.ParseID(args[0])
+	if err != nil {
+		printError("cache clear", err)
+		return 1
+	}
+	numRemoved, sizeRemoved, err := cache.ClearTLF(ctx, tlfID)
+	if err != nil {
+		printError("cache clear", err)
+		return 1
+	}
+	fmt.Printf("Removed %d blocks (%s)\n",
+		numRemoved, byteCountStr(int(sizeRemoved)))
+	return 0
+}
+
+func cacheMain(ctx context.Context, storageRoot string, log logger.Logger,
+	args []string) (exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(cacheUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	args = args[1:]
+
+	var subcommand func(context.Context, *libkbfs.DiskBlockCacheStandard,
+		[]string) int
+	switch cmd {
+	case "stats":
+		subcommand = cacheStats
+	case "ls":
+		subcommand = cacheLs
+	case "verify":
+		subcommand = cacheVerify
+	case "clear":
+		subcommand = cacheClear
+	default:
+		printError("cache", fmt.Errorf("unknown command '%s'", cmd))
+		return 1
+	}
+
+	cache, err := libkbfs.OpenDiskBlockCacheOffline(storageRoot, log)
+	if err != nil {
+		printError("cache", err)
+		return 1
+	}
+	defer cache.Shutdown(ctx)
+	return subcommand(ctx, cache, args)
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func cpNode(ctx context.Context, config libkbfs.Config,
+	srcPathStr, destPathStr string, verbose bool) error {
+	srcPath, err := fsrpc.NewPath(srcPathStr)
+	if err != nil {
+		return err
+	}
+	destPath, err := fsrpc.NewPath(destPathStr)
+	if err != nil {
+		return err
+	}
+	if destPath.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("cannot copy to %s", destPath)
+	}
+
+	src, err := srcPath.GetFileNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	// If the destination is an existing directory, copy into it
+	// under the source's name.
+	_, ei, err := destPath.GetNode(ctx, config)
+	switch err.(type) {
+	case nil:
+		if ei.Type != libkbfs.Dir {
+			return libkbfs.NameExistsError{Name: destPathStr}
+		}
+		_, name, err := srcPath.DirAndBasename()
+		if err != nil {
+			return err
+		}
+		destPath, err = destPath.Join(name)
+		if err != nil {
+			return err
+		}
+	case libkbfs.NoSuchNameError:
+	default:
+		return err
+	}
+
+	destDirPath, name, err := destPath.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	destDir, err := destDirPath.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Copying %s to %s\n", srcPath, destPath)
+	}
+
+	_, _, err = config.KBFSOps().CopyFile(ctx, src, destDir, name)
+	return err
+}
+
+const cpUsageStr = `Usage:
+  kbfstool cp [-v] /keybase/[public|private]/path/to/file /keybase/[public|private]/path/to/dest
+
+The copy shares the data blocks of the original file, so both paths
+must be within the same folder.
+
+`
+
+func cp(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs cp", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "Print extra status output.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("cp", err)
+		return 1
+	}
+
+	if flags.NArg() != 2 {
+		fmt.Print(cpUsageStr)
+		printError("cp", errors.New("a source and a destination path must be specified"))
+		return 1
+	}
+
+	err = cpNode(ctx, config, flags.Arg(0), flags.Arg(1), *verbose)
+	if err != nil {
+		printError("cp", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func formatPathChange(change libkbfs.PathChange) string {
+	var details string
+	switch change.Type {
+	case libkbfs.PathAdded:
+		details = fmt.Sprintf("%s (%s)", change.Path, change.EntryType)
+	case libkbfs.PathRenamed:
+		details = fmt.Sprintf("%s -> %s", change.OldPath, change.Path)
+	case libkbfs.PathModified:
+		ranges := make([]string, 0, len(change.Writes))
+		for _, w := range change.Writes {
+			if w.Len == 0 {
+				ranges = append(ranges, fmt.Sprintf("truncate@%d", w.Off))
+			} else {
+				ranges = append(ranges,
+					fmt.Sprintf("[%d,%d)", w.Off, w.Off+w.Len))
+			}
+		}
+		details = fmt.Sprintf("%s %s", change.Path, strings.Join(ranges, " "))
+	case libkbfs.PathAttrChanged:
+		details = fmt.Sprintf("%s (%s)", change.Path, change.Attr)
+	default:
+		details = change.Path
+	}
+	return fmt.Sprintf("%d\t%s\t%s\t%s\t%s", change.Revision,
+		change.Date.Format(time.RFC3339), change.Writer, change.Type,
+		details)
+}
+
+func diffRevisions(ctx context.Context, config libkbfs.Config,
+	tlfPathStr string, fromRev, toRev libkbfs.MetadataRevision,
+	jsonOutput bool) error {
+	p, err := fsrpc.NewPath(tlfPathStr)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%s is not in a TLF", p)
+	}
+
+	n, _, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	folderBranch := n.GetFolderBranch()
+
+	if toRev == libkbfs.MetadataRevisionUninitialized {
+		status, _, err := config.KBFSOps().FolderStatus(ctx, folderBranch)
+		if err != nil {
+			return err
+		}
+		toRev = status.Revision
+	}
+
+	changes, err := config.KBFSOps().DiffRevisions(
+		ctx, folderBranch, fromRev, toRev)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", data)
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Println(formatPathChange(change))
+	}
+	return nil
+}
+
+const diffUsageStr = `Usage:
+  kbfstool diff -from <revision> [-to <revision>] [-json] /keybase/[public|private]/tlf
+
+Prints the changes made by each revision after -from, up to and
+including -to (the latest revision by default).
+
+`
+
+func diff(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs diff", flag.ContinueOnError)
+	from := flags.Int64("from", 0, "The revision to diff from.")
+	to := flags.Int64("to", 0, "The revision to diff to.")
+	jsonOutput := flags.Bool("json", false, "Print the changes as JSON.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("diff", err)
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(diffUsageStr)
+		printError("diff", errExactlyOnePath)
+		return 1
+	}
+
+	fromRev := libkbfs.MetadataRevision(*from)
+	if fromRev < libkbfs.MetadataRevisionInitial {
+		printError("diff", errors.New("a valid -from must be specified"))
+		return 1
+	}
+
+	err = diffRevisions(ctx, config, flags.Arg(0), fromRev,
+		libkbfs.MetadataRevision(*to), *jsonOutput)
+	if err != nil {
+		printError("diff", err)
+		return 1
+	}
+
+	return 0
+}
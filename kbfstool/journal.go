@@ -0,0 +1,141 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const journalUsageStr = `Usage:
+  kbfstool journal [<subcommand>] [<args>]
+
+The export and import commands move the writes in a folder's journal
+that haven't been flushed yet from one device of a user to another,
+e.g. from a machine without network access to one with it.  The other
+commands inspect the journals under -storage-root, and only take TLF
+IDs.  KBFS shouldn't be running while any of them do.
+
+The possible subcommands are:
+  export [-keep] <tlf> <file>
+			Write the unflushed journal entries of a folder to a
+			file, and remove them from this device unless -keep
+			is given
+  import <file>		Add the entries in an exported file to this device's
+			journal, to be flushed the next time KBFS runs
+  ls [<tlf>]		List every block and MD entry in each journal, or in
+			the journal of the given folder
+  show <tlf> <rev>	Decode the ops in the journaled MD with the given revision
+  verify [<tlf>]	Check the blocks, MDs and block references of each
+			journal, or of the journal of the given folder
+
+For export, <tlf> can be a TLF ID, or a path like
+/keybase/private/alice.  Only folders with an empty journal can be
+imported into.  Once the entries have been flushed from the importing
+device, the exporting device must not flush its own copies of them,
+or they will conflict, so only use -keep if the file might not make
+it to the other device.  Encrypted journals can't be inspected, and
+the ops of private folders can't be decoded, since that needs the
+keys of a logged-in device.
+`
+
+func journalExport(ctx context.Context, jServer *libkbfs.JournalServer,
+	config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs journal export", flag.ContinueOnError)
+	keep := flags.Bool("keep", false,
+		"Keep the exported entries in this device's journal.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("journal export", err)
+		return 1
+	}
+	if flags.NArg() != 2 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	tlfID, err := getTlfID(ctx, config, flags.Arg(0))
+	if err != nil {
+		printError("journal export", err)
+		return 1
+	}
+
+	f, err := ioutil.OpenFile(
+		flags.Arg(1), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		printError("journal export", err)
+		return 1
+	}
+	err = jServer.ExportJournal(ctx, tlfID, f, !*keep)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		printError("journal export", err)
+		return 1
+	}
+	fmt.Printf("Exported the journal for %s to %s\n", tlfID, flags.Arg(1))
+	return 0
+}
+
+func journalImport(ctx context.Context, jServer *libkbfs.JournalServer,
+	config libkbfs.Config, args []string) (exitStatus int) {
+	if len(args) != 1 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	f, err := ioutil.OpenFile(args[0], os.O_RDONLY, 0)
+	if err != nil {
+		printError("journal import", err)
+		return 1
+	}
+	defer f.Close()
+
+	tlfID, err := jServer.ImportJournal(
+		ctx, f, libkbfs.TLFJournalBackgroundWorkPaused)
+	if err != nil {
+		printError("journal import", err)
+		return 1
+	}
+	fmt.Printf("Imported the journal for %s from %s\n", tlfID, args[0])
+	return 0
+}
+
+func journalMain(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	args = args[1:]
+
+	var subcommand func(context.Context, *libkbfs.JournalServer,
+		libkbfs.Config, []string) int
+	switch cmd {
+	case "export":
+		subcommand = journalExport
+	case "import":
+		subcommand = journalImport
+	default:
+		printError("journal", fmt.Errorf("unknown command '%s'", cmd))
+		return 1
+	}
+
+	jServer, err := libkbfs.GetJournalServer(config)
+	if err != nil {
+		printError("journal", err)
+		return 1
+	}
+	return subcommand(ctx, jServer, config, args)
+}
@@ -0,0 +1,243 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+func isJournalInspectCommand(cmd string) bool {
+	switch cmd {
+	case "ls", "show", "verify":
+		return true
+	default:
+		return false
+	}
+}
+
+func formatJournalEntryError(errStr string) string {
+	if errStr == "" {
+		return ""
+	}
+	return "\terror: " + errStr
+}
+
+func journalLsOne(ctx context.Context, ji *libkbfs.JournalInspector,
+	info libkbfs.JournalTLFInfo) error {
+	listing, err := ji.List(ctx, info)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Journal for %s (user %s) in %s:\n",
+		info.TlfID, info.UID, info.Dir)
+	fmt.Printf("  %d block entries:\n", len(listing.Blocks))
+	for _, b := range listing.Blocks {
+		ids := make([]string, 0, len(b.IDs))
+		for _, id := range b.IDs {
+			ids = append(ids, id.String())
+		}
+		fmt.Printf("    %d\t%s\t%s", b.Ordinal, b.Op, b.State)
+		switch {
+		case b.Op == "mdRevisionMarker":
+			fmt.Printf("\trev %d", b.Revision)
+		case b.Bytes > 0:
+			fmt.Printf("\t%s", byteCountStr(int(b.Bytes)))
+		}
+		if len(ids) > 0 {
+			fmt.Printf("\t%s", strings.Join(ids, ","))
+		}
+		if b.LocalSquash {
+			fmt.Printf("\tlocal squash")
+		}
+		fmt.Printf("%s\n", formatJournalEntryError(b.Error))
+	}
+	fmt.Printf("  %d MD entries:\n", len(listing.MDs))
+	for _, md := range listing.MDs {
+		fmt.Printf("    rev %d\t%s\t%s", md.Revision, md.ID, md.State)
+		if md.BID != libkbfs.NullBranchID {
+			fmt.Printf(" %s", md.BID)
+		}
+		fmt.Printf("\t%s", byteCountStr(int(md.Bytes)))
+		if !md.Timestamp.IsZero() {
+			fmt.Printf("\t%s", md.Timestamp)
+		}
+		if md.LocalSquash {
+			fmt.Printf("\tlocal squash")
+		}
+		fmt.Printf("%s\n", formatJournalEntryError(md.Error))
+	}
+	return nil
+}
+
+func journalLs(ctx context.Context, ji *libkbfs.JournalInspector,
+	infos []libkbfs.JournalTLFInfo, args []string) (exitStatus int) {
+	if len(args) != 0 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	for _, info := range infos {
+		err := journalLsOne(ctx, ji, info)
+		if err != nil {
+			printError("journal ls", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func journalShow(ctx context.Context, ji *libkbfs.JournalInspector,
+	infos []libkbfs.JournalTLFInfo, args []string) (exitStatus int) {
+	if len(args) != 1 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	rev, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		printError("journal show", err)
+		return 1
+	}
+
+	for _, info := range infos {
+		detail, err := ji.ShowMD(ctx, info, libkbfs.MetadataRevision(rev))
+		if err != nil {
+			printError("journal show", err)
+			return 1
+		}
+		fmt.Printf("Revision %d of %s (user %s):\n",
+			detail.Revision, info.TlfID, info.UID)
+		fmt.Printf("  MD ID: %s\n", detail.ID)
+		if detail.Error != "" {
+			fmt.Printf("  Error: %s\n", detail.Error)
+			return 1
+		}
+		fmt.Printf("  Branch ID: %s\n", detail.BID)
+		fmt.Printf("  PrevRoot: %s\n", detail.PrevRoot)
+		fmt.Printf("  Writer: %s\n", detail.Writer)
+		fmt.Printf("  Put to the journal: %s\n", detail.Timestamp)
+		fmt.Printf("  State: %s\n", detail.State)
+		switch {
+		case detail.Encrypted:
+			fmt.Printf("  The ops are encrypted\n")
+		case detail.ChangesBlock.IsInitialized():
+			fmt.Printf("  The ops are stored in block %s\n",
+				detail.ChangesBlock)
+		default:
+			fmt.Printf("  %d ops:\n", len(detail.Ops))
+			for _, op := range detail.Ops {
+				fmt.Printf("    %s\n", op)
+			}
+		}
+	}
+	return 0
+}
+
+func journalVerify(ctx context.Context, ji *libkbfs.JournalInspector,
+	infos []libkbfs.JournalTLFInfo, args []string) (exitStatus int) {
+	if len(args) != 0 {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	numProblems := 0
+	for _, info := range infos {
+		result, err := ji.Verify(ctx, info)
+		if err != nil {
+			printError("journal verify", err)
+			return 1
+		}
+		for _, p := range result.Problems {
+			fmt.Printf("%s: %s\n", info.TlfID, p)
+		}
+		fmt.Printf("%s: checked %d blocks, %d MDs and %d block references; "+
+			"%d problems", info.TlfID, result.BlocksChecked,
+			result.MDsChecked, result.RefsChecked, len(result.Problems))
+		if result.EncryptedMDs > 0 {
+			fmt.Printf(" (the block references of %d encrypted MDs "+
+				"weren't checked)", result.EncryptedMDs)
+		}
+		fmt.Printf("\n")
+		numProblems += len(result.Problems)
+	}
+	if numProblems > 0 {
+		return 1
+	}
+	return 0
+}
+
+func journalInspectMain(ctx context.Context, storageRoot string,
+	log logger.Logger, args []string) (exitStatus int) {
+	cmd := args[0]
+	args = args[1:]
+
+	var subcommand func(context.Context, *libkbfs.JournalInspector,
+		[]libkbfs.JournalTLFInfo, []string) int
+	// Whether the first argument has to be a TLF ID, or just may
+	// be one.
+	needsTlfID := false
+	switch cmd {
+	case "ls":
+		subcommand = journalLs
+	case "show":
+		subcommand = journalShow
+		needsTlfID = true
+	case "verify":
+		subcommand = journalVerify
+	default:
+		printError("journal", fmt.Errorf("unknown command '%s'", cmd))
+		return 1
+	}
+
+	var tlfID tlf.ID
+	if len(args) > 0 {
+		var err error
+		tlfID, err = tlf.ParseID(args[0])
+		if err != nil && needsTlfID {
+			printError("journal "+cmd, err)
+			return 1
+		} else if err == nil {
+			args = args[1:]
+		}
+	} else if needsTlfID {
+		fmt.Print(journalUsageStr)
+		return 1
+	}
+
+	ji, err := libkbfs.OpenJournalsOffline(storageRoot, log)
+	if err != nil {
+		printError("journal "+cmd, err)
+		return 1
+	}
+	allInfos, unreadable, err := ji.TLFs(ctx)
+	if err != nil {
+		printError("journal "+cmd, err)
+		return 1
+	}
+	for _, dir := range unreadable {
+		fmt.Printf("Skipping %s, whose info can't be read; it may be "+
+			"encrypted\n", dir)
+	}
+
+	var infos []libkbfs.JournalTLFInfo
+	for _, info := range allInfos {
+		if tlfID == (tlf.ID{}) || info.TlfID == tlfID {
+			infos = append(infos, info)
+		}
+	}
+	if tlfID != (tlf.ID{}) && len(infos) == 0 {
+		printError("journal "+cmd,
+			fmt.Errorf("no journal found for %s", tlfID))
+		return 1
+	}
+	return subcommand(ctx, ji, infos, args)
+}
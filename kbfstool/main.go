@@ -40,7 +40,13 @@ The possible commands are:
   mkdir		Make directories
   read		Dump file to stdout
   write		Write stdin to file
+  cp		Copy a file within a folder without copying its data
+  revert	Revert a file or directory to an older revision
+  undelete	Restore a recently-removed file or directory
+  diff		List the changes made between two revisions of a folder
   md            Operate on metadata objects
+  cache		Inspect the disk block cache of a stopped KBFS instance
+  journal	Move unflushed journal entries between devices, or inspect them
 
 `
 
@@ -71,6 +77,20 @@ func realMain() (exitStatus int) {
 
 	log := logger.NewWithCallDepth("", 1)
 
+	// The cache commands work on a disk block cache that no running
+	// KBFS instance is using, so they don't need the rest of KBFS.
+	if flag.Arg(0) == "cache" {
+		return cacheMain(context.Background(), kbfsParams.StorageRoot, log,
+			flag.Args()[1:])
+	}
+
+	// The journal inspection commands read the journals directly,
+	// so they also don't need the rest of KBFS.
+	if flag.Arg(0) == "journal" && isJournalInspectCommand(flag.Arg(1)) {
+		return journalInspectMain(context.Background(),
+			kbfsParams.StorageRoot, log, flag.Args()[1:])
+	}
+
 	// Pause journal background work, since it may interfere with
 	// an existing kbfs daemon instance.
 	kbfsParams.TLFJournalBackgroundWorkStatus =
@@ -105,8 +125,18 @@ func realMain() (exitStatus int) {
 		return read(ctx, config, args)
 	case "write":
 		return write(ctx, config, args)
+	case "cp":
+		return cp(ctx, config, args)
+	case "revert":
+		return revert(ctx, config, args)
+	case "undelete":
+		return undelete(ctx, config, args)
+	case "diff":
+		return diff(ctx, config, args)
 	case "md":
 		return mdMain(ctx, config, args)
+	case "journal":
+		return journalMain(ctx, config, args)
 	default:
 		printError("kbfs", fmt.Errorf("unknown command '%s'", cmd))
 		return 1
@@ -0,0 +1,74 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func revertNode(ctx context.Context, config libkbfs.Config,
+	nodePathStr string, rev libkbfs.MetadataRevision, verbose bool) error {
+	p, err := fsrpc.NewPath(nodePathStr)
+	if err != nil {
+		return err
+	}
+
+	n, _, err := p.GetNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		return fmt.Errorf("cannot revert %s", p)
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Reverting %s to revision %d\n", p, rev)
+	}
+
+	return config.KBFSOps().RevertToRevision(ctx, n, rev)
+}
+
+const revertUsageStr = `Usage:
+  kbfstool revert -rev <revision> [-v] /keybase/[public|private]/path/to/file
+
+`
+
+func revert(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs revert", flag.ContinueOnError)
+	revision := flags.Int64("rev", 0, "The revision to revert to.")
+	verbose := flags.Bool("v", false, "Print extra status output.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("revert", err)
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(revertUsageStr)
+		printError("revert", errExactlyOnePath)
+		return 1
+	}
+
+	rev := libkbfs.MetadataRevision(*revision)
+	if rev < libkbfs.MetadataRevisionInitial {
+		printError("revert", errors.New("a valid -rev must be specified"))
+		return 1
+	}
+
+	err = revertNode(ctx, config, flags.Arg(0), rev, *verbose)
+	if err != nil {
+		printError("revert", err)
+		return 1
+	}
+
+	return 0
+}
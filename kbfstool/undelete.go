@@ -0,0 +1,96 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func undeleteNode(ctx context.Context, config libkbfs.Config,
+	nodePathStr string, rev libkbfs.MetadataRevision, verbose bool) error {
+	p, err := fsrpc.NewPath(nodePathStr)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) == 0 {
+		return fmt.Errorf("cannot undelete %s", p)
+	}
+
+	dirPath, name, err := p.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	dir, err := dirPath.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	if rev == libkbfs.MetadataRevisionUninitialized {
+		// Find the most recent removal of the entry.
+		entries, err := config.KBFSOps().GetDeletedEntries(
+			ctx, dir.GetFolderBranch())
+		if err != nil {
+			return err
+		}
+		entryPath := strings.Join(p.TLFComponents, "/")
+		for _, entry := range entries {
+			if entry.Path == entryPath {
+				rev = entry.Revision
+				break
+			}
+		}
+		if rev == libkbfs.MetadataRevisionUninitialized {
+			return fmt.Errorf("no recently-deleted entry found for %s", p)
+		}
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Undeleting %s removed at revision %d\n",
+			p, rev)
+	}
+
+	return config.KBFSOps().Undelete(ctx, dir, name, rev)
+}
+
+const undeleteUsageStr = `Usage:
+  kbfstool undelete [-rev <revision>] [-v] /keybase/[public|private]/path/to/file
+
+If -rev isn't given, the most recent removal of the file is undone.
+
+`
+
+func undelete(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs undelete", flag.ContinueOnError)
+	revision := flags.Int64("rev", 0, "The revision that removed the file.")
+	verbose := flags.Bool("v", false, "Print extra status output.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("undelete", err)
+		return 1
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Print(undeleteUsageStr)
+		printError("undelete", errExactlyOnePath)
+		return 1
+	}
+
+	err = undeleteNode(ctx, config, flags.Arg(0),
+		libkbfs.MetadataRevision(*revision), *verbose)
+	if err != nil {
+		printError("undelete", err)
+		return 1
+	}
+
+	return 0
+}
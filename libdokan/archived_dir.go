@@ -0,0 +1,94 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// ArchivedDir is a virtual directory within a TLF whose entries are
+// read-only views of past revisions of that TLF.  The entries can't
+// be listed, but they can be opened by name; see
+// libfs.ArchivedRevisionFromName for the valid names.
+type ArchivedDir struct {
+	folder *Folder
+	emptyFile
+}
+
+// GetFileInformation for dokan.
+func (*ArchivedDir) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (st *dokan.Stat, err error) {
+	return defaultDirectoryInformation()
+}
+
+// open tries to open a file.
+func (ad *ArchivedDir) open(ctx context.Context, oc *openContext, path []string) (dokan.File, bool, error) {
+	if len(path) == 0 {
+		return oc.returnDirNoCleanup(ad)
+	}
+
+	ad.folder.handleMu.RLock()
+	h := ad.folder.h
+	ad.folder.handleMu.RUnlock()
+
+	rootNode, _, err := libfs.GetArchivedRootNode(
+		ctx, ad.folder.fs.config, h, path[0])
+	if err != nil {
+		return nil, false, err
+	}
+
+	dir, err := ad.rootDir(rootNode, path[0])
+	if err != nil {
+		return nil, false, err
+	}
+	return dir.open(ctx, oc, path[1:])
+}
+
+// rootDir returns the Dir for the given archived root node, reusing
+// the cached archived folder for its revision if that root is still
+// open.  The two folders' locks are never held at the same time,
+// since forgetNode takes them in the opposite order.
+func (ad *ArchivedDir) rootDir(rootNode libkbfs.Node, name string) (
+	*Dir, error) {
+	fb := rootNode.GetFolderBranch()
+	ad.folder.archivedMu.Lock()
+	folder := ad.folder.archivedFolders[fb]
+	ad.folder.archivedMu.Unlock()
+	if folder != nil {
+		folder.mu.Lock()
+		child, ok := folder.nodes[rootNode.GetID()]
+		folder.mu.Unlock()
+		if ok {
+			return child.(*Dir), nil
+		}
+		// Otherwise the cached folder is being forgotten, or
+		// belongs to a revision view that libkbfs has since shut
+		// down, so replace it.
+	}
+
+	folder = newArchivedFolder(ad.folder)
+	err := folder.setFolderBranch(fb)
+	if err != nil {
+		return nil, err
+	}
+	dir := newDir(folder, rootNode, name, nil)
+	folder.lockedAddNode(rootNode, dir)
+
+	ad.folder.archivedMu.Lock()
+	defer ad.folder.archivedMu.Unlock()
+	if ad.folder.archivedFolders == nil {
+		ad.folder.archivedFolders = make(map[libkbfs.FolderBranch]*Folder)
+	}
+	ad.folder.archivedFolders[fb] = folder
+	return dir, nil
+}
+
+// FindFiles does readdir for dokan.  There are too many possible
+// revisions to list, so the directory always appears empty.
+func (*ArchivedDir) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string, callback func(*dokan.NamedStat) error) (err error) {
+	return nil
+}
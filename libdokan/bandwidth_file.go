@@ -0,0 +1,66 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// BandwidthFile is a special file that reports the limits on the
+// background traffic of a TLF.  Writing commands to it changes the
+// limits, the journal flush schedule, or metered mode.
+type BandwidthFile struct {
+	SpecialReadFile
+	folder *Folder
+}
+
+// NewBandwidthFile returns a BandwidthFile for the given TLF.
+func NewBandwidthFile(folder *Folder) *BandwidthFile {
+	return &BandwidthFile{
+		SpecialReadFile: SpecialReadFile{
+			read: func(ctx context.Context) ([]byte, time.Time, error) {
+				return libfs.GetEncodedBandwidthStatus(
+					ctx, folder.fs.config, folder.getFolderBranch())
+			},
+			fs: folder.fs,
+		},
+		folder: folder,
+	}
+}
+
+// GetFileInformation does stats for dokan.
+func (f *BandwidthFile) GetFileInformation(ctx context.Context,
+	fi *dokan.FileInfo) (*dokan.Stat, error) {
+	a, err := f.SpecialReadFile.GetFileInformation(ctx, fi)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike other special read files, this one can be written.
+	a.FileAttributes &^= dokan.FileAttributeReadonly
+	return a, nil
+}
+
+// WriteFile implements writes for dokan.
+func (f *BandwidthFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "BandwidthFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	err = libfs.ExecuteBandwidthCommand(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch(), bs)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
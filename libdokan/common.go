@@ -69,6 +69,8 @@ func errToDokan(err error) error {
 		return dokan.ErrObjectNameNotFound
 	case libkbfs.MDServerErrorUnauthorized:
 		return dokan.ErrAccessDenied
+	case libkbfs.FileLockConflictError:
+		return dokan.ErrLockNotGranted
 	case nil:
 		return nil
 	}
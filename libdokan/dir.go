@@ -50,6 +50,22 @@ type Folder struct {
 	// noForget is turned on when the folder may not be forgotten
 	// because it has attached special file state with it.
 	noForget bool
+
+	// archived is true if this folder is a read-only view of a past
+	// revision of a TLF, reached via libfs.ArchivedDirName.  Such
+	// folders aren't tracked by the FolderList.
+	archived bool
+	// archivedParent is the folder whose ArchivedDir this archived
+	// folder was reached through.
+	archivedParent *Folder
+
+	// Protects archivedFolders.
+	archivedMu sync.Mutex
+	// archivedFolders caches the archived folders reached through
+	// this folder's ArchivedDir, so that looking up the same
+	// revision again reuses the same nodes.  An entry is dropped
+	// once all of its folder's nodes are forgotten.
+	archivedFolders map[libkbfs.FolderBranch]*Folder
 }
 
 func newFolder(fl *FolderList, h *libkbfs.TlfHandle,
@@ -64,6 +80,29 @@ func newFolder(fl *FolderList, h *libkbfs.TlfHandle,
 	return f
 }
 
+// newArchivedFolder returns a new Folder for a read-only view of a
+// past revision of the TLF represented by parent.
+func newArchivedFolder(parent *Folder) *Folder {
+	parent.handleMu.RLock()
+	defer parent.handleMu.RUnlock()
+	f := newFolder(parent.list, parent.h, parent.hPreferredName)
+	f.archived = true
+	f.archivedParent = parent
+	return f
+}
+
+// forgetArchivedFolder drops the given archived folder from the
+// cache, unless it has already been replaced.
+func (f *Folder) forgetArchivedFolder(archived *Folder) {
+	f.archivedMu.Lock()
+	defer f.archivedMu.Unlock()
+	for fb, cached := range f.archivedFolders {
+		if cached == archived {
+			delete(f.archivedFolders, fb)
+		}
+	}
+}
+
 func (f *Folder) name() libkbfs.CanonicalTlfName {
 	f.handleMu.RLock()
 	defer f.handleMu.RUnlock()
@@ -114,7 +153,11 @@ func (f *Folder) forgetNode(ctx context.Context, node libkbfs.Node) {
 	delete(f.nodes, node.GetID())
 	if len(f.nodes) == 0 && !f.noForget {
 		f.unsetFolderBranch(ctx)
-		f.list.forgetFolder(string(f.name()))
+		if f.archived {
+			f.archivedParent.forgetArchivedFolder(f)
+		} else {
+			f.list.forgetFolder(string(f.name()))
+		}
 	}
 }
 
@@ -181,7 +224,7 @@ func (f *Folder) TlfHandleChange(ctx context.Context,
 			return oldName, f.hPreferredName
 		}()
 
-		if oldName != newName {
+		if oldName != newName && !f.archived {
 			f.list.updateTlfName(ctx, string(oldName), string(newName))
 		}
 	})
@@ -288,8 +331,22 @@ func (d *Dir) open(ctx context.Context, oc *openContext, path []string) (dokan.F
 			path[0] = hit
 		}
 
+		if path[0] == libfs.ArchivedDirName {
+			ad := &ArchivedDir{folder: d.folder}
+			return ad.open(ctx, oc, path[1:])
+		}
+
 		leaf := len(path) == 1
 
+		// The sync control files act on the directory they're in.
+		if leaf && (path[0] == libfs.EnableSyncFileName ||
+			path[0] == libfs.DisableSyncFileName) {
+			return oc.returnFileNoCleanup(&SyncControlFile{
+				dir:    d,
+				enable: path[0] == libfs.EnableSyncFileName,
+			})
+		}
+
 		// Check if this is a per-file metainformation file, if so
 		// return the corresponding SpecialReadFile.
 		if leaf && strings.HasPrefix(path[0], libfs.FileInfoPrefix) {
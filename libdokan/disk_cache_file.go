@@ -0,0 +1,68 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// DiskCacheFile is a special file that reports how much of the disk
+// block cache a TLF is using.  Writing "clear" to it evicts the TLF's
+// unpinned blocks from the cache, writing "limit N" limits the TLF to
+// N bytes of the cache, and writing "policy P" picks the TLF's
+// eviction policy.
+type DiskCacheFile struct {
+	SpecialReadFile
+	folder *Folder
+}
+
+// NewDiskCacheFile returns a DiskCacheFile for the given TLF.
+func NewDiskCacheFile(folder *Folder) *DiskCacheFile {
+	return &DiskCacheFile{
+		SpecialReadFile: SpecialReadFile{
+			read: func(ctx context.Context) ([]byte, time.Time, error) {
+				return libfs.GetEncodedDiskCacheStatus(
+					ctx, folder.fs.config, folder.getFolderBranch())
+			},
+			fs: folder.fs,
+		},
+		folder: folder,
+	}
+}
+
+// GetFileInformation does stats for dokan.
+func (f *DiskCacheFile) GetFileInformation(ctx context.Context,
+	fi *dokan.FileInfo) (*dokan.Stat, error) {
+	a, err := f.SpecialReadFile.GetFileInformation(ctx, fi)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike other special read files, this one can be written.
+	a.FileAttributes &^= dokan.FileAttributeReadonly
+	return a, nil
+}
+
+// WriteFile implements writes for dokan.
+func (f *DiskCacheFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "DiskCacheFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	err = libfs.ExecuteDiskCacheCommand(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch(), bs)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
@@ -65,6 +65,17 @@ func (f *File) Cleanup(ctx context.Context, fi *dokan.FileInfo) {
 		err = f.folder.fs.config.KBFSOps().RemoveEntry(ctx, f.parent, f.name)
 	}
 
+	if fi != nil {
+		// Like closing a handle on Windows, cleanup releases the
+		// byte-range locks the process holds on the file.
+		unlockErr := f.folder.fs.config.KBFSOps().UnlockFile(ctx, f.node,
+			libkbfs.FileLock{Owner: uint64(fi.ProcessID())})
+		if unlockErr != nil {
+			f.folder.fs.log.CDebugf(ctx, "Couldn't unlock file: %v",
+				unlockErr)
+		}
+	}
+
 	if f.refcount.Decrease() {
 		f.folder.fs.log.CDebugf(ctx, "Forgetting file node")
 		f.folder.forgetNode(ctx, f.node)
@@ -118,6 +129,43 @@ func (f *File) SetEndOfFile(ctx context.Context, fi *dokan.FileInfo, length int6
 	return f.folder.fs.config.KBFSOps().Truncate(ctx, f.node, uint64(length))
 }
 
+// LockFile for dokan takes an exclusive byte-range lock on behalf of
+// the requesting process.
+func (f *File) LockFile(ctx context.Context, fi *dokan.FileInfo, offset int64, length int64) (err error) {
+	f.folder.fs.logEnter(ctx, "File LockFile")
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	if length == 0 {
+		// A zero-length lock on Windows covers no bytes, and so
+		// can't conflict with anything.
+		return nil
+	}
+
+	err = f.folder.fs.config.KBFSOps().LockFile(ctx, f.node, libkbfs.FileLock{
+		Type:   libkbfs.FileLockWrite,
+		Owner:  uint64(fi.ProcessID()),
+		Start:  offset,
+		Length: length,
+	})
+	return errToDokan(err)
+}
+
+// UnlockFile for dokan releases a byte-range lock taken by LockFile.
+func (f *File) UnlockFile(ctx context.Context, fi *dokan.FileInfo, offset int64, length int64) (err error) {
+	f.folder.fs.logEnter(ctx, "File UnlockFile")
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	if length == 0 {
+		return nil
+	}
+
+	return f.folder.fs.config.KBFSOps().UnlockFile(ctx, f.node, libkbfs.FileLock{
+		Owner:  uint64(fi.ProcessID()),
+		Start:  offset,
+		Length: length,
+	})
+}
+
 // SetAllocationSize for dokan (f)truncates but does not grow
 // file size (it may fallocate, but that is not done at the
 // moment).
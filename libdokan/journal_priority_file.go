@@ -0,0 +1,67 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// JournalPriorityFile is a special file that reports the flush
+// priority of a TLF's journal, and where it is in the queue of
+// journals waiting to flush.  Writing "low", "normal" or "high" to
+// it changes the priority.
+type JournalPriorityFile struct {
+	SpecialReadFile
+	folder *Folder
+}
+
+// NewJournalPriorityFile returns a JournalPriorityFile for the given TLF.
+func NewJournalPriorityFile(folder *Folder) *JournalPriorityFile {
+	return &JournalPriorityFile{
+		SpecialReadFile: SpecialReadFile{
+			read: func(ctx context.Context) ([]byte, time.Time, error) {
+				return libfs.GetEncodedJournalPriorityStatus(
+					ctx, folder.fs.config, folder.getFolderBranch())
+			},
+			fs: folder.fs,
+		},
+		folder: folder,
+	}
+}
+
+// GetFileInformation does stats for dokan.
+func (f *JournalPriorityFile) GetFileInformation(ctx context.Context,
+	fi *dokan.FileInfo) (*dokan.Stat, error) {
+	a, err := f.SpecialReadFile.GetFileInformation(ctx, fi)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike other special read files, this one can be written.
+	a.FileAttributes &^= dokan.FileAttributeReadonly
+	return a, nil
+}
+
+// WriteFile implements writes for dokan.
+func (f *JournalPriorityFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.folder.fs.logEnter(ctx, "JournalPriorityFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	err = libfs.SetJournalPriority(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch(), bs)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
@@ -2349,6 +2349,73 @@ func TestStatusFile(t *testing.T) {
 
 }
 
+func TestArchivedDir(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, fs, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+
+	myfile := filepath.Join(mnt.Dir, PrivateName, "jdoe", "myfile")
+	if err := ioutil.WriteFile(myfile, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncFolderToServer(t, "jdoe", fs)
+	jdoe := libkbfs.GetRootNodeOrBust(ctx, t, config, "jdoe", false)
+	status, _, err := config.KBFSOps().FolderStatus(ctx, jdoe.GetFolderBranch())
+	if err != nil {
+		t.Fatalf("Couldn't get KBFS status: %v", err)
+	}
+	oldRev := status.Revision
+	if err := ioutil.WriteFile(myfile, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncFolderToServer(t, "jdoe", fs)
+
+	archived := filepath.Join(mnt.Dir, PrivateName, "jdoe", libfs.ArchivedDirName)
+	oldDir := filepath.Join(archived, fmt.Sprintf("%s%d", libfs.ArchivedRevDirPrefix, oldRev))
+	buf, err := ioutil.ReadFile(filepath.Join(oldDir, "myfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(buf), "old"; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+
+	// A time names the latest revision written by then.  Windows
+	// file names can't have colons, so use a compact format.
+	nowDir := filepath.Join(archived,
+		libfs.ArchivedTimeDirPrefix+
+			time.Now().Add(time.Minute).Format("20060102T150405"))
+	buf, err = ioutil.ReadFile(filepath.Join(nowDir, "myfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(buf), "new"; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+
+	// Revisions past the head, times from before the folder
+	// existed, and invalid names don't exist.
+	for _, name := range []string{
+		fmt.Sprintf("%s%d", libfs.ArchivedRevDirPrefix, oldRev+100),
+		libfs.ArchivedTimeDirPrefix + "20000101T0000",
+		"bogus",
+	} {
+		if _, err := ioutil.Stat(filepath.Join(archived, name)); !ioutil.IsNotExist(err) {
+			t.Errorf("Expected ENOENT for %s, but got: %v", name, err)
+		}
+	}
+
+	// Archived revisions are read-only.
+	err = ioutil.WriteFile(filepath.Join(oldDir, "myfile"), []byte("bad"), 0644)
+	if err == nil {
+		t.Fatal("Write to an archived revision succeeded")
+	}
+}
+
 // TODO: remove once we have automatic conflict resolution tests
 func TestUnstageFile(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
@@ -22,6 +22,21 @@ func handleTLFSpecialFile(name string, folder *Folder) dokan.File {
 	case libfs.EditHistoryName:
 		return NewTlfEditHistoryFile(folder)
 
+	case libfs.DeletedEntriesFileName:
+		return NewDeletedEntriesFile(folder)
+
+	case libfs.SyncStatusFileName:
+		return NewSyncStatusFile(folder)
+
+	case libfs.DiskCacheFileName:
+		return NewDiskCacheFile(folder)
+
+	case libfs.BandwidthFileName:
+		return NewBandwidthFile(folder)
+
+	case libfs.JournalPriorityFileName:
+		return NewJournalPriorityFile(folder)
+
 	case libfs.UnstageFileName:
 		return &UnstageFile{
 			folder: folder,
@@ -65,6 +80,12 @@ func handleTLFSpecialFile(name string, folder *Folder) dokan.File {
 			action: libfs.JournalFlush,
 		}
 
+	case libfs.SquashJournalFileName:
+		return &JournalControlFile{
+			folder: folder,
+			action: libfs.JournalSquash,
+		}
+
 	case libfs.PauseJournalBackgroundWorkFileName:
 		return &JournalControlFile{
 			folder: folder,
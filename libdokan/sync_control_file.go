@@ -0,0 +1,40 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SyncControlFile is a special file used to start or stop keeping a
+// directory synced for offline use.
+type SyncControlFile struct {
+	specialWriteFile
+	dir    *Dir
+	enable bool
+}
+
+// WriteFile implements writes for dokan.
+func (f *SyncControlFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	folder := f.dir.folder
+	folder.fs.logEnter(ctx,
+		fmt.Sprintf("SyncControlFile (enable: %t) Write", f.enable))
+	defer func() { folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(bs) == 0 {
+		return 0, nil
+	}
+
+	err = folder.fs.config.KBFSOps().SetKeepSynced(ctx, f.dir.node, f.enable)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bs), nil
+}
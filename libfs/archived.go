@@ -0,0 +1,108 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// archivedTimeLayouts are the time formats accepted after
+// ArchivedTimeDirPrefix.  Times without a zone are interpreted in
+// the local time zone.  The compact layouts avoid colons, which
+// aren't allowed in Windows file names.
+var archivedTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"20060102T150405Z0700",
+	"20060102T1504Z0700",
+	"20060102T150405",
+	"20060102T1504",
+	"2006-01-02",
+}
+
+// parseArchivedTime parses the given string using the first of
+// archivedTimeLayouts that matches.
+func parseArchivedTime(s string) (time.Time, bool) {
+	for _, layout := range archivedTimeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.Local)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ArchivedRevisionFromName returns the MD revision named by the given
+// entry of an ArchivedDirName directory, for the TLF with the given
+// handle.  The name must either be ArchivedRevDirPrefix followed by a
+// revision number, or ArchivedTimeDirPrefix followed by a time, in
+// which case the latest revision written at or before that time is
+// returned.  If the name isn't valid, it returns a
+// libkbfs.NoSuchNameError.
+func ArchivedRevisionFromName(ctx context.Context, config libkbfs.Config,
+	h *libkbfs.TlfHandle, name string) (libkbfs.MetadataRevision, error) {
+	switch {
+	case strings.HasPrefix(name, ArchivedRevDirPrefix):
+		i, err := strconv.ParseInt(
+			strings.TrimPrefix(name, ArchivedRevDirPrefix), 10, 64)
+		if err != nil ||
+			libkbfs.MetadataRevision(i) < libkbfs.MetadataRevisionInitial {
+			return libkbfs.MetadataRevisionUninitialized,
+				libkbfs.NoSuchNameError{Name: name}
+		}
+		return libkbfs.MetadataRevision(i), nil
+	case strings.HasPrefix(name, ArchivedTimeDirPrefix):
+		t, ok := parseArchivedTime(
+			strings.TrimPrefix(name, ArchivedTimeDirPrefix))
+		if !ok {
+			return libkbfs.MetadataRevisionUninitialized,
+				libkbfs.NoSuchNameError{Name: name}
+		}
+		return libkbfs.GetMDRevisionByTime(ctx, config, h, t)
+	default:
+		return libkbfs.MetadataRevisionUninitialized,
+			libkbfs.NoSuchNameError{Name: name}
+	}
+}
+
+// GetArchivedRootNode returns the root node of the read-only view of
+// the given TLF named by the given entry of an ArchivedDirName
+// directory.  See ArchivedRevisionFromName for valid names.
+func GetArchivedRootNode(ctx context.Context, config libkbfs.Config,
+	h *libkbfs.TlfHandle, name string) (
+	libkbfs.Node, libkbfs.EntryInfo, error) {
+	rev, err := ArchivedRevisionFromName(ctx, config, h, name)
+	if err != nil {
+		return nil, libkbfs.EntryInfo{}, archivedNameError(name, err)
+	}
+	node, ei, err := config.KBFSOps().GetRootNodeAtRevision(ctx, h, rev)
+	if err != nil {
+		return nil, libkbfs.EntryInfo{}, archivedNameError(name, err)
+	}
+	return node, ei, nil
+}
+
+// archivedNameError turns errors that mean the given archived name
+// doesn't refer to an existing revision into a
+// libkbfs.NoSuchNameError, so that looking up a revision past the
+// head, or a time before the TLF was created, fails with ENOENT
+// rather than EIO.
+func archivedNameError(name string, err error) error {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchMDError, libkbfs.NoMDRevisionBeforeTimeError,
+		libkbfs.NoMergedMDError:
+		return libkbfs.NoSuchNameError{Name: name}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedBandwidthStatus returns serialized JSON describing the
+// bandwidth limits that apply to a folder, the journal flush schedule,
+// and whether the connection is metered.
+func GetEncodedBandwidthStatus(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	status := config.BandwidthLimiter().Status(folderBranch.Tlf)
+	data, err = PrettyJSON(status)
+	return data, time.Time{}, err
+}
+
+func parseBandwidthLimit(s string) (int64, error) {
+	limit, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("Invalid bandwidth limit %q", s)
+	}
+	return limit, nil
+}
+
+// ExecuteBandwidthCommand carries out a command written to a folder's
+// bandwidth file.  Limits are in bytes per second, and 0 means no
+// limit.  The commands are:
+//
+//	upload N          limits all journal flushes
+//	download N        limits all prefetches
+//	tlf-upload N      limits this folder's journal flushes
+//	tlf-download N    limits this folder's prefetches
+//	schedule W,...    only flushes journals in the background during
+//	                  the given daily windows, like 22:00-06:00;
+//	                  "always" removes the schedule
+//	metered on|off    stops, or restarts, background journal flushes
+func ExecuteBandwidthCommand(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch, command []byte) error {
+	bandwidth := config.BandwidthLimiter()
+	fields := strings.Fields(string(command))
+	if len(fields) != 2 {
+		return fmt.Errorf("Unknown bandwidth command %q",
+			strings.TrimSpace(string(command)))
+	}
+	switch fields[0] {
+	case "upload", "download", "tlf-upload", "tlf-download":
+		limit, err := parseBandwidthLimit(fields[1])
+		if err != nil {
+			return err
+		}
+		status := bandwidth.Status(folderBranch.Tlf)
+		limits := &status.Limits
+		if strings.HasPrefix(fields[0], "tlf-") {
+			limits = &status.TLFLimits
+		}
+		if strings.HasSuffix(fields[0], "upload") {
+			limits.UploadBytesPerSecond = limit
+		} else {
+			limits.DownloadBytesPerSecond = limit
+		}
+		if strings.HasPrefix(fields[0], "tlf-") {
+			return bandwidth.SetTLFLimits(folderBranch.Tlf, *limits)
+		}
+		return bandwidth.SetLimits(*limits)
+
+	case "schedule":
+		schedule, err := libkbfs.ParseBandwidthSchedule(fields[1])
+		if err != nil {
+			return err
+		}
+		bandwidth.SetSchedule(schedule)
+		return nil
+
+	case "metered":
+		switch fields[1] {
+		case "on":
+			bandwidth.SetMetered(true)
+		case "off":
+			bandwidth.SetMetered(false)
+		default:
+			return fmt.Errorf("Invalid metered setting %q", fields[1])
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("Unknown bandwidth command %q",
+			strings.TrimSpace(string(command)))
+	}
+}
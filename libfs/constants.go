@@ -47,6 +47,11 @@ const EnableJournalFileName = ".kbfs_enable_journal"
 // can be reached anywhere within a top-level folder.
 const FlushJournalFileName = ".kbfs_flush_journal"
 
+// SquashJournalFileName is the name of the journal-squashing file,
+// which squashes the unflushed revisions in the journal into one. It
+// can be reached anywhere within a top-level folder.
+const SquashJournalFileName = ".kbfs_squash_journal"
+
 // PauseJournalBackgroundWorkFileName is the name of the file that
 // pauses the background work of a journal. It can be reached anywhere
 // within a top-level folder.
@@ -90,5 +95,61 @@ const DisableDebugServerFileName = ".kbfs_disable_debug_server"
 // it can be reached anywhere within a top-level folder.
 const EditHistoryName = ".kbfs_edit_history"
 
+// DeletedEntriesFileName is the name of the KBFS TLF file that lists
+// recently-removed entries that can still be undeleted -- it can be
+// reached anywhere within a top-level folder.
+const DeletedEntriesFileName = ".kbfs_deleted"
+
+// SyncStatusFileName is the name of the KBFS TLF file that reports
+// which parts of the folder are kept synced for offline use, and how
+// far along the syncing is -- it can be reached anywhere within a
+// top-level folder.
+const SyncStatusFileName = ".kbfs_sync_status"
+
+// EnableSyncFileName is the name of the file which, when written to
+// within a directory of a top-level folder, starts keeping that
+// directory synced for offline use.
+const EnableSyncFileName = ".kbfs_enable_sync"
+
+// DisableSyncFileName is the name of the file which, when written to
+// within a directory of a top-level folder, stops keeping that
+// directory synced for offline use.
+const DisableSyncFileName = ".kbfs_disable_sync"
+
+// DiskCacheFileName is the name of the KBFS TLF file that reports how
+// much of the disk block cache the folder is using, and which can be
+// written to in order to clear the folder from the cache or to limit
+// how much of it the folder may use -- it can be reached anywhere
+// within a top-level folder.
+const DiskCacheFileName = ".kbfs_disk_cache"
+
+// BandwidthFileName is the name of the KBFS TLF file that reports the
+// limits on background uploads and downloads, the journal flush
+// schedule and whether the connection is metered, and which can be
+// written to in order to change them -- it can be reached anywhere
+// within a top-level folder.
+const BandwidthFileName = ".kbfs_bandwidth"
+
+// JournalPriorityFileName is the name of the KBFS TLF file that
+// reports the flush priority of the folder's journal and its place
+// in the queue of journals waiting to flush, and which can be
+// written to in order to change the priority -- it can be reached
+// anywhere within a top-level folder.
+const JournalPriorityFileName = ".kbfs_journal_priority"
+
 // FileInfoPrefix is the prefix of the per-file metadata files.
 const FileInfoPrefix = ".kbfs_fileinfo_"
+
+// ArchivedDirName is the name of the virtual per-TLF directory that
+// contains read-only views of past revisions of that TLF.  It can be
+// reached anywhere within a top-level folder.
+const ArchivedDirName = ".kbfs_archived"
+
+// ArchivedRevDirPrefix is the prefix of an entry in ArchivedDirName
+// that names a specific MD revision, e.g. "rev=1234".
+const ArchivedRevDirPrefix = "rev="
+
+// ArchivedTimeDirPrefix is the prefix of an entry in ArchivedDirName
+// that names the latest revision at or before a given time, e.g.
+// "time=2017-06-01T12:00Z".
+const ArchivedTimeDirPrefix = "time="
@@ -0,0 +1,26 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedDeletedEntries returns serialized JSON containing the
+// recently-removed entries of a folder that can still be undeleted.
+func GetEncodedDeletedEntries(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	entries, err := config.KBFSOps().GetDeletedEntries(ctx, folderBranch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(entries)
+	return data, time.Time{}, err
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func getDiskBlockCache(config libkbfs.Config) (libkbfs.DiskBlockCache, error) {
+	dbc := config.DiskBlockCache()
+	if dbc == nil {
+		return nil, fmt.Errorf("No disk block cache is configured")
+	}
+	return dbc, nil
+}
+
+// GetEncodedDiskCacheStatus returns serialized JSON describing how
+// much of the disk block cache a folder is using, and how often
+// lookups of its blocks hit the cache.
+func GetEncodedDiskCacheStatus(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	dbc, err := getDiskBlockCache(config)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	status, err := dbc.TLFStatus(ctx, folderBranch.Tlf)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(status)
+	return data, time.Time{}, err
+}
+
+// ExecuteDiskCacheCommand carries out a command written to a folder's
+// disk cache file.  The commands are:
+//
+//	clear      evicts all the folder's unpinned blocks from the cache
+//	limit N    limits the folder to N bytes of the cache; 0 goes back
+//	           to the default limit
+//	policy P   evicts the folder's blocks by policy P, one of "lru",
+//	           "lfu" or "size"; "default" goes back to the default
+//	           policy
+func ExecuteDiskCacheCommand(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch, command []byte) error {
+	dbc, err := getDiskBlockCache(config)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(command))
+	switch {
+	case len(fields) == 1 && fields[0] == "clear":
+		_, _, err := dbc.ClearTLF(ctx, folderBranch.Tlf)
+		return err
+
+	case len(fields) == 2 && fields[0] == "limit":
+		limit, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid disk cache limit %q", fields[1])
+		}
+		return dbc.SetTLFLimit(ctx, folderBranch.Tlf, limit)
+
+	case len(fields) == 2 && fields[0] == "policy":
+		policy := fields[1]
+		if policy == "default" {
+			policy = ""
+		}
+		return dbc.SetTLFEvictionPolicy(ctx, folderBranch.Tlf, policy)
+
+	default:
+		return fmt.Errorf("Unknown disk cache command %q",
+			strings.TrimSpace(string(command)))
+	}
+}
@@ -34,6 +34,9 @@ const (
 	JournalEnableAuto
 	// JournalDisableAuto is to turn off automatic journaling for new TLFs.
 	JournalDisableAuto
+	// JournalSquash is to squash the unflushed revisions in the
+	// journal into one.
+	JournalSquash
 )
 
 func (a JournalAction) String() string {
@@ -52,6 +55,8 @@ func (a JournalAction) String() string {
 		return "Enable auto-journals"
 	case JournalDisableAuto:
 		return "Disable auto-journals"
+	case JournalSquash:
+		return "Squash journal"
 	}
 	return fmt.Sprintf("JournalAction(%d)", int(a))
 }
@@ -88,6 +93,12 @@ func (a JournalAction) Execute(
 			return err
 		}
 
+	case JournalSquash:
+		err := jServer.Squash(ctx, tlfID)
+		if err != nil {
+			return err
+		}
+
 	case JournalPauseBackgroundWork:
 		jServer.PauseBackgroundWork(ctx, tlfID)
 
@@ -0,0 +1,43 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedJournalPriorityStatus returns serialized JSON describing
+// the flush priority of a folder's journal, and its position in the
+// queue of journals waiting to flush.
+func GetEncodedJournalPriorityStatus(ctx context.Context,
+	config libkbfs.Config, folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	jServer, err := libkbfs.GetJournalServer(config)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	status := jServer.FlushPriorityStatus(folderBranch.Tlf)
+	data, err = PrettyJSON(status)
+	return data, time.Time{}, err
+}
+
+// SetJournalPriority sets the flush priority of a folder's journal
+// to the one written to its journal priority file, which must be
+// "low", "normal" or "high".
+func SetJournalPriority(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch, data []byte) error {
+	priority, err := libkbfs.ParseJournalFlushPriority(string(data))
+	if err != nil {
+		return err
+	}
+	jServer, err := libkbfs.GetJournalServer(config)
+	if err != nil {
+		return err
+	}
+	return jServer.SetFlushPriority(ctx, folderBranch.Tlf, priority)
+}
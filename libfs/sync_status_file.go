@@ -0,0 +1,27 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedSyncStatus returns serialized JSON describing which parts
+// of a folder are kept synced for offline use, and how far along the
+// syncing is.
+func GetEncodedSyncStatus(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	status, err := config.KBFSOps().GetSyncStatus(ctx, folderBranch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(status)
+	return data, time.Time{}, err
+}
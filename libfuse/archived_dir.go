@@ -0,0 +1,115 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"os"
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// ArchivedDir is a virtual directory within a TLF whose entries are
+// read-only views of past revisions of that TLF.  The entries can't
+// be listed, but they can be looked up by name; see
+// libfs.ArchivedRevisionFromName for the valid names.
+type ArchivedDir struct {
+	folder *Folder
+}
+
+var _ fs.Node = (*ArchivedDir)(nil)
+
+// Attr implements the fs.Node interface for ArchivedDir.
+func (ad *ArchivedDir) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0500
+	a.Uid = uint32(os.Getuid())
+	return nil
+}
+
+var _ fs.NodeRequestLookuper = (*ArchivedDir)(nil)
+
+// Lookup implements the fs.NodeRequestLookuper interface for ArchivedDir.
+func (ad *ArchivedDir) Lookup(ctx context.Context, req *fuse.LookupRequest,
+	resp *fuse.LookupResponse) (node fs.Node, err error) {
+	ad.folder.fs.log.CDebugf(ctx, "ArchivedDir Lookup %s", req.Name)
+	defer func() { ad.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	ad.folder.handleMu.RLock()
+	h := ad.folder.h
+	ad.folder.handleMu.RUnlock()
+
+	rootNode, _, err := libfs.GetArchivedRootNode(
+		ctx, ad.folder.fs.config, h, req.Name)
+	if err != nil {
+		if _, ok := err.(libkbfs.NoSuchNameError); ok {
+			return nil, fuse.ENOENT
+		}
+		return nil, err
+	}
+
+	if strings.HasPrefix(req.Name, libfs.ArchivedTimeDirPrefix) {
+		// The revision a time maps to can change as new revisions
+		// are written, so don't let the kernel cache this entry.
+		resp.EntryValid = 0
+	}
+
+	return ad.rootDir(rootNode)
+}
+
+// rootDir returns the node for the given archived root node, reusing
+// the cached archived folder for its revision if the kernel still
+// holds a reference to that root.  The two folders' locks are never
+// held at the same time, since forgetNode takes them in the opposite
+// order.
+func (ad *ArchivedDir) rootDir(rootNode libkbfs.Node) (fs.Node, error) {
+	fb := rootNode.GetFolderBranch()
+	ad.folder.archivedMu.Lock()
+	folder := ad.folder.archivedFolders[fb]
+	ad.folder.archivedMu.Unlock()
+	if folder != nil {
+		folder.nodesMu.Lock()
+		child, ok := folder.nodes[rootNode.GetID()]
+		folder.nodesMu.Unlock()
+		if ok {
+			return child, nil
+		}
+		// Otherwise the cached folder is being forgotten, or
+		// belongs to a revision view that libkbfs has since shut
+		// down, so replace it.
+	}
+
+	folder = newArchivedFolder(ad.folder)
+	if err := folder.setFolderBranch(fb); err != nil {
+		return nil, err
+	}
+	child := newDir(folder, rootNode)
+	folder.nodesMu.Lock()
+	folder.nodes[rootNode.GetID()] = child
+	folder.nodesMu.Unlock()
+
+	ad.folder.archivedMu.Lock()
+	defer ad.folder.archivedMu.Unlock()
+	if ad.folder.archivedFolders == nil {
+		ad.folder.archivedFolders = make(map[libkbfs.FolderBranch]*Folder)
+	}
+	ad.folder.archivedFolders[fb] = folder
+	return child, nil
+}
+
+var _ fs.Handle = (*ArchivedDir)(nil)
+
+var _ fs.HandleReadDirAller = (*ArchivedDir)(nil)
+
+// ReadDirAll implements the fs.HandleReadDirAller interface for
+// ArchivedDir.  There are too many possible revisions to list, so
+// the directory always appears empty.
+func (ad *ArchivedDir) ReadDirAll(ctx context.Context) (
+	res []fuse.Dirent, err error) {
+	return nil, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// BandwidthFile is a special file that reports the limits on the
+// background traffic of a TLF.  Writing commands to it changes the
+// limits, the journal flush schedule, or metered mode, e.g.
+//
+//	echo upload 1000000 > /keybase/private/me/.kbfs_bandwidth
+//	echo metered on > /keybase/private/me/.kbfs_bandwidth
+type BandwidthFile struct {
+	folder *Folder
+}
+
+func (f *BandwidthFile) read(ctx context.Context) ([]byte, error) {
+	data, _, err := libfs.GetEncodedBandwidthStatus(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch())
+	return data, err
+}
+
+var _ fs.Node = (*BandwidthFile)(nil)
+
+// Attr implements the fs.Node interface for BandwidthFile.
+func (f *BandwidthFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	data, err := f.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.Valid = 1 * time.Second
+	a.Size = uint64(len(data))
+	a.Mode = 0644
+	return nil
+}
+
+var _ fs.NodeOpener = (*BandwidthFile)(nil)
+
+// Open implements the fs.NodeOpener interface for BandwidthFile.
+func (f *BandwidthFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenDirectIO
+	return f, nil
+}
+
+var _ fs.Handle = (*BandwidthFile)(nil)
+
+var _ fs.HandleReadAller = (*BandwidthFile)(nil)
+
+// ReadAll implements the fs.HandleReadAller interface for BandwidthFile.
+func (f *BandwidthFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return f.read(ctx)
+}
+
+var _ fs.HandleWriter = (*BandwidthFile)(nil)
+
+// Write implements the fs.HandleWriter interface for BandwidthFile.
+func (f *BandwidthFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "BandwidthFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	err = libfs.ExecuteBandwidthCommand(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch(), req.Data)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
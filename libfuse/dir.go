@@ -52,6 +52,22 @@ type Folder struct {
 	// file system.  Sending a struct{}{} on this channel will unpause
 	// the updates.
 	updateChan chan<- struct{}
+
+	// archived is true if this folder is a read-only view of a past
+	// revision of a TLF, reached via libfs.ArchivedDirName.  Such
+	// folders aren't tracked by the FolderList.
+	archived bool
+	// archivedParent is the folder whose ArchivedDir this archived
+	// folder was reached through.
+	archivedParent *Folder
+
+	// Protects archivedFolders.
+	archivedMu sync.Mutex
+	// archivedFolders caches the archived folders reached through
+	// this folder's ArchivedDir, so that looking up the same
+	// revision again reuses the same nodes.  An entry is dropped
+	// once all of its folder's nodes are forgotten.
+	archivedFolders map[libkbfs.FolderBranch]*Folder
 }
 
 func newFolder(fl *FolderList, h *libkbfs.TlfHandle,
@@ -66,6 +82,29 @@ func newFolder(fl *FolderList, h *libkbfs.TlfHandle,
 	return f
 }
 
+// newArchivedFolder returns a new Folder for a read-only view of a
+// past revision of the TLF represented by parent.
+func newArchivedFolder(parent *Folder) *Folder {
+	parent.handleMu.RLock()
+	defer parent.handleMu.RUnlock()
+	f := newFolder(parent.list, parent.h, parent.hPreferredName)
+	f.archived = true
+	f.archivedParent = parent
+	return f
+}
+
+// forgetArchivedFolder drops the given archived folder from the
+// cache, unless it has already been replaced.
+func (f *Folder) forgetArchivedFolder(archived *Folder) {
+	f.archivedMu.Lock()
+	defer f.archivedMu.Unlock()
+	for fb, cached := range f.archivedFolders {
+		if cached == archived {
+			delete(f.archivedFolders, fb)
+		}
+	}
+}
+
 func (f *Folder) name() libkbfs.CanonicalTlfName {
 	f.handleMu.RLock()
 	defer f.handleMu.RUnlock()
@@ -134,7 +173,11 @@ func (f *Folder) forgetNode(node libkbfs.Node) {
 		ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 		defer libkbfs.CleanupCancellationDelayer(ctx)
 		f.unsetFolderBranch(ctx)
-		f.list.forgetFolder(string(f.name()))
+		if f.archived {
+			f.archivedParent.forgetArchivedFolder(f)
+		} else {
+			f.list.forgetFolder(string(f.name()))
+		}
 	}
 }
 
@@ -331,12 +374,15 @@ func (f *Folder) tlfHandleChangeInvalidate(ctx context.Context,
 		return oldName, f.hPreferredName
 	}()
 
-	if oldName != newName {
+	if oldName != newName && !f.archived {
 		f.list.updateTlfName(ctx, string(oldName), string(newName))
 	}
 }
 
 func (f *Folder) isWriter(ctx context.Context) (bool, error) {
+	if f.archived {
+		return false, nil
+	}
 	session, err := libkbfs.GetCurrentSessionIfPossible(
 		ctx, f.fs.config.KBPKI(), f.list.public)
 	// We are using GetCurrentUserInfoIfPossible here so err is only non-nil if
@@ -517,6 +563,14 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 		return specialNode, nil
 	}
 
+	// The sync control files act on the directory they're in.
+	switch req.Name {
+	case libfs.EnableSyncFileName:
+		return &SyncControlFile{dir: d, enable: true}, nil
+	case libfs.DisableSyncFileName:
+		return &SyncControlFile{dir: d, enable: false}, nil
+	}
+
 	// Check if this is a per-file metainformation file, if so
 	// return the corresponding SpecialReadFile.
 	if strings.HasPrefix(req.Name, libfs.FileInfoPrefix) {
@@ -872,6 +926,121 @@ func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.
 	return nil
 }
 
+var _ fs.HandleLocker = (*Dir)(nil)
+
+// Lock implements the fs.HandleLocker interface for Dir.
+func (d *Dir) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Lock",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.Lock))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Lock owner=%#x %s flock=%t wait=%t",
+		req.LockOwner, req.Lock, req.Flock, req.Wait)
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return d.folder.lock(ctx, d.node, req)
+}
+
+// QueryLock implements the fs.HandleLocker interface for Dir.
+func (d *Dir) QueryLock(ctx context.Context, req *fuse.QueryLockRequest,
+	resp *fuse.QueryLockResponse) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.QueryLock",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.Lock))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir QueryLock owner=%#x %s",
+		req.LockOwner, req.Lock)
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return d.folder.queryLock(ctx, d.node, req, resp)
+}
+
+var _ fs.HandleReleaser = (*Dir)(nil)
+
+// Release implements the fs.HandleReleaser interface for Dir, by
+// releasing any flock(2) locks taken through the handle.
+func (d *Dir) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock == 0 {
+		return nil
+	}
+
+	ctx = d.folder.fs.maybeStartTrace(
+		ctx, "Dir.Release", d.node.GetBasename())
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Release flock owner=%#x",
+		req.LockOwner)
+	d.folder.unlockOwner(ctx, d.node, req.LockOwner, true)
+	return nil
+}
+
+var _ fs.NodeGetxattrer = (*Dir)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for Dir.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Getxattr",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Getxattr %s", req.Name)
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	resp.Xattr, err = d.folder.fs.config.KBFSOps().GetXattr(
+		ctx, d.node, req.Name)
+	return err
+}
+
+var _ fs.NodeListxattrer = (*Dir)(nil)
+
+// Listxattr implements the fs.NodeListxattrer interface for Dir.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(
+		ctx, "Dir.Listxattr", d.node.GetBasename())
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Listxattr")
+	defer func() { d.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	names, err := d.folder.fs.config.KBFSOps().ListXattrs(ctx, d.node)
+	if err != nil {
+		return err
+	}
+	resp.Append(names...)
+	return nil
+}
+
+var _ fs.NodeSetxattrer = (*Dir)(nil)
+
+// Setxattr implements the fs.NodeSetxattrer interface for Dir.
+func (d *Dir) Setxattr(ctx context.Context,
+	req *fuse.SetxattrRequest) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Setxattr",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Setxattr %s", req.Name)
+	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+
+	return setXattr(ctx, d.folder.fs.config.KBFSOps(), d.node, req)
+}
+
+var _ fs.NodeRemovexattrer = (*Dir)(nil)
+
+// Removexattr implements the fs.NodeRemovexattrer interface for Dir.
+func (d *Dir) Removexattr(ctx context.Context,
+	req *fuse.RemovexattrRequest) (err error) {
+	ctx = d.folder.fs.maybeStartTrace(ctx, "Dir.Removexattr",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Removexattr %s", req.Name)
+	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+
+	return d.folder.fs.config.KBFSOps().RemoveXattr(ctx, d.node, req.Name)
+}
+
 // isNoSuchNameError checks for libkbfs.NoSuchNameError.
 func isNoSuchNameError(err error) bool {
 	_, ok := err.(libkbfs.NoSuchNameError)
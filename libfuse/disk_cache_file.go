@@ -0,0 +1,86 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// DiskCacheFile is a special file that reports how much of the disk
+// block cache a TLF is using.  Writing "clear" to it evicts the TLF's
+// unpinned blocks from the cache, writing "limit N" limits the TLF to
+// N bytes of the cache, and writing "policy P" picks the TLF's
+// eviction policy, e.g.
+//
+//	echo limit 1000000000 > /keybase/private/me/.kbfs_disk_cache
+type DiskCacheFile struct {
+	folder *Folder
+}
+
+func (f *DiskCacheFile) read(ctx context.Context) ([]byte, error) {
+	data, _, err := libfs.GetEncodedDiskCacheStatus(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch())
+	return data, err
+}
+
+var _ fs.Node = (*DiskCacheFile)(nil)
+
+// Attr implements the fs.Node interface for DiskCacheFile.
+func (f *DiskCacheFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	data, err := f.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.Valid = 1 * time.Second
+	a.Size = uint64(len(data))
+	a.Mode = 0644
+	return nil
+}
+
+var _ fs.NodeOpener = (*DiskCacheFile)(nil)
+
+// Open implements the fs.NodeOpener interface for DiskCacheFile.
+func (f *DiskCacheFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenDirectIO
+	return f, nil
+}
+
+var _ fs.Handle = (*DiskCacheFile)(nil)
+
+var _ fs.HandleReadAller = (*DiskCacheFile)(nil)
+
+// ReadAll implements the fs.HandleReadAller interface for DiskCacheFile.
+func (f *DiskCacheFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return f.read(ctx)
+}
+
+var _ fs.HandleWriter = (*DiskCacheFile)(nil)
+
+// Write implements the fs.HandleWriter interface for DiskCacheFile.
+func (f *DiskCacheFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "DiskCacheFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	err = libfs.ExecuteDiskCacheCommand(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch(), req.Data)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
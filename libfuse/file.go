@@ -6,8 +6,11 @@ package libfuse
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"sync"
+	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -247,6 +250,202 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest,
 	return nil
 }
 
+// FICLONE isn't handled here, and can't be: the kernel implements
+// that ioctl in the VFS, through the remap_file_range file operation,
+// which FUSE doesn't support, so the request never reaches us and
+// the caller gets EOPNOTSUPP.  copy_file_range does reach FUSE, but it
+// copies a byte range into an already-open file, while
+// KBFSOps.CopyFile makes a whole new file, so it's left to the
+// kernel's fallback of reading and writing the data.  Copies that
+// share blocks are available through `kbfstool cp` and SimpleFS.
+
+var _ fs.HandleFallocater = (*File)(nil)
+
+// Fallocate implements the fs.HandleFallocater interface for File.
+// Only punching holes is supported; KBFS never preallocates space.
+func (f *File) Fallocate(ctx context.Context,
+	req *fuse.FallocateRequest) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.Fallocate",
+		fmt.Sprintf("%s off=%d len=%d mode=%#x", f.node.GetBasename(),
+			req.Offset, req.Length, req.Mode))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Fallocate off=%d len=%d mode=%#x",
+		req.Offset, req.Length, req.Mode)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+
+	if req.Mode != fuse.FallocatePunchHole|fuse.FallocateKeepSize {
+		return fuse.ENOTSUP
+	}
+
+	f.eiCache.destroy()
+	return f.folder.fs.config.KBFSOps().PunchHole(
+		ctx, f.node, req.Offset, req.Length)
+}
+
+var _ fs.HandleLseeker = (*File)(nil)
+
+// Lseek implements the fs.HandleLseeker interface for File.
+func (f *File) Lseek(ctx context.Context, req *fuse.LseekRequest,
+	resp *fuse.LseekResponse) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.Lseek",
+		fmt.Sprintf("%s off=%d whence=%d", f.node.GetBasename(),
+			req.Offset, req.Whence))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Lseek off=%d whence=%d",
+		req.Offset, req.Whence)
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	var off int64
+	switch req.Whence {
+	case fuse.SeekData:
+		off, err = f.folder.fs.config.KBFSOps().NextDataOffset(
+			ctx, f.node, req.Offset)
+	case fuse.SeekHole:
+		off, err = f.folder.fs.config.KBFSOps().NextHoleOffset(
+			ctx, f.node, req.Offset)
+	default:
+		return fuse.Errno(syscall.EINVAL)
+	}
+	if err != nil {
+		return err
+	}
+	if off < 0 {
+		// No data (or hole) at or after the offset.
+		return fuse.Errno(syscall.ENXIO)
+	}
+	resp.Offset = off
+	return nil
+}
+
+// fileLockPollPeriod is how often a blocking lock request retries
+// taking a lock held by someone else.  Locks held on other devices
+// can't notify us when they're released.
+const fileLockPollPeriod = 100 * time.Millisecond
+
+// fileLockFromFuse converts a lock from the kernel, which gives the
+// offset of the last byte covered, to a libkbfs.FileLock.
+func fileLockFromFuse(
+	owner uint64, l fuse.FileLock, flock bool) libkbfs.FileLock {
+	lock := libkbfs.FileLock{
+		Owner: owner,
+		Start: int64(l.Start),
+		Flock: flock,
+	}
+	switch l.Type {
+	case fuse.LockRead:
+		lock.Type = libkbfs.FileLockRead
+	case fuse.LockWrite:
+		lock.Type = libkbfs.FileLockWrite
+	}
+	if l.End < math.MaxInt64 {
+		lock.Length = int64(l.End-l.Start) + 1
+	}
+	return lock
+}
+
+// fileLockToFuse is the inverse of fileLockFromFuse.  The kernel
+// fills in the PID of a conflicting lock itself, when it's local.
+func fileLockToFuse(lock libkbfs.FileLock) fuse.FileLock {
+	l := fuse.FileLock{
+		Start: uint64(lock.Start),
+		End:   math.MaxInt64,
+		Type:  fuse.LockRead,
+	}
+	if lock.Type == libkbfs.FileLockWrite {
+		l.Type = fuse.LockWrite
+	}
+	if lock.Length != 0 {
+		l.End = uint64(lock.Start+lock.Length) - 1
+	}
+	return l
+}
+
+// lock handles a lock request for node, for File.Lock and Dir.Lock.
+// fcntl(2) and flock(2) locks are held by separate owners, even when
+// the kernel gives them the same lock owner, so that e.g. an flock
+// unlock doesn't release a process's fcntl locks.  The two kinds
+// still exclude each other, like on BSD.
+func (f *Folder) lock(ctx context.Context, node libkbfs.Node,
+	req *fuse.LockRequest) error {
+	kbfsOps := f.fs.config.KBFSOps()
+	lock := fileLockFromFuse(req.LockOwner, req.Lock, req.Flock)
+	if req.Lock.Type == fuse.LockUnlock {
+		return kbfsOps.UnlockFile(ctx, node, lock)
+	}
+
+	for {
+		err := kbfsOps.LockFile(ctx, node, lock)
+		if _, ok := err.(libkbfs.FileLockConflictError); !ok || !req.Wait {
+			return err
+		}
+		select {
+		case <-time.After(fileLockPollPeriod):
+		case <-ctx.Done():
+			return fuse.EINTR
+		}
+	}
+}
+
+// queryLock handles a lock query for node, for File.QueryLock and
+// Dir.QueryLock.
+func (f *Folder) queryLock(ctx context.Context, node libkbfs.Node,
+	req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) error {
+	conflict, ok, err := f.fs.config.KBFSOps().GetFileLockConflict(
+		ctx, node, fileLockFromFuse(req.LockOwner, req.Lock, req.Flock))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		resp.Lock = fuse.FileLock{Type: fuse.LockUnlock}
+		return nil
+	}
+	resp.Lock = fileLockToFuse(conflict)
+	return nil
+}
+
+// unlockOwner releases all the fcntl(2) locks, or if flock is true
+// all the flock(2) locks, held by the given owner on node.  Errors
+// are only logged, since they happen while a file is being closed.
+func (f *Folder) unlockOwner(ctx context.Context, node libkbfs.Node,
+	owner uint64, flock bool) {
+	err := f.fs.config.KBFSOps().UnlockFile(
+		ctx, node, libkbfs.FileLock{Owner: owner, Flock: flock})
+	if err != nil {
+		f.fs.log.CDebugf(ctx, "Couldn't unlock file: %v", err)
+	}
+}
+
+var _ fs.HandleLocker = (*File)(nil)
+
+// Lock implements the fs.HandleLocker interface for File.
+func (f *File) Lock(ctx context.Context, req *fuse.LockRequest) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.Lock",
+		fmt.Sprintf("%s %s", f.node.GetBasename(), req.Lock))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Lock owner=%#x %s flock=%t wait=%t",
+		req.LockOwner, req.Lock, req.Flock, req.Wait)
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return f.folder.lock(ctx, f.node, req)
+}
+
+// QueryLock implements the fs.HandleLocker interface for File.
+func (f *File) QueryLock(ctx context.Context, req *fuse.QueryLockRequest,
+	resp *fuse.QueryLockResponse) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.QueryLock",
+		fmt.Sprintf("%s %s", f.node.GetBasename(), req.Lock))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File QueryLock owner=%#x %s",
+		req.LockOwner, req.Lock)
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	return f.folder.queryLock(ctx, f.node, req, resp)
+}
+
 var _ fs.HandleFlusher = (*File)(nil)
 
 // Flush implements the fs.HandleFlusher interface for File.
@@ -267,9 +466,34 @@ func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 		return err
 	}
 
+	// Closing any descriptor for a file releases the POSIX locks its
+	// process holds on it.  The kernel doesn't send an unlock request
+	// for locks it doesn't track itself, so do it here.
+	f.folder.unlockOwner(ctx, f.node, req.LockOwner, false)
+
 	return f.sync(ctx)
 }
 
+var _ fs.HandleReleaser = (*File)(nil)
+
+// Release implements the fs.HandleReleaser interface for File.
+func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock == 0 {
+		return nil
+	}
+
+	ctx = f.folder.fs.maybeStartTrace(
+		ctx, "File.Release", f.node.GetBasename())
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	// flock(2) locks belong to the open file, and are released when
+	// its last descriptor is closed.
+	f.folder.fs.log.CDebugf(ctx, "File Release flock owner=%#x",
+		req.LockOwner)
+	f.folder.unlockOwner(ctx, f.node, req.LockOwner, true)
+	return nil
+}
+
 var _ fs.NodeSetattrer = (*File)(nil)
 
 // Setattr implements the fs.NodeSetattrer interface for File.
@@ -354,6 +578,75 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 	return nil
 }
 
+var _ fs.NodeGetxattrer = (*File)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for File.
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.Getxattr",
+		fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Getxattr %s", req.Name)
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	resp.Xattr, err = f.folder.fs.config.KBFSOps().GetXattr(
+		ctx, f.node, req.Name)
+	return err
+}
+
+var _ fs.NodeListxattrer = (*File)(nil)
+
+// Listxattr implements the fs.NodeListxattrer interface for File.
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(
+		ctx, "File.Listxattr", f.node.GetBasename())
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Listxattr")
+	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
+
+	names, err := f.folder.fs.config.KBFSOps().ListXattrs(ctx, f.node)
+	if err != nil {
+		return err
+	}
+	resp.Append(names...)
+	return nil
+}
+
+var _ fs.NodeSetxattrer = (*File)(nil)
+
+// Setxattr implements the fs.NodeSetxattrer interface for File.
+func (f *File) Setxattr(ctx context.Context,
+	req *fuse.SetxattrRequest) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.Setxattr",
+		fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Setxattr %s", req.Name)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+
+	f.eiCache.destroy()
+	return setXattr(ctx, f.folder.fs.config.KBFSOps(), f.node, req)
+}
+
+var _ fs.NodeRemovexattrer = (*File)(nil)
+
+// Removexattr implements the fs.NodeRemovexattrer interface for File.
+func (f *File) Removexattr(ctx context.Context,
+	req *fuse.RemovexattrRequest) (err error) {
+	ctx = f.folder.fs.maybeStartTrace(ctx, "File.Removexattr",
+		fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.maybeFinishTrace(ctx, err) }()
+
+	f.folder.fs.log.CDebugf(ctx, "File Removexattr %s", req.Name)
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+
+	f.eiCache.destroy()
+	return f.folder.fs.config.KBFSOps().RemoveXattr(ctx, f.node, req.Name)
+}
+
 var _ fs.NodeForgetter = (*File)(nil)
 
 // Forget kernel reference to this node.
@@ -0,0 +1,85 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// JournalPriorityFile is a special file that reports the flush
+// priority of a TLF's journal, and where it is in the queue of
+// journals waiting to flush.  Writing "low", "normal" or "high" to
+// it changes the priority, e.g.
+//
+//	echo high > /keybase/private/me/.kbfs_journal_priority
+type JournalPriorityFile struct {
+	folder *Folder
+}
+
+func (f *JournalPriorityFile) read(ctx context.Context) ([]byte, error) {
+	data, _, err := libfs.GetEncodedJournalPriorityStatus(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch())
+	return data, err
+}
+
+var _ fs.Node = (*JournalPriorityFile)(nil)
+
+// Attr implements the fs.Node interface for JournalPriorityFile.
+func (f *JournalPriorityFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	data, err := f.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	a.Valid = 1 * time.Second
+	a.Size = uint64(len(data))
+	a.Mode = 0644
+	return nil
+}
+
+var _ fs.NodeOpener = (*JournalPriorityFile)(nil)
+
+// Open implements the fs.NodeOpener interface for JournalPriorityFile.
+func (f *JournalPriorityFile) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenDirectIO
+	return f, nil
+}
+
+var _ fs.Handle = (*JournalPriorityFile)(nil)
+
+var _ fs.HandleReadAller = (*JournalPriorityFile)(nil)
+
+// ReadAll implements the fs.HandleReadAller interface for JournalPriorityFile.
+func (f *JournalPriorityFile) ReadAll(ctx context.Context) ([]byte, error) {
+	return f.read(ctx)
+}
+
+var _ fs.HandleWriter = (*JournalPriorityFile)(nil)
+
+// Write implements the fs.HandleWriter interface for JournalPriorityFile.
+func (f *JournalPriorityFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	f.folder.fs.log.CDebugf(ctx, "JournalPriorityFile Write")
+	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	err = libfs.SetJournalPriority(
+		ctx, f.folder.fs.config, f.folder.getFolderBranch(), req.Data)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package libfuse
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/sys/unix"
+)
+
+// Linux lseek(2) whence values, not defined by the vendored x/sys/unix.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+func TestFallocatePunchHoleAndSeek(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, _, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+
+	// Test configs split files into 64 KiB blocks; punch out exactly
+	// the middle one.
+	const blockSize = 64 * 1024
+	p := path.Join(mnt.Dir, PrivateName, "jdoe", "myfile")
+	input := bytes.Repeat([]byte{'x'}, 3*blockSize)
+	if err := ioutil.WriteFile(p, input, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(p, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	const mode = 0x01 | 0x02 // FALLOC_FL_KEEP_SIZE | FALLOC_FL_PUNCH_HOLE
+	if err := unix.Fallocate(
+		int(f.Fd()), mode, blockSize, blockSize); err != nil {
+		t.Fatal(err)
+	}
+	// Plain preallocation isn't supported.
+	if err := unix.Fallocate(int(f.Fd()), 0, 0, 1); err != syscall.ENOTSUP {
+		t.Errorf("expected ENOTSUP for preallocation, got %v", err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := fi.Size(), int64(len(input)); g != e {
+		t.Errorf("wrong size: %v != %v", g, e)
+	}
+	buf, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := blockSize; i < 2*blockSize; i++ {
+		input[i] = 0
+	}
+	if !bytes.Equal(buf, input) {
+		t.Errorf("read wrong content after punching a hole")
+	}
+
+	seekTests := []struct {
+		off, whence, expected int64
+	}{
+		{0, seekData, 0},
+		{0, seekHole, blockSize},
+		{blockSize, seekData, 2 * blockSize},
+		{2 * blockSize, seekHole, 3 * blockSize},
+	}
+	for _, st := range seekTests {
+		off, err := unix.Seek(int(f.Fd()), st.off, int(st.whence))
+		if err != nil {
+			t.Fatalf("seek %d from %d: %v", st.whence, st.off, err)
+		}
+		if off != st.expected {
+			t.Errorf("seek %d from %d: %d != %d",
+				st.whence, st.off, off, st.expected)
+		}
+	}
+	_, err = unix.Seek(int(f.Fd()), int64(len(input)), seekData)
+	if err != syscall.ENXIO {
+		t.Errorf("expected ENXIO seeking data at EOF, got %v", err)
+	}
+}
+
+func TestFlockAcrossOpenFiles(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, _, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+
+	p := path.Join(mnt.Dir, PrivateName, "jdoe", "myfile")
+	if err := ioutil.WriteFile(p, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f1, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+
+	// flock(2) locks belong to the open file, so the two files
+	// exclude each other.
+	if err := syscall.Flock(int(f1.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	err = syscall.Flock(int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != syscall.EWOULDBLOCK {
+		t.Fatalf("expected EWOULDBLOCK for a conflicting lock, got %v", err)
+	}
+
+	// Closing the first file releases its lock.
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(
+		int(f2.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(f2.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -9,12 +9,14 @@ package libfuse
 import "bazil.org/fuse"
 
 func getPlatformSpecificMountOptions(dir string, platformParams PlatformParams) ([]fuse.MountOption, error) {
-	return []fuse.MountOption{}, nil
+	// Have the kernel pass file locks on to KBFS, which shares them
+	// with other devices.
+	return []fuse.MountOption{fuse.LockingPOSIX()}, nil
 }
 
 // GetPlatformSpecificMountOptionsForTest makes cross-platform tests work
 func GetPlatformSpecificMountOptionsForTest() []fuse.MountOption {
-	return []fuse.MountOption{}
+	return []fuse.MountOption{fuse.LockingPOSIX()}
 }
 
 func translatePlatformSpecificError(err error, platformParams PlatformParams) error {
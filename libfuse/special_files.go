@@ -87,6 +87,35 @@ func handleTLFSpecialFile(
 	case libfs.EditHistoryName:
 		return NewTlfEditHistoryFile(folder, entryValid)
 
+	case libfs.DeletedEntriesFileName:
+		return NewDeletedEntriesFile(folder, entryValid)
+
+	case libfs.SyncStatusFileName:
+		return NewSyncStatusFile(folder, entryValid)
+
+	case libfs.DiskCacheFileName:
+		*entryValid = 0
+		return &DiskCacheFile{
+			folder: folder,
+		}
+
+	case libfs.BandwidthFileName:
+		*entryValid = 0
+		return &BandwidthFile{
+			folder: folder,
+		}
+
+	case libfs.JournalPriorityFileName:
+		*entryValid = 0
+		return &JournalPriorityFile{
+			folder: folder,
+		}
+
+	case libfs.ArchivedDirName:
+		return &ArchivedDir{
+			folder: folder,
+		}
+
 	case libfs.UnstageFileName:
 		return &UnstageFile{
 			folder: folder,
@@ -134,6 +163,12 @@ func handleTLFSpecialFile(
 			action: libfs.JournalFlush,
 		}
 
+	case libfs.SquashJournalFileName:
+		return &JournalControlFile{
+			folder: folder,
+			action: libfs.JournalSquash,
+		}
+
 	case libfs.PauseJournalBackgroundWorkFileName:
 		return &JournalControlFile{
 			folder: folder,
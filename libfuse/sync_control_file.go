@@ -0,0 +1,52 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SyncControlFile is a special file used to start or stop keeping a
+// directory synced for offline use.
+type SyncControlFile struct {
+	dir    *Dir
+	enable bool
+}
+
+var _ fs.Node = (*SyncControlFile)(nil)
+
+// Attr implements the fs.Node interface for SyncControlFile.
+func (f *SyncControlFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*SyncControlFile)(nil)
+
+var _ fs.HandleWriter = (*SyncControlFile)(nil)
+
+// Write implements the fs.HandleWriter interface for SyncControlFile.
+func (f *SyncControlFile) Write(ctx context.Context, req *fuse.WriteRequest,
+	resp *fuse.WriteResponse) (err error) {
+	folder := f.dir.folder
+	folder.fs.log.CDebugf(ctx, "SyncControlFile (enable: %t) Write",
+		f.enable)
+	defer func() { folder.reportErr(ctx, libkbfs.WriteMode, err) }()
+	if len(req.Data) == 0 {
+		return nil
+	}
+
+	err = folder.fs.config.KBFSOps().SetKeepSynced(ctx, f.dir.node, f.enable)
+	if err != nil {
+		return err
+	}
+
+	resp.Size = len(req.Data)
+	return nil
+}
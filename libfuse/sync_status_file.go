@@ -0,0 +1,27 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/keybase/kbfs/libfs"
+)
+
+// NewSyncStatusFile returns a special read file that reports which
+// parts of that TLF are kept synced for offline use, and how far
+// along the syncing is.
+func NewSyncStatusFile(
+	folder *Folder, entryValid *time.Duration) *SpecialReadFile {
+	*entryValid = 0
+	return &SpecialReadFile{
+		read: func(ctx context.Context) ([]byte, time.Time, error) {
+			return libfs.GetEncodedSyncStatus(
+				ctx, folder.fs.config, folder.getFolderBranch())
+		},
+	}
+}
@@ -243,6 +243,38 @@ func (tlf *TLF) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fus
 	return dir.Setattr(ctx, req, resp)
 }
 
+var _ fs.HandleLocker = (*TLF)(nil)
+
+// Lock implements the fs.HandleLocker interface for TLF.
+func (tlf *TLF) Lock(ctx context.Context, req *fuse.LockRequest) error {
+	dir, err := tlf.loadDir(ctx)
+	if err != nil {
+		return err
+	}
+	return dir.Lock(ctx, req)
+}
+
+// QueryLock implements the fs.HandleLocker interface for TLF.
+func (tlf *TLF) QueryLock(ctx context.Context, req *fuse.QueryLockRequest,
+	resp *fuse.QueryLockResponse) error {
+	dir, err := tlf.loadDir(ctx)
+	if err != nil {
+		return err
+	}
+	return dir.QueryLock(ctx, req, resp)
+}
+
+var _ fs.HandleReleaser = (*TLF)(nil)
+
+// Release implements the fs.HandleReleaser interface for TLF.
+func (tlf *TLF) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	dir := tlf.getStoredDir()
+	if dir == nil {
+		return nil
+	}
+	return dir.Release(ctx, req)
+}
+
 var _ fs.Handle = (*TLF)(nil)
 
 var _ fs.NodeOpener = (*TLF)(nil)
@@ -0,0 +1,40 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"bazil.org/fuse"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// Flags for fuse.SetxattrRequest; these have the same values on
+// Linux and macOS.
+const (
+	xattrCreate  = 0x1 // XATTR_CREATE
+	xattrReplace = 0x2 // XATTR_REPLACE
+)
+
+// setXattr sets an extended attribute on the given node, honoring
+// the create/replace flags of the request.
+func setXattr(ctx context.Context, kbfsOps libkbfs.KBFSOps,
+	node libkbfs.Node, req *fuse.SetxattrRequest) error {
+	if req.Flags&(xattrCreate|xattrReplace) != 0 {
+		_, err := kbfsOps.GetXattr(ctx, node, req.Name)
+		switch err.(type) {
+		case nil:
+			if req.Flags&xattrCreate != 0 {
+				return fuse.EEXIST
+			}
+		case libkbfs.NoSuchXattrError:
+			if req.Flags&xattrReplace != 0 {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	return kbfsOps.SetXattr(ctx, node, req.Name, req.Xattr)
+}
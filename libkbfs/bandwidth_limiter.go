@@ -0,0 +1,487 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// BandwidthLimits are limits, in bytes per second, on the traffic
+// KBFS generates in the background: uploads by journal flushes, and
+// downloads by prefetches.  A limit of 0 means no limit.
+type BandwidthLimits struct {
+	UploadBytesPerSecond   int64
+	DownloadBytesPerSecond int64
+}
+
+// BandwidthWindow is a daily time window, given as offsets from
+// local midnight.  If End is not after Start, the window wraps
+// around midnight.
+type BandwidthWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w BandwidthWindow) contains(timeOfDay time.Duration) bool {
+	if w.Start < w.End {
+		return timeOfDay >= w.Start && timeOfDay < w.End
+	}
+	return timeOfDay >= w.Start || timeOfDay < w.End
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func (w BandwidthWindow) String() string {
+	return formatTimeOfDay(w.Start) + "-" + formatTimeOfDay(w.End)
+}
+
+// BandwidthSchedule is a set of daily windows during which journals
+// may flush in the background.  An empty schedule lets them flush
+// at any time.
+type BandwidthSchedule []BandwidthWindow
+
+// ParseBandwidthSchedule parses a comma-separated list of daily
+// windows in 24-hour local time, like "22:00-06:00,12:00-13:00".  The
+// empty string, or "always", gives an empty schedule.
+func ParseBandwidthSchedule(s string) (BandwidthSchedule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "always" {
+		return nil, nil
+	}
+	var schedule BandwidthSchedule
+	for _, w := range strings.Split(s, ",") {
+		times := strings.Split(strings.TrimSpace(w), "-")
+		if len(times) != 2 {
+			return nil, errors.Errorf("Invalid schedule window %q", w)
+		}
+		var window BandwidthWindow
+		for i, t := range times {
+			parsed, err := time.Parse("15:04", t)
+			if err != nil {
+				return nil, errors.Errorf(
+					"Invalid time %q in schedule window %q", t, w)
+			}
+			d := time.Duration(parsed.Hour())*time.Hour +
+				time.Duration(parsed.Minute())*time.Minute
+			if i == 0 {
+				window.Start = d
+			} else {
+				window.End = d
+			}
+		}
+		if window.Start == window.End {
+			return nil, errors.Errorf("Empty schedule window %q", w)
+		}
+		schedule = append(schedule, window)
+	}
+	return schedule, nil
+}
+
+func (s BandwidthSchedule) String() string {
+	if len(s) == 0 {
+		return "always"
+	}
+	windows := make([]string, 0, len(s))
+	for _, w := range s {
+		windows = append(windows, w.String())
+	}
+	return strings.Join(windows, ",")
+}
+
+func timeOfDay(t time.Time) time.Duration {
+	midnight := time.Date(
+		t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+// untilOpen returns how long it is from t until the schedule next
+// allows flushes, which is 0 if it allows them at t.
+func (s BandwidthSchedule) untilOpen(t time.Time) time.Duration {
+	if len(s) == 0 {
+		return 0
+	}
+	tod := timeOfDay(t)
+	var minWait time.Duration = -1
+	for _, w := range s {
+		if w.contains(tod) {
+			return 0
+		}
+		wait := w.Start - tod
+		if wait < 0 {
+			wait += 24 * time.Hour
+		}
+		if minWait < 0 || wait < minWait {
+			minWait = wait
+		}
+	}
+	return minWait
+}
+
+// BandwidthStatus describes the bandwidth settings that apply to a
+// TLF.
+type BandwidthStatus struct {
+	Limits         BandwidthLimits
+	TLFLimits      BandwidthLimits
+	Schedule       string
+	Metered        bool
+	FlushesAllowed bool
+}
+
+// makeBandwidthRateLimiter returns a limiter for the given rate, or
+// nil if the rate is unlimited.  The burst is one second's worth of
+// bytes.
+func makeBandwidthRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond
+	if burst > MaxBlockSizeBytesDefault {
+		burst = MaxBlockSizeBytesDefault
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// waitBandwidth waits until n bytes can go through the given
+// limiter, which may be nil.
+func waitBandwidth(ctx context.Context, l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := n
+		if chunk > l.Burst() {
+			chunk = l.Burst()
+		}
+		err := l.WaitN(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+type bandwidthRateLimiters struct {
+	limits   BandwidthLimits
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+func makeBandwidthRateLimiters(limits BandwidthLimits) bandwidthRateLimiters {
+	return bandwidthRateLimiters{
+		limits:   limits,
+		upload:   makeBandwidthRateLimiter(limits.UploadBytesPerSecond),
+		download: makeBandwidthRateLimiter(limits.DownloadBytesPerSecond),
+	}
+}
+
+// BandwidthLimiter throttles the background traffic of KBFS.  It
+// enforces upload limits on journal flushes and download limits on
+// prefetches, both globally and per TLF, and it holds off background
+// journal flushes outside of the flush schedule, or when the
+// connection is metered.  Explicit flushes still go through, at the
+// limited rate.  All of its methods may be called on a nil
+// *BandwidthLimiter, which doesn't limit anything.
+type BandwidthLimiter struct {
+	clock clockGetter
+
+	lock     sync.RWMutex
+	global   bandwidthRateLimiters
+	tlfs     map[tlf.ID]bandwidthRateLimiters
+	schedule BandwidthSchedule
+	metered  bool
+	// changeCh is closed, and replaced, whenever the schedule or
+	// metered mode changes.
+	changeCh chan struct{}
+}
+
+// newBandwidthLimiter returns a BandwidthLimiter with no limits,
+// which uses the given clock to check the schedule.
+func newBandwidthLimiter(clock clockGetter) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		clock:    clock,
+		tlfs:     make(map[tlf.ID]bandwidthRateLimiters),
+		changeCh: make(chan struct{}),
+	}
+}
+
+func checkBandwidthLimits(limits BandwidthLimits) error {
+	if limits.UploadBytesPerSecond < 0 ||
+		limits.DownloadBytesPerSecond < 0 {
+		return errors.Errorf("Invalid bandwidth limits %+v", limits)
+	}
+	return nil
+}
+
+// Limits returns the global bandwidth limits.
+func (l *BandwidthLimiter) Limits() BandwidthLimits {
+	if l == nil {
+		return BandwidthLimits{}
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.global.limits
+}
+
+// SetLimits sets the global bandwidth limits.
+func (l *BandwidthLimiter) SetLimits(limits BandwidthLimits) error {
+	if l == nil {
+		return errors.New("No bandwidth limiter")
+	}
+	err := checkBandwidthLimits(limits)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.global = makeBandwidthRateLimiters(limits)
+	return nil
+}
+
+// SetTLFLimits sets the bandwidth limits for the given TLF, which
+// apply on top of the global ones.  Zero limits remove them.
+func (l *BandwidthLimiter) SetTLFLimits(
+	tlfID tlf.ID, limits BandwidthLimits) error {
+	if l == nil {
+		return errors.New("No bandwidth limiter")
+	}
+	err := checkBandwidthLimits(limits)
+	if err != nil {
+		return err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if limits == (BandwidthLimits{}) {
+		delete(l.tlfs, tlfID)
+		return nil
+	}
+	l.tlfs[tlfID] = makeBandwidthRateLimiters(limits)
+	return nil
+}
+
+func (l *BandwidthLimiter) signalChangeLocked() {
+	close(l.changeCh)
+	l.changeCh = make(chan struct{})
+}
+
+// SetSchedule sets the daily windows during which journals may flush
+// in the background.
+func (l *BandwidthLimiter) SetSchedule(schedule BandwidthSchedule) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.schedule = schedule
+	l.signalChangeLocked()
+}
+
+// SetMetered turns metered mode, in which journals don't flush in
+// the background at all, on or off.
+func (l *BandwidthLimiter) SetMetered(metered bool) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.metered = metered
+	l.signalChangeLocked()
+}
+
+// untilFlushAllowedLocked returns whether background flushes are
+// allowed now, and if not, how long until the schedule allows them,
+// which is 0 if only a settings change can allow them.
+func (l *BandwidthLimiter) untilFlushAllowedLocked() (
+	allowed bool, wait time.Duration) {
+	if l.metered {
+		return false, 0
+	}
+	wait = l.schedule.untilOpen(l.clock.Clock().Now())
+	return wait == 0, wait
+}
+
+// Status returns the bandwidth settings that apply to the given TLF.
+func (l *BandwidthLimiter) Status(tlfID tlf.ID) BandwidthStatus {
+	if l == nil {
+		return BandwidthStatus{Schedule: "always", FlushesAllowed: true}
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	allowed, _ := l.untilFlushAllowedLocked()
+	return BandwidthStatus{
+		Limits:         l.global.limits,
+		TLFLimits:      l.tlfs[tlfID].limits,
+		Schedule:       l.schedule.String(),
+		Metered:        l.metered,
+		FlushesAllowed: allowed,
+	}
+}
+
+// flushesAllowed returns whether journals are allowed to flush in
+// the background right now.
+func (l *BandwidthLimiter) flushesAllowed() bool {
+	if l == nil {
+		return true
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	allowed, _ := l.untilFlushAllowedLocked()
+	return allowed
+}
+
+// waitForFlushWindow blocks until journals are allowed to flush in
+// the background, or until ctx is canceled.
+func (l *BandwidthLimiter) waitForFlushWindow(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		allowed, wait, changeCh := func() (bool, time.Duration, chan struct{}) {
+			l.lock.RLock()
+			defer l.lock.RUnlock()
+			allowed, wait := l.untilFlushAllowedLocked()
+			return allowed, wait, l.changeCh
+		}()
+		if allowed {
+			return nil
+		}
+
+		// With no timer, only a settings change can allow
+		// flushes.
+		var timer *time.Timer
+		var timerCh <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timerCh = timer.C
+		}
+		select {
+		case <-changeCh:
+		case <-timerCh:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+	}
+}
+
+func (l *BandwidthLimiter) getRateLimiters(tlfID tlf.ID) (
+	global, tlf bandwidthRateLimiters) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.global, l.tlfs[tlfID]
+}
+
+// waitForUpload blocks until n bytes may be uploaded for the given
+// TLF, or until ctx is canceled.
+func (l *BandwidthLimiter) waitForUpload(
+	ctx context.Context, tlfID tlf.ID, n int) error {
+	if l == nil {
+		return nil
+	}
+	global, tlf := l.getRateLimiters(tlfID)
+	err := waitBandwidth(ctx, tlf.upload, n)
+	if err != nil {
+		return err
+	}
+	return waitBandwidth(ctx, global.upload, n)
+}
+
+// limitsDownloads returns whether background downloads for the given
+// TLF are limited at all.
+func (l *BandwidthLimiter) limitsDownloads(tlfID tlf.ID) bool {
+	if l == nil {
+		return false
+	}
+	global, tlf := l.getRateLimiters(tlfID)
+	return global.download != nil || tlf.download != nil
+}
+
+// waitForDownload blocks until n bytes may be downloaded in the
+// background for the given TLF, or until ctx is canceled.
+func (l *BandwidthLimiter) waitForDownload(
+	ctx context.Context, tlfID tlf.ID, n int) error {
+	if l == nil {
+		return nil
+	}
+	global, tlf := l.getRateLimiters(tlfID)
+	err := waitBandwidth(ctx, tlf.download, n)
+	if err != nil {
+		return err
+	}
+	return waitBandwidth(ctx, global.download, n)
+}
+
+// ctxBackgroundFlushKeyType is a type for the context key that marks
+// background journal flushes.
+type ctxBackgroundFlushKeyType int
+
+const (
+	// ctxBackgroundFlushKey is set in the context of background
+	// journal flushes, which have to stay within the flush schedule.
+	ctxBackgroundFlushKey ctxBackgroundFlushKeyType = iota
+)
+
+// errFlushWindowClosed is returned by background journal flushes
+// that stop because the flush schedule no longer allows them.
+type errFlushWindowClosed struct{}
+
+func (e errFlushWindowClosed) Error() string {
+	return "The flush schedule doesn't allow background flushes right now"
+}
+
+// checkFlushWindow returns errFlushWindowClosed if ctx belongs to a
+// background flush and the flush schedule doesn't allow it right
+// now.  It never blocks, so it's safe to call while holding journal
+// locks or flush scheduler slots.
+func (l *BandwidthLimiter) checkFlushWindow(ctx context.Context) error {
+	if ctx.Value(ctxBackgroundFlushKey) == nil || l.flushesAllowed() {
+		return nil
+	}
+	return errors.WithStack(errFlushWindowClosed{})
+}
+
+// throttledBlockServer is a BlockServer that waits for the
+// BandwidthLimiter before each put.  Puts from background flushes
+// fail with errFlushWindowClosed when the flush schedule doesn't
+// allow them, so that a flush that's under way stops at the end of a
+// window, or when metered mode is turned on, instead of blocking
+// while it holds the journal's flush locks.
+type throttledBlockServer struct {
+	BlockServer
+	limiter *BandwidthLimiter
+}
+
+var _ BlockServer = throttledBlockServer{}
+
+// Put implements the BlockServer interface for throttledBlockServer.
+func (b throttledBlockServer) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	err := b.limiter.checkFlushWindow(ctx)
+	if err != nil {
+		return err
+	}
+	err = b.limiter.waitForUpload(ctx, tlfID, len(buf))
+	if err != nil {
+		return err
+	}
+	return b.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
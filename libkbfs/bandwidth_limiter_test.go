@@ -0,0 +1,161 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestBandwidthScheduleParse(t *testing.T) {
+	schedule, err := ParseBandwidthSchedule("22:00-06:00, 12:30-13:00")
+	require.NoError(t, err)
+	require.Equal(t, BandwidthSchedule{
+		{22 * time.Hour, 6 * time.Hour},
+		{12*time.Hour + 30*time.Minute, 13 * time.Hour},
+	}, schedule)
+	require.Equal(t, "22:00-06:00,12:30-13:00", schedule.String())
+
+	schedule, err = ParseBandwidthSchedule("always")
+	require.NoError(t, err)
+	require.Len(t, schedule, 0)
+	require.Equal(t, "always", schedule.String())
+
+	for _, s := range []string{"22:00", "22:00-25:00", "1-2", "10:00-10:00"} {
+		_, err = ParseBandwidthSchedule(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestBandwidthScheduleUntilOpen(t *testing.T) {
+	schedule, err := ParseBandwidthSchedule("22:00-06:00,12:00-13:00")
+	require.NoError(t, err)
+	at := func(hour, min int) time.Time {
+		return time.Date(2017, 6, 1, hour, min, 0, 0, time.Local)
+	}
+
+	require.Equal(t, time.Duration(0), schedule.untilOpen(at(23, 0)))
+	require.Equal(t, time.Duration(0), schedule.untilOpen(at(3, 0)))
+	require.Equal(t, time.Duration(0), schedule.untilOpen(at(12, 0)))
+	require.Equal(t, 6*time.Hour, schedule.untilOpen(at(6, 0)))
+	require.Equal(t, 30*time.Minute, schedule.untilOpen(at(11, 30)))
+	require.Equal(t, 9*time.Hour, schedule.untilOpen(at(13, 0)))
+	require.Equal(t, time.Duration(0), BandwidthSchedule(nil).untilOpen(
+		at(13, 0)))
+}
+
+func TestBandwidthLimiterFlushWindow(t *testing.T) {
+	clock := newTestClockGetter()
+	clock.TestClock().Set(time.Date(2017, 6, 1, 15, 0, 0, 0, time.Local))
+	l := newBandwidthLimiter(clock)
+	ctx := context.Background()
+	tlfID := tlf.FakeID(1, false)
+
+	require.True(t, l.Status(tlfID).FlushesAllowed)
+	require.NoError(t, l.waitForFlushWindow(ctx))
+
+	// Outside of the schedule, flushes wait.
+	schedule, err := ParseBandwidthSchedule("22:00-06:00")
+	require.NoError(t, err)
+	l.SetSchedule(schedule)
+	status := l.Status(tlfID)
+	require.False(t, status.FlushesAllowed)
+	require.Equal(t, "22:00-06:00", status.Schedule)
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = l.waitForFlushWindow(waitCtx)
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+
+	// A metered connection holds them off until it's turned off.
+	l.SetSchedule(nil)
+	l.SetMetered(true)
+	require.False(t, l.Status(tlfID).FlushesAllowed)
+
+	// Background flushes that are already under way fail instead of
+	// waiting, but explicit ones don't.
+	bgCtx := context.WithValue(ctx, ctxBackgroundFlushKey, true)
+	err = l.checkFlushWindow(bgCtx)
+	require.IsType(t, errFlushWindowClosed{}, errors.Cause(err))
+	require.NoError(t, l.checkFlushWindow(ctx))
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- l.waitForFlushWindow(ctx)
+	}()
+	select {
+	case err := <-errCh:
+		t.Fatalf("Flush window opened while metered: %+v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	l.SetMetered(false)
+	require.NoError(t, <-errCh)
+	require.True(t, l.Status(tlfID).FlushesAllowed)
+
+	// A nil limiter allows everything.
+	var nilLimiter *BandwidthLimiter
+	require.NoError(t, nilLimiter.waitForFlushWindow(ctx))
+	require.NoError(t, nilLimiter.checkFlushWindow(
+		context.WithValue(ctx, ctxBackgroundFlushKey, true)))
+	require.NoError(t, nilLimiter.waitForUpload(ctx, tlfID, 1<<30))
+	require.True(t, nilLimiter.Status(tlfID).FlushesAllowed)
+}
+
+func TestBandwidthLimiterRates(t *testing.T) {
+	l := newBandwidthLimiter(newTestClockGetter())
+	ctx := context.Background()
+	tlfID := tlf.FakeID(1, false)
+	otherTlfID := tlf.FakeID(2, false)
+
+	err := l.SetLimits(BandwidthLimits{UploadBytesPerSecond: -1})
+	require.Error(t, err)
+	require.False(t, l.limitsDownloads(tlfID))
+
+	// The burst lets the first second's worth through right away,
+	// and the rest has to wait.
+	err = l.SetTLFLimits(tlfID, BandwidthLimits{
+		UploadBytesPerSecond:   200000,
+		DownloadBytesPerSecond: 200000,
+	})
+	require.NoError(t, err)
+	require.Equal(t, BandwidthLimits{
+		UploadBytesPerSecond:   200000,
+		DownloadBytesPerSecond: 200000,
+	}, l.Status(tlfID).TLFLimits)
+	require.True(t, l.limitsDownloads(tlfID))
+	require.False(t, l.limitsDownloads(otherTlfID))
+	start := time.Now()
+	err = l.waitForUpload(ctx, tlfID, 300000)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= 250*time.Millisecond)
+
+	// Other TLFs aren't limited.
+	start = time.Now()
+	err = l.waitForUpload(ctx, otherTlfID, 1<<30)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < 250*time.Millisecond)
+
+	// Waits can be canceled.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = l.waitForDownload(cancelCtx, tlfID, 1<<20)
+	require.Error(t, err)
+
+	// Zero limits remove a TLF's limits.
+	err = l.SetTLFLimits(tlfID, BandwidthLimits{})
+	require.NoError(t, err)
+	require.False(t, l.limitsDownloads(tlfID))
+
+	// Global limits apply to everyone.
+	err = l.SetLimits(BandwidthLimits{DownloadBytesPerSecond: 1000})
+	require.NoError(t, err)
+	require.True(t, l.limitsDownloads(otherTlfID))
+	require.Equal(t, BandwidthLimits{DownloadBytesPerSecond: 1000},
+		l.Limits())
+}
@@ -54,9 +54,16 @@ import (
 //           May be missing, but should be present when data is.
 //   - refs: The list of references to the block, along with other
 //           block-specific info, encoded as a serialized
-//           blockJournalInfo. May be missing.  TODO: rename this to
-//           something more generic if we ever upgrade the journal
-//           version.
+//           blockJournalInfo preceded by its checksum. May be
+//           missing.  TODO: rename this to something more generic if
+//           we ever upgrade the journal version.
+//
+// The id and refs files, which are overwritten in place, are written
+// atomically, so a crash leaves them either with their old contents
+// or their new ones.  The data and ksh files are only written once,
+// before the refs file that makes them reachable, so they're written
+// directly; a torn data file doesn't match the block ID, and a torn
+// ksh file can't be parsed.
 //
 // Future versions of the disk store might add more files to this
 // directory; if any code is written to move blocks around, it should
@@ -70,8 +77,10 @@ import (
 // blockDiskStore is not goroutine-safe, so any code that uses it must
 // guarantee that only one goroutine at a time calls its functions.
 type blockDiskStore struct {
-	codec kbfscodec.Codec
-	dir   string
+	codec           kbfscodec.Codec
+	dir             string
+	writeFile       fileWriter
+	writeFileAtomic fileWriter
 }
 
 // filesPerBlockMax is an upper bound for the number of files
@@ -84,8 +93,10 @@ const filesPerBlockMax = 7
 // directory.
 func makeBlockDiskStore(codec kbfscodec.Codec, dir string) *blockDiskStore {
 	return &blockDiskStore{
-		codec: codec,
-		dir:   dir,
+		codec:           codec,
+		dir:             dir,
+		writeFile:       ioutil.WriteFile,
+		writeFileAtomic: ioutil.WriteFileAtomic,
 	}
 }
 
@@ -132,7 +143,7 @@ func (s *blockDiskStore) makeDir(id kbfsblock.ID) error {
 
 	// TODO: Only write if the file doesn't exist.
 
-	return ioutil.WriteFile(s.idPath(id), []byte(id.String()), 0600)
+	return s.writeFileAtomic(s.idPath(id), []byte(id.String()), 0600)
 }
 
 // blockJournalInfo contains info about a particular block in the
@@ -149,7 +160,7 @@ type blockJournalInfo struct {
 // getRefInfo returns the references for the given ID.
 func (s *blockDiskStore) getInfo(id kbfsblock.ID) (blockJournalInfo, error) {
 	var info blockJournalInfo
-	err := kbfscodec.DeserializeFromFile(s.codec, s.infoPath(id), &info)
+	err := deserializeFromChecksummedFile(s.codec, s.infoPath(id), &info)
 	if !ioutil.IsNotExist(err) && err != nil {
 		return blockJournalInfo{}, err
 	}
@@ -163,7 +174,8 @@ func (s *blockDiskStore) getInfo(id kbfsblock.ID) (blockJournalInfo, error) {
 
 // putRefInfo stores the given references for the given ID.
 func (s *blockDiskStore) putInfo(id kbfsblock.ID, info blockJournalInfo) error {
-	return kbfscodec.SerializeToFile(s.codec, info, s.infoPath(id))
+	return serializeToChecksummedFile(
+		s.codec, s.writeFileAtomic, info, s.infoPath(id))
 }
 
 // addRefs adds references for the given contexts to the given ID, all
@@ -429,7 +441,7 @@ func (s *blockDiskStore) put(id kbfsblock.ID, context kbfsblock.Context,
 			return false, err
 		}
 
-		err = ioutil.WriteFile(s.dataPath(id), buf, 0600)
+		err = s.writeFile(s.dataPath(id), buf, 0600)
 		if err != nil {
 			return false, err
 		}
@@ -440,7 +452,7 @@ func (s *blockDiskStore) put(id kbfsblock.ID, context kbfsblock.Context,
 		if err != nil {
 			return false, err
 		}
-		err = ioutil.WriteFile(s.keyServerHalfPath(id), data, 0600)
+		err = s.writeFile(s.keyServerHalfPath(id), data, 0600)
 		if err != nil {
 			return false, err
 		}
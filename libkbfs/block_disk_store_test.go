@@ -14,6 +14,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -214,3 +215,70 @@ func TestBlockDiskStoreRemove(t *testing.T) {
 		})
 	require.NoError(t, err)
 }
+
+// TestBlockDiskStoreCrashDuringPut simulates a crash at each file
+// write done by a put, and checks that the put can be redone after a
+// restart.
+func TestBlockDiskStoreCrashDuringPut(t *testing.T) {
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	uid1 := keybase1.MakeTestUID(1)
+	bCtx := kbfsblock.MakeFirstContext(uid1, keybase1.BlockType_DATA)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+
+	// The files that are overwritten in place are written
+	// atomically, so only the data and ksh files can be torn.
+	for _, mode := range []crashMode{crashBeforeWrite, crashBeforeRename} {
+		for n := 0; ; n++ {
+			tempdir, s := setupBlockDiskStoreTest(t)
+			s.writeFile, s.writeFileAtomic =
+				crashingFileWriters(t, n, mode)
+			_, err := s.put(bID, bCtx, data, serverHalf, "")
+			crashed := err != nil
+			if crashed {
+				require.Equal(t, errSimulatedCrash, errors.Cause(err))
+			}
+
+			s = makeBlockDiskStore(s.codec, s.dir)
+			didPut, err := s.put(bID, bCtx, data, serverHalf, "")
+			require.NoError(t, err, "%s after %d writes", mode, n)
+			require.Equal(t, crashed, didPut)
+			getAndCheckBlockDiskData(t, s, bID, bCtx, data, serverHalf)
+
+			teardownBlockDiskStoreTest(t, tempdir)
+			if !crashed {
+				break
+			}
+		}
+	}
+}
+
+func TestBlockDiskStoreChecksum(t *testing.T) {
+	tempdir, s := setupBlockDiskStoreTest(t)
+	defer teardownBlockDiskStoreTest(t, tempdir)
+
+	data := []byte{1, 2, 3, 4}
+	bID, bCtx, _ := putBlockDisk(t, s, data)
+
+	// An info file written before checksums were added can still
+	// be read.
+	info, err := s.getInfo(bID)
+	require.NoError(t, err)
+	err = kbfscodec.SerializeToFile(s.codec, info, s.infoPath(bID))
+	require.NoError(t, err)
+	hasContext, err := s.hasContext(bID, bCtx)
+	require.NoError(t, err)
+	require.True(t, hasContext)
+
+	// But a checksummed one that's been cut short can't.
+	err = s.putInfo(bID, info)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadFile(s.infoPath(bID))
+	require.NoError(t, err)
+	err = ioutil.WriteFile(s.infoPath(bID), buf[:len(buf)-1], 0600)
+	require.NoError(t, err)
+	_, err = s.hasContext(bID, bCtx)
+	require.IsType(t, checksumMismatchError{}, errors.Cause(err))
+}
@@ -60,6 +60,8 @@ import (
 type blockJournal struct {
 	codec kbfscodec.Codec
 	dir   string
+	// crypter, if non-nil, encrypts the aggregate info.
+	crypter *localStorageCrypter
 
 	log      logger.Logger
 	deferLog logger.Logger
@@ -175,7 +177,7 @@ func deferredGCBlockJournalDir(dir string) string {
 // directory. Any existing journal entries are read.
 func makeBlockJournal(
 	ctx context.Context, codec kbfscodec.Codec, dir string,
-	log logger.Logger) (*blockJournal, error) {
+	crypter *localStorageCrypter, log logger.Logger) (*blockJournal, error) {
 	journalPath := blockJournalDir(dir)
 	deferLog := log.CloneWithAddedDepth(1)
 	j, err := makeDiskJournal(
@@ -195,6 +197,7 @@ func makeBlockJournal(
 	s := makeBlockDiskStore(codec, storeDir)
 	journal := &blockJournal{
 		codec:      codec,
+		crypter:    crypter,
 		dir:        dir,
 		log:        log,
 		deferLog:   deferLog,
@@ -204,7 +207,7 @@ func makeBlockJournal(
 	}
 
 	// Get initial aggregate info.
-	err = kbfscodec.DeserializeFromFile(
+	err = crypter.deserializeFromFile(
 		codec, aggregateInfoPath(dir), &journal.aggregateInfo)
 	if !ioutil.IsNotExist(err) && err != nil {
 		return nil, err
@@ -267,7 +270,7 @@ func (j *blockJournal) changeCounts(
 	saturateAdd(&j.aggregateInfo.StoredBytes, deltaStoredBytes)
 	saturateAdd(&j.aggregateInfo.StoredFiles, deltaStoredFiles)
 	saturateAdd(&j.aggregateInfo.UnflushedBytes, deltaUnflushedBytes)
-	return kbfscodec.SerializeToFile(
+	return j.crypter.serializeToFile(
 		j.codec, j.aggregateInfo, aggregateInfoPath(j.dir))
 }
 
@@ -694,7 +697,7 @@ func (j *blockJournal) getNextEntriesToFlush(
 			entries.puts.addNewBlock(
 				BlockPointer{ID: id, Context: bctx},
 				nil, /* only used by folderBranchOps */
-				ReadyBlockData{data, serverHalf}, nil)
+				ReadyBlockData{buf: data, serverHalf: serverHalf}, nil)
 
 		case addRefOp:
 			id, bctx, err := entry.getSingleContext()
@@ -117,7 +117,7 @@ func setupBlockJournalTest(t *testing.T) (
 		}
 	}()
 
-	j, err = makeBlockJournal(ctx, codec, tempdir, log)
+	j, err = makeBlockJournal(ctx, codec, tempdir, nil, log)
 	require.NoError(t, err)
 	require.Equal(t, uint64(0), j.length())
 
@@ -204,7 +204,7 @@ func TestBlockJournalBasic(t *testing.T) {
 	// Shutdown and restart.
 	err := j.checkInSyncForTest()
 	require.NoError(t, err)
-	j, err = makeBlockJournal(ctx, j.codec, tempdir, j.log)
+	j, err = makeBlockJournal(ctx, j.codec, tempdir, nil, j.log)
 	require.NoError(t, err)
 
 	require.Equal(t, uint64(2), j.length())
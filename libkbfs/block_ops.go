@@ -5,9 +5,12 @@
 package libkbfs
 
 import (
+	"fmt"
+
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
 )
 
@@ -20,6 +23,9 @@ type blockOpsConfig interface {
 	cryptoPureGetter
 	keyGetterGetter
 	diskBlockCacheGetter
+	blockEncodingGetter
+	metricsRegistryGetter
+	bandwidthLimiterGetter
 }
 
 // BlockOpsStandard implements the BlockOps interface by relaying
@@ -99,6 +105,27 @@ func (b *BlockOpsStandard) GetEncodedSize(ctx context.Context, kmd KeyMetadata,
 	return block.GetEncodedSize(), nil
 }
 
+// recordBlockCompression updates the compression metrics for the
+// given TLF, after a block of uncompressedSize bytes was encrypted
+// as plainSize bytes.  The ratio is the total uncompressed size of
+// all blocks readied so far, over their total stored size.
+func recordBlockCompression(r metrics.Registry, tlfID tlf.ID,
+	uncompressedSize, plainSize int) {
+	if r == nil {
+		return
+	}
+	prefix := fmt.Sprintf("BlockCompression.%s.", tlfID)
+	uncompressed := metrics.GetOrRegisterCounter(
+		prefix+"UncompressedBytes", r)
+	stored := metrics.GetOrRegisterCounter(prefix+"StoredBytes", r)
+	ratio := metrics.GetOrRegisterGaugeFloat64(prefix+"Ratio", r)
+	uncompressed.Inc(int64(uncompressedSize))
+	stored.Inc(int64(plainSize))
+	if s := stored.Count(); s > 0 {
+		ratio.Update(float64(uncompressed.Count()) / float64(s))
+	}
+}
+
 // Ready implements the BlockOps interface for BlockOpsStandard.
 func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 	block Block) (id kbfsblock.ID, plainSize int, readyBlockData ReadyBlockData,
@@ -126,9 +153,29 @@ func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 	}
 
 	blockKey := kbfscrypto.UnmaskBlockCryptKey(serverHalf, tlfCryptKey)
-	plainSize, encryptedBlock, err := crypto.EncryptBlock(block, blockKey)
-	if err != nil {
-		return
+	params := BlockEncryptionParams{
+		Compress: b.config.BlockCompression(),
+		Version:  b.config.BlockEncryptionVer(),
+	}
+	var encryptedBlock EncryptedBlock
+	compressed := false
+	if params.Compress || params.Version != EncryptionSecretbox {
+		var uncompressedSize int
+		plainSize, uncompressedSize, encryptedBlock, err =
+			crypto.EncryptBlockWithParams(block, blockKey, params)
+		if err != nil {
+			return
+		}
+		compressed = plainSize < uncompressedSize
+		if params.Compress {
+			recordBlockCompression(b.config.MetricsRegistry(),
+				kmd.TlfID(), uncompressedSize, plainSize)
+		}
+	} else {
+		plainSize, encryptedBlock, err = crypto.EncryptBlock(block, blockKey)
+		if err != nil {
+			return
+		}
 	}
 
 	buf, err := b.config.Codec().Encode(encryptedBlock)
@@ -139,6 +186,7 @@ func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 	readyBlockData = ReadyBlockData{
 		buf:        buf,
 		serverHalf: serverHalf,
+		minDataVer: params.minDataVer(compressed),
 	}
 
 	encodedSize := readyBlockData.GetEncodedSize()
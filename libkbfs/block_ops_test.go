@@ -17,6 +17,7 @@ import (
 	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
@@ -84,10 +85,17 @@ type testBlockOpsConfig struct {
 	cp      cryptoPure
 	cache   BlockCache
 	diskBlockCacheGetter
+	compress bool
+	encVer   EncryptionVer
+	registry metrics.Registry
 }
 
 var _ blockOpsConfig = (*testBlockOpsConfig)(nil)
 
+func (config testBlockOpsConfig) BandwidthLimiter() *BandwidthLimiter {
+	return nil
+}
+
 func (config testBlockOpsConfig) BlockServer() BlockServer {
 	return config.bserver
 }
@@ -108,6 +116,18 @@ func (config testBlockOpsConfig) DataVersion() DataVer {
 	return ChildHolesDataVer
 }
 
+func (config testBlockOpsConfig) BlockCompression() bool {
+	return config.compress
+}
+
+func (config testBlockOpsConfig) BlockEncryptionVer() EncryptionVer {
+	return config.encVer
+}
+
+func (config testBlockOpsConfig) MetricsRegistry() metrics.Registry {
+	return config.registry
+}
+
 func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	lm := newTestLogMaker(t)
 	codecGetter := newTestCodecGetter()
@@ -115,7 +135,9 @@ func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	crypto := MakeCryptoCommon(codecGetter.Codec())
 	cache := NewBlockCacheStandard(10, getDefaultCleanBlockCacheCapacity())
 	dbcg := newTestDiskBlockCacheGetter(t, nil)
-	return testBlockOpsConfig{codecGetter, lm, bserver, crypto, cache, dbcg}
+	return testBlockOpsConfig{
+		codecGetter, lm, bserver, crypto, cache, dbcg, false,
+		EncryptionSecretbox, nil}
 }
 
 // TestBlockOpsReadySuccess checks that BlockOpsStandard.Ready()
@@ -161,6 +183,112 @@ func TestBlockOpsReadySuccess(t *testing.T) {
 	require.Equal(t, block, decryptedBlock)
 }
 
+// TestBlockOpsReadyCompressed checks that BlockOpsStandard.Ready()
+// compresses blocks when configured to, but only when that saves
+// space, and records the compression ratio for the TLF.
+func TestBlockOpsReadyCompressed(t *testing.T) {
+	config := makeTestBlockOpsConfig(t)
+	config.compress = true
+	config.registry = metrics.NewRegistry()
+	bops := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize)
+	defer bops.Shutdown()
+
+	tlfID := tlf.FakeID(0, false)
+	var latestKeyGen KeyGen = 5
+	kmd := makeFakeKeyMetadata(tlfID, latestKeyGen)
+	blockCryptKey := func(readyBlockData ReadyBlockData) kbfscrypto.BlockCryptKey {
+		return kbfscrypto.UnmaskBlockCryptKey(
+			readyBlockData.serverHalf,
+			kmd.keys[latestKeyGen-FirstValidKeyGen])
+	}
+
+	block := &FileBlock{}
+	for i := 0; i < 1000; i++ {
+		block.Contents = append(block.Contents,
+			[]byte(fmt.Sprintf("line %d of a very compressible file\n", i))...)
+	}
+	encodedBlock, err := config.Codec().Encode(block)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	id, compressedSize, readyBlockData, err := bops.Ready(ctx, kmd, block)
+	require.NoError(t, err)
+	require.Equal(t, CompressedBlocksDataVer, readyBlockData.minDataVer)
+	require.True(t, compressedSize < len(encodedBlock))
+	require.True(t, readyBlockData.GetEncodedSize() < len(encodedBlock))
+
+	err = kbfsblock.VerifyID(readyBlockData.buf, id)
+	require.NoError(t, err)
+
+	var encryptedBlock EncryptedBlock
+	err = config.Codec().Decode(readyBlockData.buf, &encryptedBlock)
+	require.NoError(t, err)
+	decryptedBlock := &FileBlock{}
+	err = config.cryptoPure().DecryptBlock(
+		encryptedBlock, blockCryptKey(readyBlockData), decryptedBlock)
+	require.NoError(t, err)
+	decryptedBlock.SetEncodedSize(uint32(readyBlockData.GetEncodedSize()))
+	require.Equal(t, block, decryptedBlock)
+
+	prefix := fmt.Sprintf("BlockCompression.%s.", tlfID)
+	ratio := config.registry.Get(prefix + "Ratio").(metrics.GaugeFloat64)
+	require.True(t, ratio.Value() > 1)
+
+	// Random data doesn't compress, so it's left alone.
+	block = &FileBlock{Contents: make([]byte, 1000)}
+	err = kbfscrypto.RandRead(block.Contents)
+	require.NoError(t, err)
+	encodedBlock, err = config.Codec().Encode(block)
+	require.NoError(t, err)
+	_, plainSize, readyBlockData, err := bops.Ready(ctx, kmd, block)
+	require.NoError(t, err)
+	require.Equal(t, FirstValidDataVer, readyBlockData.minDataVer)
+	require.Equal(t, len(encodedBlock), plainSize)
+	stored := config.registry.Get(prefix + "StoredBytes").(metrics.Counter)
+	require.Equal(t, int64(compressedSize+plainSize), stored.Count())
+}
+
+// TestBlockOpsReadyChunkedEncryption checks that
+// BlockOpsStandard.Ready() encrypts blocks with the configured
+// encryption version, and requires a new enough DataVer for them.
+func TestBlockOpsReadyChunkedEncryption(t *testing.T) {
+	config := makeTestBlockOpsConfig(t)
+	config.encVer = EncryptionXChaCha20Poly1305
+	bops := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize)
+	defer bops.Shutdown()
+
+	tlfID := tlf.FakeID(0, false)
+	var latestKeyGen KeyGen = 5
+	kmd := makeFakeKeyMetadata(tlfID, latestKeyGen)
+
+	block := &FileBlock{
+		Contents: []byte{1, 2, 3, 4, 5},
+	}
+
+	ctx := context.Background()
+	id, _, readyBlockData, err := bops.Ready(ctx, kmd, block)
+	require.NoError(t, err)
+	require.Equal(t, ChunkedEncryptionDataVer, readyBlockData.minDataVer)
+
+	var encryptedBlock EncryptedBlock
+	err = config.Codec().Decode(readyBlockData.buf, &encryptedBlock)
+	require.NoError(t, err)
+	require.Equal(t, EncryptionXChaCha20Poly1305, encryptedBlock.Version)
+
+	bCtx := kbfsblock.MakeFirstContext(
+		keybase1.MakeTestUID(1), keybase1.BlockType_DATA)
+	err = config.bserver.Put(ctx, tlfID, id, bCtx,
+		readyBlockData.buf, readyBlockData.serverHalf)
+	require.NoError(t, err)
+
+	decryptedBlock := &FileBlock{}
+	err = bops.Get(ctx, kmd,
+		BlockPointer{ID: id, KeyGen: latestKeyGen, Context: bCtx},
+		decryptedBlock, NoCacheEntry)
+	require.NoError(t, err)
+	require.Equal(t, block, decryptedBlock)
+}
+
 // TestBlockOpsReadyFailKeyGet checks that BlockOpsStandard.Ready()
 // fails properly if we fail to retrieve the key.
 func TestBlockOpsReadyFailKeyGet(t *testing.T) {
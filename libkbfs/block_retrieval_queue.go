@@ -27,6 +27,7 @@ type blockRetrievalPartialConfig interface {
 	logMaker
 	blockCacher
 	diskBlockCacheGetter
+	bandwidthLimiterGetter
 }
 
 type blockRetrievalConfig interface {
@@ -42,6 +42,10 @@ func (c testBlockRetrievalConfig) DataVersion() DataVer {
 	return ChildHolesDataVer
 }
 
+func (c testBlockRetrievalConfig) BandwidthLimiter() *BandwidthLimiter {
+	return nil
+}
+
 func (c testBlockRetrievalConfig) blockGetter() blockGetter {
 	return c.bg
 }
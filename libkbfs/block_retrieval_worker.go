@@ -6,6 +6,8 @@ package libkbfs
 
 import (
 	"io"
+
+	"golang.org/x/net/context"
 )
 
 // blockRetrievalWorker processes blockRetrievalQueue requests
@@ -59,9 +61,18 @@ func (brw *blockRetrievalWorker) HandleRequest() (err error) {
 		return io.EOF
 	}
 
+	// Retrievals below on-demand priority are prefetches, which
+	// count against the background download limits.
+	brw.queue.mtx.RLock()
+	background := retrieval.priority < defaultOnDemandRequestPriority
+	brw.queue.mtx.RUnlock()
+
 	var block Block
 	defer func() {
 		brw.queue.FinalizeRequest(retrieval, block, err)
+		if err == nil && background {
+			brw.throttleDownload(retrieval.kmd, block)
+		}
 	}()
 
 	// Handle canceled contexts.
@@ -80,6 +91,31 @@ func (brw *blockRetrievalWorker) HandleRequest() (err error) {
 	return brw.getBlock(retrieval.ctx, retrieval.kmd, retrieval.blockPtr, block)
 }
 
+// throttleDownload waits until the bandwidth limiter allows the bytes
+// of the given block to be downloaded in the background, so that this
+// worker doesn't retrieve anything else too soon.  The requestors
+// have already been given the block by then, so it's only the
+// following retrievals that are delayed.
+func (brw *blockRetrievalWorker) throttleDownload(
+	kmd KeyMetadata, block Block) {
+	limiter := brw.queue.config.BandwidthLimiter()
+	if !limiter.limitsDownloads(kmd.TlfID()) {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-brw.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// The only error is a cancellation due to shutdown.
+	_ = limiter.waitForDownload(
+		ctx, kmd.TlfID(), int(block.GetEncodedSize()))
+}
+
 // Shutdown shuts down the blockRetrievalWorker once its current work is done.
 func (brw *blockRetrievalWorker) Shutdown() {
 	select {
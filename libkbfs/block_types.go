@@ -47,6 +47,11 @@ type IndirectFilePtr struct {
 type CommonBlock struct {
 	// IsInd indicates where this block is so big it requires indirect pointers
 	IsInd bool `codec:"s"`
+	// Compressed, if non-nil, holds the flate-compressed encoding
+	// of the whole block, and every other field is empty.  It is
+	// only ever set between encoding and encryption, or between
+	// decryption and decoding; blocks in memory never have it set.
+	Compressed []byte `codec:"z,omitempty"`
 
 	codec.UnknownFieldSetHandler
 
@@ -155,6 +155,7 @@ func makeFakeDirBlockFuture(t *testing.T) *dirBlockFuture {
 		dirBlockCurrent{
 			CommonBlock{
 				true,
+				nil,
 				codec.UnknownFieldSetHandler{},
 				sync.RWMutex{},
 				0,
@@ -225,6 +226,7 @@ func makeFakeFileBlockFuture(t *testing.T) *fileBlockFuture {
 		fileBlockCurrent{
 			CommonBlock{
 				false,
+				nil,
 				codec.UnknownFieldSetHandler{},
 				sync.RWMutex{},
 				0,
@@ -22,6 +22,21 @@ func isRecoverableBlockError(err error) bool {
 	return isArchiveError || isDeleteError || isRefError || isMaxExceededError
 }
 
+// noDedupBlockCache wraps a BlockCache, but never reports any known
+// pointers for file blocks.  Blocks readied with it are always
+// treated as brand new blocks, rather than as new references to
+// existing blocks.
+type noDedupBlockCache struct {
+	BlockCache
+}
+
+// CheckForKnownPtr implements the BlockCache interface for
+// noDedupBlockCache.
+func (noDedupBlockCache) CheckForKnownPtr(
+	_ tlf.ID, _ *FileBlock) (BlockPointer, error) {
+	return BlockPointer{}, nil
+}
+
 // putBlockToServer either puts the full block to the block server, or
 // just adds a reference, depending on the refnonce in blockPtr.
 func putBlockToServer(ctx context.Context, bserv BlockServer, tlfID tlf.ID,
@@ -0,0 +1,205 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/kbfs/kbfscodec"
+)
+
+// cdcGearWindow is the number of trailing bytes that affect the
+// top bits of the gear hash, since each byte shifts the hash left by
+// one.
+const cdcGearWindow = 64
+
+// cdcGearTable maps each byte value to a pseudo-random 64-bit value
+// for the gear hash.  It must never change, since block boundaries
+// (and hence block IDs) computed by different devices and different
+// versions of KBFS need to match for deduplication to work.
+var cdcGearTable = func() (table [256]uint64) {
+	// splitmix64, with a fixed seed.
+	x := uint64(0x6b62667363646321)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// BlockSplitterCDC implements the BlockSplitter interface using
+// content-defined chunking: a block ends wherever a rolling (gear)
+// hash of the preceding bytes matches a fixed pattern, subject to a
+// minimum and maximum block size.  Since the boundaries depend only
+// on nearby content, inserting or removing bytes in a file only
+// changes the blocks around the edit, and the rest of the file keeps
+// the same block IDs as its previous revision.
+type BlockSplitterCDC struct {
+	minSize int64
+	maxSize int64
+	// A boundary falls after any byte where the hash has all of
+	// these (top) bits clear.
+	mask                    uint64
+	maxPtrsPerBlock         int
+	blockChangeEmbedMaxSize uint64
+}
+
+var _ BlockSplitter = (*BlockSplitterCDC)(nil)
+
+// newBlockSplitterCDCWithSizes makes a BlockSplitterCDC whose blocks
+// are between minSize and maxSize bytes, averaging roughly halfway
+// between the two.
+func newBlockSplitterCDCWithSizes(minSize, maxSize int64, maxPtrs int,
+	blockChangeEmbedMaxSize uint64) *BlockSplitterCDC {
+	bits := uint(0)
+	for int64(1)<<(bits+1) <= (maxSize-minSize)/2 {
+		bits++
+	}
+	var mask uint64
+	if bits > 0 {
+		mask = ((uint64(1) << bits) - 1) << (64 - bits)
+	}
+	return &BlockSplitterCDC{
+		minSize:                 minSize,
+		maxSize:                 maxSize,
+		mask:                    mask,
+		maxPtrsPerBlock:         maxPtrs,
+		blockChangeEmbedMaxSize: blockChangeEmbedMaxSize,
+	}
+}
+
+// NewBlockSplitterCDC creates a new BlockSplitterCDC whose maximum
+// block size is adjusted, as in NewBlockSplitterSimple, to match the
+// desired size for encoded file blocks.  The minimum block size is a
+// quarter of the maximum.
+func NewBlockSplitterCDC(desiredBlockSize int64,
+	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec) (
+	*BlockSplitterCDC, error) {
+	maxSize, maxPtrs, err := getMaxBlockSizes(desiredBlockSize, codec)
+	if err != nil {
+		return nil, err
+	}
+	minSize := maxSize / 4
+	if minSize < 1 {
+		return nil, fmt.Errorf("Desired block size %d is too small for "+
+			"content-defined chunking", desiredBlockSize)
+	}
+	return newBlockSplitterCDCWithSizes(
+		minSize, maxSize, maxPtrs, blockChangeEmbedMaxSize), nil
+}
+
+// chunkEnd returns the length of the first chunk of a block whose
+// bytes are given by byteAt, scanning for a boundary no earlier than
+// `from` and no later than `end`.  It returns 0 if no boundary is
+// found.  The hash after any byte only depends on the cdcGearWindow
+// bytes up to it, so its state at `from` is rolled up from just
+// those bytes, and nothing before them is scanned again.
+func (b *BlockSplitterCDC) chunkEnd(
+	byteAt func(int64) byte, from, end int64) int64 {
+	if from < b.minSize {
+		from = b.minSize
+	}
+	start := from - cdcGearWindow
+	if start < 0 {
+		start = 0
+	}
+	var h uint64
+	for i := start; i < end; i++ {
+		h = (h << 1) + cdcGearTable[byteAt(i)]
+		if i+1 >= from && h&b.mask == 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// CopyUntilSplit implements the BlockSplitter interface for
+// BlockSplitterCDC.
+func (b *BlockSplitterCDC) CopyUntilSplit(
+	block *FileBlock, lastBlock bool, data []byte, off int64) int64 {
+	n := int64(len(data))
+	currLen := int64(len(block.Contents))
+	if currLen > b.maxSize || off > b.maxSize {
+		// If it is already over maxSize w/o any added bytes, just
+		// give up.
+		return 0
+	}
+
+	end := off + n
+	if end > b.maxSize {
+		end = b.maxSize
+	}
+	if end > currLen && lastBlock {
+		// This write extends the file, so it should stop at the next
+		// boundary.  Bytes before the write (or the old end of the
+		// block, whichever is first) were already checked when they
+		// were written.  Writes that fill a hole in the middle of
+		// the file just fill up the block, like BlockSplitterSimple.
+		byteAt := func(i int64) byte {
+			switch {
+			case i >= off:
+				return data[i-off]
+			case i < currLen:
+				return block.Contents[i]
+			default:
+				// In a hole between the block and the write.
+				return 0
+			}
+		}
+		from := off
+		if currLen < from {
+			from = currLen
+		}
+		if chunkEnd := b.chunkEnd(byteAt, from, end); chunkEnd > 0 {
+			if chunkEnd < off || chunkEnd < currLen {
+				// Don't extend the block past a boundary that comes
+				// before the new data; anything after it belongs in
+				// the next block.
+				end = currLen
+			} else {
+				end = chunkEnd
+			}
+		}
+	}
+	if end > currLen {
+		block.Contents = append(
+			block.Contents, make([]byte, end-currLen)...)
+	}
+
+	if off >= end {
+		return 0
+	}
+	toCopy := end - off
+	copy(block.Contents[off:end], data[:toCopy])
+	return toCopy
+}
+
+// CheckSplit implements the BlockSplitter interface for
+// BlockSplitterCDC.  Boundaries are only chosen as data is appended
+// to a file, in CopyUntilSplit, so files written from start to end
+// (as most editors do when saving) are always cut at their content
+// boundaries.  Blocks are never re-split after an overwrite in the
+// middle of a file: that only costs some deduplication for the
+// blocks around the edit, while moving bytes between blocks would
+// re-dirty the rest of the file.
+func (b *BlockSplitterCDC) CheckSplit(block *FileBlock) int64 {
+	return 0
+}
+
+// MaxPtrsPerBlock implements the BlockSplitter interface for
+// BlockSplitterCDC.
+func (b *BlockSplitterCDC) MaxPtrsPerBlock() int {
+	return b.maxPtrsPerBlock
+}
+
+// ShouldEmbedBlockChanges implements the BlockSplitter interface for
+// BlockSplitterCDC.
+func (b *BlockSplitterCDC) ShouldEmbedBlockChanges(
+	bc *BlockChanges) bool {
+	return bc.SizeEstimate() <= b.blockChangeEmbedMaxSize
+}
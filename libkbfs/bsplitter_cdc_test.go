@@ -0,0 +1,135 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/require"
+)
+
+// cdcSplitAll splits data into blocks by appending it in small
+// writes, the way file_data.write does for a new file.
+func cdcSplitAll(t *testing.T, bsplit *BlockSplitterCDC,
+	data []byte) (blocks [][]byte) {
+	block := NewFileBlock().(*FileBlock)
+	for len(data) > 0 {
+		toWrite := data
+		if len(toWrite) > 10 {
+			toWrite = toWrite[:10]
+		}
+		n := bsplit.CopyUntilSplit(
+			block, true, toWrite, int64(len(block.Contents)))
+		require.True(t, int64(len(block.Contents)) <= bsplit.maxSize)
+		data = data[n:]
+		if n < int64(len(toWrite)) {
+			blocks = append(blocks, block.Contents)
+			block = NewFileBlock().(*FileBlock)
+		}
+	}
+	return append(blocks, block.Contents)
+}
+
+func TestBsplitterCDCBoundariesFollowContent(t *testing.T) {
+	bsplit := newBlockSplitterCDCWithSizes(64, 256, 10, 10)
+	data := make([]byte, 16*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	blocks := cdcSplitAll(t, bsplit, data)
+	for _, b := range blocks[:len(blocks)-1] {
+		require.True(t, int64(len(b)) >= bsplit.minSize)
+		require.Equal(t, int64(0),
+			bsplit.CheckSplit(&FileBlock{Contents: b}))
+	}
+
+	// Inserting a byte only changes the blocks near the insertion.
+	newData := append([]byte{}, data[:1000]...)
+	newData = append(newData, 0)
+	newData = append(newData, data[1000:]...)
+	newBlocks := cdcSplitAll(t, bsplit, newData)
+	oldBlocks := make(map[string]bool)
+	for _, b := range blocks {
+		oldBlocks[string(b)] = true
+	}
+	changed := 0
+	for _, b := range newBlocks {
+		if !oldBlocks[string(b)] {
+			changed++
+		}
+	}
+	require.True(t, changed <= 3, "%d of %d blocks changed",
+		changed, len(newBlocks))
+}
+
+func TestBsplitterCDCWriteSizes(t *testing.T) {
+	bsplit := newBlockSplitterCDCWithSizes(64, 256, 10, 10)
+	data := make([]byte, 4*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	blocks := cdcSplitAll(t, bsplit, data)
+
+	// Appending in big writes finds the same boundaries as appending
+	// in small ones.
+	var bigBlocks [][]byte
+	for len(data) > 0 {
+		block := NewFileBlock().(*FileBlock)
+		n := bsplit.CopyUntilSplit(block, true, data, 0)
+		bigBlocks = append(bigBlocks, block.Contents)
+		data = data[n:]
+	}
+	require.Equal(t, blocks, bigBlocks)
+
+	// An append after a hole at the end of the block scans the
+	// hole as zeros.
+	block := &FileBlock{Contents: make([]byte, 100)}
+	n := bsplit.CopyUntilSplit(block, true, blocks[0], 150)
+	require.True(t, n > 0)
+	require.Equal(t, blocks[0][:n], block.Contents[150:])
+}
+
+func TestBsplitterCDCFillHole(t *testing.T) {
+	bsplit := newBlockSplitterCDCWithSizes(64, 256, 10, 10)
+	data := make([]byte, 1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	blocks := cdcSplitAll(t, bsplit, data)
+	require.True(t, len(blocks) > 2)
+
+	// A block followed by a hole fills up to the maximum size,
+	// ignoring content boundaries.
+	fblock := NewFileBlock().(*FileBlock)
+	n := bsplit.CopyUntilSplit(fblock, false, data, 0)
+	require.Equal(t, bsplit.maxSize, n)
+	require.Equal(t, data[:n], fblock.Contents)
+
+	// Blocks are never re-split.
+	joined := append(append([]byte{}, blocks[0]...), blocks[1]...)
+	require.Equal(t, int64(0),
+		bsplit.CheckSplit(&FileBlock{Contents: joined}))
+}
+
+func TestBsplitterCDCOverwriteMiddle(t *testing.T) {
+	bsplit := newBlockSplitterCDCWithSizes(64, 256, 10, 10)
+	fblock := NewFileBlock().(*FileBlock)
+	fblock.Contents = make([]byte, 100)
+	data := []byte{1, 2, 3, 4, 5}
+
+	// Writes that don't extend the block are copied in full.
+	n := bsplit.CopyUntilSplit(fblock, false, data, 10)
+	require.Equal(t, int64(len(data)), n)
+	require.Len(t, fblock.Contents, 100)
+	require.Equal(t, data, fblock.Contents[10:15])
+}
+
+func TestNewBlockSplitterCDC(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	bsplit, err := NewBlockSplitterCDC(MaxBlockSizeBytesDefault, 8*1024,
+		codec)
+	require.NoError(t, err)
+	simple, err := NewBlockSplitterSimple(MaxBlockSizeBytesDefault,
+		8*1024, codec)
+	require.NoError(t, err)
+	require.Equal(t, simple.maxSize, bsplit.maxSize)
+	require.Equal(t, bsplit.maxSize/4, bsplit.minSize)
+}
@@ -18,13 +18,13 @@ type BlockSplitterSimple struct {
 	blockChangeEmbedMaxSize uint64
 }
 
-// NewBlockSplitterSimple creates a new BlockSplittleSimple and
-// adjusts the max size to try to match the desired size for file
-// blocks, given the overhead of encoding a file block and the
-// round-up padding we do.
-func NewBlockSplitterSimple(desiredBlockSize int64,
-	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec) (
-	*BlockSplitterSimple, error) {
+// getMaxBlockSizes returns the max number of bytes of contents to
+// put in a file block so that its encoded size matches the desired
+// size for file blocks, given the overhead of encoding a file block
+// and the round-up padding we do.  It also returns the max number of
+// pointers to put in an indirect block.
+func getMaxBlockSizes(desiredBlockSize int64, codec kbfscodec.Codec) (
+	maxSize int64, maxPtrs int, err error) {
 	// If the desired block size is exactly a power of 2, subtract one
 	// from it to account for the padding we will do, which rounds up
 	// when the encoded size is exactly a power of 2.
@@ -42,7 +42,7 @@ func NewBlockSplitterSimple(desiredBlockSize int64,
 		fullData[i] = byte(i)
 	}
 
-	maxSize := desiredBlockSize
+	maxSize = desiredBlockSize
 	var encodedLen int64
 	// Iterate until we find the right size (up to a maximum number of
 	// attempts), because the overhead is not constant across
@@ -52,12 +52,12 @@ func NewBlockSplitterSimple(desiredBlockSize int64,
 		block.Contents = fullData[:maxSize]
 		encodedBlock, err := codec.Encode(block)
 		if err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 
 		encodedLen = int64(len(encodedBlock))
 		if encodedLen >= 2*desiredBlockSize {
-			return nil, fmt.Errorf("Encoded block of %d bytes is more than "+
+			return 0, 0, fmt.Errorf("Encoded block of %d bytes is more than "+
 				"twice as big as the desired block size %d",
 				encodedLen, desiredBlockSize)
 		}
@@ -70,7 +70,7 @@ func NewBlockSplitterSimple(desiredBlockSize int64,
 	}
 
 	if encodedLen != desiredBlockSize {
-		return nil, fmt.Errorf("Couldn't converge on a max block size for a "+
+		return 0, 0, fmt.Errorf("Couldn't converge on a max block size for a "+
 			"desired size of %d", desiredBlockSize)
 	}
 
@@ -78,11 +78,26 @@ func NewBlockSplitterSimple(desiredBlockSize int64,
 	// the number of realistic indirect pointers you can fit into the
 	// default block size.  TODO: calculate this number more exactly
 	// during initialization for a given `maxSize`.
-	maxPtrs := int(.75 * float64(maxSize/int64(bpSize)))
+	maxPtrs = int(.75 * float64(maxSize/int64(bpSize)))
 	if maxPtrs < 2 {
 		maxPtrs = 2
 	}
 
+	return maxSize, maxPtrs, nil
+}
+
+// NewBlockSplitterSimple creates a new BlockSplittleSimple and
+// adjusts the max size to try to match the desired size for file
+// blocks, given the overhead of encoding a file block and the
+// round-up padding we do.
+func NewBlockSplitterSimple(desiredBlockSize int64,
+	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec) (
+	*BlockSplitterSimple, error) {
+	maxSize, maxPtrs, err := getMaxBlockSizes(desiredBlockSize, codec)
+	if err != nil {
+		return nil, err
+	}
+
 	return &BlockSplitterSimple{
 		maxSize:                 maxSize,
 		maxPtrsPerBlock:         maxPtrs,
@@ -0,0 +1,111 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+)
+
+// fileWriter writes data to the file at the given path, like
+// ioutil.WriteFile.  Files that are overwritten in place use
+// ioutil.WriteFileAtomic instead, so a crash never leaves part of
+// data in them.  Tests that simulate crashes partway through a write
+// swap these out.
+type fileWriter func(path string, data []byte, perm os.FileMode) error
+
+// checksummedFileMagic starts every file written by
+// serializeToChecksummedFile, and is followed by the SHA-256 hash of
+// the encoded object that makes up the rest of the file.  0xc1 is
+// never used in msgpack, so files written before checksums were
+// added, which are plain msgpack, can still be told apart and read
+// without being checked.
+//
+// Versions of KBFS from before checksums were added can't read
+// checksummed files, and fail to open any journal that has them.
+// Since journals aren't versioned, there's no way to refuse a
+// downgrade up front; instead, journals have to be completely
+// flushed before downgrading, which removes all of their entries and
+// blocks.  The last byte of the magic is the version of this
+// format, to allow for changing it.
+var checksummedFileMagic = []byte{0xc1, 'k', 'c', 1}
+
+// checksumMismatchError is returned when a checksummed file doesn't
+// match its checksum, usually because a crash interrupted a write to
+// it that wasn't atomic.
+type checksumMismatchError struct {
+	path string
+}
+
+func (e checksumMismatchError) Error() string {
+	return fmt.Sprintf("Checksum mismatch for %q", e.path)
+}
+
+// serializeToChecksummedFile is like kbfscodec.SerializeToFile,
+// except that it prepends a checksum to the file and writes it with
+// writeFile.
+func serializeToChecksummedFile(codec kbfscodec.Codec,
+	writeFile fileWriter, obj interface{}, path string) error {
+	err := ioutil.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	buf, err := codec.Encode(obj)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(buf)
+	data := make([]byte, 0, len(checksummedFileMagic)+len(sum)+len(buf))
+	data = append(data, checksummedFileMagic...)
+	data = append(data, sum[:]...)
+	data = append(data, buf...)
+	return writeFile(path, data, 0600)
+}
+
+// readChecksummedFile returns the encoded object in the given file,
+// after checking it against the file's checksum, if it has one. It
+// may return an error for which ioutil.IsNotExist() returns true.
+func readChecksummedFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, checksummedFileMagic) {
+		return data, nil
+	}
+	data = data[len(checksummedFileMagic):]
+	if len(data) < sha256.Size {
+		return nil, errors.WithStack(checksumMismatchError{path})
+	}
+	buf := data[sha256.Size:]
+	sum := sha256.Sum256(buf)
+	if !bytes.Equal(sum[:], data[:sha256.Size]) {
+		return nil, errors.WithStack(checksumMismatchError{path})
+	}
+	return buf, nil
+}
+
+// deserializeFromChecksummedFile is like
+// kbfscodec.DeserializeFromFile, except that it checks the file
+// against its checksum first.
+func deserializeFromChecksummedFile(
+	codec kbfscodec.Codec, path string, objPtr interface{}) error {
+	buf, err := readChecksummedFile(path)
+	if err != nil {
+		return err
+	}
+
+	return codec.Decode(buf, objPtr)
+}
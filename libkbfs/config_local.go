@@ -75,8 +75,15 @@ type ConfigLocal struct {
 	registry       metrics.Registry
 	loggerFn       func(prefix string) logger.Logger
 	noBGFlush      bool // logic opposite so the default value is the common setting
+	compressBlocks bool
+	blockEncVer    EncryptionVer
 	rwpWaitTime    time.Duration
 	diskLimiter    DiskLimiter
+	encryptLocal   bool
+	dbcPolicy      DiskCacheEvictionPolicy
+	dbcTLFLimit    int64
+	cacheFlushed   bool
+	bandwidth      *BandwidthLimiter
 
 	maxNameBytes uint32
 	maxDirBytes  uint64
@@ -249,6 +256,7 @@ func NewConfigLocal(mode InitMode, loggerFn func(module string) logger.Logger,
 	config.SetCodec(kbfscodec.NewMsgpack())
 	config.SetKeyOps(&KeyOpsStandard{config})
 	config.SetRekeyQueue(NewRekeyQueueStandard(config))
+	config.bandwidth = newBandwidthLimiter(config)
 
 	config.maxNameBytes = maxNameBytesDefault
 	config.maxDirBytes = maxDirBytesDefault
@@ -267,6 +275,7 @@ func NewConfigLocal(mode InitMode, loggerFn func(module string) logger.Logger,
 
 	config.tlfValidDuration = tlfValidDurationDefault
 	config.metadataVersion = defaultClientMetadataVer
+	config.blockEncVer = EncryptionSecretbox
 
 	return config
 }
@@ -638,7 +647,7 @@ func (c *ConfigLocal) SetMetadataVersion(mdVer MetadataVer) {
 
 // DataVersion implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) DataVersion() DataVer {
-	return AtLeastTwoLevelsOfChildrenDataVer
+	return ChunkedEncryptionDataVer
 }
 
 // DoBackgroundFlushes implements the Config interface for ConfigLocal.
@@ -661,6 +670,35 @@ func (c *ConfigLocal) SetDoBackgroundFlushes(doBGFlush bool) {
 	c.noBGFlush = !doBGFlush
 }
 
+// BlockCompression implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BlockCompression() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.compressBlocks
+}
+
+// SetBlockCompression implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetBlockCompression(compress bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.compressBlocks = compress
+}
+
+// BlockEncryptionVer implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BlockEncryptionVer() EncryptionVer {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.blockEncVer
+}
+
+// SetBlockEncryptionVer implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetBlockEncryptionVer(ver EncryptionVer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blockEncVer = ver
+}
+
 // RekeyWithPromptWaitTime implements the Config interface for
 // ConfigLocal.
 func (c *ConfigLocal) RekeyWithPromptWaitTime() time.Duration {
@@ -727,6 +765,76 @@ func (c *ConfigLocal) StorageRoot() string {
 	return c.storageRoot
 }
 
+// DiskCacheEvictionPolicy implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) DiskCacheEvictionPolicy() DiskCacheEvictionPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dbcPolicy
+}
+
+// SetDiskCacheEvictionPolicy implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetDiskCacheEvictionPolicy(
+	policy DiskCacheEvictionPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dbcPolicy = policy
+}
+
+// DiskCacheTLFLimit implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) DiskCacheTLFLimit() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dbcTLFLimit
+}
+
+// SetDiskCacheTLFLimit implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetDiskCacheTLFLimit(limit int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dbcTLFLimit = limit
+}
+
+// CacheFlushedJournalBlocks implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) CacheFlushedJournalBlocks() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cacheFlushed
+}
+
+// SetCacheFlushedJournalBlocks implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetCacheFlushedJournalBlocks(cacheFlushed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cacheFlushed = cacheFlushed
+}
+
+// BandwidthLimiter implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BandwidthLimiter() *BandwidthLimiter {
+	// The limiter is set once at construction, so there's no need
+	// to lock.
+	return c.bandwidth
+}
+
+// EncryptLocalStorage implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) EncryptLocalStorage() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.encryptLocal
+}
+
+// SetEncryptLocalStorage implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetEncryptLocalStorage(encrypt bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.encryptLocal = encrypt
+}
+
 func (c *ConfigLocal) resetCachesWithoutShutdown() DirtyBlockCache {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -1941,8 +1941,9 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 
 		fileActions := actionMap[p.tailPointer()]
 
-		// If this is a directory with setAttr(mtime)-related actions,
-		// just those action should be collapsed into the parent.
+		// If this is a directory with setAttr(mtime)- or
+		// setXattr-related actions, just those action should be
+		// collapsed into the parent.
 		if !chain.isFile() {
 			var parentActions crActionList
 			var otherDirActions crActionList
@@ -1950,7 +1951,11 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 				moved := false
 				switch realAction := action.(type) {
 				case *copyUnmergedAttrAction:
-					if realAction.attr[0] == mtimeAttr && !realAction.moved {
+					isDirAttr := len(realAction.xattrs) > 0 ||
+						(len(realAction.attr) > 0 &&
+							(realAction.attr[0] == mtimeAttr ||
+								realAction.attr[0] == lockIDAttr))
+					if isDirAttr && !realAction.moved {
 						realAction.moved = true
 						parentActions = append(parentActions, realAction)
 						moved = true
@@ -1968,8 +1973,8 @@ func collapseActions(unmergedChains *crChains, unmergedPaths []path,
 				}
 			}
 			if len(parentActions) == 0 {
-				// A directory with no mtime actions, so treat it
-				// normally.
+				// A directory with no mtime or xattr actions, so
+				// treat it normally.
 				continue
 			}
 			fileActions = parentActions
@@ -2415,12 +2420,22 @@ func (cr *ConflictResolver) makeRevertedOps(ctx context.Context,
 				}
 			} else {
 				op = chains.copyOpAndRevertUnrefsToOriginals(op)
-				// The dir of renamed setAttrOps must be reverted to
-				// the new parent's original pointer.
-				if sao, ok := op.(*setAttrOp); ok {
+				// The dir of renamed setAttrOps and setXattrOps
+				// must be reverted to the new parent's original
+				// pointer.
+				switch realOp := op.(type) {
+				case *setAttrOp:
+					if newDir, _, ok :=
+						otherChains.renamedParentAndName(realOp.File); ok {
+						err := realOp.Dir.setUnref(newDir)
+						if err != nil {
+							return nil, err
+						}
+					}
+				case *setXattrOp:
 					if newDir, _, ok :=
-						otherChains.renamedParentAndName(sao.File); ok {
-						err := sao.Dir.setUnref(newDir)
+						otherChains.renamedParentAndName(realOp.File); ok {
+						err := realOp.Dir.setUnref(newDir)
 						if err != nil {
 							return nil, err
 						}
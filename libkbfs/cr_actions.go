@@ -27,6 +27,7 @@ type copyUnmergedEntryAction struct {
 	unique        bool
 	unmergedEntry DirEntry
 	attr          []attrChange
+	xattrs        []string
 }
 
 func fixupNamesInOps(fromName string, toName string, ops []op,
@@ -35,9 +36,9 @@ func fixupNamesInOps(fromName string, toName string, ops []op,
 	for _, uop := range ops {
 		done := false
 		switch realOp := uop.(type) {
-		// The only names that matter are in createOps or
-		// setAttrOps.  rms on the unmerged side wouldn't be
-		// part of the unmerged entry
+		// The only names that matter are in createOps,
+		// setAttrOps or setXattrOps.  rms on the unmerged side
+		// wouldn't be part of the unmerged entry
 		case *createOp:
 			if realOp.NewName == fromName {
 				realOpCopy := *realOp
@@ -64,6 +65,13 @@ func fixupNamesInOps(fromName string, toName string, ops []op,
 				retOps = append(retOps, &realOpCopy)
 				done = true
 			}
+		case *setXattrOp:
+			if realOp.Name == fromName {
+				realOpCopy := *realOp
+				realOpCopy.Name = toName
+				retOps = append(retOps, &realOpCopy)
+				done = true
+			}
 		}
 		if !done {
 			retOps = append(retOps, uop)
@@ -93,15 +101,20 @@ func (cuea *copyUnmergedEntryAction) swapUnmergedBlock(
 	// the "sizeAttr" fields.
 	ptr := unmergedEntry.BlockPointer
 	if chain, ok := unmergedChains.byMostRecent[ptr]; ok {
-		// If the chain has only setAttr ops, we still want to do the
-		// swap, but we need to preserve those unmerged attr changes.
+		// If the chain has only setAttr or setXattr ops, we still
+		// want to do the swap, but we need to preserve those
+		// unmerged attr changes.
 		for _, op := range chain.ops {
-			// As soon as we find an op that is NOT a setAttrOp, we
-			// should abort the swap.  Otherwise save the changed
-			// attributes so we can re-apply them during do().
-			if sao, ok := op.(*setAttrOp); ok {
-				cuea.attr = append(cuea.attr, sao.Attr)
-			} else {
+			// As soon as we find an op that is NOT a setAttrOp or
+			// setXattrOp, we should abort the swap.  Otherwise save
+			// the changed attributes so we can re-apply them during
+			// do().
+			switch realOp := op.(type) {
+			case *setAttrOp:
+				cuea.attr = append(cuea.attr, realOp.Attr)
+			case *setXattrOp:
+				cuea.xattrs = append(cuea.xattrs, realOp.Xattr)
+			default:
 				return false, zeroPtr, nil
 			}
 		}
@@ -179,6 +192,9 @@ func (cuea *copyUnmergedEntryAction) do(ctx context.Context,
 			entry.Size = unmergedEntry.Size
 			entry.EncodedSize = unmergedEntry.EncodedSize
 			entry.BlockPointer = unmergedEntry.BlockPointer
+			for _, name := range cuea.xattrs {
+				entry.copyXattr(cuea.unmergedEntry, name)
+			}
 			mergedBlock.Children[cuea.toName] = entry
 			return nil
 		}
@@ -190,8 +206,13 @@ func (cuea *copyUnmergedEntryAction) do(ctx context.Context,
 				unmergedEntry.Type = cuea.unmergedEntry.Type
 			case mtimeAttr:
 				unmergedEntry.Mtime = cuea.unmergedEntry.Mtime
+			case lockIDAttr:
+				unmergedEntry.LockID = cuea.unmergedEntry.LockID
 			}
 		}
+		for _, name := range cuea.xattrs {
+			unmergedEntry.copyXattr(cuea.unmergedEntry, name)
+		}
 	}
 
 	mergedBlock.Children[cuea.toName] = unmergedEntry
@@ -351,13 +372,15 @@ func (cuea *copyUnmergedEntryAction) String() string {
 		cuea.fromName, cuea.toName, cuea.symPath)
 }
 
-// copyUnmergedAttrAction says that the given attributes in the
-// unmerged entry for the given name should be copied directly into
-// the merged version of the directory; there should be no conflict.
+// copyUnmergedAttrAction says that the given attributes (and
+// extended attributes) in the unmerged entry for the given name
+// should be copied directly into the merged version of the
+// directory; there should be no conflict.
 type copyUnmergedAttrAction struct {
 	fromName string
 	toName   string
 	attr     []attrChange
+	xattrs   []string
 	moved    bool // move this action to the parent at most one time
 }
 
@@ -386,12 +409,17 @@ func (cuaa *copyUnmergedAttrAction) do(ctx context.Context,
 			mergedEntry.Type = unmergedEntry.Type
 		case mtimeAttr:
 			mergedEntry.Mtime = unmergedEntry.Mtime
+		case lockIDAttr:
+			mergedEntry.LockID = unmergedEntry.LockID
 		case sizeAttr:
 			mergedEntry.Size = unmergedEntry.Size
 			mergedEntry.EncodedSize = unmergedEntry.EncodedSize
 			mergedEntry.BlockPointer = unmergedEntry.BlockPointer
 		}
 	}
+	for _, name := range cuaa.xattrs {
+		mergedEntry.copyXattr(unmergedEntry, name)
+	}
 	mergedBlock.Children[cuaa.toName] = mergedEntry
 
 	return nil
@@ -420,8 +448,8 @@ func (cuaa *copyUnmergedAttrAction) updateOps(unmergedMostRecent BlockPointer,
 }
 
 func (cuaa *copyUnmergedAttrAction) String() string {
-	return fmt.Sprintf("copyUnmergedAttr: %s -> %s (%s)",
-		cuaa.fromName, cuaa.toName, cuaa.attr)
+	return fmt.Sprintf("copyUnmergedAttr: %s -> %s (%s) (xattrs %v)",
+		cuaa.fromName, cuaa.toName, cuaa.attr, cuaa.xattrs)
 }
 
 // rmMergedEntryAction says that the merged entry for the given name
@@ -580,6 +608,8 @@ func (rua *renameUnmergedAction) updateOps(unmergedMostRecent BlockPointer,
 				realOp.RefBlocks = nil
 			case *setAttrOp:
 				realOp.File = newMergedEntry.BlockPointer
+			case *setXattrOp:
+				realOp.File = newMergedEntry.BlockPointer
 			}
 		}
 
@@ -936,6 +966,18 @@ func (cal crActionList) collapse() crActionList {
 						topAction.attr = append(topAction.attr, a)
 					}
 				}
+				for _, x := range action.xattrs {
+					found := false
+					for _, topX := range topAction.xattrs {
+						if x == topX {
+							found = true
+							break
+						}
+					}
+					if !found {
+						topAction.xattrs = append(topAction.xattrs, x)
+					}
+				}
 				indicesToRemove[i] = true
 			default:
 				setTopAction(action, action.fromName, i, infoMap,
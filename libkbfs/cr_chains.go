@@ -79,6 +79,8 @@ func (cc *crChain) collapse(createdOriginals map[BlockPointer]bool,
 			}
 		case *setAttrOp:
 			// TODO: Collapse opposite setex pairs
+		case *setXattrOp:
+			// TODO: Collapse repeated sets of the same xattr
 		case *syncOp:
 			wr = realOp.collapseWriteRange(wr)
 			indicesToRemove[i] = true
@@ -227,12 +229,16 @@ func (cc *crChain) identifyType(ctx context.Context, fbo *folderBlockOps,
 			cc.file = true
 			return nil
 		case *setAttrOp:
-			if realOp.Attr != mtimeAttr {
+			if realOp.Attr != mtimeAttr && realOp.Attr != lockIDAttr {
 				cc.file = true
 				return nil
 			}
-			// We can't tell the file type from an mtimeAttr, so we
-			// may have to actually fetch the block to figure it out.
+			// We can't tell the file type from an mtimeAttr or a
+			// lockIDAttr, so we may have to actually fetch the block
+			// to figure it out.
+			parentDir = realOp.Dir.Ref
+		case *setXattrOp:
+			// Same for xattrs, which can be set on anything.
 			parentDir = realOp.Dir.Ref
 		default:
 			return nil
@@ -579,6 +585,20 @@ func (ccs *crChains) makeChainForOp(op op) error {
 			ccs.byMostRecent[realOp.File] = chain
 		}
 
+		err := ccs.addOp(realOp.File, op)
+		if err != nil {
+			return err
+		}
+	case *setXattrOp:
+		// Same as setAttrOp: the file's pointer doesn't change, so
+		// we may need to create a new chain.
+		_, ok := ccs.byMostRecent[realOp.File]
+		if !ok {
+			chain := &crChain{original: realOp.File, mostRecent: realOp.File}
+			ccs.byOriginal[realOp.File] = chain
+			ccs.byMostRecent[realOp.File] = chain
+		}
+
 		err := ccs.addOp(realOp.File, op)
 		if err != nil {
 			return err
@@ -651,6 +671,8 @@ func (ccs *crChains) makeChainForNewOp(targetPtr BlockPointer, newOp op) error {
 		return nil
 	case *setAttrOp:
 		return ccs.makeChainForNewOpWithUpdate(targetPtr, newOp, &realOp.Dir)
+	case *setXattrOp:
+		return ccs.makeChainForNewOpWithUpdate(targetPtr, newOp, &realOp.Dir)
 	case *syncOp:
 		return ccs.makeChainForNewOpWithUpdate(targetPtr, newOp, &realOp.File)
 	default:
@@ -949,6 +971,10 @@ func (ccs *crChains) copyOpAndRevertUnrefsToOriginals(currOp op) op {
 		newSetAttrOp := *realOp
 		unrefs = append(unrefs, &newSetAttrOp.Dir.Unref, &newSetAttrOp.File)
 		newOp = &newSetAttrOp
+	case *setXattrOp:
+		newSetXattrOp := *realOp
+		unrefs = append(unrefs, &newSetXattrOp.Dir.Unref, &newSetXattrOp.File)
+		newOp = &newSetXattrOp
 	case *GCOp:
 		// No need to copy a GCOp, it won't be modified
 		newOp = realOp
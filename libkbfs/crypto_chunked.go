@@ -0,0 +1,152 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/binary"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Data encrypted with EncryptionXChaCha20Poly1305 is split into
+// chunks of chunkedPlaintextSize bytes (the last one may be shorter,
+// and empty data is a single empty chunk), and each chunk is sealed
+// separately with XChaCha20-Poly1305, following the STREAM
+// construction: the nonce for chunk i is the random nonce prefix
+// stored with the data, followed by i as a big-endian 56-bit
+// counter, followed by a byte that is 1 for the last chunk and 0
+// otherwise.  So chunks can't be reordered, and the data can't be
+// truncated at a chunk boundary, without failing authentication.
+//
+// Every sealed chunk but the last is exactly chunkedCiphertextSize
+// bytes, so any single chunk can be found and authenticated without
+// touching the rest of the data.
+const (
+	chunkedPlaintextSize  = 64 * 1024
+	chunkedCiphertextSize = chunkedPlaintextSize + chacha20poly1305.Overhead
+	// chunkedNoncePrefixSize is the size of the random nonce
+	// prefix stored with each encrypted object.
+	chunkedNoncePrefixSize = chacha20poly1305.NonceSizeX - 8
+	// chunkedMaxChunks is the size of the chunk counter space.
+	chunkedMaxChunks = 1 << 56
+)
+
+func chunkedNonce(prefix []byte, i uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	// Put the 56-bit counter in the 7 bytes after the prefix,
+	// leaving the final byte for the last-chunk flag.
+	binary.BigEndian.PutUint64(nonce[chunkedNoncePrefixSize:], i<<8)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// numChunks returns the number of chunks in data encrypted with
+// EncryptionXChaCha20Poly1305 whose ciphertext is the given size.
+func numChunks(ciphertextLen int) int {
+	n := (ciphertextLen + chunkedCiphertextSize - 1) / chunkedCiphertextSize
+	if n == 0 {
+		// An empty ciphertext is invalid, but let decryption
+		// fail on it.
+		return 1
+	}
+	return n
+}
+
+func (c CryptoCommon) encryptDataChunked(data []byte, key [32]byte) (
+	encryptedData, error) {
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return encryptedData{}, err
+	}
+
+	prefix := make([]byte, chunkedNoncePrefixSize)
+	err = kbfscrypto.RandRead(prefix)
+	if err != nil {
+		return encryptedData{}, err
+	}
+
+	chunks := (len(data) + chunkedPlaintextSize - 1) / chunkedPlaintextSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	if uint64(chunks) > chunkedMaxChunks {
+		return encryptedData{}, errors.Errorf(
+			"Can't encrypt %d bytes in %d chunks", len(data), chunks)
+	}
+	sealedData := make([]byte, 0, len(data)+chunks*aead.Overhead())
+	for i := 0; i < chunks; i++ {
+		start := i * chunkedPlaintextSize
+		end := start + chunkedPlaintextSize
+		if end > len(data) {
+			end = len(data)
+		}
+		nonce := chunkedNonce(prefix, uint64(i), i == chunks-1)
+		sealedData = aead.Seal(sealedData, nonce, data[start:end], nil)
+	}
+
+	return encryptedData{
+		Version:       EncryptionXChaCha20Poly1305,
+		Nonce:         prefix,
+		EncryptedData: sealedData,
+	}, nil
+}
+
+// decryptDataChunk decrypts and authenticates just the i'th chunk of
+// data encrypted with EncryptionXChaCha20Poly1305.
+func (c CryptoCommon) decryptDataChunk(
+	encryptedData encryptedData, key [32]byte, i int) ([]byte, error) {
+	if encryptedData.Version != EncryptionXChaCha20Poly1305 {
+		return nil, errors.WithStack(
+			UnknownEncryptionVer{encryptedData.Version})
+	}
+	if len(encryptedData.Nonce) != chunkedNoncePrefixSize {
+		return nil, errors.WithStack(
+			InvalidNonceError{encryptedData.Nonce})
+	}
+
+	sealedData := encryptedData.EncryptedData
+	chunks := numChunks(len(sealedData))
+	if i < 0 || i >= chunks {
+		return nil, errors.Errorf(
+			"Chunk %d out of range; there are %d chunks", i, chunks)
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+	start := i * chunkedCiphertextSize
+	end := start + chunkedCiphertextSize
+	if end > len(sealedData) {
+		end = len(sealedData)
+	}
+	nonce := chunkedNonce(
+		encryptedData.Nonce, uint64(i), i == chunks-1)
+	chunk, err := aead.Open(nil, nonce, sealedData[start:end], nil)
+	if err != nil {
+		return nil, errors.WithStack(libkb.DecryptionError{})
+	}
+	return chunk, nil
+}
+
+func (c CryptoCommon) decryptDataChunked(
+	encryptedData encryptedData, key [32]byte) ([]byte, error) {
+	chunks := numChunks(len(encryptedData.EncryptedData))
+	data := make([]byte, 0, len(encryptedData.EncryptedData))
+	for i := 0; i < chunks; i++ {
+		chunk, err := c.decryptDataChunk(encryptedData, key, i)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
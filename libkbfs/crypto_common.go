@@ -5,9 +5,12 @@
 package libkbfs
 
 import (
+	"bytes"
+	"compress/flate"
 	"crypto/rand"
 	"encoding/binary"
 	"io"
+	"io/ioutil"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -252,7 +255,11 @@ func (c CryptoCommon) EncryptPrivateMetadata(
 }
 
 func (c CryptoCommon) decryptData(encryptedData encryptedData, key [32]byte) ([]byte, error) {
-	if encryptedData.Version != EncryptionSecretbox {
+	switch encryptedData.Version {
+	case EncryptionSecretbox:
+	case EncryptionXChaCha20Poly1305:
+		return c.decryptDataChunked(encryptedData, key)
+	default:
 		return nil, errors.WithStack(
 			UnknownEncryptionVer{encryptedData.Version})
 	}
@@ -350,6 +357,89 @@ func (c CryptoCommon) depadBlock(paddedBlock []byte) ([]byte, error) {
 	return paddedBlock[padPrefixSize:blockEndPos], nil
 }
 
+// maxDecompressedBlockSize bounds the size of a decompressed block
+// encoding.  It is far larger than any block we write, and only
+// guards against compressed blocks that would expand without bound.
+const maxDecompressedBlockSize = 64 << 20
+
+// compressEncodedBlock returns the encoding of a block that holds
+// the compressed form of encodedBlock, or nil if compressing wouldn't
+// make the padded block any smaller.
+func (c CryptoCommon) compressEncodedBlock(encodedBlock []byte) (
+	[]byte, error) {
+	var buf bytes.Buffer
+	// Blocks are compressed on every write, so favor speed.
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encodedBlock); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	compressedBlock, err := c.codec.Encode(
+		&CommonBlock{Compressed: buf.Bytes()})
+	if err != nil {
+		return nil, err
+	}
+	// Blocks are padded up to a power of two, so compression only
+	// helps if it gets the block under the next-smallest power.
+	if powerOfTwoEqualOrGreater(len(compressedBlock)) >=
+		powerOfTwoEqualOrGreater(len(encodedBlock)) {
+		return nil, nil
+	}
+	return compressedBlock, nil
+}
+
+// decompressBlock decompresses the encoding stored in
+// block.Compressed, if any, and decodes it into block.
+func (c CryptoCommon) decompressBlock(block Block) error {
+	cb := block.ToCommonBlock()
+	if cb == nil || cb.Compressed == nil {
+		return nil
+	}
+	r := flate.NewReader(bytes.NewReader(cb.Compressed))
+	defer r.Close()
+	encodedBlock, err := ioutil.ReadAll(
+		io.LimitReader(r, maxDecompressedBlockSize+1))
+	if err != nil {
+		return err
+	}
+	if len(encodedBlock) > maxDecompressedBlockSize {
+		return errors.Errorf("Decompressed block is bigger than %d bytes",
+			maxDecompressedBlockSize)
+	}
+	cb.Compressed = nil
+	return c.codec.Decode(encodedBlock, &block)
+}
+
+func (c CryptoCommon) encryptEncodedBlock(encodedBlock []byte,
+	key kbfscrypto.BlockCryptKey, ver EncryptionVer) (
+	EncryptedBlock, error) {
+	paddedBlock, err := c.padBlock(encodedBlock)
+	if err != nil {
+		return EncryptedBlock{}, err
+	}
+
+	var encryptedData encryptedData
+	switch ver {
+	case EncryptionSecretbox:
+		encryptedData, err = c.encryptData(paddedBlock, key.Data())
+	case EncryptionXChaCha20Poly1305:
+		encryptedData, err = c.encryptDataChunked(paddedBlock, key.Data())
+	default:
+		err = errors.WithStack(UnknownEncryptionVer{ver})
+	}
+	if err != nil {
+		return EncryptedBlock{}, err
+	}
+
+	return EncryptedBlock{encryptedData}, nil
+}
+
 // EncryptBlock implements the Crypto interface for CryptoCommon.
 func (c CryptoCommon) EncryptBlock(block Block, key kbfscrypto.BlockCryptKey) (
 	plainSize int, encryptedBlock EncryptedBlock, err error) {
@@ -358,19 +448,45 @@ func (c CryptoCommon) EncryptBlock(block Block, key kbfscrypto.BlockCryptKey) (
 		return -1, EncryptedBlock{}, err
 	}
 
-	paddedBlock, err := c.padBlock(encodedBlock)
+	encryptedBlock, err = c.encryptEncodedBlock(
+		encodedBlock, key, EncryptionSecretbox)
 	if err != nil {
 		return -1, EncryptedBlock{}, err
 	}
 
-	encryptedData, err := c.encryptData(paddedBlock, key.Data())
+	return len(encodedBlock), encryptedBlock, nil
+}
+
+// EncryptBlockWithParams implements the Crypto interface for
+// CryptoCommon.
+func (c CryptoCommon) EncryptBlockWithParams(
+	block Block, key kbfscrypto.BlockCryptKey,
+	params BlockEncryptionParams) (
+	plainSize, uncompressedSize int, encryptedBlock EncryptedBlock,
+	err error) {
+	encodedBlock, err := c.codec.Encode(block)
+	if err != nil {
+		return -1, -1, EncryptedBlock{}, err
+	}
+	uncompressedSize = len(encodedBlock)
+
+	if params.Compress {
+		compressedBlock, err := c.compressEncodedBlock(encodedBlock)
+		if err != nil {
+			return -1, -1, EncryptedBlock{}, err
+		}
+		if compressedBlock != nil {
+			encodedBlock = compressedBlock
+		}
+	}
+
+	encryptedBlock, err = c.encryptEncodedBlock(
+		encodedBlock, key, params.Version)
 	if err != nil {
-		return -1, EncryptedBlock{}, err
+		return -1, -1, EncryptedBlock{}, err
 	}
 
-	plainSize = len(encodedBlock)
-	encryptedBlock = EncryptedBlock{encryptedData}
-	return plainSize, encryptedBlock, nil
+	return len(encodedBlock), uncompressedSize, encryptedBlock, nil
 }
 
 // DecryptBlock implements the Crypto interface for CryptoCommon.
@@ -392,6 +508,10 @@ func (c CryptoCommon) DecryptBlock(
 	if err != nil {
 		return errors.WithStack(BlockDecodeError{err})
 	}
+	err = c.decompressBlock(block)
+	if err != nil {
+		return errors.WithStack(BlockDecodeError{err})
+	}
 	return nil
 }
 
@@ -274,7 +274,8 @@ func checkDecryptionFailures(
 	// Wrong version.
 
 	encryptedDataWrongVersion := encryptedData
-	encryptedDataWrongVersion.Version++
+	// Not a version any client knows about.
+	encryptedDataWrongVersion.Version = 1000
 	err := decryptFn(encryptedDataWrongVersion, key)
 	assert.Equal(t,
 		UnknownEncryptionVer{encryptedDataWrongVersion.Version},
@@ -404,6 +405,140 @@ func TestDecryptEncryptedBlock(t *testing.T) {
 	require.Equal(t, block, decryptedBlock)
 }
 
+// Test that crypto.EncryptBlockWithParams() compresses file and
+// directory blocks only when that saves space, and that
+// crypto.DecryptBlock() decompresses them.
+func TestEncryptDecryptBlockCompressed(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+
+	cryptKey := makeFakeBlockCryptKey(t)
+
+	fblock := NewFileBlock().(*FileBlock)
+	fblock.Contents = bytes.Repeat([]byte("compressible "), 1000)
+	dblock := NewDirBlock().(*DirBlock)
+	for i := 0; i < 100; i++ {
+		dblock.Children[fmt.Sprintf("file%d", i)] = DirEntry{
+			EntryInfo: EntryInfo{Type: File, Size: uint64(i)},
+		}
+	}
+	random := NewFileBlock().(*FileBlock)
+	random.Contents = make([]byte, 1000)
+	err := kbfscrypto.RandRead(random.Contents)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		block      Block
+		compressed bool
+	}{
+		{fblock, true},
+		{dblock, true},
+		{random, false},
+	} {
+		encodedBlock, err := c.codec.Encode(test.block)
+		require.NoError(t, err)
+
+		plainSize, uncompressedSize, encryptedBlock, err :=
+			c.EncryptBlockWithParams(test.block, cryptKey,
+				BlockEncryptionParams{
+					Compress: true,
+					Version:  EncryptionSecretbox,
+				})
+		require.NoError(t, err)
+		require.Equal(t, len(encodedBlock), uncompressedSize)
+		require.Equal(t, test.compressed, plainSize < uncompressedSize)
+
+		decryptedBlock := test.block.NewEmpty()
+		err = c.DecryptBlock(encryptedBlock, cryptKey, decryptedBlock)
+		require.NoError(t, err)
+		require.Equal(t, test.block, decryptedBlock)
+	}
+}
+
+// Test that blocks encrypted with EncryptionXChaCha20Poly1305
+// decrypt, whether they fit in one chunk or not.
+func TestEncryptDecryptBlockChunked(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+
+	cryptKey := makeFakeBlockCryptKey(t)
+
+	for _, size := range []int{0, 1000, 3*chunkedPlaintextSize + 7} {
+		block := NewFileBlock().(*FileBlock)
+		block.Contents = make([]byte, size)
+		err := kbfscrypto.RandRead(block.Contents)
+		require.NoError(t, err)
+
+		_, _, encryptedBlock, err := c.EncryptBlockWithParams(
+			block, cryptKey, BlockEncryptionParams{
+				Version: EncryptionXChaCha20Poly1305,
+			})
+		require.NoError(t, err)
+		require.Equal(t, EncryptionXChaCha20Poly1305,
+			encryptedBlock.Version)
+
+		decryptedBlock := NewFileBlock()
+		err = c.DecryptBlock(encryptedBlock, cryptKey, decryptedBlock)
+		require.NoError(t, err)
+		require.Equal(t, block, decryptedBlock)
+	}
+}
+
+// Test that single chunks can be decrypted on their own, and that
+// chunks can't be dropped or reordered.
+func TestDecryptDataChunked(t *testing.T) {
+	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
+
+	key := makeFakeBlockCryptKey(t).Data()
+	data := make([]byte, 2*chunkedPlaintextSize+100)
+	err := kbfscrypto.RandRead(data)
+	require.NoError(t, err)
+
+	ed, err := c.encryptDataChunked(data, key)
+	require.NoError(t, err)
+	require.Equal(t, 3, numChunks(len(ed.EncryptedData)))
+
+	chunk, err := c.decryptDataChunk(ed, key, 1)
+	require.NoError(t, err)
+	require.Equal(t,
+		data[chunkedPlaintextSize:2*chunkedPlaintextSize], chunk)
+	chunk, err = c.decryptDataChunk(ed, key, 2)
+	require.NoError(t, err)
+	require.Equal(t, data[2*chunkedPlaintextSize:], chunk)
+	_, err = c.decryptDataChunk(ed, key, 3)
+	require.Error(t, err)
+
+	// Truncating at a chunk boundary fails.
+	truncated := ed
+	truncated.EncryptedData = ed.EncryptedData[:2*chunkedCiphertextSize]
+	_, err = c.decryptData(truncated, key)
+	require.Equal(t, libkb.DecryptionError{}, errors.Cause(err))
+
+	// So does swapping two chunks.
+	swapped := ed
+	swapped.EncryptedData = append([]byte{},
+		ed.EncryptedData[chunkedCiphertextSize:2*chunkedCiphertextSize]...)
+	swapped.EncryptedData = append(swapped.EncryptedData,
+		ed.EncryptedData[:chunkedCiphertextSize]...)
+	swapped.EncryptedData = append(swapped.EncryptedData,
+		ed.EncryptedData[2*chunkedCiphertextSize:]...)
+	_, err = c.decryptData(swapped, key)
+	require.Equal(t, libkb.DecryptionError{}, errors.Cause(err))
+
+	decrypted, err := c.decryptData(ed, key)
+	require.NoError(t, err)
+	require.Equal(t, data, decrypted)
+
+	checkDecryptionFailures(t, ed, key,
+		func(encryptedData encryptedData, key interface{}) error {
+			_, err := c.decryptData(encryptedData, key.([32]byte))
+			return err
+		},
+		func(key interface{}) interface{} {
+			keyCorrupt := key.([32]byte)
+			keyCorrupt[0] = ^keyCorrupt[0]
+			return keyCorrupt
+		})
+}
+
 // Test various failure cases for crypto.DecryptBlock().
 func TestDecryptBlockFailures(t *testing.T) {
 	c := MakeCryptoCommon(kbfscodec.NewMsgpack())
@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -56,18 +57,38 @@ type SessionInfo struct {
 }
 
 // EncryptionVer denotes a version for the encryption method.
+//
+// Every encrypted object records the EncryptionVer it was encrypted
+// with, and clients must keep being able to decrypt every version
+// they have ever written.  A client that sees a version it doesn't
+// know fails with UnknownEncryptionVer, but by then it may already
+// have, e.g., cached the object or shown an error to the user, so
+// new versions must also be gated by something older clients check
+// before fetching: a new DataVer for blocks (as with
+// ChunkedEncryptionDataVer), or a new MetadataVer for metadata.
+// Switching the version used for new objects is then a config
+// change (see InitParams.BlockEncryption), which should only become
+// the default once enough clients understand the gating version.
 type EncryptionVer int
 
 const (
 	// EncryptionSecretbox is the encryption version that uses
 	// nacl/secretbox or nacl/box.
 	EncryptionSecretbox EncryptionVer = 1
+	// EncryptionXChaCha20Poly1305 is the encryption version that
+	// splits the data into fixed-size chunks, each sealed with
+	// XChaCha20-Poly1305 under its own nonce, so that single
+	// chunks can be authenticated and decrypted on their own (see
+	// crypto_chunked.go).  It is only used for blocks.
+	EncryptionXChaCha20Poly1305 EncryptionVer = 2
 )
 
 func (v EncryptionVer) String() string {
 	switch v {
 	case EncryptionSecretbox:
 		return "EncryptionSecretbox"
+	case EncryptionXChaCha20Poly1305:
+		return "EncryptionXChaCha20Poly1305"
 	default:
 		return fmt.Sprintf("EncryptionVer(%d)", v)
 	}
@@ -215,6 +236,12 @@ func (v MetadataVer) String() string {
 // one indirect pointer with an indirect DirectType [although if it
 // holds for one, it should hold for all], and all of its indirect
 // pointers must have DataVer 3, by c).
+// e) Any block may instead be compressed (see
+// CompressedBlocksDataVer), or encrypted with a newer EncryptionVer
+// (see ChunkedEncryptionDataVer), in which case its pointer has at
+// least the corresponding DataVer regardless of the rules above.
+// This is the one exception to #1: an indirect block may point to
+// blocks with greater versions if they were encoded differently.
 type DataVer int
 
 const (
@@ -229,6 +256,16 @@ const (
 	// blocks that have multiple levels of indirection below them
 	// (i.e., indirect blocks that point to other indirect blocks).
 	AtLeastTwoLevelsOfChildrenDataVer DataVer = 3
+	// CompressedBlocksDataVer is the data version for blocks whose
+	// encoding was compressed before encryption (see
+	// CommonBlock.Compressed).  Older clients would silently see
+	// such blocks as empty, so this version keeps them from reading
+	// them at all.
+	CompressedBlocksDataVer DataVer = 4
+	// ChunkedEncryptionDataVer is the data version for blocks
+	// encrypted with EncryptionXChaCha20Poly1305.  (They may also be
+	// compressed.)
+	ChunkedEncryptionDataVer DataVer = 5
 )
 
 // BlockRef is a block ID/ref nonce pair, which defines a unique
@@ -356,6 +393,31 @@ type ReadyBlockData struct {
 	// These fields should not be used outside of putBlockToServer.
 	buf        []byte
 	serverHalf kbfscrypto.BlockCryptKeyServerHalf
+	// minDataVer is the lowest DataVer that pointers to this block
+	// may have, given how buf was encoded and encrypted.
+	minDataVer DataVer
+}
+
+// BlockEncryptionParams says how to encode and encrypt a block.
+type BlockEncryptionParams struct {
+	// Compress says whether to compress the encoded block, when
+	// that makes it smaller.
+	Compress bool
+	// Version is the encryption version to use.
+	Version EncryptionVer
+}
+
+// minDataVer returns the lowest DataVer that pointers to a block
+// encrypted with these params may have.
+func (p BlockEncryptionParams) minDataVer(compressed bool) DataVer {
+	switch {
+	case p.Version == EncryptionXChaCha20Poly1305:
+		return ChunkedEncryptionDataVer
+	case compressed:
+		return CompressedBlocksDataVer
+	default:
+		return FirstValidDataVer
+	}
 }
 
 // GetEncodedSize returns the size of the encoded (and encrypted)
@@ -408,8 +470,42 @@ const (
 	// folder.  Set to the empty string so that the default will be
 	// the master branch.
 	MasterBranch BranchName = ""
+
+	// branchRevPrefix is the prefix of the name of any read-only
+	// branch that pins a top-level folder to a particular historical
+	// MD revision.
+	branchRevPrefix = "rev="
 )
 
+// MakeRevBranchName returns the name of the read-only branch that
+// represents the given folder at the given historical revision.
+func MakeRevBranchName(rev MetadataRevision) BranchName {
+	return BranchName(branchRevPrefix + rev.String())
+}
+
+// IsArchived returns true if the branch specifies an archived,
+// read-only revision of a top-level folder.
+func (bn BranchName) IsArchived() bool {
+	_, ok := bn.RevisionIfSpecified()
+	return ok
+}
+
+// RevisionIfSpecified returns the archived revision named by this
+// branch, and true, if the branch name specifies one.  Otherwise it
+// returns MetadataRevisionUninitialized and false.
+func (bn BranchName) RevisionIfSpecified() (MetadataRevision, bool) {
+	if !strings.HasPrefix(string(bn), branchRevPrefix) {
+		return MetadataRevisionUninitialized, false
+	}
+
+	i, err := strconv.ParseInt(
+		strings.TrimPrefix(string(bn), branchRevPrefix), 10, 64)
+	if err != nil || MetadataRevision(i) < MetadataRevisionInitial {
+		return MetadataRevisionUninitialized, false
+	}
+	return MetadataRevision(i), true
+}
+
 // FolderBranch represents a unique pair of top-level folder and a
 // branch of that folder.
 type FolderBranch struct {
@@ -635,6 +731,54 @@ type TLFUpdateHistory struct {
 	Updates []UpdateSummary
 }
 
+// PathChangeType indicates the kind of change described by a
+// PathChange.
+type PathChangeType string
+
+const (
+	// PathAdded means a new entry was created at the path.
+	PathAdded PathChangeType = "added"
+	// PathRemoved means the entry at the path was removed.
+	PathRemoved PathChangeType = "removed"
+	// PathRenamed means the entry at the old path was renamed to
+	// the path.
+	PathRenamed PathChangeType = "renamed"
+	// PathModified means some byte ranges of the file at the path
+	// were written or truncated.
+	PathModified PathChangeType = "modified"
+	// PathAttrChanged means an attribute of the entry at the path
+	// was changed.  For extended attributes, the change's Attr is
+	// "xattr:" followed by the attribute name.
+	PathAttrChanged PathChangeType = "attr"
+)
+
+// PathChange describes a single path-level change made by a merged
+// revision of a TLF, and is suitable for encoding directly as JSON.
+type PathChange struct {
+	Revision  MetadataRevision
+	Date      time.Time
+	Writer    string
+	Type      PathChangeType
+	Path      string       // relative to the TLF root
+	OldPath   string       `json:",omitempty"` // only for renames
+	EntryType string       `json:",omitempty"`
+	Writes    []WriteRange `json:",omitempty"` // a 0 Len is a truncate
+	Attr      string       `json:",omitempty"`
+}
+
+// DeletedEntry describes an entry that was removed from a TLF, and
+// that can still be restored with KBFSOps.Undelete because its
+// blocks haven't yet been reclaimed.
+type DeletedEntry struct {
+	Path     string // relative to the TLF root, as of the removal
+	Type     string
+	Size     uint64
+	Mtime    time.Time
+	Revision MetadataRevision // the revision that removed the entry
+	Date     time.Time
+	Writer   string
+}
+
 // writerInfo is the keybase UID and device (represented by its
 // verifying key) that generated the operation at the given revision.
 type writerInfo struct {
@@ -4,13 +4,31 @@
 
 package libkbfs
 
-import "github.com/keybase/go-codec/codec"
+import (
+	"sort"
+
+	"github.com/keybase/go-codec/codec"
+)
 
 // DirEntry is all the data info a directory know about its child.
 type DirEntry struct {
 	BlockInfo
 	EntryInfo
 
+	// Xattrs holds the extended attributes of the entry, keyed by
+	// attribute name.  It lives here rather than in EntryInfo so
+	// that old clients, which don't know about it, still preserve
+	// it as an unknown field.  The map is shared between copies of
+	// the entry, so it must never be modified in place; use
+	// setXattr instead.
+	Xattrs map[string][]byte `codec:"x,omitempty"`
+
+	// LockID identifies the entry to the MD server for advisory file
+	// lock leases, so that leases stay with the entry when it's
+	// renamed.  It's assigned the first time the entry is locked by
+	// a writer.
+	LockID string `codec:"lid,omitempty"`
+
 	codec.UnknownFieldSetHandler
 }
 
@@ -19,6 +37,57 @@ func (de *DirEntry) IsInitialized() bool {
 	return de.BlockPointer.IsInitialized()
 }
 
+// xattrNames returns the sorted names of all the extended attributes
+// of this entry.
+func (de DirEntry) xattrNames() []string {
+	names := make([]string, 0, len(de.Xattrs))
+	for name := range de.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// xattrsSize returns the total number of bytes in the names and
+// values of all the extended attributes of this entry.
+func (de DirEntry) xattrsSize() uint64 {
+	var size uint64
+	for name, value := range de.Xattrs {
+		size += uint64(len(name) + len(value))
+	}
+	return size
+}
+
+// setXattr sets the extended attribute with the given name to the
+// given value, or removes it if value is nil.  It always replaces
+// the entry's Xattrs map with a new one, since the old one may be
+// shared with other copies of this entry.
+func (de *DirEntry) setXattr(name string, value []byte) {
+	xattrs := make(map[string][]byte, len(de.Xattrs)+1)
+	for k, v := range de.Xattrs {
+		if k != name {
+			xattrs[k] = v
+		}
+	}
+	if value != nil {
+		xattrs[name] = value
+	}
+	if len(xattrs) == 0 {
+		xattrs = nil
+	}
+	de.Xattrs = xattrs
+}
+
+// copyXattr copies the extended attribute with the given name (or
+// its absence) from another entry into this one.
+func (de *DirEntry) copyXattr(from DirEntry, name string) {
+	value, ok := from.Xattrs[name]
+	if ok && value == nil {
+		value = []byte{}
+	}
+	de.setXattr(name, value)
+}
+
 type dirEntryWithName struct {
 	DirEntry
 	entryName string
@@ -34,6 +34,8 @@ func makeFakeDirEntry(t *testing.T, typ EntryType, size uint64) DirEntry {
 			101,
 			102,
 		},
+		map[string][]byte{"user.fake": []byte("fake value")},
+		"fake lock ID",
 		codec.UnknownFieldSetHandler{},
 	}
 }
@@ -6,10 +6,12 @@ package libkbfs
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,6 +22,7 @@ import (
 	"github.com/keybase/kbfs/kbfshash"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -35,6 +38,7 @@ const (
 	blockDbFilename               string = "diskCacheBlocks.leveldb"
 	metaDbFilename                string = "diskCacheMetadata.leveldb"
 	tlfDbFilename                 string = "diskCacheTLF.leveldb"
+	settingsFilename              string = "settings.json"
 	versionFilename               string = "version"
 	initialDiskCacheVersion       uint64 = 1
 	currentDiskCacheVersion       uint64 = initialDiskCacheVersion
@@ -48,6 +52,74 @@ type diskBlockCacheConfig interface {
 	logMaker
 	clockGetter
 	diskLimiterGetter
+	diskCachePolicyGetter
+	metricsRegistryGetter
+}
+
+// diskBlockCacheMetrics keeps track of the disk block cache's stats
+// in a metrics registry.  All of its methods may be called on a nil
+// *diskBlockCacheMetrics, in which case they do nothing.
+type diskBlockCacheMetrics struct {
+	registry       metrics.Registry
+	hitMeter       metrics.Meter
+	missMeter      metrics.Meter
+	evictionMeter  metrics.Meter
+	putTimer       metrics.Timer
+	totalByteGauge metrics.Gauge
+}
+
+func newDiskBlockCacheMetrics(r metrics.Registry) *diskBlockCacheMetrics {
+	if r == nil {
+		return nil
+	}
+	return &diskBlockCacheMetrics{
+		registry:  r,
+		hitMeter:  metrics.GetOrRegisterMeter("DiskBlockCache.Hits", r),
+		missMeter: metrics.GetOrRegisterMeter("DiskBlockCache.Misses", r),
+		evictionMeter: metrics.GetOrRegisterMeter(
+			"DiskBlockCache.Evictions", r),
+		putTimer: metrics.GetOrRegisterTimer("DiskBlockCache.Put", r),
+		totalByteGauge: metrics.GetOrRegisterGauge(
+			"DiskBlockCache.Bytes", r),
+	}
+}
+
+func (m *diskBlockCacheMetrics) markLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	if hit {
+		m.hitMeter.Mark(1)
+	} else {
+		m.missMeter.Mark(1)
+	}
+}
+
+func (m *diskBlockCacheMetrics) markEvictions(numEvicted int) {
+	if m == nil {
+		return
+	}
+	m.evictionMeter.Mark(int64(numEvicted))
+}
+
+func (m *diskBlockCacheMetrics) updatePutTime(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.putTimer.UpdateSince(start)
+}
+
+// updateBytes records the number of bytes the given TLF, and the
+// whole cache, take up.
+func (m *diskBlockCacheMetrics) updateBytes(
+	tlfID tlf.ID, tlfBytes, totalBytes uint64) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrRegisterGauge(
+		fmt.Sprintf("DiskBlockCache.TLFBytes.%s", tlfID),
+		m.registry).Update(int64(tlfBytes))
+	m.totalByteGauge.Update(int64(totalBytes))
 }
 
 // DiskBlockCacheStandard is the standard implementation for DiskBlockCache.
@@ -55,18 +127,34 @@ type DiskBlockCacheStandard struct {
 	config     diskBlockCacheConfig
 	log        logger.Logger
 	maxBlockID []byte
+	// If non-nil, encrypts the metadata for each block, and hides
+	// the TLF IDs in the keys of tlfDb.
+	crypter *localStorageCrypter
 	// Track the number of blocks in the cache per TLF and overall.
 	tlfCounts map[tlf.ID]int
 	numBlocks int
 	// Track the aggregate size of blocks in the cache per TLF and overall.
 	tlfSizes  map[tlf.ID]uint64
 	currBytes uint64
+	// Per-TLF overrides of the config's settings, saved to
+	// settingsPath if it's non-empty.
+	tlfSettings  map[tlf.ID]diskBlockCacheTLFSettings
+	settingsPath string
+	// Protects the counts of lookups, which are updated under the
+	// read lock.
+	statsLock sync.Mutex
+	tlfHits   map[tlf.ID]int64
+	tlfMisses map[tlf.ID]int64
+	// May be nil, if the config has no metrics registry.
+	metrics *diskBlockCacheMetrics
 	// This protects the disk caches from being shutdown while they're being
 	// accessed.
 	lock    sync.RWMutex
 	blockDb *leveldb.DB
 	metaDb  *leveldb.DB
 	tlfDb   *leveldb.DB
+	// The storage under each DB, closed along with them.
+	storages []storage.Storage
 
 	compactCh  chan struct{}
 	startedCh  chan struct{}
@@ -76,6 +164,41 @@ type DiskBlockCacheStandard struct {
 
 var _ DiskBlockCache = (*DiskBlockCacheStandard)(nil)
 
+// DiskBlockCacheTLFStatus describes how much of the disk block cache
+// a TLF uses, and how often its blocks are found there.
+type DiskBlockCacheTLFStatus struct {
+	NumBlocks int
+	Bytes     uint64
+	// LimitBytes is the most the TLF may use, or 0 if there's no
+	// limit besides the size of the whole cache.
+	LimitBytes     int64 `json:",omitempty"`
+	EvictionPolicy string
+	// Hits and Misses count the lookups of the TLF's blocks since
+	// the cache was started.
+	Hits    int64
+	Misses  int64
+	HitRate float64
+	// CacheBytes is the size of the whole cache.
+	CacheBytes uint64
+}
+
+// diskBlockCacheTLFSettings overrides the config's disk cache
+// settings for a single TLF.
+type diskBlockCacheTLFSettings struct {
+	// Limit overrides the config's DiskCacheTLFLimit if it's
+	// non-zero.
+	Limit int64 `json:",omitempty"`
+	// EvictionPolicy overrides the config's DiskCacheEvictionPolicy
+	// if it's non-empty.
+	EvictionPolicy string `json:",omitempty"`
+}
+
+// diskBlockCacheSettings is what's saved to the settings file of a
+// DiskBlockCacheStandard.
+type diskBlockCacheSettings struct {
+	TLFs map[tlf.ID]diskBlockCacheTLFSettings `json:",omitempty"`
+}
+
 // openLevelDB opens or recovers a leveldb.DB with a passed-in storage.Storage
 // as its underlying storage layer.
 func openLevelDB(stor storage.Storage) (db *leveldb.DB, err error) {
@@ -97,10 +220,10 @@ func diskBlockCacheRootFromStorageRoot(storageRoot string) string {
 
 // newDiskBlockCacheStandardFromStorage creates a new *DiskBlockCacheStandard
 // with the passed-in storage.Storage interfaces as storage layers for each
-// cache.
+// cache.  If crypter is non-nil, it's used to encrypt the cache's metadata.
 func newDiskBlockCacheStandardFromStorage(config diskBlockCacheConfig,
-	blockStorage, metadataStorage, tlfStorage storage.Storage) (
-	cache *DiskBlockCacheStandard, err error) {
+	blockStorage, metadataStorage, tlfStorage storage.Storage,
+	crypter *localStorageCrypter) (cache *DiskBlockCacheStandard, err error) {
 	log := config.MakeLogger("KBC")
 	blockDb, err := openLevelDB(blockStorage)
 	if err != nil {
@@ -140,14 +263,21 @@ func newDiskBlockCacheStandardFromStorage(config diskBlockCacheConfig,
 	startedCh := make(chan struct{})
 	startErrCh := make(chan struct{})
 	cache = &DiskBlockCacheStandard{
-		config:     config,
-		maxBlockID: maxBlockID.Bytes(),
-		tlfCounts:  map[tlf.ID]int{},
-		tlfSizes:   map[tlf.ID]uint64{},
-		log:        log,
-		blockDb:    blockDb,
-		metaDb:     metaDb,
-		tlfDb:      tlfDb,
+		config:      config,
+		maxBlockID:  maxBlockID.Bytes(),
+		crypter:     crypter,
+		tlfCounts:   map[tlf.ID]int{},
+		tlfSizes:    map[tlf.ID]uint64{},
+		tlfSettings: map[tlf.ID]diskBlockCacheTLFSettings{},
+		tlfHits:     map[tlf.ID]int64{},
+		tlfMisses:   map[tlf.ID]int64{},
+		metrics:     newDiskBlockCacheMetrics(config.MetricsRegistry()),
+		log:         log,
+		blockDb:     blockDb,
+		metaDb:      metaDb,
+		tlfDb:       tlfDb,
+		storages: []storage.Storage{
+			blockStorage, metadataStorage, tlfStorage},
 		compactCh:  compactCh,
 		startedCh:  startedCh,
 		startErrCh: startErrCh,
@@ -224,8 +354,8 @@ func getVersionedPathForDiskCache(dirPath string) (versionedDirPath string,
 
 // newDiskBlockCacheStandard creates a new *DiskBlockCacheStandard with a
 // specified directory on the filesystem as storage.
-func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
-	cache *DiskBlockCacheStandard, err error) {
+func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string,
+	crypter *localStorageCrypter) (cache *DiskBlockCacheStandard, err error) {
 	versionPath, err := getVersionedPathForDiskCache(dirPath)
 	if err != nil {
 		return nil, err
@@ -260,8 +390,92 @@ func newDiskBlockCacheStandard(config diskBlockCacheConfig, dirPath string) (
 			tlfStorage.Close()
 		}
 	}()
-	return newDiskBlockCacheStandardFromStorage(config, blockStorage,
-		metadataStorage, tlfStorage)
+	cache, err = newDiskBlockCacheStandardFromStorage(config, blockStorage,
+		metadataStorage, tlfStorage, crypter)
+	if err != nil {
+		return nil, err
+	}
+	cache.settingsPath = filepath.Join(versionPath, settingsFilename)
+	var settings diskBlockCacheSettings
+	err = crypter.deserializeFromJSONFile(cache.settingsPath, &settings)
+	switch {
+	case ioutil.IsNotExist(err):
+	case err != nil:
+		// The settings aren't worth failing over.
+		cache.log.Warning("Couldn't read the disk cache settings: %+v", err)
+	default:
+		for tlfID, tlfSettings := range settings.TLFs {
+			if tlfSettings.EvictionPolicy != "" {
+				_, err := ParseDiskCacheEvictionPolicy(
+					tlfSettings.EvictionPolicy)
+				if err != nil {
+					cache.log.Warning("Ignoring the disk cache eviction "+
+						"policy of %s: %+v", tlfID, err)
+					tlfSettings.EvictionPolicy = ""
+				}
+			}
+			cache.tlfSettings[tlfID] = tlfSettings
+		}
+	}
+	return cache, nil
+}
+
+// newDiskBlockCacheStandardForConfig creates a new
+// *DiskBlockCacheStandard under the given storage root, encrypting
+// its metadata if the config asks for that.
+func newDiskBlockCacheStandardForConfig(ctx context.Context, config Config,
+	storageRoot string) (*DiskBlockCacheStandard, error) {
+	crypter, err := makeLocalStorageCrypter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := diskBlockCacheRootFromStorageRoot(storageRoot)
+	if crypter != nil {
+		err := removePlaintextDiskBlockCache(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		err = encryptSyncedFolderConfigs(
+			config.Codec(), crypter, storageRoot)
+		if err != nil {
+			return nil, err
+		}
+		// Keep each device's encrypted cache apart from those of
+		// other devices, which it couldn't read.
+		dirPath = filepath.Join(dirPath, "encrypted",
+			crypter.obfuscateName("disk_block_cache", 16))
+	}
+	return newDiskBlockCacheStandard(config, dirPath, crypter)
+}
+
+// removePlaintextDiskBlockCache removes the unencrypted disk block
+// cache under dirPath, if any, since its metadata shows which blocks
+// of which folders were accessed, and when.  The blocks are fetched
+// again as needed.
+func removePlaintextDiskBlockCache(dirPath string) error {
+	fileInfos, err := ioutil.ReadDir(dirPath)
+	switch {
+	case ioutil.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	for _, fi := range fileInfos {
+		name := fi.Name()
+		isVersionDir := false
+		if fi.IsDir() && strings.HasPrefix(name, "v") {
+			_, err := strconv.ParseUint(name[1:], 10, strconv.IntSize)
+			isVersionDir = err == nil
+		}
+		if name != versionFilename && !isVersionDir {
+			continue
+		}
+		err := ioutil.RemoveAll(filepath.Join(dirPath, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // WaitUntilStarted waits until this cache has started.
@@ -284,8 +498,7 @@ func (cache *DiskBlockCacheStandard) syncBlockCountsFromDb() error {
 	iter := cache.metaDb.NewIterator(nil, nil)
 	defer iter.Release()
 	for iter.Next() {
-		metadata := diskBlockCacheMetadata{}
-		err := cache.config.Codec().Decode(iter.Value(), &metadata)
+		metadata, err := cache.decodeMetadata(iter.Value())
 		if err != nil {
 			return err
 		}
@@ -299,25 +512,77 @@ func (cache *DiskBlockCacheStandard) syncBlockCountsFromDb() error {
 	cache.numBlocks = numBlocks
 	cache.tlfSizes = tlfSizes
 	cache.currBytes = totalSize
+	for tlfID, size := range tlfSizes {
+		cache.metrics.updateBytes(tlfID, size, totalSize)
+	}
 	return nil
 }
 
+// tlfPrefix returns the prefix of the TLF cache keys for the blocks of
+// a TLF.
+func (cache *DiskBlockCacheStandard) tlfPrefix(tlfID tlf.ID) []byte {
+	return cache.crypter.obfuscate(tlfID.Bytes())
+}
+
 // tlfKey generates a TLF cache key from a tlf.ID and a binary-encoded block
 // ID.
-func (*DiskBlockCacheStandard) tlfKey(tlfID tlf.ID, blockKey []byte) []byte {
-	return append(tlfID.Bytes(), blockKey...)
+func (cache *DiskBlockCacheStandard) tlfKey(
+	tlfID tlf.ID, blockKey []byte) []byte {
+	return append(cache.tlfPrefix(tlfID), blockKey...)
+}
+
+// encodeMetadata encodes (and maybe encrypts) the metadata for a
+// block.
+func (cache *DiskBlockCacheStandard) encodeMetadata(
+	metadata diskBlockCacheMetadata) ([]byte, error) {
+	encodedMetadata, err := cache.config.Codec().Encode(&metadata)
+	if err != nil {
+		return nil, err
+	}
+	return cache.crypter.encrypt(encodedMetadata)
+}
+
+// decodeMetadata is the inverse of encodeMetadata.
+func (cache *DiskBlockCacheStandard) decodeMetadata(buf []byte) (
+	metadata diskBlockCacheMetadata, err error) {
+	encodedMetadata, err := cache.crypter.decrypt(buf)
+	if err != nil {
+		return metadata, err
+	}
+	err = cache.config.Codec().Decode(encodedMetadata, &metadata)
+	return metadata, err
 }
 
 // updateMetadataLocked updates the LRU time of a block in the LRU cache to
-// the current time.
+// the current time, and counts the use of the block.  A pinned block stays
+// pinned.
 func (cache *DiskBlockCacheStandard) updateMetadataLocked(ctx context.Context,
 	tlfID tlf.ID, blockKey []byte, encodeLen int) error {
 	metadata := diskBlockCacheMetadata{
 		TlfID:     tlfID,
 		LRUTime:   cache.config.Clock().Now(),
 		BlockSize: uint32(encodeLen),
+		HitCount:  1,
 	}
-	encodedMetadata, err := cache.config.Codec().Encode(&metadata)
+	if oldMetadataBytes, err := cache.metaDb.Get(blockKey, nil); err == nil {
+		oldMetadata, err := cache.decodeMetadata(oldMetadataBytes)
+		if err != nil {
+			return err
+		}
+		metadata.Pinned = oldMetadata.Pinned
+		if oldMetadata.HitCount < math.MaxUint32 {
+			metadata.HitCount = oldMetadata.HitCount + 1
+		} else {
+			metadata.HitCount = oldMetadata.HitCount
+		}
+	}
+	return cache.putMetadataLocked(ctx, blockKey, metadata)
+}
+
+// putMetadataLocked writes the metadata for a block.
+func (cache *DiskBlockCacheStandard) putMetadataLocked(ctx context.Context,
+	blockKey []byte, metadata diskBlockCacheMetadata) error {
+	encodedMetadata, err := cache.encodeMetadata(metadata)
 	if err != nil {
 		return err
 	}
@@ -337,8 +602,7 @@ func (cache *DiskBlockCacheStandard) getMetadata(blockID kbfsblock.ID) (
 	if err != nil {
 		return metadata, err
 	}
-	err = cache.config.Codec().Decode(metadataBytes, &metadata)
-	return metadata, err
+	return cache.decodeMetadata(metadataBytes)
 }
 
 // getLRU retrieves the LRU time for a block in the cache, or returns
@@ -375,6 +639,66 @@ func (cache *DiskBlockCacheStandard) encodeBlockCacheEntry(buf []byte,
 	return cache.config.Codec().Encode(&entry)
 }
 
+// recordLookup counts a lookup of a block of the given TLF.
+func (cache *DiskBlockCacheStandard) recordLookup(tlfID tlf.ID, hit bool) {
+	cache.metrics.markLookup(hit)
+	cache.statsLock.Lock()
+	defer cache.statsLock.Unlock()
+	if hit {
+		cache.tlfHits[tlfID]++
+	} else {
+		cache.tlfMisses[tlfID]++
+	}
+}
+
+// tlfLimitLocked returns the most bytes the given TLF may use, or 0 if
+// there's no limit.
+func (cache *DiskBlockCacheStandard) tlfLimitLocked(tlfID tlf.ID) int64 {
+	if limit := cache.tlfSettings[tlfID].Limit; limit != 0 {
+		return limit
+	}
+	return cache.config.DiskCacheTLFLimit()
+}
+
+// tlfEvictionPolicyLocked returns the policy for picking which blocks
+// of the given TLF to evict first.
+func (cache *DiskBlockCacheStandard) tlfEvictionPolicyLocked(
+	tlfID tlf.ID) DiskCacheEvictionPolicy {
+	if s := cache.tlfSettings[tlfID].EvictionPolicy; s != "" {
+		// The policy was checked when it was set or loaded.
+		if policy, err := ParseDiskCacheEvictionPolicy(s); err == nil {
+			return policy
+		}
+	}
+	return cache.config.DiskCacheEvictionPolicy()
+}
+
+// makeRoomInTLFLocked evicts blocks of the given TLF until a new block
+// of the given size fits within the TLF's limit.
+func (cache *DiskBlockCacheStandard) makeRoomInTLFLocked(ctx context.Context,
+	tlfID tlf.ID, blockID kbfsblock.ID, encodedLen int64) error {
+	limit := cache.tlfLimitLocked(tlfID)
+	if limit <= 0 {
+		return nil
+	}
+	for i := 0; int64(cache.tlfSizes[tlfID])+encodedLen > limit; i++ {
+		if i == maxEvictionsPerPut {
+			return cachePutCacheFullError{blockID}
+		}
+		numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlfID,
+			defaultNumBlocksToEvict)
+		if err != nil {
+			return err
+		}
+		if numRemoved == 0 {
+			// Everything left is pinned (or the block is bigger
+			// than the limit).
+			return cachePutCacheFullError{blockID}
+		}
+	}
+	return nil
+}
+
 // Get implements the DiskBlockCache interface for DiskBlockCacheStandard.
 func (cache *DiskBlockCacheStandard) Get(ctx context.Context, tlfID tlf.ID,
 	blockID kbfsblock.ID) (buf []byte,
@@ -405,6 +729,7 @@ func (cache *DiskBlockCacheStandard) Get(ctx context.Context, tlfID tlf.ID,
 	}()
 	blockKey := blockID.Bytes()
 	entry, err := cache.blockDb.Get(blockKey, nil)
+	cache.recordLookup(tlfID, err == nil)
 	if err != nil {
 		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
 			NoSuchBlockError{blockID}
@@ -420,6 +745,7 @@ func (cache *DiskBlockCacheStandard) Get(ctx context.Context, tlfID tlf.ID,
 func (cache *DiskBlockCacheStandard) Put(ctx context.Context, tlfID tlf.ID,
 	blockID kbfsblock.ID, buf []byte,
 	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	defer cache.metrics.updatePutTime(time.Now())
 	select {
 	case <-cache.startedCh:
 	default:
@@ -453,6 +779,10 @@ func (cache *DiskBlockCacheStandard) Put(ctx context.Context, tlfID tlf.ID,
 		return err
 	}
 	if !hasKey {
+		err = cache.makeRoomInTLFLocked(ctx, tlfID, blockID, encodedLen)
+		if err != nil {
+			return err
+		}
 		i := 0
 		for ; i < maxEvictionsPerPut; i++ {
 			select {
@@ -497,6 +827,8 @@ func (cache *DiskBlockCacheStandard) Put(ctx context.Context, tlfID tlf.ID,
 		encodedLenUint := uint64(encodedLen)
 		cache.tlfSizes[tlfID] += encodedLenUint
 		cache.currBytes += encodedLenUint
+		cache.metrics.updateBytes(
+			tlfID, cache.tlfSizes[tlfID], cache.currBytes)
 	}
 	tlfKey := cache.tlfKey(tlfID, blockKey)
 	hasKey, err = cache.tlfDb.Has(tlfKey, nil)
@@ -546,6 +878,292 @@ func (cache *DiskBlockCacheStandard) UpdateLRUTime(ctx context.Context,
 		int(md.BlockSize))
 }
 
+// Pin implements the DiskBlockCache interface for DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) Pin(ctx context.Context, tlfID tlf.ID,
+	blockID kbfsblock.ID) (err error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		// If the cache hasn't started yet, return an error.
+		return DiskCacheStartingError{"Pin"}
+	}
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache Pin id=%s tlf=%s err=%+v",
+			blockID, tlfID, err)
+	}()
+	// Take a write lock so that a concurrent Get can't overwrite the
+	// pin with stale metadata.
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return DiskCacheClosedError{"Pin"}
+	default:
+	}
+	md, err := cache.getMetadata(blockID)
+	if err != nil {
+		return NoSuchBlockError{blockID}
+	}
+	if md.Pinned {
+		return nil
+	}
+	md.Pinned = true
+	return cache.putMetadataLocked(ctx, blockID.Bytes(), md)
+}
+
+// UnpinTLF implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) UnpinTLF(ctx context.Context,
+	tlfID tlf.ID, except map[kbfsblock.ID]bool) (numUnpinned int, err error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		// If the cache hasn't started yet, return an error.
+		return 0, DiskCacheStartingError{"UnpinTLF"}
+	}
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache UnpinTLF tlf=%s numExcepted=%d "+
+			"numUnpinned=%d err=%+v", tlfID, len(except), numUnpinned, err)
+	}()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return 0, DiskCacheClosedError{"UnpinTLF"}
+	default:
+	}
+
+	tlfBytes := cache.tlfPrefix(tlfID)
+	rng := &util.Range{
+		Start: tlfBytes,
+		Limit: cache.tlfKey(tlfID, cache.maxBlockID),
+	}
+	iter := cache.tlfDb.NewIterator(rng, nil)
+	defer iter.Release()
+	metadataBatch := new(leveldb.Batch)
+	for iter.Next() {
+		blockID, err := kbfsblock.IDFromBytes(iter.Key()[len(tlfBytes):])
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error decoding block ID %x",
+				iter.Key())
+			continue
+		}
+		if except[blockID] {
+			continue
+		}
+		md, err := cache.getMetadata(blockID)
+		if err != nil || !md.Pinned {
+			continue
+		}
+		md.Pinned = false
+		encodedMetadata, err := cache.encodeMetadata(md)
+		if err != nil {
+			return 0, err
+		}
+		metadataBatch.Put(blockID.Bytes(), encodedMetadata)
+		numUnpinned++
+	}
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if err := cache.metaDb.Write(metadataBatch, nil); err != nil {
+		return 0, err
+	}
+	return numUnpinned, nil
+}
+
+// ClearTLF implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) ClearTLF(ctx context.Context,
+	tlfID tlf.ID) (numRemoved int, sizeRemoved int64, err error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		// If the cache hasn't started yet, return an error.
+		return 0, 0, DiskCacheStartingError{"ClearTLF"}
+	}
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache ClearTLF tlf=%s numRemoved=%d "+
+			"sizeRemoved=%d err=%+v", tlfID, numRemoved, sizeRemoved, err)
+	}()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return 0, 0, DiskCacheClosedError{"ClearTLF"}
+	default:
+	}
+
+	tlfBytes := cache.tlfPrefix(tlfID)
+	iter := cache.tlfDb.NewIterator(util.BytesPrefix(tlfBytes), nil)
+	defer iter.Release()
+	var blockIDs []kbfsblock.ID
+	for iter.Next() {
+		blockID, err := kbfsblock.IDFromBytes(iter.Key()[len(tlfBytes):])
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error decoding block ID %x",
+				iter.Key())
+			continue
+		}
+		md, err := cache.getMetadata(blockID)
+		if err != nil || md.Pinned {
+			continue
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	if err := iter.Error(); err != nil {
+		return 0, 0, err
+	}
+	return cache.deleteLocked(ctx, blockIDs)
+}
+
+func (cache *DiskBlockCacheStandard) saveSettingsLocked() error {
+	if cache.settingsPath == "" {
+		return nil
+	}
+	return cache.crypter.serializeToJSONFile(
+		diskBlockCacheSettings{TLFs: cache.tlfSettings},
+		cache.settingsPath)
+}
+
+// setTLFSettingsLocked replaces the settings of the given TLF, and
+// saves them.
+func (cache *DiskBlockCacheStandard) setTLFSettingsLocked(
+	tlfID tlf.ID, settings diskBlockCacheTLFSettings) error {
+	if settings == (diskBlockCacheTLFSettings{}) {
+		delete(cache.tlfSettings, tlfID)
+	} else {
+		cache.tlfSettings[tlfID] = settings
+	}
+	return cache.saveSettingsLocked()
+}
+
+// SetTLFLimit implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.  If the TLF already uses more than the new
+// limit, blocks are evicted until it doesn't.
+func (cache *DiskBlockCacheStandard) SetTLFLimit(ctx context.Context,
+	tlfID tlf.ID, limit int64) (err error) {
+	if limit < 0 {
+		return errors.Errorf("Negative disk cache limit %d", limit)
+	}
+	select {
+	case <-cache.startedCh:
+	default:
+		// If the cache hasn't started yet, return an error.
+		return DiskCacheStartingError{"SetTLFLimit"}
+	}
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache SetTLFLimit tlf=%s limit=%d err=%+v",
+			tlfID, limit, err)
+	}()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return DiskCacheClosedError{"SetTLFLimit"}
+	default:
+	}
+
+	settings := cache.tlfSettings[tlfID]
+	settings.Limit = limit
+	err = cache.setTLFSettingsLocked(tlfID, settings)
+	if err != nil {
+		return err
+	}
+
+	limit = cache.tlfLimitLocked(tlfID)
+	for limit > 0 && int64(cache.tlfSizes[tlfID]) > limit {
+		numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlfID,
+			defaultNumBlocksToEvict)
+		if err != nil {
+			return err
+		}
+		if numRemoved == 0 {
+			// The rest is pinned.
+			break
+		}
+	}
+	return nil
+}
+
+// SetTLFEvictionPolicy implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) SetTLFEvictionPolicy(
+	ctx context.Context, tlfID tlf.ID, policy string) (err error) {
+	if policy != "" {
+		_, err := ParseDiskCacheEvictionPolicy(policy)
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case <-cache.startedCh:
+	default:
+		// If the cache hasn't started yet, return an error.
+		return DiskCacheStartingError{"SetTLFEvictionPolicy"}
+	}
+	defer func() {
+		cache.log.CDebugf(ctx, "Cache SetTLFEvictionPolicy tlf=%s "+
+			"policy=%q err=%+v", tlfID, policy, err)
+	}()
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return DiskCacheClosedError{"SetTLFEvictionPolicy"}
+	default:
+	}
+
+	settings := cache.tlfSettings[tlfID]
+	settings.EvictionPolicy = policy
+	return cache.setTLFSettingsLocked(tlfID, settings)
+}
+
+// TLFStatus implements the DiskBlockCache interface for
+// DiskBlockCacheStandard.
+func (cache *DiskBlockCacheStandard) TLFStatus(ctx context.Context,
+	tlfID tlf.ID) (DiskBlockCacheTLFStatus, error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		// If the cache hasn't started yet, return an error.
+		return DiskBlockCacheTLFStatus{}, DiskCacheStartingError{"TLFStatus"}
+	}
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return DiskBlockCacheTLFStatus{}, DiskCacheClosedError{"TLFStatus"}
+	default:
+	}
+	return cache.tlfStatusLocked(tlfID), nil
+}
+
+func (cache *DiskBlockCacheStandard) tlfStatusLocked(
+	tlfID tlf.ID) DiskBlockCacheTLFStatus {
+	status := DiskBlockCacheTLFStatus{
+		NumBlocks:      cache.tlfCounts[tlfID],
+		Bytes:          cache.tlfSizes[tlfID],
+		LimitBytes:     cache.tlfLimitLocked(tlfID),
+		EvictionPolicy: cache.tlfEvictionPolicyLocked(tlfID).String(),
+		CacheBytes:     cache.currBytes,
+	}
+	cache.statsLock.Lock()
+	defer cache.statsLock.Unlock()
+	status.Hits = cache.tlfHits[tlfID]
+	status.Misses = cache.tlfMisses[tlfID]
+	if lookups := status.Hits + status.Misses; lookups > 0 {
+		status.HitRate = float64(status.Hits) / float64(lookups)
+	}
+	return status
+}
+
 // Size implements the DiskBlockCache interface for DiskBlockCacheStandard.
 func (cache *DiskBlockCacheStandard) Size() int64 {
 	select {
@@ -585,8 +1203,7 @@ func (cache *DiskBlockCacheStandard) deleteLocked(ctx context.Context,
 			// don't account for its non-presence.
 			continue
 		}
-		metadata := diskBlockCacheMetadata{}
-		err = cache.config.Codec().Decode(metadataBytes, &metadata)
+		metadata, err := cache.decodeMetadata(metadataBytes)
 		if err != nil {
 			return 0, 0, err
 		}
@@ -617,6 +1234,9 @@ func (cache *DiskBlockCacheStandard) deleteLocked(ctx context.Context,
 		cache.tlfSizes[k] -= removalSizes[k]
 		cache.currBytes -= removalSizes[k]
 	}
+	for k := range removalCounts {
+		cache.metrics.updateBytes(k, cache.tlfSizes[k], cache.currBytes)
+	}
 	cache.config.DiskLimiter().onDiskBlockCacheDelete(ctx, sizeRemoved)
 
 	return numRemoved, sizeRemoved, nil
@@ -668,72 +1288,88 @@ func (*DiskBlockCacheStandard) getRandomBlockID(numElements,
 }
 
 func (cache *DiskBlockCacheStandard) evictSomeBlocks(ctx context.Context,
-	numBlocks int, blockIDs blockIDsByTime) (numRemoved int, sizeRemoved int64,
-	err error) {
+	numBlocks int, blockIDs blockIDsByTime, policy DiskCacheEvictionPolicy) (
+	numRemoved int, sizeRemoved int64, err error) {
 	if len(blockIDs) <= numBlocks {
 		numBlocks = len(blockIDs)
 	} else {
 		// Only sort if we need to grab a subset of blocks.
-		sort.Sort(blockIDs)
+		sort.Sort(blockIDsByPolicy{
+			blockIDs, policy, cache.config.Clock().Now()})
 	}
 
 	blocksToDelete := blockIDs.ToBlockIDSlice(numBlocks)
-	return cache.deleteLocked(ctx, blocksToDelete)
+	numRemoved, sizeRemoved, err = cache.deleteLocked(ctx, blocksToDelete)
+	cache.metrics.markEvictions(numRemoved)
+	return numRemoved, sizeRemoved, err
 }
 
 // evictFromTLFLocked evicts a number of blocks from the cache for a given TLF.
 // We choose a pivot variable b randomly. Then begin an iterator into
 // cache.tlfDb.Range(tlfID + b, tlfID + MaxBlockID) and iterate from there to
 // get numBlocks * evictionConsiderationFactor block IDs.  We sort the
-// resulting blocks by value (LRU time) and pick the minimum numBlocks. We then
-// call cache.Delete() on that list of block IDs.
+// resulting blocks according to the eviction policy (by default, by LRU time)
+// and pick the first numBlocks. We then call cache.Delete() on that list of
+// block IDs.  Pinned blocks are skipped over, and don't count toward the
+// blocks under consideration.  If there aren't enough unpinned blocks after
+// the pivot, we wrap around and consider the TLF's blocks before it too.
 func (cache *DiskBlockCacheStandard) evictFromTLFLocked(ctx context.Context,
 	tlfID tlf.ID, numBlocks int) (numRemoved int, sizeRemoved int64, err error) {
-	tlfBytes := tlfID.Bytes()
+	tlfBytes := cache.tlfPrefix(tlfID)
 	numElements := numBlocks * evictionConsiderationFactor
 	blockID, err := cache.getRandomBlockID(numElements, cache.tlfCounts[tlfID])
 	if err != nil {
 		return 0, 0, err
 	}
-	rng := &util.Range{
-		Start: append(tlfBytes, blockID.Bytes()...),
-		Limit: append(tlfBytes, cache.maxBlockID...),
+	pivot := cache.tlfKey(tlfID, blockID.Bytes())
+	rngs := []*util.Range{
+		{Start: pivot, Limit: cache.tlfKey(tlfID, cache.maxBlockID)},
+	}
+	if blockID != (kbfsblock.ID{}) {
+		rngs = append(rngs, &util.Range{Start: tlfBytes, Limit: pivot})
 	}
-	iter := cache.tlfDb.NewIterator(rng, nil)
-	defer iter.Release()
 
 	blockIDs := make(blockIDsByTime, 0, numElements)
 
-	for i := 0; i < numElements; i++ {
-		if !iter.Next() {
-			break
-		}
-		key := iter.Key()
+	for _, rng := range rngs {
+		iter := cache.tlfDb.NewIterator(rng, nil)
+		for len(blockIDs) < numElements && iter.Next() {
+			key := iter.Key()
 
-		blockIDBytes := key[len(tlfBytes):]
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding block ID %x", blockIDBytes)
-			continue
-		}
-		blockID, err := kbfsblock.IDFromBytes(blockIDBytes)
-		lru, err := cache.getLRU(blockID)
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding LRU time for block %s",
-				blockID)
-			continue
+			blockIDBytes := key[len(tlfBytes):]
+			blockID, err := kbfsblock.IDFromBytes(blockIDBytes)
+			if err != nil {
+				cache.log.CWarningf(ctx, "Error decoding block ID %x",
+					blockIDBytes)
+				continue
+			}
+			metadata, err := cache.getMetadata(blockID)
+			if err != nil {
+				cache.log.CWarningf(ctx,
+					"Error decoding LRU time for block %s", blockID)
+				continue
+			}
+			if metadata.Pinned {
+				continue
+			}
+			blockIDs = append(blockIDs, makeLRUEntry(blockID, metadata))
 		}
-		blockIDs = append(blockIDs, lruEntry{blockID, lru})
+		iter.Release()
 	}
 
-	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
+	return cache.evictSomeBlocks(
+		ctx, numBlocks, blockIDs, cache.tlfEvictionPolicyLocked(tlfID))
 }
 
 // evictLocked evicts a number of blocks from the cache.  We choose a pivot
 // variable b randomly. Then begin an iterator into cache.metaDb.Range(b,
 // MaxBlockID) and iterate from there to get numBlocks *
-// evictionConsiderationFactor block IDs.  We sort the resulting blocks by
-// value (LRU time) and pick the minimum numBlocks. We then call cache.Delete()
-// on that list of block IDs.
+// evictionConsiderationFactor block IDs.  We sort the resulting blocks
+// according to the eviction policy (by default, by LRU time) and pick the
+// first numBlocks. We then call cache.Delete() on that list of block IDs.
+// Pinned blocks are skipped over, and don't count toward the blocks under
+// consideration.  If there aren't enough unpinned blocks after the pivot, we
+// wrap around and consider the blocks before it too.
 func (cache *DiskBlockCacheStandard) evictLocked(ctx context.Context,
 	numBlocks int) (numRemoved int, sizeRemoved int64, err error) {
 	numElements := numBlocks * evictionConsiderationFactor
@@ -741,34 +1377,39 @@ func (cache *DiskBlockCacheStandard) evictLocked(ctx context.Context,
 	if err != nil {
 		return 0, 0, err
 	}
-	rng := &util.Range{Start: blockID.Bytes(), Limit: cache.maxBlockID}
-	iter := cache.metaDb.NewIterator(rng, nil)
-	defer iter.Release()
+	rngs := []*util.Range{{Start: blockID.Bytes(), Limit: cache.maxBlockID}}
+	if blockID != (kbfsblock.ID{}) {
+		rngs = append(rngs, &util.Range{Limit: blockID.Bytes()})
+	}
 
 	blockIDs := make(blockIDsByTime, 0, numElements)
 
-	for i := 0; i < numElements; i++ {
-		if !iter.Next() {
-			break
-		}
-		key := iter.Key()
+	for _, rng := range rngs {
+		iter := cache.metaDb.NewIterator(rng, nil)
+		for len(blockIDs) < numElements && iter.Next() {
+			key := iter.Key()
 
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding block ID %x", key)
-			continue
-		}
-		blockID, err := kbfsblock.IDFromBytes(key)
-		metadata := diskBlockCacheMetadata{}
-		err = cache.config.Codec().Decode(iter.Value(), &metadata)
-		if err != nil {
-			cache.log.CWarningf(ctx, "Error decoding metadata for block %s",
-				blockID)
-			continue
+			blockID, err := kbfsblock.IDFromBytes(key)
+			if err != nil {
+				cache.log.CWarningf(ctx, "Error decoding block ID %x", key)
+				continue
+			}
+			metadata, err := cache.decodeMetadata(iter.Value())
+			if err != nil {
+				cache.log.CWarningf(ctx,
+					"Error decoding metadata for block %s", blockID)
+				continue
+			}
+			if metadata.Pinned {
+				continue
+			}
+			blockIDs = append(blockIDs, makeLRUEntry(blockID, metadata))
 		}
-		blockIDs = append(blockIDs, lruEntry{blockID, metadata.LRUTime})
+		iter.Release()
 	}
 
-	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
+	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs,
+		cache.config.DiskCacheEvictionPolicy())
 }
 
 // Shutdown implements the DiskBlockCache interface for DiskBlockCacheStandard.
@@ -800,9 +1441,23 @@ func (cache *DiskBlockCacheStandard) Shutdown(ctx context.Context) {
 	cache.blockDb = nil
 	err = cache.metaDb.Close()
 	if err != nil {
-		cache.log.CWarningf(ctx, "Error closing blockDb: %+v", err)
+		cache.log.CWarningf(ctx, "Error closing metaDb: %+v", err)
 	}
 	cache.metaDb = nil
+	err = cache.tlfDb.Close()
+	if err != nil {
+		cache.log.CWarningf(ctx, "Error closing tlfDb: %+v", err)
+	}
+	cache.tlfDb = nil
+	// The DBs don't close the storage they were opened with, which
+	// holds the lock on each DB's directory.
+	for _, stor := range cache.storages {
+		err = stor.Close()
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error closing storage: %+v", err)
+		}
+	}
+	cache.storages = nil
 	cache.config.DiskLimiter().onDiskBlockCacheDisable(ctx,
 		int64(cache.currBytes))
 }
@@ -1,6 +1,7 @@
 package libkbfs
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/keybase/kbfs/kbfsblock"
@@ -24,12 +25,76 @@ type diskBlockCacheMetadata struct {
 	LRUTime time.Time
 	// the size of the block
 	BlockSize uint32
+	// whether the block is pinned, i.e. it belongs to a folder that
+	// is kept synced, and so is exempt from eviction
+	Pinned bool `codec:",omitempty"`
+	// the number of times the block has been put or gotten
+	HitCount uint32 `codec:",omitempty"`
 }
 
-// lruEntry is an entry for sorting LRU times
+// DiskCacheEvictionPolicy says which blocks the disk block cache
+// evicts first when it needs to make room.
+type DiskCacheEvictionPolicy int
+
+const (
+	// DiskCacheEvictLRU evicts the least recently used blocks
+	// first.
+	DiskCacheEvictLRU DiskCacheEvictionPolicy = iota
+	// DiskCacheEvictLFU evicts the least frequently used blocks
+	// first, and the least recently used among equally used ones.
+	DiskCacheEvictLFU
+	// DiskCacheEvictSizeWeighted evicts the blocks with the largest
+	// product of size and time since last use first, so big blocks
+	// go before small ones that were last used at the same time.
+	DiskCacheEvictSizeWeighted
+)
+
+func (p DiskCacheEvictionPolicy) String() string {
+	switch p {
+	case DiskCacheEvictLRU:
+		return DiskCacheEvictLRUString
+	case DiskCacheEvictLFU:
+		return DiskCacheEvictLFUString
+	case DiskCacheEvictSizeWeighted:
+		return DiskCacheEvictSizeWeightedString
+	default:
+		return fmt.Sprintf("DiskCacheEvictionPolicy(%d)", int(p))
+	}
+}
+
+// ParseDiskCacheEvictionPolicy returns the policy named by s, which
+// is one of DiskCacheEvictLRUString, DiskCacheEvictLFUString or
+// DiskCacheEvictSizeWeightedString.
+func ParseDiskCacheEvictionPolicy(s string) (DiskCacheEvictionPolicy, error) {
+	switch s {
+	case DiskCacheEvictLRUString:
+		return DiskCacheEvictLRU, nil
+	case DiskCacheEvictLFUString:
+		return DiskCacheEvictLFU, nil
+	case DiskCacheEvictSizeWeightedString:
+		return DiskCacheEvictSizeWeighted, nil
+	default:
+		return 0, fmt.Errorf(
+			"Unexpected disk cache eviction policy: %s", s)
+	}
+}
+
+// lruEntry is an entry for sorting blocks for eviction
 type lruEntry struct {
 	BlockID kbfsblock.ID
 	Time    time.Time
+	Hits    uint32
+	Size    uint32
+}
+
+func makeLRUEntry(
+	blockID kbfsblock.ID, metadata diskBlockCacheMetadata) lruEntry {
+	return lruEntry{
+		BlockID: blockID,
+		Time:    metadata.LRUTime,
+		Hits:    metadata.HitCount,
+		Size:    metadata.BlockSize,
+	}
 }
 
 type blockIDsByTime []lruEntry
@@ -38,6 +103,34 @@ func (b blockIDsByTime) Len() int           { return len(b) }
 func (b blockIDsByTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
 func (b blockIDsByTime) Less(i, j int) bool { return b[i].Time.Before(b[j].Time) }
 
+// blockIDsByPolicy sorts blocks so that the ones the given policy
+// would evict first come first.
+type blockIDsByPolicy struct {
+	blockIDsByTime
+	policy DiskCacheEvictionPolicy
+	now    time.Time
+}
+
+func (b blockIDsByPolicy) sizeWeightedAge(i int) float64 {
+	// Count from a second before now so that recently used blocks
+	// still get weighted by their size.
+	age := b.now.Sub(b.blockIDsByTime[i].Time) + time.Second
+	return age.Seconds() * float64(b.blockIDsByTime[i].Size)
+}
+
+func (b blockIDsByPolicy) Less(i, j int) bool {
+	switch b.policy {
+	case DiskCacheEvictLFU:
+		hi, hj := b.blockIDsByTime[i].Hits, b.blockIDsByTime[j].Hits
+		if hi != hj {
+			return hi < hj
+		}
+	case DiskCacheEvictSizeWeighted:
+		return b.sizeWeightedAge(i) > b.sizeWeightedAge(j)
+	}
+	return b.blockIDsByTime.Less(i, j)
+}
+
 func (b blockIDsByTime) ToBlockIDSlice(numBlocks int) []kbfsblock.ID {
 	ids := make([]kbfsblock.ID, 0, numBlocks)
 	for _, entry := range b {
@@ -0,0 +1,219 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/net/context"
+)
+
+// diskBlockCacheOfflineConfig is the diskBlockCacheConfig for a disk
+// block cache opened without the rest of KBFS, e.g. by kbfstool.
+type diskBlockCacheOfflineConfig struct {
+	codec   kbfscodec.Codec
+	log     logger.Logger
+	limiter DiskLimiter
+}
+
+var _ diskBlockCacheConfig = diskBlockCacheOfflineConfig{}
+
+func (c diskBlockCacheOfflineConfig) Codec() kbfscodec.Codec {
+	return c.codec
+}
+
+func (c diskBlockCacheOfflineConfig) MakeLogger(module string) logger.Logger {
+	return c.log
+}
+
+func (c diskBlockCacheOfflineConfig) Clock() Clock {
+	return wallClock{}
+}
+
+func (c diskBlockCacheOfflineConfig) DiskLimiter() DiskLimiter {
+	return c.limiter
+}
+
+func (c diskBlockCacheOfflineConfig) DiskCacheEvictionPolicy() DiskCacheEvictionPolicy {
+	return DiskCacheEvictLRU
+}
+
+func (c diskBlockCacheOfflineConfig) DiskCacheTLFLimit() int64 {
+	return 0
+}
+
+func (c diskBlockCacheOfflineConfig) MetricsRegistry() metrics.Registry {
+	return nil
+}
+
+// OpenDiskBlockCacheOffline opens the disk block cache under the
+// given storage root, for inspection while no KBFS instance is using
+// it.  Only unencrypted caches can be opened this way, since the key
+// for an encrypted cache comes from the logged-in device.
+func OpenDiskBlockCacheOffline(storageRoot string, log logger.Logger) (
+	*DiskBlockCacheStandard, error) {
+	dirPath := diskBlockCacheRootFromStorageRoot(storageRoot)
+	// Don't create a cache that isn't there.
+	_, err := ioutil.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	config := diskBlockCacheOfflineConfig{
+		codec: kbfscodec.NewMsgpack(),
+		log:   log,
+		// The cache isn't limited by anything but the disk, since
+		// nothing else is running.
+		limiter: newSemaphoreDiskLimiter(
+			math.MaxInt64/2, math.MaxInt64/2, math.MaxInt64/2),
+	}
+	cache, err := newDiskBlockCacheStandard(config, dirPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-cache.startedCh:
+	case <-cache.startErrCh:
+		cache.Shutdown(context.Background())
+		return nil, errors.Errorf(
+			"Couldn't read the block counts of the disk block cache at %s",
+			dirPath)
+	}
+	return cache, nil
+}
+
+// DiskBlockCacheEntryInfo describes a block in the disk block cache.
+type DiskBlockCacheEntryInfo struct {
+	ID      kbfsblock.ID
+	Size    uint32
+	LRUTime time.Time
+	Hits    uint32
+	Pinned  bool
+}
+
+// TLFStatuses returns the status of every TLF with blocks in the
+// cache.
+func (cache *DiskBlockCacheStandard) TLFStatuses(ctx context.Context) (
+	map[tlf.ID]DiskBlockCacheTLFStatus, error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		return nil, DiskCacheStartingError{"TLFStatuses"}
+	}
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return nil, DiskCacheClosedError{"TLFStatuses"}
+	default:
+	}
+
+	statuses := make(map[tlf.ID]DiskBlockCacheTLFStatus, len(cache.tlfCounts))
+	for tlfID, count := range cache.tlfCounts {
+		if count == 0 {
+			continue
+		}
+		statuses[tlfID] = cache.tlfStatusLocked(tlfID)
+	}
+	return statuses, nil
+}
+
+// ListTLF describes every block of the given TLF in the cache.
+func (cache *DiskBlockCacheStandard) ListTLF(ctx context.Context,
+	tlfID tlf.ID) ([]DiskBlockCacheEntryInfo, error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		return nil, DiskCacheStartingError{"ListTLF"}
+	}
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return nil, DiskCacheClosedError{"ListTLF"}
+	default:
+	}
+
+	tlfBytes := cache.tlfPrefix(tlfID)
+	iter := cache.tlfDb.NewIterator(util.BytesPrefix(tlfBytes), nil)
+	defer iter.Release()
+	var infos []DiskBlockCacheEntryInfo
+	for iter.Next() {
+		blockID, err := kbfsblock.IDFromBytes(iter.Key()[len(tlfBytes):])
+		if err != nil {
+			return nil, err
+		}
+		md, err := cache.getMetadata(blockID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, DiskBlockCacheEntryInfo{
+			ID:      blockID,
+			Size:    md.BlockSize,
+			LRUTime: md.LRUTime,
+			Hits:    md.HitCount,
+			Pinned:  md.Pinned,
+		})
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Verify checks every block in the cache against its ID, and returns
+// the number of blocks checked and the IDs of the ones that don't
+// match (or can't be decoded).
+func (cache *DiskBlockCacheStandard) Verify(ctx context.Context) (
+	numChecked int, badIDs []kbfsblock.ID, err error) {
+	select {
+	case <-cache.startedCh:
+	default:
+		return 0, nil, DiskCacheStartingError{"Verify"}
+	}
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	// shutdownCh has to be checked under lock, otherwise we can race.
+	select {
+	case <-cache.shutdownCh:
+		return 0, nil, DiskCacheClosedError{"Verify"}
+	default:
+	}
+
+	iter := cache.blockDb.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		select {
+		case <-ctx.Done():
+			return numChecked, badIDs, ctx.Err()
+		default:
+		}
+		blockID, err := kbfsblock.IDFromBytes(iter.Key())
+		if err != nil {
+			return numChecked, badIDs, err
+		}
+		numChecked++
+		buf, _, err := cache.decodeBlockCacheEntry(iter.Value())
+		if err == nil {
+			err = kbfsblock.VerifyID(buf, blockID)
+		}
+		if err != nil {
+			cache.log.CDebugf(ctx, "Block %s failed verification: %+v",
+				blockID, err)
+			badIDs = append(badIDs, blockID)
+		}
+	}
+	return numChecked, badIDs, iter.Error()
+}
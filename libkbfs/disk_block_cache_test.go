@@ -5,14 +5,22 @@
 package libkbfs
 
 import (
+	"bytes"
 	"math"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/storage"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -27,7 +35,10 @@ type testDiskBlockCacheConfig struct {
 	codecGetter
 	logMaker
 	*testClockGetter
-	limiter DiskLimiter
+	limiter  DiskLimiter
+	policy   DiskCacheEvictionPolicy
+	tlfLimit int64
+	registry metrics.Registry
 }
 
 func newTestDiskBlockCacheConfig(t *testing.T) *testDiskBlockCacheConfig {
@@ -36,6 +47,9 @@ func newTestDiskBlockCacheConfig(t *testing.T) *testDiskBlockCacheConfig {
 		newTestLogMaker(t),
 		newTestClockGetter(),
 		nil,
+		DiskCacheEvictLRU,
+		0,
+		nil,
 	}
 }
 
@@ -43,6 +57,18 @@ func (c testDiskBlockCacheConfig) DiskLimiter() DiskLimiter {
 	return c.limiter
 }
 
+func (c testDiskBlockCacheConfig) DiskCacheEvictionPolicy() DiskCacheEvictionPolicy {
+	return c.policy
+}
+
+func (c testDiskBlockCacheConfig) DiskCacheTLFLimit() int64 {
+	return c.tlfLimit
+}
+
+func (c testDiskBlockCacheConfig) MetricsRegistry() metrics.Registry {
+	return c.registry
+}
+
 func newDiskBlockCacheStandardForTest(config *testDiskBlockCacheConfig,
 	maxBytes int64, limiter DiskLimiter) (*DiskBlockCacheStandard, error) {
 	blockStorage := storage.NewMemStorage()
@@ -50,7 +76,7 @@ func newDiskBlockCacheStandardForTest(config *testDiskBlockCacheConfig,
 	tlfStorage := storage.NewMemStorage()
 	maxFiles := int64(10000)
 	cache, err := newDiskBlockCacheStandardFromStorage(config, blockStorage,
-		lruStorage, tlfStorage)
+		lruStorage, tlfStorage, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -514,3 +540,594 @@ func TestDiskBlockCacheWithRetrievalQueue(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, block1, block)
 }
+
+func TestDiskBlockCachePinned(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that pinned blocks are never evicted until unpinned.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(0, false)
+
+	t.Log("Put 20 blocks in the cache, and pin every other one.")
+	var pinnedIDs, unpinnedIDs []kbfsblock.ID
+	for i := 0; i < 20; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, config)
+		err := cache.Put(ctx, tlf1, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			err = cache.Pin(ctx, tlf1, blockPtr.ID)
+			require.NoError(t, err)
+			pinnedIDs = append(pinnedIDs, blockPtr.ID)
+		} else {
+			unpinnedIDs = append(unpinnedIDs, blockPtr.ID)
+		}
+		clock.Add(time.Second)
+	}
+
+	t.Log("Pinning a block that isn't in the cache fails.")
+	err := cache.Pin(ctx, tlf1, kbfsblock.FakeID(1))
+	require.IsType(t, NoSuchBlockError{}, err)
+
+	t.Log("Reading a pinned block keeps it pinned.")
+	_, _, err = cache.Get(ctx, tlf1, pinnedIDs[0])
+	require.NoError(t, err)
+	md, err := cache.getMetadata(pinnedIDs[0])
+	require.NoError(t, err)
+	require.True(t, md.Pinned)
+
+	t.Log("Evict everything that can be evicted.  Considering 10 " +
+		"blocks at a time means every block is considered.")
+	for {
+		numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlf1, 10)
+		require.NoError(t, err)
+		if numRemoved == 0 {
+			break
+		}
+	}
+	numRemoved, _, err := cache.evictLocked(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, 0, numRemoved)
+	require.Equal(t, len(pinnedIDs), cache.numBlocks)
+	for _, id := range pinnedIDs {
+		_, _, err := cache.Get(ctx, tlf1, id)
+		require.NoError(t, err)
+	}
+	for _, id := range unpinnedIDs {
+		_, _, err := cache.Get(ctx, tlf1, id)
+		require.IsType(t, NoSuchBlockError{}, err)
+	}
+
+	t.Log("Unpin all but one block, and evict the rest.")
+	numUnpinned, err := cache.UnpinTLF(ctx, tlf1,
+		map[kbfsblock.ID]bool{pinnedIDs[0]: true})
+	require.NoError(t, err)
+	require.Equal(t, len(pinnedIDs)-1, numUnpinned)
+	numRemoved, _, err = cache.evictLocked(ctx, len(pinnedIDs))
+	require.NoError(t, err)
+	require.Equal(t, len(pinnedIDs)-1, numRemoved)
+	_, _, err = cache.Get(ctx, tlf1, pinnedIDs[0])
+	require.NoError(t, err)
+}
+
+func TestDiskBlockCacheEncrypted(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that an encrypted disk cache hides which TLFs it holds.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+	// The cache is still empty, so it's safe to switch it over.
+	cache.crypter = newLocalStorageCrypter(config.Codec(), []byte("secret"))
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(0, false)
+	tlf2 := tlf.FakeID(1, false)
+
+	t.Log("Put 10 blocks for each of two TLFs in the cache.")
+	var tlf1IDs []kbfsblock.ID
+	for _, id := range []tlf.ID{tlf1, tlf2} {
+		for i := 0; i < 10; i++ {
+			blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+				t, config)
+			err := cache.Put(ctx, id, blockPtr.ID, blockEncoded, serverHalf)
+			require.NoError(t, err)
+			if id == tlf1 {
+				tlf1IDs = append(tlf1IDs, blockPtr.ID)
+			}
+			clock.Add(time.Second)
+		}
+	}
+
+	t.Log("Neither the metadata nor the TLF keys contain the TLF IDs.")
+	for _, db := range []*leveldb.DB{cache.metaDb, cache.tlfDb} {
+		func() {
+			iter := db.NewIterator(nil, nil)
+			defer iter.Release()
+			for iter.Next() {
+				for _, id := range []tlf.ID{tlf1, tlf2} {
+					require.False(t, bytes.Contains(iter.Key(), id.Bytes()))
+					require.False(t, bytes.Contains(iter.Value(), id.Bytes()))
+				}
+			}
+		}()
+	}
+
+	t.Log("Blocks can still be read, and the counts rebuilt.")
+	for _, id := range tlf1IDs {
+		_, _, err := cache.Get(ctx, tlf1, id)
+		require.NoError(t, err)
+	}
+	cache.tlfCounts = nil
+	err := cache.syncBlockCountsFromDb()
+	require.NoError(t, err)
+	require.Equal(t, 10, cache.tlfCounts[tlf1])
+	require.Equal(t, 10, cache.tlfCounts[tlf2])
+
+	t.Log("Evicting from one TLF leaves the other alone.")
+	numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlf1, 10)
+	require.NoError(t, err)
+	require.Equal(t, 10, numRemoved)
+	require.Equal(t, 0, cache.tlfCounts[tlf1])
+	require.Equal(t, 10, cache.tlfCounts[tlf2])
+	for _, id := range tlf1IDs {
+		_, _, err := cache.Get(ctx, tlf1, id)
+		require.IsType(t, NoSuchBlockError{}, err)
+	}
+
+	t.Log("Without the key, the metadata can't be read.")
+	cache.crypter = newLocalStorageCrypter(config.Codec(), []byte("other"))
+	err = cache.syncBlockCountsFromDb()
+	require.Error(t, err)
+}
+
+func TestDiskBlockCacheTLFLimit(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that a TLF can't use more than its limit.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(0, false)
+	tlf2 := tlf.FakeID(1, false)
+
+	t.Log("Put 10 blocks in a TLF without a limit.")
+	for i := 0; i < 10; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, config)
+		err := cache.Put(ctx, tlf2, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		clock.Add(time.Second)
+	}
+	tlf2Size := cache.tlfSizes[tlf2]
+	limit := int64(tlf2Size / 2)
+
+	t.Log("Limit another TLF to half that, and put 10 blocks in it.")
+	err := cache.SetTLFLimit(ctx, tlf1, limit)
+	require.NoError(t, err)
+	var lastID kbfsblock.ID
+	for i := 0; i < 10; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, config)
+		err := cache.Put(ctx, tlf1, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		require.True(t, int64(cache.tlfSizes[tlf1]) <= limit)
+		lastID = blockPtr.ID
+		clock.Add(time.Second)
+	}
+	_, _, err = cache.Get(ctx, tlf1, lastID)
+	require.NoError(t, err)
+
+	t.Log("The other TLF was left alone.")
+	require.Equal(t, 10, cache.tlfCounts[tlf2])
+	require.Equal(t, tlf2Size, cache.tlfSizes[tlf2])
+
+	t.Log("Lowering the limit of a TLF evicts from it right away.")
+	err = cache.SetTLFLimit(ctx, tlf2, limit)
+	require.NoError(t, err)
+	require.True(t, int64(cache.tlfSizes[tlf2]) <= limit)
+
+	t.Log("Negative limits aren't allowed.")
+	err = cache.SetTLFLimit(ctx, tlf2, -1)
+	require.Error(t, err)
+
+	t.Log("A limit of 0 goes back to the configured default.")
+	err = cache.SetTLFLimit(ctx, tlf2, 0)
+	require.NoError(t, err)
+	status, err := cache.TLFStatus(ctx, tlf2)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), status.LimitBytes)
+	config.tlfLimit = 1
+	status, err = cache.TLFStatus(ctx, tlf2)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), status.LimitBytes)
+	status, err = cache.TLFStatus(ctx, tlf1)
+	require.NoError(t, err)
+	require.Equal(t, limit, status.LimitBytes)
+}
+
+func TestDiskBlockCacheTLFEvictionPolicy(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that a TLF can have its own eviction policy.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	clock := config.TestClock()
+	tlf1 := tlf.FakeID(0, false)
+	tlf2 := tlf.FakeID(1, false)
+
+	t.Log("In each TLF, put a block that's read a lot, and then two " +
+		"newer blocks.")
+	putBlocks := func(tlfID tlf.ID) (ids []kbfsblock.ID) {
+		for i := 0; i < 3; i++ {
+			blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+				t, config)
+			err := cache.Put(ctx, tlfID, blockPtr.ID, blockEncoded, serverHalf)
+			require.NoError(t, err)
+			if i == 0 {
+				for j := 0; j < 3; j++ {
+					_, _, err = cache.Get(ctx, tlfID, blockPtr.ID)
+					require.NoError(t, err)
+				}
+			}
+			ids = append(ids, blockPtr.ID)
+			clock.Add(time.Second)
+		}
+		return ids
+	}
+	tlf1IDs := putBlocks(tlf1)
+	tlf2IDs := putBlocks(tlf2)
+
+	err := cache.SetTLFEvictionPolicy(ctx, tlf1, DiskCacheEvictLFUString)
+	require.NoError(t, err)
+	status, err := cache.TLFStatus(ctx, tlf1)
+	require.NoError(t, err)
+	require.Equal(t, DiskCacheEvictLFUString, status.EvictionPolicy)
+	status, err = cache.TLFStatus(ctx, tlf2)
+	require.NoError(t, err)
+	require.Equal(t, DiskCacheEvictLRUString, status.EvictionPolicy)
+
+	t.Log("The TLF with the LFU policy evicts its least used block, " +
+		"and the other TLF its least recently used one.")
+	numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlf1, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, numRemoved)
+	_, _, err = cache.Get(ctx, tlf1, tlf1IDs[0])
+	require.NoError(t, err)
+	_, _, err = cache.Get(ctx, tlf1, tlf1IDs[1])
+	require.IsType(t, NoSuchBlockError{}, err)
+	numRemoved, _, err = cache.evictFromTLFLocked(ctx, tlf2, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, numRemoved)
+	_, _, err = cache.Get(ctx, tlf2, tlf2IDs[0])
+	require.IsType(t, NoSuchBlockError{}, err)
+	_, _, err = cache.Get(ctx, tlf2, tlf2IDs[1])
+	require.NoError(t, err)
+
+	t.Log("Unknown policies aren't allowed.")
+	err = cache.SetTLFEvictionPolicy(ctx, tlf1, "bogus")
+	require.Error(t, err)
+
+	t.Log("An empty policy goes back to the configured default.")
+	err = cache.SetTLFEvictionPolicy(ctx, tlf1, "")
+	require.NoError(t, err)
+	config.policy = DiskCacheEvictSizeWeighted
+	status, err = cache.TLFStatus(ctx, tlf1)
+	require.NoError(t, err)
+	require.Equal(t, DiskCacheEvictSizeWeightedString, status.EvictionPolicy)
+}
+
+func TestDiskBlockCacheEvictWrapsAround(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that eviction finds unpinned blocks before its random " +
+		"starting point.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(0, false)
+
+	t.Log("Put 20 blocks in the cache, and pin all but the one with " +
+		"the lowest ID.")
+	var lowest BlockPointer
+	var lowestEncoded []byte
+	var lowestServerHalf kbfscrypto.BlockCryptKeyServerHalf
+	var ids []kbfsblock.ID
+	for i := 0; i < 20; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, config)
+		err := cache.Put(ctx, tlf1, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		ids = append(ids, blockPtr.ID)
+		if i == 0 || bytes.Compare(
+			blockPtr.ID.Bytes(), lowest.ID.Bytes()) < 0 {
+			lowest = blockPtr
+			lowestEncoded = blockEncoded
+			lowestServerHalf = serverHalf
+		}
+	}
+	for _, id := range ids {
+		if id != lowest.ID {
+			err := cache.Pin(ctx, tlf1, id)
+			require.NoError(t, err)
+		}
+	}
+
+	t.Log("Evicting always finds the one unpinned block, wherever it " +
+		"starts looking.")
+	for i := 0; i < 10; i++ {
+		numRemoved, _, err := cache.evictFromTLFLocked(ctx, tlf1, 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, numRemoved)
+		err = cache.Put(
+			ctx, tlf1, lowest.ID, lowestEncoded, lowestServerHalf)
+		require.NoError(t, err)
+
+		numRemoved, _, err = cache.evictLocked(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, 1, numRemoved)
+		err = cache.Put(
+			ctx, tlf1, lowest.ID, lowestEncoded, lowestServerHalf)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 20, cache.numBlocks)
+}
+
+func TestDiskBlockCacheClearTLF(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that a single TLF can be cleared from the cache.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(0, false)
+	tlf2 := tlf.FakeID(1, false)
+
+	t.Log("Put 10 blocks for each of two TLFs, and pin one of them.")
+	var pinnedID kbfsblock.ID
+	for _, id := range []tlf.ID{tlf1, tlf2} {
+		for i := 0; i < 10; i++ {
+			blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+				t, config)
+			err := cache.Put(ctx, id, blockPtr.ID, blockEncoded, serverHalf)
+			require.NoError(t, err)
+			if id == tlf1 && i == 0 {
+				err = cache.Pin(ctx, id, blockPtr.ID)
+				require.NoError(t, err)
+				pinnedID = blockPtr.ID
+			}
+		}
+	}
+	tlf2Size := cache.tlfSizes[tlf2]
+
+	t.Log("Look up one block that's there, and one that isn't.")
+	_, _, err := cache.Get(ctx, tlf1, pinnedID)
+	require.NoError(t, err)
+	_, _, err = cache.Get(ctx, tlf1, kbfsblock.FakeID(1))
+	require.IsType(t, NoSuchBlockError{}, err)
+	status, err := cache.TLFStatus(ctx, tlf1)
+	require.NoError(t, err)
+	require.Equal(t, 10, status.NumBlocks)
+	require.Equal(t, int64(1), status.Hits)
+	require.Equal(t, int64(1), status.Misses)
+	require.Equal(t, 0.5, status.HitRate)
+	require.Equal(t, DiskCacheEvictLRUString, status.EvictionPolicy)
+	require.Equal(t, cache.currBytes, status.CacheBytes)
+
+	t.Log("Clear the first TLF; only the pinned block is left.")
+	numRemoved, _, err := cache.ClearTLF(ctx, tlf1)
+	require.NoError(t, err)
+	require.Equal(t, 9, numRemoved)
+	require.Equal(t, 1, cache.tlfCounts[tlf1])
+	_, _, err = cache.Get(ctx, tlf1, pinnedID)
+	require.NoError(t, err)
+
+	t.Log("The other TLF was left alone.")
+	require.Equal(t, 10, cache.tlfCounts[tlf2])
+	require.Equal(t, tlf2Size, cache.tlfSizes[tlf2])
+}
+
+func TestDiskBlockCacheEvictionPolicies(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that each eviction policy orders blocks as expected.")
+	now := time.Now()
+	old := lruEntry{kbfsblock.FakeID(1), now.Add(-time.Hour), 10, 100}
+	frequent := lruEntry{kbfsblock.FakeID(2), now.Add(-time.Minute), 100, 100}
+	big := lruEntry{kbfsblock.FakeID(3), now.Add(-2 * time.Minute), 1, 100000}
+
+	order := func(policy DiskCacheEvictionPolicy) []kbfsblock.ID {
+		blockIDs := blockIDsByTime{frequent, big, old}
+		sort.Sort(blockIDsByPolicy{blockIDs, policy, now})
+		return blockIDs.ToBlockIDSlice(len(blockIDs))
+	}
+	require.Equal(t, []kbfsblock.ID{old.BlockID, big.BlockID,
+		frequent.BlockID}, order(DiskCacheEvictLRU))
+	require.Equal(t, []kbfsblock.ID{big.BlockID, old.BlockID,
+		frequent.BlockID}, order(DiskCacheEvictLFU))
+	require.Equal(t, []kbfsblock.ID{big.BlockID, old.BlockID,
+		frequent.BlockID}, order(DiskCacheEvictSizeWeighted))
+
+	t.Log("Putting a block and each read of it count as a use.")
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(0, false)
+	blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+	err := cache.Put(ctx, tlf1, blockPtr.ID, blockEncoded, serverHalf)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, _, err = cache.Get(ctx, tlf1, blockPtr.ID)
+		require.NoError(t, err)
+	}
+	md, err := cache.getMetadata(blockPtr.ID)
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), md.HitCount)
+}
+
+func TestDiskBlockCacheMetrics(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that the disk cache keeps its metrics up to date.")
+	config := newTestDiskBlockCacheConfig(t)
+	config.registry = metrics.NewRegistry()
+	cache, err := newDiskBlockCacheStandardForTest(config,
+		testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+	defer shutdownDiskBlockCacheTest(cache)
+
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(0, false)
+	var blockIDs []kbfsblock.ID
+	for i := 0; i < 5; i++ {
+		blockPtr, _, blockEncoded, serverHalf := setupBlockForDiskCache(
+			t, config)
+		err := cache.Put(ctx, tlf1, blockPtr.ID, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		blockIDs = append(blockIDs, blockPtr.ID)
+	}
+	_, _, err = cache.Get(ctx, tlf1, blockIDs[0])
+	require.NoError(t, err)
+	_, _, err = cache.Get(ctx, tlf1, kbfsblock.FakeID(1))
+	require.IsType(t, NoSuchBlockError{}, err)
+
+	r := config.registry
+	require.Equal(t, int64(1),
+		metrics.GetOrRegisterMeter("DiskBlockCache.Hits", r).Count())
+	require.Equal(t, int64(1),
+		metrics.GetOrRegisterMeter("DiskBlockCache.Misses", r).Count())
+	require.Equal(t, int64(5),
+		metrics.GetOrRegisterTimer("DiskBlockCache.Put", r).Count())
+	tlfBytes := metrics.GetOrRegisterGauge(
+		"DiskBlockCache.TLFBytes."+tlf1.String(), r)
+	require.Equal(t, int64(cache.tlfSizes[tlf1]), tlfBytes.Value())
+	require.Equal(t, int64(cache.currBytes),
+		metrics.GetOrRegisterGauge("DiskBlockCache.Bytes", r).Value())
+
+	t.Log("Evictions are counted, and the sizes updated.")
+	numRemoved, _, err := cache.evictLocked(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(numRemoved), metrics.GetOrRegisterMeter(
+		"DiskBlockCache.Evictions", r).Count())
+	require.Equal(t, int64(cache.tlfSizes[tlf1]), tlfBytes.Value())
+}
+
+func TestDiskBlockCacheInspect(t *testing.T) {
+	t.Parallel()
+	t.Log("Test that a cache can be listed and verified offline.")
+	tempdir, err := ioutil.TempDir(os.TempDir(), "disk_block_cache")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	t.Log("There's nothing to open under an empty storage root.")
+	log := logger.NewTestLogger(t)
+	_, err = OpenDiskBlockCacheOffline(tempdir, log)
+	require.True(t, ioutil.IsNotExist(err))
+
+	t.Log("Put 5 blocks with proper IDs in a cache, and pin one.")
+	config := newTestDiskBlockCacheConfig(t)
+	config.limiter = newSemaphoreDiskLimiter(
+		testDiskBlockCacheMaxBytes, 10000, testDiskBlockCacheMaxBytes)
+	cache, err := newDiskBlockCacheStandard(config,
+		diskBlockCacheRootFromStorageRoot(tempdir), nil)
+	require.NoError(t, err)
+	cache.WaitUntilStarted()
+	ctx := context.Background()
+	tlf1 := tlf.FakeID(0, false)
+	var blockIDs []kbfsblock.ID
+	for i := 0; i < 5; i++ {
+		_, _, blockEncoded, serverHalf := setupBlockForDiskCache(t, config)
+		id, err := kbfsblock.MakePermanentID(blockEncoded)
+		require.NoError(t, err)
+		err = cache.Put(ctx, tlf1, id, blockEncoded, serverHalf)
+		require.NoError(t, err)
+		blockIDs = append(blockIDs, id)
+	}
+	err = cache.Pin(ctx, tlf1, blockIDs[0])
+	require.NoError(t, err)
+
+	t.Log("Corrupt one of the blocks.")
+	entry, err := cache.encodeBlockCacheEntry(
+		[]byte("garbage"), kbfscrypto.BlockCryptKeyServerHalf{})
+	require.NoError(t, err)
+	err = cache.blockDb.Put(blockIDs[1].Bytes(), entry, nil)
+	require.NoError(t, err)
+	cache.Shutdown(ctx)
+
+	t.Log("Open the cache offline, and check what's in it.")
+	cache, err = OpenDiskBlockCacheOffline(tempdir, log)
+	require.NoError(t, err)
+	defer cache.Shutdown(ctx)
+
+	statuses, err := cache.TLFStatuses(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, 5, statuses[tlf1].NumBlocks)
+
+	infos, err := cache.ListTLF(ctx, tlf1)
+	require.NoError(t, err)
+	require.Len(t, infos, 5)
+	for _, info := range infos {
+		require.Equal(t, info.ID == blockIDs[0], info.Pinned)
+		require.NotZero(t, info.Size)
+	}
+
+	numChecked, badIDs, err := cache.Verify(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 5, numChecked)
+	require.Equal(t, []kbfsblock.ID{blockIDs[1]}, badIDs)
+}
+
+func TestDiskBlockCacheEncryptionRemovesPlaintext(t *testing.T) {
+	t.Log("Turning on local storage encryption removes the unencrypted " +
+		"disk cache, and encrypts the configs of synced folders.")
+	ctx := context.Background()
+	config := MakeTestConfigOrBust(t, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "disk_block_cache_plain")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+	_, err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+
+	cache, err := newDiskBlockCacheStandardForConfig(ctx, config, tempdir)
+	require.NoError(t, err)
+	cache.Shutdown(ctx)
+	root := diskBlockCacheRootFromStorageRoot(tempdir)
+	plainPath := versionPathFromVersion(root, currentDiskCacheVersion)
+	_, err = ioutil.Stat(plainPath)
+	require.NoError(t, err)
+	tlfID := tlf.FakeID(1, false)
+	syncConfig := folderSyncConfig{TlfID: tlfID, Paths: []string{"a"}}
+	err = ioutil.SerializeToJSONFile(
+		syncConfig, syncedFolderConfigPath(nil, tempdir, tlfID))
+	require.NoError(t, err)
+
+	config.SetEncryptLocalStorage(true)
+	cache, err = newDiskBlockCacheStandardForConfig(ctx, config, tempdir)
+	require.NoError(t, err)
+	defer cache.Shutdown(ctx)
+	for _, p := range []string{
+		filepath.Join(root, versionFilename), plainPath,
+		syncedFolderConfigPath(nil, tempdir, tlfID),
+	} {
+		_, err = ioutil.Stat(p)
+		require.True(t, ioutil.IsNotExist(err), "%s still exists", p)
+	}
+
+	crypter, err := makeLocalStorageCrypter(ctx, config)
+	require.NoError(t, err)
+	var gotSyncConfig folderSyncConfig
+	err = crypter.deserializeFromJSONFile(
+		syncedFolderConfigPath(crypter, tempdir, tlfID), &gotSyncConfig)
+	require.NoError(t, err)
+	require.Equal(t, syncConfig, gotSyncConfig)
+}
@@ -6,7 +6,6 @@ package libkbfs
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -52,8 +51,16 @@ func (o journalOrdinal) String() string {
 // dir/0...fff
 //
 // Each file in dir is named with an ordinal and contains a generic
-// serializable entry object. The files EARLIEST and LATEST point to
-// the earliest and latest valid ordinal, respectively.
+// serializable entry object, preceded by its checksum (see
+// serializeToChecksummedFile). The files EARLIEST and LATEST point to
+// the earliest and latest valid ordinal, respectively. Entries are
+// synced to disk before the ordinals that make them part of the
+// journal are written, and since they're only written once, the
+// checksum catches a torn one. The ordinal files are replaced with
+// a rename, so they're never torn, but they aren't synced, since
+// they're rewritten on every append and removal; a crash can leave
+// one stale or empty. Either way, the files can end up inconsistent
+// with each other, and repairDiskJournal fixes that up.
 //
 // This class is not goroutine-safe; it assumes that all
 // synchronization is done at a higher level.
@@ -67,6 +74,10 @@ type diskJournal struct {
 	dir       string
 	entryType reflect.Type
 
+	// writeFile writes entries, and replaceFile writes ordinals.
+	writeFile   fileWriter
+	replaceFile fileWriter
+
 	// The journal must be considered empty when either
 	// earliestValid or latestValid is false.
 
@@ -82,9 +93,11 @@ func makeDiskJournal(
 	codec kbfscodec.Codec, dir string, entryType reflect.Type) (
 	*diskJournal, error) {
 	j := &diskJournal{
-		codec:     codec,
-		dir:       dir,
-		entryType: entryType,
+		codec:       codec,
+		dir:         dir,
+		entryType:   entryType,
+		writeFile:   ioutil.WriteFileSync,
+		replaceFile: ioutil.ReplaceFile,
 	}
 
 	earliest, err := j.readEarliestOrdinalFromDisk()
@@ -139,31 +152,8 @@ func (j diskJournal) readOrdinalFromDisk(path string) (journalOrdinal, error) {
 func (j *diskJournal) writeOrdinalToDisk(path string, o journalOrdinal) error {
 	// Don't use ioutil.WriteFile because it truncates the file first,
 	// and if there's a crash it will leave the journal in an unknown
-	// state.  TODO: it's technically possible a partial write could
-	// lead to corrupted ordinal.  If we ever get rid of the block
-	// journal (which would greatly reduce the number of times this is
-	// called) it might make sense to do an atomic rename here
-	// instead.
-	f, err := ioutil.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		closeErr := f.Close()
-		if err == nil {
-			err = errors.WithStack(closeErr)
-		}
-	}()
-	// Overwrite whatever data is there.  This works as long as the
-	// ordinals are a constant size.
-	s := o.String()
-	n, err := f.WriteString(s)
-	if err != nil {
-		return errors.WithStack(err)
-	} else if n < len(s) {
-		return errors.WithStack(io.ErrShortWrite)
-	}
-	return nil
+	// state.
+	return j.replaceFile(path, []byte(o.String()), 0600)
 }
 
 func (j diskJournal) readEarliestOrdinalFromDisk() (journalOrdinal, error) {
@@ -241,7 +231,7 @@ func (j *diskJournal) clear() error {
 	j.latest = journalOrdinal(0)
 
 	// j.dir will be recreated on the next call to
-	// writeJournalEntry (via kbfscodec.SerializeToFile), which
+	// writeJournalEntry (via serializeToChecksummedFile), which
 	// must always come before any ordinal write.
 	return ioutil.RemoveAll(j.dir)
 }
@@ -287,7 +277,7 @@ func (j *diskJournal) removeEarliest() (empty bool, err error) {
 func (j diskJournal) readJournalEntry(o journalOrdinal) (interface{}, error) {
 	p := j.journalEntryPath(o)
 	entry := reflect.New(j.entryType)
-	err := kbfscodec.DeserializeFromFile(j.codec, p, entry)
+	err := deserializeFromChecksummedFile(j.codec, p, entry)
 	if err != nil {
 		return nil, err
 	}
@@ -303,7 +293,8 @@ func (j *diskJournal) writeJournalEntry(
 			j.entryType, entryType))
 	}
 
-	return kbfscodec.SerializeToFile(j.codec, entry, j.journalEntryPath(o))
+	return serializeToChecksummedFile(
+		j.codec, j.writeFile, entry, j.journalEntryPath(o))
 }
 
 // appendJournalEntry appends the given entry to the journal. If o is
@@ -0,0 +1,309 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+)
+
+// diskJournalRepair describes what repairDiskJournal did to make a
+// journal consistent again.
+type diskJournalRepair struct {
+	// Dir is the directory of the repaired journal.
+	Dir string
+	// Problems describes each inconsistency that was found, and
+	// what was done about it.
+	Problems []string
+	// Quarantined lists the entries that were moved to the
+	// quarantine directory, in the order they were moved.
+	Quarantined []journalOrdinal
+}
+
+func (r *diskJournalRepair) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// readOrdinalForRepair is like readOrdinalFromDisk, except that it
+// returns whether the file exists and holds a valid ordinal instead
+// of failing when it doesn't.
+func (j diskJournal) readOrdinalForRepair(path string) (
+	o journalOrdinal, exists, valid bool, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if ioutil.IsNotExist(err) {
+		return 0, false, false, nil
+	} else if err != nil {
+		return 0, false, false, err
+	}
+	o, err = makeJournalOrdinal(string(buf))
+	if err != nil {
+		return 0, true, false, nil
+	}
+	return o, true, true, nil
+}
+
+// checkEntryForRepair returns a description of what's wrong with the
+// entry with the given ordinal, or the empty string if it can be
+// read.
+func (j diskJournal) checkEntryForRepair(o journalOrdinal) (
+	problem string, err error) {
+	buf, err := readChecksummedFile(j.journalEntryPath(o))
+	switch errors.Cause(err).(type) {
+	case nil:
+	case checksumMismatchError:
+		return fmt.Sprintf("entry %s doesn't match its checksum", o), nil
+	default:
+		if ioutil.IsNotExist(err) {
+			return fmt.Sprintf("entry %s is missing", o), nil
+		}
+		return "", err
+	}
+
+	entry := reflect.New(j.entryType)
+	err = j.codec.Decode(buf, entry.Interface())
+	if err != nil {
+		return fmt.Sprintf("entry %s can't be decoded: %v", o, err), nil
+	}
+	return "", nil
+}
+
+// quarantineEntry moves the entry with the given ordinal, if it
+// exists, to quarantineDir, and returns whether it did.
+func (j diskJournal) quarantineEntry(o journalOrdinal,
+	quarantineDir string, repair *diskJournalRepair) (bool, error) {
+	p := j.journalEntryPath(o)
+	_, err := ioutil.Stat(p)
+	if ioutil.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	err = ioutil.MkdirAll(quarantineDir, 0700)
+	if err != nil {
+		return false, err
+	}
+	err = ioutil.Rename(p, filepath.Join(quarantineDir, o.String()))
+	if err != nil {
+		return false, err
+	}
+	repair.Quarantined = append(repair.Quarantined, o)
+	return true, nil
+}
+
+// removeOrdinalsForRepair makes the journal empty by removing its
+// ordinal files, in the same order as clear() does, but leaves its
+// entries alone.
+func (j diskJournal) removeOrdinalsForRepair() error {
+	for _, p := range []string{j.earliestPath(), j.latestPath()} {
+		err := ioutil.Remove(p)
+		if err != nil && !ioutil.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateForRepair cuts the journal, which has entries from
+// earliest through latest, down to the ones before end, moving the
+// others to quarantineDir, and empties it if that's all of them.
+// The ordinals are written first, so the journal is consistent even
+// if this is interrupted.
+func (j diskJournal) truncateForRepair(earliest, latest, end journalOrdinal,
+	quarantineDir string, repair *diskJournalRepair) error {
+	var err error
+	if end <= earliest {
+		err = j.removeOrdinalsForRepair()
+	} else {
+		err = j.writeOrdinalToDisk(j.latestPath(), end-1)
+	}
+	if err != nil {
+		return err
+	}
+	for o := end; o <= latest; o++ {
+		_, err := j.quarantineEntry(o, quarantineDir, repair)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repairDiskJournal makes sure that the journal in dir, whose entries
+// have the given type, can be opened with makeDiskJournal, and that
+// all of its entries can be read.  A crash can leave behind
+// temporary files, which are removed; an entry past the latest
+// ordinal, which is overwritten by the next append anyway; and
+// stale or empty ordinal files.  Journals written by older versions
+// may also have torn ordinal files or torn entries, so:
+//
+//   - An ordinal file that doesn't hold a valid ordinal, or that
+//     points to a missing entry, is rebuilt from the entries that
+//     are there.
+//   - Every entry from the earliest to the latest ordinal is read,
+//     and if one can't be, the journal is truncated to the entries
+//     before it, and it and the entries after it are moved to
+//     quarantineDir.  The entries after a bad one may be fine on
+//     their own, but they may depend on it.
+//
+// It returns nil if nothing needed repairing.
+func repairDiskJournal(codec kbfscodec.Codec, dir string,
+	entryType reflect.Type, quarantineDir string) (
+	*diskJournalRepair, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	j := diskJournal{
+		codec:       codec,
+		dir:         dir,
+		entryType:   entryType,
+		writeFile:   ioutil.WriteFileSync,
+		replaceFile: ioutil.ReplaceFile,
+	}
+	repair := &diskJournalRepair{Dir: dir}
+	present := make(map[journalOrdinal]bool)
+	var highest journalOrdinal
+	for _, fi := range fileInfos {
+		name := fi.Name()
+		if ioutil.IsAtomicTempFile(name) {
+			// Nothing points to a temporary file, so it's
+			// safe to remove without counting it as a
+			// repair.
+			err := ioutil.Remove(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		o, err := makeJournalOrdinal(name)
+		if err != nil {
+			continue
+		}
+		present[o] = true
+		if o > highest {
+			highest = o
+		}
+	}
+
+	earliest, earliestExists, earliestValid, err :=
+		j.readOrdinalForRepair(j.earliestPath())
+	if err != nil {
+		return nil, err
+	}
+	latest, latestExists, latestValid, err :=
+		j.readOrdinalForRepair(j.latestPath())
+	if err != nil {
+		return nil, err
+	}
+
+	if !earliestExists || !latestExists {
+		// The journal is empty, e.g. because a crash interrupted
+		// its first append or a clear.  The remaining ordinal
+		// file only needs to be removed if it's invalid, since
+		// makeDiskJournal would fail to read it.
+		if (earliestExists && !earliestValid) ||
+			(latestExists && !latestValid) {
+			repair.addProblem("the journal is empty, but has " +
+				"an invalid ordinal; removed it")
+			err := j.removeOrdinalsForRepair()
+			if err != nil {
+				return nil, err
+			}
+			return repair, nil
+		}
+		return nil, nil
+	}
+
+	if !earliestValid || !latestValid || earliest > latest ||
+		!present[earliest] || !present[latest] {
+		// Rebuild the range from the contiguous run of entries
+		// that ends at the latest ordinal, if that can be
+		// trusted, or otherwise the run that starts with the
+		// earliest ordinal.
+		var end journalOrdinal
+		switch {
+		case latestValid && present[latest]:
+			end = latest
+		case earliestValid && present[earliest]:
+			end = earliest
+			for present[end+1] {
+				end++
+			}
+		default:
+			end = highest
+		}
+		if !present[end] {
+			repair.addProblem("the ordinals are invalid, and "+
+				"there are no entries (earliest=%t latest=%t); "+
+				"emptied the journal", earliestValid, latestValid)
+			err := j.removeOrdinalsForRepair()
+			if err != nil {
+				return nil, err
+			}
+			return repair, nil
+		}
+		start := end
+		for start > 0 && present[start-1] {
+			start--
+		}
+		if earliestValid && start <= earliest && earliest <= end {
+			start = earliest
+		}
+
+		repair.addProblem("the ordinals were inconsistent "+
+			"(earliest=%s valid=%t, latest=%s valid=%t); "+
+			"rebuilt them as %s to %s", earliest, earliestValid,
+			latest, latestValid, start, end)
+		err := j.writeOrdinalToDisk(j.earliestPath(), start)
+		if err != nil {
+			return nil, err
+		}
+		err = j.writeOrdinalToDisk(j.latestPath(), end)
+		if err != nil {
+			return nil, err
+		}
+		earliest, latest = start, end
+	}
+
+	// Truncate the journal before the first entry that can't be
+	// read.
+	for o := earliest; o <= latest; o++ {
+		problem, err := j.checkEntryForRepair(o)
+		if err != nil {
+			return nil, err
+		}
+		if problem == "" {
+			continue
+		}
+
+		err = j.truncateForRepair(earliest, latest, o, quarantineDir, repair)
+		if err != nil {
+			return nil, err
+		}
+		if o == earliest {
+			repair.addProblem("%s; emptied the journal, and "+
+				"quarantined %d entries", problem, len(repair.Quarantined))
+		} else {
+			repair.addProblem("%s; truncated the journal to end at "+
+				"%s, and quarantined %d entries", problem, o-1,
+				len(repair.Quarantined))
+		}
+		break
+	}
+
+	if len(repair.Problems) == 0 {
+		return nil, nil
+	}
+	return repair, nil
+}
@@ -0,0 +1,296 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crashMode says what a crash in the middle of a file write leaves
+// on disk.
+type crashMode int
+
+const (
+	// crashBeforeWrite leaves nothing, as if the process was
+	// killed just before the write.
+	crashBeforeWrite crashMode = iota
+	// crashBeforeRename leaves a temporary file with part of the
+	// data, as if the process was killed in the middle of an
+	// atomic write.  A write that isn't atomic is torn instead.
+	crashBeforeRename
+	// crashTornWrite leaves part of the data in the file itself,
+	// as if the process was killed in the middle of a write that
+	// wasn't atomic, even for atomic writes, like the ones done by
+	// older versions.
+	crashTornWrite
+)
+
+func (m crashMode) String() string {
+	switch m {
+	case crashBeforeWrite:
+		return "before write"
+	case crashBeforeRename:
+		return "before rename"
+	case crashTornWrite:
+		return "torn write"
+	default:
+		return fmt.Sprintf("crashMode(%d)", int(m))
+	}
+}
+
+var errSimulatedCrash = errors.New("simulated crash")
+
+// crashingFileWriters returns a pair of fileWriters, for plain writes
+// and writes through a temporary file, that together do the first n writes normally, and
+// then simulate a crash of the given kind on each write after that.
+func crashingFileWriters(t *testing.T, n int, mode crashMode) (
+	writeFile, writeFileAtomic fileWriter) {
+	count := 0
+	makeWriter := func(atomic bool) fileWriter {
+		return func(path string, data []byte, perm os.FileMode) error {
+			if count < n {
+				count++
+				if atomic {
+					return ioutil.WriteFileAtomic(path, data, perm)
+				}
+				return ioutil.WriteFile(path, data, perm)
+			}
+
+			partial := data[:len(data)/2]
+			switch {
+			case mode == crashBeforeRename && atomic:
+				tempPath := path + ".tmp12345"
+				require.True(t,
+					ioutil.IsAtomicTempFile(filepath.Base(tempPath)))
+				err := ioutil.WriteFile(tempPath, partial, perm)
+				require.NoError(t, err)
+			case mode == crashBeforeRename || mode == crashTornWrite:
+				err := ioutil.WriteFile(path, partial, perm)
+				require.NoError(t, err)
+			}
+			return errors.WithStack(errSimulatedCrash)
+		}
+	}
+	return makeWriter(false), makeWriter(true)
+}
+
+func makeDiskJournalForRepairTest(
+	t *testing.T, dir string, count int) *diskJournal {
+	j, err := makeDiskJournal(
+		kbfscodec.NewMsgpack(), dir, reflect.TypeOf(testJournalEntry{}))
+	require.NoError(t, err)
+	for i := 1; i <= count; i++ {
+		_, err := j.appendJournalEntry(nil, testJournalEntry{i})
+		require.NoError(t, err)
+	}
+	return j
+}
+
+// reopenDiskJournalForRepairTest repairs and reopens the journal in
+// dir, as on a restart, and returns the entries in it.
+func reopenDiskJournalForRepairTest(t *testing.T, dir string) (
+	*diskJournal, *diskJournalRepair, []int) {
+	codec := kbfscodec.NewMsgpack()
+	entryType := reflect.TypeOf(testJournalEntry{})
+	repair, err := repairDiskJournal(
+		codec, dir, entryType, filepath.Join(dir, "..", "quarantine"))
+	require.NoError(t, err)
+	j, err := makeDiskJournal(codec, dir, entryType)
+	require.NoError(t, err)
+
+	var entries []int
+	if !j.empty() {
+		for o := j.earliest; o <= j.latest; o++ {
+			entry, err := j.readJournalEntry(o)
+			require.NoError(t, err)
+			entries = append(entries, entry.(testJournalEntry).I)
+		}
+	}
+	return j, repair, entries
+}
+
+// TestDiskJournalCrashRecovery simulates a crash at each file write
+// done by each diskJournal operation, and checks that the journal
+// comes back either as it was before the operation, or as it would
+// have been after it, and that it can be appended to.
+func TestDiskJournalCrashRecovery(t *testing.T) {
+	ops := []struct {
+		name          string
+		initialCount  int
+		op            func(j *diskJournal) error
+		before, after []int
+	}{
+		{
+			name: "append to empty",
+			op: func(j *diskJournal) error {
+				_, err := j.appendJournalEntry(nil, testJournalEntry{1})
+				return err
+			},
+			before: nil,
+			after:  []int{1},
+		},
+		{
+			name:         "append",
+			initialCount: 3,
+			op: func(j *diskJournal) error {
+				_, err := j.appendJournalEntry(nil, testJournalEntry{4})
+				return err
+			},
+			before: []int{1, 2, 3},
+			after:  []int{1, 2, 3, 4},
+		},
+		{
+			name:         "remove earliest",
+			initialCount: 3,
+			op: func(j *diskJournal) error {
+				_, err := j.removeEarliest()
+				return err
+			},
+			before: []int{1, 2, 3},
+			after:  []int{2, 3},
+		},
+	}
+
+	modes := []crashMode{crashBeforeWrite, crashBeforeRename, crashTornWrite}
+	for _, op := range ops {
+		for _, mode := range modes {
+			for n := 0; ; n++ {
+				name := fmt.Sprintf("%s, %s after %d writes", op.name, mode, n)
+				tempdir, err := ioutil.TempDir(os.TempDir(), "disk_journal")
+				require.NoError(t, err)
+				dir := filepath.Join(tempdir, "journal")
+
+				j := makeDiskJournalForRepairTest(t, dir, op.initialCount)
+				j.writeFile, j.replaceFile =
+					crashingFileWriters(t, n, mode)
+				err = op.op(j)
+				crashed := err != nil
+				if crashed {
+					require.Equal(t, errSimulatedCrash, errors.Cause(err), name)
+				}
+
+				j, _, entries := reopenDiskJournalForRepairTest(t, dir)
+				if !reflect.DeepEqual(op.before, entries) {
+					require.Equal(t, op.after, entries, name)
+				}
+
+				fileInfos, err := ioutil.ReadDir(dir)
+				if !ioutil.IsNotExist(err) {
+					require.NoError(t, err)
+				}
+				for _, fi := range fileInfos {
+					require.False(t, ioutil.IsAtomicTempFile(fi.Name()),
+						"%s: %s", name, fi.Name())
+				}
+
+				// The journal should still be usable.
+				o, err := j.appendJournalEntry(nil, testJournalEntry{100})
+				require.NoError(t, err, name)
+				_, _, entries = reopenDiskJournalForRepairTest(t, dir)
+				require.Equal(t, 100, entries[len(entries)-1], name)
+				require.Equal(t, o, j.latest, name)
+
+				err = ioutil.RemoveAll(tempdir)
+				assert.NoError(t, err)
+				if !crashed {
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestDiskJournalRepairCorruptEntries(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "disk_journal")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	dir := filepath.Join(tempdir, "journal")
+	quarantineDir := filepath.Join(tempdir, "quarantine")
+
+	j := makeDiskJournalForRepairTest(t, dir, 6)
+
+	// Nothing to repair yet.
+	_, repair, entries := reopenDiskJournalForRepairTest(t, dir)
+	require.Nil(t, repair)
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6}, entries)
+
+	// A bad entry in the middle truncates the journal before it,
+	// even though the entries after it are fine.
+	p5 := j.journalEntryPath(5)
+	buf, err := ioutil.ReadFile(p5)
+	require.NoError(t, err)
+	buf[len(buf)-1] ^= 1
+	err = ioutil.WriteFile(p5, buf, 0600)
+	require.NoError(t, err)
+	_, repair, entries = reopenDiskJournalForRepairTest(t, dir)
+	require.NotNil(t, repair)
+	require.Equal(t, []journalOrdinal{5, 6}, repair.Quarantined)
+	require.Len(t, repair.Problems, 1)
+	require.Equal(t, []int{1, 2, 3, 4}, entries)
+
+	// An entry written before checksums were added can still be
+	// read.
+	err = kbfscodec.SerializeToFile(
+		j.codec, testJournalEntry{2}, j.journalEntryPath(2))
+	require.NoError(t, err)
+
+	// Flip a bit in the last entry, and cut the one before it
+	// short.
+	p4 := j.journalEntryPath(4)
+	buf, err = ioutil.ReadFile(p4)
+	require.NoError(t, err)
+	buf[len(buf)-1] ^= 1
+	err = ioutil.WriteFile(p4, buf, 0600)
+	require.NoError(t, err)
+	p3 := j.journalEntryPath(3)
+	buf, err = ioutil.ReadFile(p3)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(p3, buf[:len(buf)/2], 0600)
+	require.NoError(t, err)
+
+	_, err = j.readJournalEntry(4)
+	require.IsType(t, checksumMismatchError{}, errors.Cause(err))
+
+	_, repair, entries = reopenDiskJournalForRepairTest(t, dir)
+	require.NotNil(t, repair)
+	require.Equal(t, []journalOrdinal{3, 4}, repair.Quarantined)
+	require.Len(t, repair.Problems, 1)
+	require.Equal(t, []int{1, 2}, entries)
+	for _, o := range []journalOrdinal{3, 4, 5, 6} {
+		_, err := ioutil.Stat(filepath.Join(quarantineDir, o.String()))
+		require.NoError(t, err)
+	}
+
+	// A LATEST file that points way past the end gets rebuilt.
+	err = ioutil.WriteFile(j.latestPath(), []byte("0000000000000f02"), 0600)
+	require.NoError(t, err)
+	_, repair, entries = reopenDiskJournalForRepairTest(t, dir)
+	require.NotNil(t, repair)
+	require.Len(t, repair.Quarantined, 0)
+	require.Equal(t, []int{1, 2}, entries)
+
+	// If no entry can be read, the journal ends up empty.
+	for _, o := range []journalOrdinal{1, 2} {
+		err = ioutil.WriteFile(j.journalEntryPath(o), nil, 0600)
+		require.NoError(t, err)
+	}
+	_, repair, entries = reopenDiskJournalForRepairTest(t, dir)
+	require.NotNil(t, repair)
+	require.Equal(t, []journalOrdinal{1, 2}, repair.Quarantined)
+	require.Len(t, entries, 0)
+}
@@ -6,6 +6,8 @@ package libkbfs
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -1270,3 +1272,207 @@ func (e DiskCacheStartingError) Error() string {
 	return fmt.Sprintf("Error performing %s operation: the disk cache is "+
 		"still starting", e.op)
 }
+
+// WriteToReadonlyNodeError indicates an error when trying to write a
+// node that's marked as read-only, such as a node belonging to an
+// archived revision of a folder.
+type WriteToReadonlyNodeError struct {
+	Filename string
+}
+
+// Error implements the error interface for WriteToReadonlyNodeError.
+func (e WriteToReadonlyNodeError) Error() string {
+	return fmt.Sprintf("%s is read-only", e.Filename)
+}
+
+// NoMDRevisionBeforeTimeError indicates that a TLF has no MD
+// revisions written at or before the given time.
+type NoMDRevisionBeforeTimeError struct {
+	Tlf  CanonicalTlfName
+	Time time.Time
+}
+
+// Error implements the error interface for NoMDRevisionBeforeTimeError.
+func (e NoMDRevisionBeforeTimeError) Error() string {
+	return fmt.Sprintf("Folder %s has no revisions from before %s",
+		e.Tlf, e.Time)
+}
+
+// DeletedEntryReclaimedError indicates that an entry can't be
+// undeleted, because quota reclamation has already deleted the
+// blocks it was using.
+type DeletedEntryReclaimedError struct {
+	Name string
+	// Revision is the revision that removed the entry.
+	Revision MetadataRevision
+	// LastGCRevision is the last revision whose unreferenced
+	// blocks have been reclaimed, if known.
+	LastGCRevision MetadataRevision
+}
+
+// Error implements the error interface for DeletedEntryReclaimedError.
+func (e DeletedEntryReclaimedError) Error() string {
+	if e.LastGCRevision == MetadataRevisionUninitialized {
+		return fmt.Sprintf("Can't undelete %s removed at revision %d: "+
+			"its blocks have already been reclaimed", e.Name, e.Revision)
+	}
+	return fmt.Sprintf("Can't undelete %s removed at revision %d: "+
+		"its blocks were reclaimed through revision %d",
+		e.Name, e.Revision, e.LastGCRevision)
+}
+
+// InvalidRevisionRangeError indicates that a range of revisions was
+// requested whose start is after its end.
+type InvalidRevisionRangeError struct {
+	From MetadataRevision
+	To   MetadataRevision
+}
+
+// Error implements the error interface for InvalidRevisionRangeError.
+func (e InvalidRevisionRangeError) Error() string {
+	return fmt.Sprintf("Invalid revision range: %d is after %d",
+		e.From, e.To)
+}
+
+// NoSuchXattrError indicates that the requested extended attribute
+// isn't set on a directory entry.
+type NoSuchXattrError struct {
+	Name  string
+	Xattr string
+}
+
+// Error implements the error interface for NoSuchXattrError.
+func (e NoSuchXattrError) Error() string {
+	return fmt.Sprintf("%s has no extended attribute %s", e.Name, e.Xattr)
+}
+
+// XattrTooBigError indicates that the user tried to set an extended
+// attribute value that is bigger than KBFS's supported size.
+type XattrTooBigError struct {
+	Xattr           string
+	Size            uint64
+	MaxAllowedBytes uint64
+}
+
+// Error implements the error interface for XattrTooBigError.
+func (e XattrTooBigError) Error() string {
+	return fmt.Sprintf("Value of extended attribute %s is %d bytes, more "+
+		"than the maximum allowed number of bytes (%d)",
+		e.Xattr, e.Size, e.MaxAllowedBytes)
+}
+
+// XattrsTooBigError indicates that the user tried to set an extended
+// attribute that would make all of an entry's extended attributes
+// together bigger than KBFS's supported size.
+type XattrsTooBigError struct {
+	Name            string
+	Size            uint64
+	MaxAllowedBytes uint64
+}
+
+// Error implements the error interface for XattrsTooBigError.
+func (e XattrsTooBigError) Error() string {
+	return fmt.Sprintf("Extended attributes of %s would take %d bytes, "+
+		"more than the maximum allowed number of bytes (%d)",
+		e.Name, e.Size, e.MaxAllowedBytes)
+}
+
+// CopyAcrossFoldersError indicates that the user tried to copy a file
+// by reference into a different top-level folder.
+type CopyAcrossFoldersError struct {
+}
+
+// Error implements the error interface for CopyAcrossFoldersError.
+func (e CopyAcrossFoldersError) Error() string {
+	return "Cannot copy by reference across top-level folders"
+}
+
+// InvalidRangeError indicates that the user gave a negative offset or
+// length for a range of a file.
+type InvalidRangeError struct {
+	Off    int64
+	Length int64
+}
+
+// Error implements the error interface for InvalidRangeError.
+func (e InvalidRangeError) Error() string {
+	return fmt.Sprintf("Invalid file range: offset %d, length %d",
+		e.Off, e.Length)
+}
+
+// FileLockConflictError indicates that an advisory lock couldn't be
+// taken because another owner, possibly on another device, holds a
+// conflicting lock.
+type FileLockConflictError struct {
+	Lock FileLock
+}
+
+// Error implements the error interface for FileLockConflictError.
+func (e FileLockConflictError) Error() string {
+	return fmt.Sprintf("Conflicting file lock held: %s", e.Lock)
+}
+
+// FileLockLostError indicates that locks held by an owner were
+// dropped because their leases couldn't be renewed on the MD server,
+// so another device may have taken them.
+type FileLockLostError struct {
+	Lock FileLock
+	Err  error
+}
+
+// Error implements the error interface for FileLockLostError.
+func (e FileLockLostError) Error() string {
+	return fmt.Sprintf("Lost file lock %s: %+v", e.Lock, e.Err)
+}
+
+// FileLeasesUnsupportedError indicates that the MD server doesn't
+// support cross-device leases for advisory file locks.
+type FileLeasesUnsupportedError struct {
+}
+
+// Error implements the error interface for FileLeasesUnsupportedError.
+func (e FileLeasesUnsupportedError) Error() string {
+	return "The MD server doesn't support file lock leases"
+}
+
+// UnflushedJournalInOtherModeError indicates that the current device
+// has a journal with unflushed changes that was written with local
+// storage encryption turned the other way, so it can't be used in the
+// current mode.  KBFS has to be run in the journal's mode until it's
+// flushed.
+type UnflushedJournalInOtherModeError struct {
+	Dir string
+	// Encrypted is whether the journal was written with local
+	// storage encryption on.
+	Encrypted bool
+}
+
+// Error implements the error interface for
+// UnflushedJournalInOtherModeError.
+func (e UnflushedJournalInOtherModeError) Error() string {
+	return fmt.Sprintf("The journal at %s has unflushed changes that "+
+		"were written with -encrypt-local-storage=%t; run KBFS that "+
+		"way until they're flushed", e.Dir, e.Encrypted)
+}
+
+// JournalRepairedError indicates that a TLF's journal was found to be
+// inconsistent when it was enabled, usually because of a crash in
+// the middle of a write, and was repaired.  Any journal entries that
+// couldn't be recovered were moved to QuarantineDir, along with a
+// report of the repairs.
+type JournalRepairedError struct {
+	TlfID    tlf.ID
+	Problems []string
+	// QuarantineDir is empty if no entries were quarantined.
+	QuarantineDir string
+}
+
+// Error implements the error interface for JournalRepairedError.
+func (e JournalRepairedError) Error() string {
+	s := fmt.Sprintf("Repaired the journal for %s: %s",
+		e.TlfID, strings.Join(e.Problems, "; "))
+	if e.QuarantineDir != "" {
+		s += fmt.Sprintf(" (see %s)", e.QuarantineDir)
+	}
+	return s
+}
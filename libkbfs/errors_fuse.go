@@ -139,3 +139,75 @@ func (e NoSuchFolderListError) Errno() fuse.Errno {
 func (e RenameAcrossDirsError) Errno() fuse.Errno {
 	return fuse.Errno(syscall.EXDEV)
 }
+
+var _ fuse.ErrorNumber = WriteToReadonlyNodeError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// WriteToReadonlyNodeError.
+func (e WriteToReadonlyNodeError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EROFS)
+}
+
+var _ fuse.ErrorNumber = NoMDRevisionBeforeTimeError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// NoMDRevisionBeforeTimeError.
+func (e NoMDRevisionBeforeTimeError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.ENOENT)
+}
+
+var _ fuse.ErrorNumber = NoSuchXattrError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// NoSuchXattrError.
+func (e NoSuchXattrError) Errno() fuse.Errno {
+	return fuse.ErrNoXattr
+}
+
+var _ fuse.ErrorNumber = XattrTooBigError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// XattrTooBigError.
+func (e XattrTooBigError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.E2BIG)
+}
+
+var _ fuse.ErrorNumber = XattrsTooBigError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// XattrsTooBigError.
+func (e XattrsTooBigError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.ENOSPC)
+}
+
+var _ fuse.ErrorNumber = CopyAcrossFoldersError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// CopyAcrossFoldersError.
+func (e CopyAcrossFoldersError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EXDEV)
+}
+
+var _ fuse.ErrorNumber = InvalidRangeError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// InvalidRangeError.
+func (e InvalidRangeError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EINVAL)
+}
+
+var _ fuse.ErrorNumber = FileLockConflictError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// FileLockConflictError.
+func (e FileLockConflictError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.EAGAIN)
+}
+
+var _ fuse.ErrorNumber = FileLockLostError{}
+
+// Errno implements the fuse.ErrorNumber interface for
+// FileLockLostError.
+func (e FileLockLostError) Errno() fuse.Errno {
+	return fuse.Errno(syscall.ENOLCK)
+}
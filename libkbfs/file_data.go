@@ -491,7 +491,7 @@ func (fd *fileData) getByteSlicesInOffsetRange(ctx context.Context,
 	// Grab the relevant byte slices from each block described by the
 	// indirect pointer, filling in holes as needed.
 	var bytes [][]byte
-	for _, iptr := range iptrs {
+	for i, iptr := range iptrs {
 		block := blockMap[iptr.BlockPointer]
 		blockLen := int64(len(block.Contents))
 		nextByte := nRead + startOff
@@ -500,6 +500,11 @@ func (fd *fileData) getByteSlicesInOffsetRange(ctx context.Context,
 		lastByteInBlock := blockOff + blockLen
 
 		if nextByte >= lastByteInBlock {
+			if i < len(iptrs)-1 {
+				// The hole following this block is filled in when
+				// processing the next block.
+				continue
+			}
 			if nextBlockOff > 0 {
 				fill := nextBlockOff - nextByte
 				if fill > toRead {
@@ -1319,7 +1324,7 @@ func (fd *fileData) truncateShrink(ctx context.Context, size uint64,
 					// unreferenced (unless it's on the left-most edge
 					// of the tree, in which case we keep it around
 					// for now -- see above TODO).
-					if pb.childIndex == 0 && !leftMost {
+					if removeStartingFromIndex == 0 && !leftMost {
 						if parentInfo.EncodedSize != 0 {
 							unrefs = append(unrefs, parentInfo)
 						}
@@ -1391,6 +1396,155 @@ func (fd *fileData) truncateShrink(ctx context.Context, size uint64,
 	return newDe, dirtyPtrs, unrefs, newlyDirtiedChildBytes, nil
 }
 
+// punchHole deallocates the data in the half-inclusive range `[off,
+// end)` of the file, which must end before the last byte of the file
+// (ranges that reach the end of the file should be handled by
+// truncating instead).  Leaf blocks lying completely within the
+// range are emptied, and leaf blocks that end within the range are
+// shortened, so that the range becomes a hole.  Any other leaf blocks
+// overlapping the range are zeroed in place.  Return params:
+// * dirtyPtrs: a slice of the BlockPointers that have been dirtied during
+//   the punch.
+// * unrefs: a slice of BlockInfos that must be unreferenced as part of an
+//   eventual sync of this punch.  May be non-nil even if err != nil.
+// * newlyDirtiedChildBytes is the total amount of block data dirtied by this
+//   punch.  As above, it may be non-zero even if err != nil.
+func (fd *fileData) punchHole(ctx context.Context, off, end int64,
+	topBlock *FileBlock) (dirtyPtrs []BlockPointer, unrefs []BlockInfo,
+	newlyDirtiedChildBytes int64, err error) {
+	fd.log.CDebugf(ctx, "Punching hole in [%d, %d) of file %v",
+		off, end, fd.rootBlockPointer())
+
+	dirtyMap := make(map[BlockPointer]bool)
+	for curr := off; curr < end; {
+		ptr, parentBlocks, block, nextBlockOff, startOff, wasDirty, err :=
+			fd.getFileBlockAtOffset(ctx, topBlock, curr, blockWrite)
+		if err != nil {
+			return nil, unrefs, newlyDirtiedChildBytes, err
+		}
+
+		endOfBlock := startOff + int64(len(block.Contents))
+		if curr < endOfBlock {
+			oldLen := len(block.Contents)
+			zeroEnd := endOfBlock
+			if end < zeroEnd {
+				zeroEnd = end
+			}
+			if topBlock.IsInd && zeroEnd == endOfBlock {
+				// Leave a hole between the remaining data (if any)
+				// and the next block.  Note we make a new slice in
+				// order to make sure the punched data can be fully
+				// garbage-collected.
+				block.Contents = append(
+					[]byte(nil), block.Contents[:curr-startOff]...)
+				for _, pb := range parentBlocks {
+					pb.pblock.IPtrs[pb.childIndex].Holes = true
+				}
+			} else {
+				zeros := block.Contents[curr-startOff : zeroEnd-startOff]
+				for i := range zeros {
+					zeros[i] = 0
+				}
+			}
+
+			newlyDirtiedChildBytes += int64(len(block.Contents))
+			if wasDirty {
+				newlyDirtiedChildBytes -= int64(oldLen)
+			}
+
+			newDirtyPtrs, newUnrefs, err := fd.markParentsDirty(
+				ctx, parentBlocks)
+			unrefs = append(unrefs, newUnrefs...)
+			if err != nil {
+				return nil, unrefs, newlyDirtiedChildBytes, err
+			}
+			for _, p := range newDirtyPtrs {
+				dirtyMap[p] = true
+			}
+
+			// Keep the old block ID while it's dirty.
+			if err = fd.cacher(ptr, block); err != nil {
+				return nil, unrefs, newlyDirtiedChildBytes, err
+			}
+			dirtyMap[ptr] = true
+		}
+
+		if nextBlockOff < 0 {
+			break
+		}
+		curr = nextBlockOff
+	}
+
+	if topBlock.IsInd {
+		// Always make the top block dirty, so we will sync its
+		// indirect blocks.
+		if err = fd.cacher(fd.rootBlockPointer(), topBlock); err != nil {
+			return nil, unrefs, newlyDirtiedChildBytes, err
+		}
+		dirtyMap[fd.rootBlockPointer()] = true
+	}
+
+	dirtyPtrs = make([]BlockPointer, 0, len(dirtyMap))
+	for p := range dirtyMap {
+		dirtyPtrs = append(dirtyPtrs, p)
+	}
+	return dirtyPtrs, unrefs, newlyDirtiedChildBytes, nil
+}
+
+// nextDataOffset returns the offset of the first byte of data in the
+// file at or after `off`, or -1 if there is nothing but holes after
+// `off`.
+func (fd *fileData) nextDataOffset(ctx context.Context, off int64) (
+	int64, error) {
+	topBlock, _, err := fd.getter(ctx, fd.kmd, fd.rootBlockPointer(),
+		fd.file, blockRead)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		_, _, block, nextBlockOff, startOff, _, err :=
+			fd.getFileBlockAtOffset(ctx, topBlock, off, blockRead)
+		if err != nil {
+			return 0, err
+		}
+		if off < startOff+int64(len(block.Contents)) {
+			return off, nil
+		}
+		if nextBlockOff < 0 {
+			return -1, nil
+		}
+		off = nextBlockOff
+	}
+}
+
+// nextHoleOffset returns the offset of the first byte of the first
+// hole in the file at or after `off`.  If there are no holes, it
+// returns the offset of the end of the data in the file.
+func (fd *fileData) nextHoleOffset(ctx context.Context, off int64) (
+	int64, error) {
+	topBlock, _, err := fd.getter(ctx, fd.kmd, fd.rootBlockPointer(),
+		fd.file, blockRead)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		_, _, block, nextBlockOff, startOff, _, err :=
+			fd.getFileBlockAtOffset(ctx, topBlock, off, blockRead)
+		if err != nil {
+			return 0, err
+		}
+		if endOfData := startOff + int64(len(block.Contents)); off < endOfData {
+			off = endOfData
+		}
+		if nextBlockOff < 0 || off < nextBlockOff {
+			return off, nil
+		}
+		// The data runs right up to the start of the next block.
+	}
+}
+
 // split, if given an indirect top block of a file, checks whether any
 // of the dirty leaf blocks in that file need to be split up
 // differently (i.e., if the BlockSplitter is using
@@ -1716,9 +1870,15 @@ func (fd *fileData) findIPtrsAndClearSize(
 			}
 			infoSeen[parentPtr] = true
 
-			for _, iptr := range pb.pblock.IPtrs {
+			for j, iptr := range pb.pblock.IPtrs {
 				if ptrs[iptr.BlockPointer] {
-					// Mark this pointer, and all parent blocks, as dirty.
+					// Mark this pointer, and all parent blocks, as
+					// dirty.  The pointer isn't necessarily the one
+					// this path goes through, so point the bottom of
+					// a copy of the path at it.
+					iptrPath := make([]parentBlockAndChildIndex, level+1)
+					copy(iptrPath, path[:level+1])
+					iptrPath[level].childIndex = j
 					parentPtr := fd.rootBlockPointer()
 					for i := 0; i <= level; i++ {
 						// Get a writeable copy for each block.
@@ -1727,10 +1887,10 @@ func (fd *fileData) findIPtrsAndClearSize(
 						if err != nil {
 							return nil, err
 						}
-						path[i].pblock = pblock
-						parentPtr = path[i].childIPtr().BlockPointer
+						iptrPath[i].pblock = pblock
+						parentPtr = iptrPath[i].childIPtr().BlockPointer
 					}
-					_, _, err = fd.markParentsDirty(ctx, path[:level+1])
+					_, _, err = fd.markParentsDirty(ctx, iptrPath)
 					if err != nil {
 						return nil, err
 					}
@@ -764,6 +764,9 @@ func TestFileDataTruncateShrink(t *testing.T) {
 		{"WithinBlock", 6, 5},
 		{"WithinLevel", 8, 5},
 		{"ToZero", 8, 0},
+		// The new end is under the second child of a block on
+		// the right edge of the tree, whose first child is kept.
+		{"KeepLeftChildOfRightBlock", 16, 9},
 	}
 
 	for _, test := range tests {
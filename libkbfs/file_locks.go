@@ -0,0 +1,203 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/keybase/kbfs/kbfscrypto"
+)
+
+// FileLockType indicates whether an advisory file lock is shared or
+// exclusive.
+type FileLockType int
+
+const (
+	// FileLockRead is a shared lock; any number of owners may hold
+	// overlapping read locks at once.
+	FileLockRead FileLockType = iota + 1
+	// FileLockWrite is an exclusive lock.
+	FileLockWrite
+)
+
+func (t FileLockType) String() string {
+	switch t {
+	case FileLockRead:
+		return "read"
+	case FileLockWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("FileLockType(%d)", int(t))
+	}
+}
+
+// FileLock describes a POSIX-style advisory lock on a byte range of
+// a file.
+type FileLock struct {
+	Type FileLockType
+	// Owner identifies the holder of the lock on its device, e.g.
+	// the lock owner given by the kernel, or a process ID.
+	Owner uint64
+	// Start is the offset of the first byte covered by the lock.
+	Start int64
+	// Length is the number of bytes covered by the lock.  A length
+	// of 0 covers everything from Start onwards, however large the
+	// file grows.
+	Length int64
+	// Flock is true for whole-file locks taken with flock(2), which
+	// belong to an open file rather than a process.  They're held
+	// by a different owner than POSIX locks with the same Owner,
+	// though the two kinds of lock still conflict with each other.
+	Flock bool
+}
+
+func (l FileLock) String() string {
+	if l.Flock {
+		return fmt.Sprintf("%s flock by %d", l.Type, l.Owner)
+	} else if l.Length == 0 {
+		return fmt.Sprintf("%s lock by %d on [%d, EOF)",
+			l.Type, l.Owner, l.Start)
+	}
+	return fmt.Sprintf("%s lock by %d on [%d, %d)",
+		l.Type, l.Owner, l.Start, l.Start+l.Length)
+}
+
+// end returns the offset just past the last byte covered by the lock.
+func (l FileLock) end() int64 {
+	if l.Length == 0 || l.Start > math.MaxInt64-l.Length {
+		return math.MaxInt64
+	}
+	return l.Start + l.Length
+}
+
+func (l FileLock) overlaps(other FileLock) bool {
+	return l.Start < other.end() && other.Start < l.end()
+}
+
+// withRange returns a copy of l covering [start, end).
+func (l FileLock) withRange(start, end int64) FileLock {
+	l.Start = start
+	if end == math.MaxInt64 {
+		l.Length = 0
+	} else {
+		l.Length = end - start
+	}
+	return l
+}
+
+// fileLockOwner identifies the holder of a lock across devices.  For
+// locks that are only tracked locally, device is the zero key.
+type fileLockOwner struct {
+	device kbfscrypto.CryptPublicKey
+	owner  uint64
+	flock  bool
+}
+
+// makeFileLockOwner returns the owner of lock on the given device.
+func makeFileLockOwner(
+	device kbfscrypto.CryptPublicKey, lock FileLock) fileLockOwner {
+	return fileLockOwner{device, lock.Owner, lock.Flock}
+}
+
+type fileLockEntry struct {
+	owner fileLockOwner
+	lock  FileLock
+	// expiry is the zero time for locks that never expire.
+	expiry time.Time
+}
+
+// fileLockTable keeps track of the advisory locks held on a single
+// file.  Like POSIX record locks, a new lock replaces whatever the
+// same owner already holds on the overlapping range, and an unlock
+// may split an existing lock in two.  It is not goroutine-safe.
+type fileLockTable struct {
+	entries []fileLockEntry
+}
+
+func (t *fileLockTable) isEmpty() bool {
+	return len(t.entries) == 0
+}
+
+// expire removes all entries that expired at or before now.
+func (t *fileLockTable) expire(now time.Time) {
+	entries := t.entries[:0]
+	for _, e := range t.entries {
+		if !e.expiry.IsZero() && !now.Before(e.expiry) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	t.entries = entries
+}
+
+// conflict returns the first lock held by someone other than owner
+// that prevents owner from taking lock.
+func (t *fileLockTable) conflict(
+	owner fileLockOwner, lock FileLock) (FileLock, bool) {
+	for _, e := range t.entries {
+		if e.owner == owner || !e.lock.overlaps(lock) {
+			continue
+		}
+		if e.lock.Type == FileLockWrite || lock.Type == FileLockWrite {
+			return e.lock, true
+		}
+	}
+	return FileLock{}, false
+}
+
+// remove releases owner's locks on the range covered by lock.
+func (t *fileLockTable) remove(owner fileLockOwner, lock FileLock) {
+	var entries []fileLockEntry
+	for _, e := range t.entries {
+		if e.owner != owner || !e.lock.overlaps(lock) {
+			entries = append(entries, e)
+			continue
+		}
+		// Keep the parts of the old lock on either side of the
+		// removed range.
+		if e.lock.Start < lock.Start {
+			before := e
+			before.lock = e.lock.withRange(e.lock.Start, lock.Start)
+			entries = append(entries, before)
+		}
+		if lock.end() < e.lock.end() {
+			after := e
+			after.lock = e.lock.withRange(lock.end(), e.lock.end())
+			entries = append(entries, after)
+		}
+	}
+	t.entries = entries
+}
+
+// set gives owner the given lock, replacing any of owner's locks on
+// the same range.  The caller must check for conflicts first.
+func (t *fileLockTable) set(
+	owner fileLockOwner, lock FileLock, expiry time.Time) {
+	t.remove(owner, lock)
+	t.entries = append(t.entries, fileLockEntry{owner, lock, expiry})
+}
+
+// holds returns true if owner holds exactly the given lock.
+func (t *fileLockTable) holds(owner fileLockOwner, lock FileLock) bool {
+	for _, e := range t.entries {
+		if e.owner == owner && e.lock == lock {
+			return true
+		}
+	}
+	return false
+}
+
+// locks returns all the locks held by any owner on device.
+func (t *fileLockTable) locks(
+	device kbfscrypto.CryptPublicKey) (locks []FileLock) {
+	for _, e := range t.entries {
+		if e.owner.device == device {
+			locks = append(locks, e.lock)
+		}
+	}
+	return locks
+}
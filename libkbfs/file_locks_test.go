@@ -0,0 +1,85 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLockTableSplit(t *testing.T) {
+	var table fileLockTable
+	owner := fileLockOwner{owner: 1}
+	table.set(owner, FileLock{Type: FileLockWrite, Owner: 1, Start: 10},
+		time.Time{})
+
+	// Unlocking the middle of a lock splits it in two.
+	table.remove(owner, FileLock{Owner: 1, Start: 20, Length: 10})
+	require.Equal(t, []FileLock{
+		{Type: FileLockWrite, Owner: 1, Start: 10, Length: 10},
+		{Type: FileLockWrite, Owner: 1, Start: 30},
+	}, table.locks(kbfscrypto.CryptPublicKey{}))
+
+	// Re-locking part of the range with a different type replaces
+	// just that part.
+	table.set(owner,
+		FileLock{Type: FileLockRead, Owner: 1, Start: 15, Length: 20},
+		time.Time{})
+	require.Equal(t, []FileLock{
+		{Type: FileLockWrite, Owner: 1, Start: 10, Length: 5},
+		{Type: FileLockWrite, Owner: 1, Start: 35},
+		{Type: FileLockRead, Owner: 1, Start: 15, Length: 20},
+	}, table.locks(kbfscrypto.CryptPublicKey{}))
+
+	other := fileLockOwner{owner: 2}
+	_, ok := table.conflict(other,
+		FileLock{Type: FileLockRead, Owner: 2, Start: 15, Length: 20})
+	require.False(t, ok)
+	conflict, ok := table.conflict(other,
+		FileLock{Type: FileLockRead, Owner: 2, Start: 34, Length: 2})
+	require.True(t, ok)
+	require.Equal(t, FileLock{Type: FileLockWrite, Owner: 1, Start: 35}, conflict)
+
+	table.remove(owner, FileLock{Owner: 1})
+	require.True(t, table.isEmpty())
+}
+
+func TestFileLockTableExpire(t *testing.T) {
+	var table fileLockTable
+	now := time.Now()
+	table.set(fileLockOwner{owner: 1},
+		FileLock{Type: FileLockWrite, Owner: 1}, now.Add(time.Minute))
+	table.set(fileLockOwner{owner: 2},
+		FileLock{Type: FileLockRead, Owner: 2}, time.Time{})
+
+	table.expire(now)
+	require.Len(t, table.entries, 2)
+	table.expire(now.Add(time.Minute))
+	require.Equal(t, []FileLock{{Type: FileLockRead, Owner: 2}},
+		table.locks(kbfscrypto.CryptPublicKey{}))
+}
+
+func TestFileLockTableFlockOwners(t *testing.T) {
+	var table fileLockTable
+	posix := FileLock{Type: FileLockRead, Owner: 1}
+	flock := FileLock{Type: FileLockWrite, Owner: 1, Flock: true}
+	table.set(makeFileLockOwner(kbfscrypto.CryptPublicKey{}, posix), posix,
+		time.Time{})
+
+	// An flock lock doesn't replace a POSIX lock with the same
+	// owner, and conflicts with it instead.
+	flockOwner := makeFileLockOwner(kbfscrypto.CryptPublicKey{}, flock)
+	conflict, ok := table.conflict(flockOwner, flock)
+	require.True(t, ok)
+	require.Equal(t, posix, conflict)
+
+	// Unlocking the flock owner leaves the POSIX lock alone.
+	table.remove(flockOwner, FileLock{Owner: 1, Flock: true})
+	require.Equal(t, []FileLock{posix},
+		table.locks(kbfscrypto.CryptPublicKey{}))
+}
@@ -313,6 +313,8 @@ func isArchivableOp(op op) bool {
 		return true
 	case *setAttrOp:
 		return true
+	case *setXattrOp:
+		return true
 	case *resolutionOp:
 		return true
 	default:
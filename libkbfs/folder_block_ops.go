@@ -220,6 +220,10 @@ type folderBlockOps struct {
 	// call PathFromNode() only under blockLock (see nodeCache
 	// comments in folder_branch_ops.go).
 	nodeCache NodeCache
+
+	// readAhead tracks how each file is being read, to decide what
+	// to prefetch after each read.  It is goroutine-safe.
+	readAhead *readAheadTracker
 }
 
 // Only exported methods of folderBlockOps should be used outside of this
@@ -719,6 +723,27 @@ func (fbo *folderBlockOps) UndupChildrenInCopy(ctx context.Context,
 		fbo.config.BlockOps(), bps, topBlock)
 }
 
+// ReadyChildrenInCopyAsNew is like UndupChildrenInCopy, except that
+// the leaf blocks are never deduplicated against known blocks, and so
+// are all uploaded again as brand new blocks.  This is needed when
+// the original blocks can no longer accept new references (e.g.,
+// because they have been archived).
+func (fbo *folderBlockOps) ReadyChildrenInCopyAsNew(ctx context.Context,
+	lState *lockState, kmd KeyMetadata, file path, bps *blockPutState,
+	dirtyBcache DirtyBlockCache, topBlock *FileBlock) ([]BlockInfo, error) {
+	fbo.blockLock.Lock(lState)
+	defer fbo.blockLock.Unlock(lState)
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fd := fbo.newFileDataWithCache(
+		lState, file, session.UID, kmd, dirtyBcache)
+	return fd.undupChildrenInCopy(ctx,
+		noDedupBlockCache{fbo.config.BlockCache()}, fbo.config.BlockOps(),
+		bps, topBlock)
+}
+
 func (fbo *folderBlockOps) ReadyNonLeafBlocksInCopy(ctx context.Context,
 	lState *lockState, kmd KeyMetadata, file path, bps *blockPutState,
 	dirtyBcache DirtyBlockCache, topBlock *FileBlock) ([]BlockInfo, error) {
@@ -1262,7 +1287,84 @@ func (fbo *folderBlockOps) Read(
 
 	var uid keybase1.UID // Data reads don't depend on the uid.
 	fd := fbo.newFileData(lState, file, uid, kmd)
-	return fd.read(ctx, dest, off)
+	n, err := fd.read(ctx, dest, off)
+	if err != nil {
+		return 0, err
+	}
+	fbo.readAheadLocked(ctx, lState, kmd, file, fd, off, n)
+	return n, nil
+}
+
+// readAheadLocked records the read of n bytes at off from the given
+// file, and if the file looks like it's being read sequentially,
+// starts prefetching the blocks in the read-ahead window following
+// the read.  Finding those blocks can mean fetching indirect blocks,
+// so it's done in the background, without blockLock held.
+func (fbo *folderBlockOps) readAheadLocked(
+	ctx context.Context, lState *lockState, kmd KeyMetadata, file path,
+	fd *fileData, off, n int64) {
+	fbo.blockLock.AssertAnyLocked(lState)
+
+	ptr := file.tailPointer()
+	start, end := fbo.readAhead.onRead(ptr, off, n)
+	if start >= end {
+		return
+	}
+	// Dirty files may have blocks that aren't on the server yet.
+	if _, ok := fbo.dirtyFiles[ptr]; ok {
+		return
+	}
+
+	// The read's context may be canceled as soon as it returns.
+	ctx = ctxWithRandomIDReplayable(
+		context.Background(), CtxFBOIDKey, CtxFBOOpID, fbo.log)
+	fbo.readAhead.start()
+	go func() {
+		defer fbo.readAhead.done()
+		fbo.prefetchRange(ctx, kmd, file, fd, start, end)
+	}()
+}
+
+// prefetchRange prefetches the direct blocks of the given file
+// within [start, end).  fd must only be used to get blocks with
+// blockReadParallel, since blockLock isn't held.  Errors are only
+// logged, since the read itself succeeded.
+func (fbo *folderBlockOps) prefetchRange(ctx context.Context,
+	kmd KeyMetadata, file path, fd *fileData, start, end int64) {
+	ptr := file.tailPointer()
+	topBlock, _, err := fbo.getFileBlockLocked(
+		ctx, nil, kmd, ptr, file, blockReadParallel)
+	if err != nil {
+		fbo.log.CDebugf(ctx, "Couldn't get %v for read-ahead: %+v", ptr, err)
+		return
+	}
+	if !topBlock.IsInd {
+		// The whole file has already been read.
+		return
+	}
+	pfr, err := fd.getIndirectBlocksForOffsetRange(ctx, topBlock, start, end)
+	if err != nil {
+		fbo.log.CDebugf(ctx, "Couldn't get indirect blocks for "+
+			"read-ahead of %v: %+v", ptr, err)
+		return
+	}
+
+	prefetcher := fbo.config.BlockOps().Prefetcher()
+	numPrefetched := 0
+	for _, p := range pfr {
+		if len(p) == 0 {
+			continue
+		}
+		childPtr := p[len(p)-1].childIPtr().BlockPointer
+		err := prefetcher.PrefetchBlock(
+			&FileBlock{}, childPtr, kmd, readAheadPrefetchPriority)
+		if err != nil {
+			fbo.log.CDebugf(ctx, "Couldn't prefetch %v: %+v", childPtr, err)
+			return
+		}
+		numPrefetched++
+	}
+	fbo.readAhead.markPrefetched(numPrefetched)
 }
 
 func (fbo *folderBlockOps) maybeWaitOnDeferredWrites(
@@ -1704,6 +1806,227 @@ func (fbo *folderBlockOps) Truncate(
 	return nil
 }
 
+// Returns the ranges of the file that changed, along with the set of
+// newly-ID'd blocks created during this punch that might need to be
+// cleaned up if the punch is deferred.
+func (fbo *folderBlockOps) punchHoleLocked(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	file path, off, length int64) ([]WriteRange, []BlockPointer, int64, error) {
+	de, err := fbo.getDirtyEntryLocked(ctx, lState, kmd, file)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	size := int64(de.Size)
+	end := off + length
+	if end > size || end < off {
+		end = size
+	}
+	if off >= end {
+		return nil, nil, 0, nil
+	}
+
+	if end == size {
+		// Punching all the way to the end of the file is the same
+		// as shrinking it, and then extending it back out with a
+		// hole.
+		shrinkWrite, dirtyPtrs, newlyDirtiedChildBytes, err :=
+			fbo.truncateLocked(ctx, lState, kmd, file, uint64(off))
+		if err != nil {
+			return nil, dirtyPtrs, newlyDirtiedChildBytes, err
+		}
+
+		fblock, uid, err := fbo.writeGetFileLocked(ctx, lState, kmd, file)
+		if err != nil {
+			return nil, dirtyPtrs, newlyDirtiedChildBytes, err
+		}
+		fd := fbo.newFileData(lState, file, uid, kmd)
+		_, parentBlocks, _, _, _, _, err := fd.getFileBlockAtOffset(
+			ctx, fblock, size, blockWrite)
+		if err != nil {
+			return nil, dirtyPtrs, newlyDirtiedChildBytes, err
+		}
+		extendWrite, newDirtyPtrs, err := fbo.truncateExtendLocked(
+			ctx, lState, kmd, file, uint64(size), parentBlocks)
+		dirtyPtrs = append(dirtyPtrs, newDirtyPtrs...)
+		if err != nil {
+			return nil, dirtyPtrs, newlyDirtiedChildBytes, err
+		}
+		latestWrites := []WriteRange{extendWrite}
+		if shrinkWrite != nil {
+			latestWrites = append([]WriteRange{*shrinkWrite}, latestWrites...)
+		}
+		return latestWrites, dirtyPtrs, newlyDirtiedChildBytes, nil
+	}
+
+	if jServer, err := GetJournalServer(fbo.config); err == nil {
+		jServer.dirtyOpStart(fbo.id())
+		defer jServer.dirtyOpEnd(fbo.id())
+	}
+
+	fblock, uid, err := fbo.writeGetFileLocked(ctx, lState, kmd, file)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	fd := fbo.newFileData(lState, file, uid, kmd)
+
+	si, err := fbo.getOrCreateSyncInfoLocked(lState, de)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	dirtyPtrs, unrefs, newlyDirtiedChildBytes, err := fd.punchHole(
+		ctx, off, end, fblock)
+	// Record the unrefs before checking the error so we remember the
+	// state of newly dirtied blocks.
+	si.unrefs = append(si.unrefs, unrefs...)
+	if err != nil {
+		return nil, nil, newlyDirtiedChildBytes, err
+	}
+
+	// Update dirtied bytes and unrefs regardless of error.
+	df := fbo.getOrCreateDirtyFileLocked(lState, file)
+	df.updateNotYetSyncingBytes(newlyDirtiedChildBytes)
+
+	// The punched range now reads back as zeroes, so record it as a
+	// write.
+	latestWrite := si.op.addWrite(uint64(off), uint64(end-off))
+	fbo.deCache[file.tailPointer().Ref()] = de
+
+	return []WriteRange{latestWrite}, dirtyPtrs, newlyDirtiedChildBytes, nil
+}
+
+// PunchHole deallocates the given range of the given file, which
+// will read back as zeroes, without changing the size of the file.
+// May block if there is too much unflushed data; in that case, it
+// will be unblocked by a future sync.
+func (fbo *folderBlockOps) PunchHole(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	file Node, off, length int64) error {
+	// If there is too much unflushed data, we should wait until some
+	// of it gets flush so our memory usage doesn't grow without
+	// bound.
+	//
+	// Only the blocks at the edges of the range can keep any dirty
+	// data, so don't ask for more than that.
+	estimatedDirtyBytes := length
+	if estimatedDirtyBytes > 2*MaxBlockSizeBytesDefault {
+		estimatedDirtyBytes = 2 * MaxBlockSizeBytesDefault
+	}
+	c, err := fbo.config.DirtyBlockCache().RequestPermissionToDirty(ctx,
+		fbo.id(), estimatedDirtyBytes)
+	if err != nil {
+		return err
+	}
+	defer fbo.config.DirtyBlockCache().UpdateUnsyncedBytes(fbo.id(),
+		-estimatedDirtyBytes, false)
+	err = fbo.maybeWaitOnDeferredWrites(ctx, lState, file, c)
+	if err != nil {
+		return err
+	}
+
+	fbo.blockLock.Lock(lState)
+	defer fbo.blockLock.Unlock(lState)
+
+	filePath, err := fbo.pathFromNodeForBlockWriteLocked(lState, file)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		fbo.doDeferWrite = false
+	}()
+
+	latestWrites, dirtyPtrs, newlyDirtiedChildBytes, err :=
+		fbo.punchHoleLocked(ctx, lState, kmd, filePath, off, length)
+	if err != nil {
+		return err
+	}
+
+	for _, latestWrite := range latestWrites {
+		fbo.observers.localChange(ctx, file, latestWrite)
+	}
+
+	if fbo.doDeferWrite {
+		// There's an ongoing sync, and this punch altered dirty
+		// blocks that are in the process of syncing.  So, we have
+		// to redo this punch once the sync is complete, using the
+		// new file path.
+		fbo.log.CDebugf(ctx, "Deferring a hole punch to file %v "+
+			"off=%d len=%d", filePath.tailPointer(), off, length)
+		fbo.deferredDirtyDeletes = append(fbo.deferredDirtyDeletes,
+			dirtyPtrs...)
+		fbo.deferredWrites = append(fbo.deferredWrites,
+			func(ctx context.Context, lState *lockState, kmd KeyMetadata, f path) error {
+				// We are about to re-dirty these bytes, so mark that
+				// they will no longer be synced via the old file.
+				df := fbo.getOrCreateDirtyFileLocked(lState, filePath)
+				df.updateNotYetSyncingBytes(-newlyDirtiedChildBytes)
+
+				// Punch the hole again.  We know this won't be
+				// deferred, so no need to check the new ptrs.
+				_, _, _, err := fbo.punchHoleLocked(
+					ctx, lState, kmd, f, off, length)
+				return err
+			})
+		fbo.deferredWaitBytes += newlyDirtiedChildBytes
+	}
+
+	return nil
+}
+
+// NextDataOffset returns the offset of the first byte of data in the
+// given file at or after `off`, or -1 if there is no more data.
+func (fbo *folderBlockOps) NextDataOffset(
+	ctx context.Context, lState *lockState, kmd KeyMetadata, file path,
+	off int64) (int64, error) {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+
+	de, err := fbo.getDirtyEntryLocked(ctx, lState, kmd, file)
+	if err != nil {
+		return 0, err
+	}
+	if off >= int64(de.Size) {
+		return -1, nil
+	}
+
+	var uid keybase1.UID // Data reads don't depend on the uid.
+	fd := fbo.newFileData(lState, file, uid, kmd)
+	return fd.nextDataOffset(ctx, off)
+}
+
+// NextHoleOffset returns the offset of the first byte of the first
+// hole in the given file at or after `off`, where the end of the file
+// counts as a hole.  It returns -1 if `off` is past the end of the
+// file.
+func (fbo *folderBlockOps) NextHoleOffset(
+	ctx context.Context, lState *lockState, kmd KeyMetadata, file path,
+	off int64) (int64, error) {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+
+	de, err := fbo.getDirtyEntryLocked(ctx, lState, kmd, file)
+	if err != nil {
+		return 0, err
+	}
+	size := int64(de.Size)
+	if off >= size {
+		return -1, nil
+	}
+
+	var uid keybase1.UID // Data reads don't depend on the uid.
+	fd := fbo.newFileData(lState, file, uid, kmd)
+	holeOff, err := fd.nextHoleOffset(ctx, off)
+	if err != nil {
+		return 0, err
+	}
+	if holeOff > size {
+		holeOff = size
+	}
+	return holeOff, nil
+}
+
 // IsDirty returns whether the given file is dirty; if false is
 // returned, then the file doesn't need to be synced.
 func (fbo *folderBlockOps) IsDirty(lState *lockState, file path) bool {
@@ -1847,6 +2170,9 @@ func ReadyBlock(ctx context.Context, bcache BlockCache, bops BlockOps,
 			DirectType: directType,
 			Context:    kbfsblock.MakeFirstContext(uid, bType),
 		}
+		if ptr.DataVer < readyBlockData.minDataVer {
+			ptr.DataVer = readyBlockData.minDataVer
+		}
 	}
 
 	info = BlockInfo{
@@ -2666,7 +2992,7 @@ func (fbo *folderBlockOps) getUndirtiedEntry(
 
 func (fbo *folderBlockOps) setCachedAttr(
 	ctx context.Context, lState *lockState,
-	ref BlockRef, op *setAttrOp, realEntry *DirEntry, doCreate bool) {
+	ref BlockRef, op op, realEntry *DirEntry, doCreate bool) {
 	fbo.blockLock.Lock(lState)
 	defer fbo.blockLock.Unlock(lState)
 
@@ -2678,23 +3004,39 @@ func (fbo *folderBlockOps) setCachedAttr(
 		fileEntry = *realEntry
 	}
 
-	switch op.Attr {
-	case exAttr:
-		fileEntry.Type = realEntry.Type
-	case mtimeAttr:
-		fileEntry.Mtime = realEntry.Mtime
+	switch realOp := op.(type) {
+	case *setAttrOp:
+		switch realOp.Attr {
+		case exAttr:
+			fileEntry.Type = realEntry.Type
+		case mtimeAttr:
+			fileEntry.Mtime = realEntry.Mtime
+		case lockIDAttr:
+			fileEntry.LockID = realEntry.LockID
+		}
+	case *setXattrOp:
+		fileEntry.copyXattr(*realEntry, realOp.Xattr)
 	}
 	fileEntry.Ctime = realEntry.Ctime
 	fbo.deCache[ref] = fileEntry
 }
 
 // UpdateCachedEntryAttributes updates any cached entry for the given
-// path according to the given op. The node for the path is returned
-// if there is one.
+// path according to the given op, which must be a setAttrOp or a
+// setXattrOp. The node for the path is returned if there is one.
 func (fbo *folderBlockOps) UpdateCachedEntryAttributes(
 	ctx context.Context, lState *lockState, kmd KeyMetadata,
-	dir path, op *setAttrOp) (Node, error) {
-	childPath := dir.ChildPathNoPtr(op.Name)
+	dir path, op op) (Node, error) {
+	var name string
+	switch realOp := op.(type) {
+	case *setAttrOp:
+		name = realOp.Name
+	case *setXattrOp:
+		name = realOp.Name
+	default:
+		return nil, fmt.Errorf("Unexpected attribute op %s", op)
+	}
+	childPath := dir.ChildPathNoPtr(name)
 
 	// find the node for the actual change; requires looking up
 	// the child entry to get the BlockPointer, unfortunately.
@@ -2710,7 +3052,7 @@ func (fbo *folderBlockOps) UpdateCachedEntryAttributes(
 		return nil, nil
 	}
 
-	childPath = dir.ChildPath(op.Name, de.BlockPointer)
+	childPath = dir.ChildPath(name, de.BlockPointer)
 
 	// If there's a cache entry, we need to update it, so try and
 	// fetch the undirtied entry.
@@ -2727,12 +3069,13 @@ func (fbo *folderBlockOps) UpdateCachedEntryAttributes(
 }
 
 // UpdateCachedEntryAttributesOnRemovedFile updates any cached entry
-// for the given path of an unlinked file, according to the given op,
-// and it makes a new dirty cache entry if one doesn't exist yet.  We
-// assume Sync will be called eventually on the corresponding open
-// file handle, which will clear out the entry.
+// for the given path of an unlinked file, according to the given op
+// (a setAttrOp or a setXattrOp), and it makes a new dirty cache entry
+// if one doesn't exist yet.  We assume Sync will be called eventually
+// on the corresponding open file handle, which will clear out the
+// entry.
 func (fbo *folderBlockOps) UpdateCachedEntryAttributesOnRemovedFile(
-	ctx context.Context, lState *lockState, op *setAttrOp, de DirEntry) {
+	ctx context.Context, lState *lockState, op op, de DirEntry) {
 	fbo.setCachedAttr(ctx, lState, de.Ref(), op, &de, true)
 }
 
@@ -5,9 +5,11 @@
 package libkbfs
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -322,6 +324,12 @@ type folderBranchOps struct {
 
 	editHistory *TlfEditHistory
 
+	// Advisory file locks held by owners on this device.
+	fileLocks *folderFileLocks
+
+	// Keeps selected subtrees pinned in the disk block cache.
+	syncer *folderSyncer
+
 	branchChanges      kbfssync.RepeatedWaitGroup
 	mdFlushes          kbfssync.RepeatedWaitGroup
 	forcedFastForwards kbfssync.RepeatedWaitGroup
@@ -385,6 +393,7 @@ func newFolderBranchOps(config Config, fb FolderBranch,
 			unrefCache: make(map[BlockRef]*syncInfo),
 			deCache:    make(map[BlockRef]DirEntry),
 			nodeCache:  nodeCache,
+			readAhead:  newReadAheadTracker(config.MetricsRegistry()),
 		},
 		nodeCache:       nodeCache,
 		log:             log,
@@ -402,6 +411,9 @@ func newFolderBranchOps(config Config, fb FolderBranch,
 	fbo.cr = NewConflictResolver(config, fbo)
 	fbo.fbm = newFolderBlockManager(config, fb, fbo)
 	fbo.editHistory = NewTlfEditHistory(config, fbo, log)
+	fbo.fileLocks = newFolderFileLocks(
+		config, fb.Tlf, log, fbo.reportLostFileLock)
+	fbo.syncer = newFolderSyncer(config, fb.Tlf, log)
 	fbo.rekeyFSM = NewRekeyFSM(fbo)
 	if config.DoBackgroundFlushes() {
 		go fbo.backgroundFlusher(secondsBetweenBackgroundFlushes * time.Second)
@@ -429,6 +441,8 @@ func (fbo *folderBranchOps) Shutdown(ctx context.Context) error {
 
 		if fbo.blocks.GetState(lState) == dirtyState {
 			fbo.log.CDebugf(ctx, "Skipping state-checking due to dirty state")
+		} else if fbo.isArchived() {
+			fbo.log.CDebugf(ctx, "Skipping state-checking for archived branch")
 		} else if !fbo.isMasterBranch(lState) {
 			fbo.log.CDebugf(ctx, "Skipping state-checking due to being staged")
 		} else {
@@ -449,6 +463,8 @@ func (fbo *folderBranchOps) Shutdown(ctx context.Context) error {
 	fbo.cr.Shutdown()
 	fbo.fbm.shutdown()
 	fbo.editHistory.Shutdown()
+	fbo.fileLocks.shutdown()
+	fbo.syncer.shutdown()
 	fbo.rekeyFSM.Shutdown()
 	// Wait for the update goroutine to finish, so that we don't have
 	// any races with logging during test reporting.
@@ -466,6 +482,12 @@ func (fbo *folderBranchOps) branch() BranchName {
 	return fbo.folderBranch.Branch
 }
 
+// isArchived returns true if this folder-branch is a read-only view
+// of a historical revision.
+func (fbo *folderBranchOps) isArchived() bool {
+	return fbo.bType == archive || fbo.bType == archiveOffline
+}
+
 func (fbo *folderBranchOps) GetFavorites(ctx context.Context) (
 	[]Favorite, error) {
 	return nil, errors.New("GetFavorites is not supported by folderBranchOps")
@@ -762,6 +784,11 @@ func (fbo *folderBranchOps) setHeadLocked(
 		fbo.headStatus = headTrusted
 	}
 	fbo.status.setRootMetadata(md)
+	if md.MergedStatus() == Merged && md.IsReadable() &&
+		fbo.branch() == MasterBranch && !fbo.isArchived() &&
+		fbo.config.Mode() != InitMinimal {
+		fbo.syncer.headChanged(md)
+	}
 	if isFirstHead {
 		// Start registering for updates right away, using this MD
 		// as a starting point. For now only the master branch can
@@ -1469,6 +1496,12 @@ func (fbo *folderBranchOps) GetRootNode(
 	return nil, EntryInfo{}, errors.New("GetRootNode is not supported by folderBranchOps")
 }
 
+func (fbo *folderBranchOps) GetRootNodeAtRevision(
+	ctx context.Context, h *TlfHandle, rev MetadataRevision) (
+	node Node, ei EntryInfo, err error) {
+	return nil, EntryInfo{}, errors.New("GetRootNodeAtRevision is not supported by folderBranchOps")
+}
+
 func (fbo *folderBranchOps) checkNode(node Node) error {
 	fb := node.GetFolderBranch()
 	if fb != fbo.folderBranch {
@@ -1477,6 +1510,19 @@ func (fbo *folderBranchOps) checkNode(node Node) error {
 	return nil
 }
 
+// checkNodeForWrite is like checkNode, but additionally makes sure
+// the node doesn't belong to a read-only (archived) branch.
+func (fbo *folderBranchOps) checkNodeForWrite(node Node) error {
+	err := fbo.checkNode(node)
+	if err != nil {
+		return err
+	}
+	if fbo.isArchived() {
+		return WriteToReadonlyNodeError{node.GetBasename()}
+	}
+	return nil
+}
+
 // SetInitialHeadFromServer sets the head to the given
 // ImmutableRootMetadata, which must be retrieved from the MD server.
 func (fbo *folderBranchOps) SetInitialHeadFromServer(
@@ -1514,6 +1560,15 @@ func (fbo *folderBranchOps) SetInitialHeadFromServer(
 
 	return runUnlessCanceled(ctx, func() error {
 		fb := FolderBranch{md.TlfID(), MasterBranch}
+		if fbo.isArchived() {
+			// An archived branch can only ever be set to the exact
+			// merged revision it names.
+			if md.MergedStatus() != Merged {
+				return errors.Errorf("Can't set unmerged revision %d "+
+					"on archived branch %s", md.Revision(), fbo.branch())
+			}
+			fb.Branch = MakeRevBranchName(md.Revision())
+		}
 		if fb != fbo.folderBranch {
 			return WrongOpsError{fbo.folderBranch, fb}
 		}
@@ -2618,7 +2673,7 @@ func (fbo *folderBranchOps) CreateDir(
 			getNodeIDStr(dir), path, getNodeIDStr(n), err)
 	}()
 
-	err = fbo.checkNode(dir)
+	err = fbo.checkNodeForWrite(dir)
 	if err != nil {
 		return nil, EntryInfo{}, err
 	}
@@ -2653,7 +2708,7 @@ func (fbo *folderBranchOps) CreateFile(
 			getNodeIDStr(n), err)
 	}()
 
-	err = fbo.checkNode(dir)
+	err = fbo.checkNodeForWrite(dir)
 	if err != nil {
 		return nil, EntryInfo{}, err
 	}
@@ -2777,7 +2832,7 @@ func (fbo *folderBranchOps) CreateLink(
 			getNodeIDStr(dir), fromName, toPath, err)
 	}()
 
-	err = fbo.checkNode(dir)
+	err = fbo.checkNodeForWrite(dir)
 	if err != nil {
 		return EntryInfo{}, err
 	}
@@ -2915,7 +2970,7 @@ func (fbo *folderBranchOps) RemoveDir(
 			getNodeIDStr(dir), dirName, err)
 	}()
 
-	err = fbo.checkNode(dir)
+	err = fbo.checkNodeForWrite(dir)
 	if err != nil {
 		return
 	}
@@ -2934,7 +2989,7 @@ func (fbo *folderBranchOps) RemoveEntry(ctx context.Context, dir Node,
 			getNodeIDStr(dir), name, err)
 	}()
 
-	err = fbo.checkNode(dir)
+	err = fbo.checkNodeForWrite(dir)
 	if err != nil {
 		return err
 	}
@@ -3110,7 +3165,7 @@ func (fbo *folderBranchOps) Rename(
 			getNodeIDStr(newParent), newName, err)
 	}()
 
-	err = fbo.checkNode(newParent)
+	err = fbo.checkNodeForWrite(newParent)
 	if err != nil {
 		return err
 	}
@@ -3211,7 +3266,7 @@ func (fbo *folderBranchOps) Write(
 			getNodeIDStr(file), len(data), off, err)
 	}()
 
-	err = fbo.checkNode(file)
+	err = fbo.checkNodeForWrite(file)
 	if err != nil {
 		return err
 	}
@@ -3246,7 +3301,7 @@ func (fbo *folderBranchOps) Truncate(
 			getNodeIDStr(file), size, err)
 	}()
 
-	err = fbo.checkNode(file)
+	err = fbo.checkNodeForWrite(file)
 	if err != nil {
 		return err
 	}
@@ -3273,6 +3328,329 @@ func (fbo *folderBranchOps) Truncate(
 	})
 }
 
+func (fbo *folderBranchOps) PunchHole(
+	ctx context.Context, file Node, off, length int64) (err error) {
+	fbo.log.CDebugf(ctx, "PunchHole %s %d %d", getNodeIDStr(file),
+		off, length)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "PunchHole %s %d %d done: %+v",
+			getNodeIDStr(file), off, length, err)
+	}()
+
+	if off < 0 || length < 0 {
+		return InvalidRangeError{off, length}
+	}
+
+	err = fbo.checkNodeForWrite(file)
+	if err != nil {
+		return err
+	}
+
+	return runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+
+		// Get the MD for reading.  We won't modify it; we'll track the
+		// unref changes on the side, and put them into the MD during the
+		// sync.
+		md, err := fbo.getMDForReadLocked(ctx, lState, mdReadNeedIdentify)
+		if err != nil {
+			return err
+		}
+
+		err = fbo.blocks.PunchHole(
+			ctx, lState, md.ReadOnly(), file, off, length)
+		if err != nil {
+			return err
+		}
+
+		fbo.status.addDirtyNode(file)
+		return nil
+	})
+}
+
+// seekHelper finds the next data or hole offset in the given file,
+// using the given folderBlockOps function.
+func (fbo *folderBranchOps) seekHelper(ctx context.Context, file Node,
+	off int64, seek func(context.Context, *lockState, KeyMetadata, path,
+		int64) (int64, error)) (int64, error) {
+	if off < 0 {
+		return 0, InvalidRangeError{off, 0}
+	}
+
+	err := fbo.checkNode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return 0, err
+	}
+
+	// Don't let the goroutine below write directly to the return
+	// variable, since if the context is canceled the goroutine might
+	// outlast this function call, and end up in a read/write race
+	// with the caller.
+	var retOff int64
+	err = runUnlessCanceled(ctx, func() error {
+		lState := makeFBOLockState()
+
+		// verify we have permission to read
+		md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+
+		retOff, err = seek(ctx, lState, md.ReadOnly(), filePath, off)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return retOff, nil
+}
+
+func (fbo *folderBranchOps) NextDataOffset(
+	ctx context.Context, file Node, off int64) (dataOff int64, err error) {
+	fbo.log.CDebugf(ctx, "NextDataOffset %s %d", getNodeIDStr(file), off)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "NextDataOffset %s %d done: %d %+v",
+			getNodeIDStr(file), off, dataOff, err)
+	}()
+
+	return fbo.seekHelper(ctx, file, off, fbo.blocks.NextDataOffset)
+}
+
+func (fbo *folderBranchOps) NextHoleOffset(
+	ctx context.Context, file Node, off int64) (holeOff int64, err error) {
+	fbo.log.CDebugf(ctx, "NextHoleOffset %s %d", getNodeIDStr(file), off)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "NextHoleOffset %s %d done: %d %+v",
+			getNodeIDStr(file), off, holeOff, err)
+	}()
+
+	return fbo.seekHelper(ctx, file, off, fbo.blocks.NextHoleOffset)
+}
+
+func checkFileLock(lock FileLock) error {
+	if lock.Start < 0 || lock.Length < 0 {
+		return InvalidRangeError{lock.Start, lock.Length}
+	}
+	return nil
+}
+
+// setLockIDLocked gives file a lock ID, unless it already has one,
+// and returns it.  Unlinked files get no lock ID, and an empty string
+// is returned for them.
+func (fbo *folderBranchOps) setLockIDLocked(
+	ctx context.Context, lState *lockState, file path) (string, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return "", err
+	}
+
+	dblock, de, err := fbo.blocks.GetDirtyParentAndEntry(
+		ctx, lState, md.ReadOnly(), file)
+	if err != nil {
+		return "", err
+	}
+	if de.LockID != "" {
+		return de.LockID, nil
+	}
+
+	// If the MD doesn't match the MD expected by the path, that
+	// implies we are using a cached path, which implies the node has
+	// been unlinked.  Nobody else can find it by name anymore, so
+	// there's no need for a lock ID.
+	if md.data.Dir.BlockPointer.ID != file.path[0].BlockPointer.ID {
+		fbo.log.CDebugf(ctx, "Not setting a lock ID for a removed "+
+			"file %v", file.tailPointer())
+		return "", nil
+	}
+
+	// The lock ID isn't user-visible, so don't touch the ctime.
+	de.LockID, err = MakeRandomRequestID()
+	if err != nil {
+		return "", err
+	}
+
+	parentPath := file.parentPath()
+	sao, err := newSetAttrOp(file.tailName(), parentPath.tailPointer(),
+		lockIDAttr, file.tailPointer())
+	if err != nil {
+		return "", err
+	}
+	sao.setFinalPath(file)
+	md.AddOp(sao)
+
+	dblock.Children[file.tailName()] = de
+	_, err = fbo.syncBlockAndFinalizeLocked(
+		ctx, lState, md, dblock, *parentPath.parentPath(), parentPath.tailName(),
+		Dir, false, false, zeroPtr, NoExcl)
+	if err != nil {
+		return "", err
+	}
+	return de.LockID, nil
+}
+
+// fileLockHelper checks that the user can read file, and returns the
+// key identifying file for lock leases.  If assignLockID is true and
+// the user can write to the folder, file is given a lock ID first if
+// it doesn't have one yet, so that its leases survive renames.
+func (fbo *folderBranchOps) fileLockHelper(
+	ctx context.Context, file Node, assignLockID bool) (
+	key string, err error) {
+	err = fbo.checkNode(file)
+	if err != nil {
+		return "", err
+	}
+
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return "", err
+	}
+
+	lState := makeFBOLockState()
+	// verify we have permission to read
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return "", err
+	}
+	if !filePath.hasValidParent() {
+		// The root directory can't be renamed.
+		return fileLockKey(filePath, ""), nil
+	}
+
+	de, err := fbo.blocks.GetDirtyEntry(ctx, lState, md.ReadOnly(), filePath)
+	if err != nil {
+		return "", err
+	}
+	if de.LockID != "" || !assignLockID || fbo.isArchived() {
+		return fileLockKey(filePath, de.LockID), nil
+	}
+
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !md.GetTlfHandle().IsWriter(session.UID) {
+		return fileLockKey(filePath, ""), nil
+	}
+
+	var lockID string
+	err = fbo.doMDWriteWithRetry(ctx, lState,
+		func(lState *lockState) error {
+			filePath, err = fbo.pathFromNodeForMDWriteLocked(lState, file)
+			if err != nil {
+				return err
+			}
+			lockID, err = fbo.setLockIDLocked(ctx, lState, filePath)
+			return err
+		})
+	if err != nil {
+		return "", err
+	}
+	return fileLockKey(filePath, lockID), nil
+}
+
+// reportLostFileLock tells the user that a file lock was dropped
+// because its lease couldn't be renewed.
+func (fbo *folderBranchOps) reportLostFileLock(
+	ctx context.Context, err error) {
+	lState := makeFBOLockState()
+	head := fbo.getTrustedHead(lState)
+	if head == (ImmutableRootMetadata{}) {
+		return
+	}
+	handle := head.GetTlfHandle()
+	fbo.config.Reporter().ReportErr(ctx,
+		handle.GetCanonicalName(), handle.IsPublic(), WriteMode, err)
+}
+
+func (fbo *folderBranchOps) LockFile(
+	ctx context.Context, file Node, lock FileLock) (err error) {
+	fbo.log.CDebugf(ctx, "LockFile %s %s", getNodeIDStr(file), lock)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "LockFile %s %s done: %+v",
+			getNodeIDStr(file), lock, err)
+	}()
+
+	if lock.Type != FileLockRead && lock.Type != FileLockWrite {
+		return errors.Errorf("Unknown file lock type %s", lock.Type)
+	}
+	if err := checkFileLock(lock); err != nil {
+		return err
+	}
+
+	return runUnlessCanceled(ctx, func() error {
+		key, err := fbo.fileLockHelper(ctx, file, true)
+		if err != nil {
+			return err
+		}
+		return fbo.fileLocks.lockFile(ctx, file.GetID(), key, lock)
+	})
+}
+
+func (fbo *folderBranchOps) UnlockFile(
+	ctx context.Context, file Node, lock FileLock) (err error) {
+	fbo.log.CDebugf(ctx, "UnlockFile %s %s", getNodeIDStr(file), lock)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "UnlockFile %s %s done: %+v",
+			getNodeIDStr(file), lock, err)
+	}()
+
+	if err := checkFileLock(lock); err != nil {
+		return err
+	}
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return err
+	}
+
+	return runUnlessCanceled(ctx, func() error {
+		return fbo.fileLocks.unlockFile(ctx, file.GetID(), lock)
+	})
+}
+
+func (fbo *folderBranchOps) GetFileLockConflict(
+	ctx context.Context, file Node, lock FileLock) (
+	conflict FileLock, conflicted bool, err error) {
+	fbo.log.CDebugf(ctx, "GetFileLockConflict %s %s",
+		getNodeIDStr(file), lock)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetFileLockConflict %s %s done: "+
+			"%t %s %+v", getNodeIDStr(file), lock, conflicted, conflict, err)
+	}()
+
+	if err := checkFileLock(lock); err != nil {
+		return FileLock{}, false, err
+	}
+
+	// Don't let the goroutine below write directly to the return
+	// variables, since if the context is canceled the goroutine
+	// might outlast this function call, and end up in a read/write
+	// race with the caller.
+	var retConflict FileLock
+	var retConflicted bool
+	err = runUnlessCanceled(ctx, func() error {
+		key, err := fbo.fileLockHelper(ctx, file, false)
+		if err != nil {
+			return err
+		}
+		retConflict, retConflicted, err = fbo.fileLocks.getConflict(
+			ctx, file.GetID(), key, lock)
+		return err
+	})
+	if err != nil {
+		return FileLock{}, false, err
+	}
+	return retConflict, retConflicted, nil
+}
+
 func (fbo *folderBranchOps) setExLocked(
 	ctx context.Context, lState *lockState, file path,
 	ex bool) (err error) {
@@ -3347,7 +3725,7 @@ func (fbo *folderBranchOps) SetEx(
 			getNodeIDStr(file), ex, err)
 	}()
 
-	err = fbo.checkNode(file)
+	err = fbo.checkNodeForWrite(file)
 	if err != nil {
 		return
 	}
@@ -3425,7 +3803,7 @@ func (fbo *folderBranchOps) SetMtime(
 		return nil
 	}
 
-	err = fbo.checkNode(file)
+	err = fbo.checkNodeForWrite(file)
 	if err != nil {
 		return
 	}
@@ -3441,21 +3819,195 @@ func (fbo *folderBranchOps) SetMtime(
 		})
 }
 
-func (fbo *folderBranchOps) syncLocked(ctx context.Context,
-	lState *lockState, file path) (stillDirty bool, err error) {
-	fbo.mdWriterLock.AssertLocked(lState)
+// maxXattrValueBytes is the largest value an extended attribute can
+// hold.  It matches Linux's XATTR_SIZE_MAX; since extended attributes
+// live in directory blocks, they need to stay small.
+const maxXattrValueBytes = 64 * 1024
 
-	// if the cache for this file isn't dirty, we're done
-	if !fbo.blocks.IsDirty(lState, file) {
-		return false, nil
-	}
+// maxXattrsTotalBytes is the most that the names and values of all
+// the extended attributes of an entry can add up to.
+const maxXattrsTotalBytes = 2 * maxXattrValueBytes
 
-	// Verify we have permission to write.  We do this after the dirty
-	// check because otherwise readers who sync clean files on close
-	// would get an error.
-	md, err := fbo.getMDForWriteLocked(ctx, lState)
+// GetXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) GetXattr(
+	ctx context.Context, node Node, xattr string) (value []byte, err error) {
+	fbo.log.CDebugf(ctx, "GetXattr %s %s", getNodeIDStr(node), xattr)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetXattr %s %s done: %+v",
+			getNodeIDStr(node), xattr, err)
+	}()
+
+	var de DirEntry
+	err = runUnlessCanceled(ctx, func() error {
+		de, err = fbo.statEntry(ctx, node)
+		return err
+	})
 	if err != nil {
-		return true, err
+		return nil, err
+	}
+	value, ok := de.Xattrs[xattr]
+	if !ok {
+		return nil, NoSuchXattrError{node.GetBasename(), xattr}
+	}
+	return value, nil
+}
+
+// ListXattrs implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) ListXattrs(
+	ctx context.Context, node Node) (xattrs []string, err error) {
+	fbo.log.CDebugf(ctx, "ListXattrs %s", getNodeIDStr(node))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "ListXattrs %s done: %+v",
+			getNodeIDStr(node), err)
+	}()
+
+	var de DirEntry
+	err = runUnlessCanceled(ctx, func() error {
+		de, err = fbo.statEntry(ctx, node)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return de.xattrNames(), nil
+}
+
+// setXattrLocked sets the given extended attribute of the given file
+// to the given value, or removes it if value is nil.
+func (fbo *folderBranchOps) setXattrLocked(
+	ctx context.Context, lState *lockState, file path, xattr string,
+	value []byte) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return err
+	}
+
+	dblock, de, err := fbo.blocks.GetDirtyParentAndEntry(
+		ctx, lState, md.ReadOnly(), file)
+	if err != nil {
+		return err
+	}
+
+	oldValue, exists := de.Xattrs[xattr]
+	if value == nil && !exists {
+		return NoSuchXattrError{file.tailName(), xattr}
+	} else if value != nil && exists && bytes.Equal(oldValue, value) {
+		// As with setex, skip no-ops to keep xattr-preserving
+		// copies fast.
+		fbo.log.CDebugf(ctx, "Ignoring no-op setxattr")
+		return nil
+	}
+
+	de.setXattr(xattr, value)
+	if size := de.xattrsSize(); value != nil && size > maxXattrsTotalBytes {
+		return XattrsTooBigError{
+			file.tailName(), size, maxXattrsTotalBytes}
+	}
+	de.Ctime = fbo.nowUnixNano()
+
+	parentPath := file.parentPath()
+	sxo, err := newSetXattrOp(file.tailName(), parentPath.tailPointer(),
+		xattr, file.tailPointer())
+	if err != nil {
+		return err
+	}
+
+	// If the MD doesn't match the MD expected by the path, that
+	// implies we are using a cached path, which implies the node has
+	// been unlinked.  In that case, we can safely ignore this
+	// setxattr.
+	if md.data.Dir.BlockPointer.ID != file.path[0].BlockPointer.ID {
+		fbo.log.CDebugf(ctx, "Skipping setxattr for a removed file %v",
+			file.tailPointer())
+		fbo.blocks.UpdateCachedEntryAttributesOnRemovedFile(
+			ctx, lState, sxo, de)
+		return nil
+	}
+
+	sxo.setFinalPath(file)
+	md.AddOp(sxo)
+
+	dblock.Children[file.tailName()] = de
+	_, err = fbo.syncBlockAndFinalizeLocked(
+		ctx, lState, md, dblock, *parentPath.parentPath(), parentPath.tailName(),
+		Dir, false, false, zeroPtr, NoExcl)
+	return err
+}
+
+func (fbo *folderBranchOps) doSetXattr(
+	ctx context.Context, file Node, xattr string, value []byte) error {
+	err := fbo.checkNodeForWrite(file)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
+			if err != nil {
+				return err
+			}
+
+			return fbo.setXattrLocked(ctx, lState, filePath, xattr, value)
+		})
+}
+
+// SetXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) SetXattr(
+	ctx context.Context, file Node, xattr string, value []byte) (err error) {
+	fbo.log.CDebugf(ctx, "SetXattr %s %s (%d bytes)",
+		getNodeIDStr(file), xattr, len(value))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetXattr %s %s done: %+v",
+			getNodeIDStr(file), xattr, err)
+	}()
+
+	if uint32(len(xattr)) > fbo.config.MaxNameBytes() {
+		return NameTooLongError{xattr, fbo.config.MaxNameBytes()}
+	}
+	if len(value) > maxXattrValueBytes {
+		return XattrTooBigError{
+			xattr, uint64(len(value)), maxXattrValueBytes}
+	}
+
+	// Copy the value so the caller can't change it out from under
+	// us, and so that an empty value is never mistaken for a
+	// removal.
+	valueCopy := make([]byte, len(value))
+	copy(valueCopy, value)
+	return fbo.doSetXattr(ctx, file, xattr, valueCopy)
+}
+
+// RemoveXattr implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) RemoveXattr(
+	ctx context.Context, file Node, xattr string) (err error) {
+	fbo.log.CDebugf(ctx, "RemoveXattr %s %s", getNodeIDStr(file), xattr)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RemoveXattr %s %s done: %+v",
+			getNodeIDStr(file), xattr, err)
+	}()
+
+	return fbo.doSetXattr(ctx, file, xattr, nil)
+}
+
+func (fbo *folderBranchOps) syncLocked(ctx context.Context,
+	lState *lockState, file path) (stillDirty bool, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// if the cache for this file isn't dirty, we're done
+	if !fbo.blocks.IsDirty(lState, file) {
+		return false, nil
+	}
+
+	// Verify we have permission to write.  We do this after the dirty
+	// check because otherwise readers who sync clean files on close
+	// would get an error.
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return true, err
 	}
 
 	// If the MD doesn't match the MD expected by the path, that
@@ -3506,78 +4058,977 @@ func (fbo *folderBranchOps) syncLocked(ctx context.Context,
 		return true, err
 	}
 
-	newPath, _, newBps, err :=
-		fbo.syncBlockAndCheckEmbedLocked(
-			ctx, lState, md, fblock, *file.parentPath(),
-			file.tailName(), File, true, true, zeroPtr, lbc)
+	newPath, _, newBps, err :=
+		fbo.syncBlockAndCheckEmbedLocked(
+			ctx, lState, md, fblock, *file.parentPath(),
+			file.tailName(), File, true, true, zeroPtr, lbc)
+	if err != nil {
+		return true, err
+	}
+
+	bps.mergeOtherBps(newBps)
+
+	// Note: We explicitly don't call fbo.fbm.cleanUpBlockState here
+	// when there's an error, because it's possible some of the blocks
+	// will be reused in a future attempt at this same sync, and we
+	// don't want them cleaned up in that case.  Instead, the
+	// FinishSync call below will take care of that.
+
+	blocksToRemove, err = doBlockPuts(ctx, fbo.config.BlockServer(),
+		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, md.TlfID(),
+		md.GetTlfHandle().GetCanonicalName(), *bps)
+	if err != nil {
+		return true, err
+	}
+
+	// Call this under the same blockLock as when the pointers are
+	// updated, so there's never any point in time where a read or
+	// write might slip in after the pointers are updated, but before
+	// the deferred writes are re-applied.
+	afterUpdateFn := func() error {
+		stillDirty, err = fbo.blocks.FinishSyncLocked(
+			ctx, lState, file, newPath, md.ReadOnly(), syncState, fbo.fbm)
+		return err
+	}
+
+	err = fbo.finalizeMDWriteLocked(ctx, lState, md, bps, NoExcl, afterUpdateFn)
+	if err != nil {
+		return true, err
+	}
+	return stillDirty, err
+}
+
+func (fbo *folderBranchOps) Sync(ctx context.Context, file Node) (err error) {
+	fbo.log.CDebugf(ctx, "Sync %s", getNodeIDStr(file))
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "Sync %s done: %+v",
+			getNodeIDStr(file), err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return
+	}
+
+	var stillDirty bool
+	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
+			if err != nil {
+				return err
+			}
+
+			stillDirty, err = fbo.syncLocked(ctx, lState, filePath)
+			return err
+		})
+	if err != nil {
+		return err
+	}
+
+	if !stillDirty {
+		fbo.status.rmDirtyNode(file)
+	}
+
+	return nil
+}
+
+// revertState holds the in-progress changes made while reverting a
+// subtree to an older revision, or while copying a file.
+type revertState struct {
+	md          *RootMetadata
+	uid         keybase1.UID
+	bps         *blockPutState
+	dirtyBcache DirtyBlockCache
+	// reuseBlocks is true if new references should be added to the
+	// old file blocks, rather than uploading copies of them.
+	reuseBlocks bool
+	// ops describes the changes made by the revert.  They will
+	// follow a resolutionOp that holds all the block changes, like
+	// the ops produced by conflict resolution.
+	ops   []op
+	resOp *resolutionOp
+}
+
+// copyFileForRevertLocked makes new references to all the blocks of
+// the given file from an older revision, and returns the BlockInfo
+// of the new top block.  The caller is responsible for accounting
+// for the new top block in the MD.
+func (fbo *folderBranchOps) copyFileForRevertLocked(ctx context.Context,
+	lState *lockState, rs *revertState, file path, de DirEntry) (
+	BlockInfo, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	newPtr, _, err := fbo.blocks.DeepCopyFile(
+		ctx, lState, rs.md.ReadOnly(), file, rs.dirtyBcache,
+		fbo.config.DataVersion())
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	block, err := rs.dirtyBcache.Get(fbo.id(), newPtr, fbo.branch())
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	fblock, isFileBlock := block.(*FileBlock)
+	if !isFileBlock {
+		return BlockInfo{}, NotFileBlockError{newPtr, fbo.branch(), file}
+	}
+
+	// If journaling is enabled, new references aren't supported.  We
+	// have to fetch each block and ready it.  TODO: remove this when
+	// KBFS-1149 is fixed.
+	journalEnabled := TLFJournalEnabled(fbo.config, fbo.id())
+	if !fblock.IsInd && rs.reuseBlocks && !journalEnabled {
+		// A direct file only needs a new reference to its one block.
+		rs.bps.addNewBlock(newPtr, nil, ReadyBlockData{}, nil)
+		return BlockInfo{BlockPointer: newPtr, EncodedSize: de.EncodedSize},
+			nil
+	}
+
+	if fblock.IsInd {
+		var infos []BlockInfo
+		switch {
+		case !rs.reuseBlocks:
+			infos, err = fbo.blocks.ReadyChildrenInCopyAsNew(
+				ctx, lState, rs.md.ReadOnly(), file, rs.bps,
+				rs.dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, err
+			}
+		case journalEnabled:
+			infos, err = fbo.blocks.UndupChildrenInCopy(
+				ctx, lState, rs.md.ReadOnly(), file, rs.bps,
+				rs.dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, err
+			}
+		default:
+			// Ready any mid-level internal children.
+			_, err = fbo.blocks.ReadyNonLeafBlocksInCopy(
+				ctx, lState, rs.md.ReadOnly(), file, rs.bps,
+				rs.dirtyBcache, fblock)
+			if err != nil {
+				return BlockInfo{}, err
+			}
+
+			infos, err = fbo.blocks.GetIndirectFileBlockInfosWithTopBlock(
+				ctx, lState, rs.md.ReadOnly(), file, fblock)
+			if err != nil {
+				return BlockInfo{}, err
+			}
+
+			for _, info := range infos {
+				// The indirect blocks were already added to
+				// rs.bps, so only add the dedup'd leaf blocks.
+				if info.RefNonce != kbfsblock.ZeroRefNonce {
+					rs.bps.addNewBlock(
+						info.BlockPointer, nil, ReadyBlockData{}, nil)
+				}
+			}
+		}
+		for _, info := range infos {
+			rs.md.AddRefBlock(info)
+		}
+	}
+
+	bcache := fbo.config.BlockCache()
+	if !rs.reuseBlocks {
+		bcache = noDedupBlockCache{bcache}
+	}
+	info, _, readyBlockData, err := ReadyBlock(
+		ctx, bcache, fbo.config.BlockOps(), fbo.config.cryptoPure(),
+		rs.md.ReadOnly(), fblock, rs.uid, keybase1.BlockType_DATA)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	rs.bps.addNewBlock(info.BlockPointer, fblock, readyBlockData, nil)
+	return info, nil
+}
+
+// copyEntryForRevertLocked makes a copy of the given entry from an
+// older revision, along with everything under it, and returns the
+// new entry.  The caller is responsible for accounting for the new
+// top block of the entry in the MD.
+func (fbo *folderBranchOps) copyEntryForRevertLocked(ctx context.Context,
+	lState *lockState, rs *revertState, entryPath path, de DirEntry) (
+	DirEntry, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	switch de.Type {
+	case Sym:
+		return de, nil
+	case File, Exec:
+		info, err := fbo.copyFileForRevertLocked(
+			ctx, lState, rs, entryPath, de)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		de.BlockInfo = info
+		return de, nil
+	case Dir:
+		oldBlock, err := fbo.blocks.GetDirBlockForReading(
+			ctx, lState, rs.md.ReadOnly(), de.BlockPointer, fbo.branch(),
+			entryPath)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		newBlock := &DirBlock{
+			Children: make(map[string]DirEntry, len(oldBlock.Children)),
+		}
+		for name, childDe := range oldBlock.Children {
+			newChildDe, err := fbo.copyEntryForRevertLocked(
+				ctx, lState, rs, entryPath.ChildPath(name, childDe.BlockPointer),
+				childDe)
+			if err != nil {
+				return DirEntry{}, err
+			}
+			if newChildDe.Type != Sym {
+				rs.md.AddRefBlock(newChildDe.BlockInfo)
+			}
+			newBlock.Children[name] = newChildDe
+		}
+		info, plainSize, err := fbo.prepper.readyBlockMultiple(
+			ctx, rs.md.ReadOnly(), newBlock, rs.uid, rs.bps,
+			keybase1.BlockType_DATA)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		de.BlockInfo = info
+		de.Size = uint64(plainSize)
+		return de, nil
+	default:
+		return DirEntry{}, fmt.Errorf("unhandled entry type: %v", de.Type)
+	}
+}
+
+// unrefChildrenForRevertLocked unreferences all the blocks under the
+// top block of the given current entry.
+func (fbo *folderBranchOps) unrefChildrenForRevertLocked(
+	ctx context.Context, lState *lockState, rs *revertState,
+	entryPath path, de DirEntry) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	switch de.Type {
+	case File, Exec:
+		infos, err := fbo.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, rs.md.ReadOnly(), entryPath)
+		if isRecoverableBlockErrorForRemoval(err) {
+			msg := fmt.Sprintf("Recoverable block error encountered for unrefChildrenForRevertLocked(%v); continuing", entryPath)
+			fbo.log.CWarningf(ctx, "%s", msg)
+			fbo.log.CDebugf(ctx, "%s (err=%v)", msg, err)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			rs.md.AddUnrefBlock(info)
+		}
+	case Dir:
+		dblock, err := fbo.blocks.GetDirBlockForReading(
+			ctx, lState, rs.md.ReadOnly(), de.BlockPointer, fbo.branch(),
+			entryPath)
+		if isRecoverableBlockErrorForRemoval(err) {
+			msg := fmt.Sprintf("Recoverable block error encountered for unrefChildrenForRevertLocked(%v); continuing", entryPath)
+			fbo.log.CWarningf(ctx, "%s", msg)
+			fbo.log.CDebugf(ctx, "%s (err=%v)", msg, err)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for name, childDe := range dblock.Children {
+			rs.md.AddUnrefBlock(childDe.BlockInfo)
+			err := fbo.unrefChildrenForRevertLocked(ctx, lState, rs,
+				entryPath.ChildPath(name, childDe.BlockPointer), childDe)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// revertEntryLocked changes the entry for `name` in the given
+// (modifiable) block for `dir` to match `oldDe`, the entry for the
+// same name in `oldDir` as of an older revision.  If `oldExists` is
+// false, the entry is removed.  It returns true if anything changed.
+func (fbo *folderBranchOps) revertEntryLocked(ctx context.Context,
+	lState *lockState, rs *revertState, dir path, dblock *DirBlock,
+	oldDir path, name string, oldDe DirEntry, oldExists bool) (
+	changed bool, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	currDe, currExists := dblock.Children[name]
+	bothExist := oldExists && currExists
+	switch {
+	case !oldExists && !currExists:
+		return false, nil
+	case bothExist && oldDe.Type == Dir && currDe.Type == Dir:
+		if oldDe.BlockPointer == currDe.BlockPointer {
+			return false, nil
+		}
+		// Revert the directory in place, so that only the entries
+		// that differ are touched.
+		childPath := dir.ChildPath(name, currDe.BlockPointer)
+		childBlock, err := fbo.blocks.GetDir(
+			ctx, lState, rs.md.ReadOnly(), childPath, blockWrite)
+		if err != nil {
+			return false, err
+		}
+		oldChildPath := oldDir.ChildPath(name, oldDe.BlockPointer)
+		oldChildBlock, err := fbo.blocks.GetDirBlockForReading(
+			ctx, lState, rs.md.ReadOnly(), oldDe.BlockPointer, fbo.branch(),
+			oldChildPath)
+		if err != nil {
+			return false, err
+		}
+		changed, err := fbo.revertDirChildrenLocked(
+			ctx, lState, rs, childPath, childBlock, oldChildPath,
+			oldChildBlock)
+		if err != nil || !changed {
+			return false, err
+		}
+		info, plainSize, err := fbo.prepper.readyBlockMultiple(
+			ctx, rs.md.ReadOnly(), childBlock, rs.uid, rs.bps,
+			keybase1.BlockType_DATA)
+		if err != nil {
+			return false, err
+		}
+		rs.md.AddUpdate(currDe.BlockInfo, info)
+		newDe := oldDe
+		newDe.BlockInfo = info
+		newDe.Size = uint64(plainSize)
+		dblock.Children[name] = newDe
+		return true, nil
+	case bothExist && oldDe.Type == currDe.Type && oldDe.Type != Sym:
+		// Both are files of the same type, so just replace the
+		// contents of the current file.
+		if oldDe.BlockPointer == currDe.BlockPointer {
+			return false, nil
+		}
+		err := fbo.unrefChildrenForRevertLocked(ctx, lState, rs,
+			dir.ChildPath(name, currDe.BlockPointer), currDe)
+		if err != nil {
+			return false, err
+		}
+		newDe, err := fbo.copyEntryForRevertLocked(ctx, lState, rs,
+			oldDir.ChildPath(name, oldDe.BlockPointer), oldDe)
+		if err != nil {
+			return false, err
+		}
+		rs.md.AddUpdate(currDe.BlockInfo, newDe.BlockInfo)
+		dblock.Children[name] = newDe
+
+		so, err := newSyncOp(currDe.BlockPointer)
+		if err != nil {
+			return false, err
+		}
+		so.addTruncate(0)
+		if newDe.Size > 0 {
+			so.addWrite(0, newDe.Size)
+		}
+		rs.ops = append(rs.ops, so)
+		return true, nil
+	case bothExist && oldDe.Type == Sym && currDe.Type == Sym &&
+		oldDe.SymPath == currDe.SymPath:
+		return false, nil
+	}
+
+	// Otherwise, remove the current entry (if any) and re-create the
+	// old one (if any).
+	if currExists {
+		rs.md.AddUnrefBlock(currDe.BlockInfo)
+		err := fbo.unrefChildrenForRevertLocked(ctx, lState, rs,
+			dir.ChildPath(name, currDe.BlockPointer), currDe)
+		if err != nil {
+			return false, err
+		}
+		delete(dblock.Children, name)
+
+		ro, err := newRmOp(name, dir.tailPointer())
+		if err != nil {
+			return false, err
+		}
+		rs.ops = append(rs.ops, ro)
+	}
+	if oldExists {
+		newDe, err := fbo.copyEntryForRevertLocked(ctx, lState, rs,
+			oldDir.ChildPath(name, oldDe.BlockPointer), oldDe)
+		if err != nil {
+			return false, err
+		}
+		if newDe.Type != Sym {
+			rs.md.AddRefBlock(newDe.BlockInfo)
+		}
+		dblock.Children[name] = newDe
+
+		co, err := newCreateOp(name, dir.tailPointer(), newDe.Type)
+		if err != nil {
+			return false, err
+		}
+		rs.ops = append(rs.ops, co)
+	}
+	return true, nil
+}
+
+// revertDirChildrenLocked changes all the entries in the given
+// (modifiable) block for `dir` to match the entries in `oldDblock`,
+// the block for `oldDir` as of an older revision.  It returns true
+// if anything changed.
+func (fbo *folderBranchOps) revertDirChildrenLocked(ctx context.Context,
+	lState *lockState, rs *revertState, dir path, dblock *DirBlock,
+	oldDir path, oldDblock *DirBlock) (changed bool, err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	names := make([]string, 0, len(dblock.Children)+len(oldDblock.Children))
+	for name := range dblock.Children {
+		names = append(names, name)
+	}
+	for name := range oldDblock.Children {
+		if _, ok := dblock.Children[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	// Sort the names so the resulting ops are deterministic.
+	sort.Strings(names)
+
+	for _, name := range names {
+		oldDe, oldExists := oldDblock.Children[name]
+		entryChanged, err := fbo.revertEntryLocked(
+			ctx, lState, rs, dir, dblock, oldDir, name, oldDe, oldExists)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || entryChanged
+	}
+	return changed, nil
+}
+
+// getEntryAtRevisionLocked returns the entry for `p`, along with its
+// path, as of the given merged revision.
+func (fbo *folderBranchOps) getEntryAtRevisionLocked(ctx context.Context,
+	lState *lockState, md *RootMetadata, p path, rev MetadataRevision) (
+	DirEntry, path, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	head, _ := fbo.getHead(lState)
+	oldMD, err := getSingleMD(
+		ctx, fbo.config, fbo.id(), NullBranchID, rev, Merged)
+	if err != nil {
+		return DirEntry{}, path{}, err
+	}
+	oldMD, err = makeMDReadable(ctx, fbo.config, oldMD, head)
+	if err != nil {
+		return DirEntry{}, path{}, err
+	}
+
+	// The latest key generation can decrypt all the old blocks.
+	oldDe := oldMD.data.Dir
+	oldPath := path{
+		FolderBranch: fbo.folderBranch,
+		path:         []pathNode{{oldDe.BlockPointer, p.path[0].Name}},
+	}
+	for _, pn := range p.path[1:] {
+		if oldDe.Type != Dir {
+			return DirEntry{}, path{}, NoSuchNameError{pn.Name}
+		}
+		dblock, err := fbo.blocks.GetDirBlockForReading(
+			ctx, lState, md.ReadOnly(), oldDe.BlockPointer, fbo.branch(),
+			oldPath)
+		if err != nil {
+			return DirEntry{}, path{}, err
+		}
+		de, ok := dblock.Children[pn.Name]
+		if !ok {
+			fbo.log.CDebugf(ctx, "%s did not exist at revision %d", p, rev)
+			return DirEntry{}, path{}, NoSuchNameError{pn.Name}
+		}
+		oldDe = de
+		oldPath = oldPath.ChildPath(pn.Name, de.BlockPointer)
+	}
+	return oldDe, oldPath, nil
+}
+
+// getPathBeforeRevisionLocked returns a copy of `dir`, which must be
+// a path in the latest merged revision, pointing to the version of
+// the directory from just before the given merged revision.  The
+// names in the returned path are the current ones.
+func (fbo *folderBranchOps) getPathBeforeRevisionLocked(
+	ctx context.Context, lState *lockState, dir path,
+	rev MetadataRevision) (path, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if !fbo.isMasterBranchLocked(lState) {
+		return path{}, errors.New("can't look up old revisions while staged")
+	}
+
+	rmds, err := getMergedMDUpdates(ctx, fbo.config, fbo.id(), rev)
+	if err != nil {
+		return path{}, err
+	}
+	chains, err := newCRChainsForIRMDs(
+		ctx, fbo.config.Codec(), rmds, &fbo.blocks, false)
+	if err != nil {
+		return path{}, err
+	}
+	oldPtr, err := chains.originalFromMostRecentOrSame(dir.tailPointer())
+	if err != nil {
+		return path{}, err
+	}
+	if chains.isCreated(oldPtr) {
+		fbo.log.CDebugf(ctx, "%s was created at or after revision %d",
+			dir, rev)
+		return path{}, NoSuchNameError{dir.tailName()}
+	}
+
+	oldDir := dir
+	oldDir.path = make([]pathNode, len(dir.path))
+	copy(oldDir.path, dir.path)
+	oldDir.path[len(oldDir.path)-1].BlockPointer = oldPtr
+	return oldDir, nil
+}
+
+// startRevertLocked adds a leading resolutionOp to `md`, which will
+// hold all the block changes made by a revert, and returns a new
+// revertState for making those changes.
+func (fbo *folderBranchOps) startRevertLocked(ctx context.Context,
+	lState *lockState, md *RootMetadata, reuseBlocks bool) (
+	*revertState, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resOp := newResolutionOp()
+	md.AddOp(resOp)
+	return &revertState{
+		md:          md,
+		uid:         session.UID,
+		bps:         newBlockPutState(1),
+		dirtyBcache: simpleDirtyBlockCacheStandard(),
+		reuseBlocks: reuseBlocks,
+		resOp:       resOp,
+	}, nil
+}
+
+// finishRevertLocked syncs the given modified block for `dir`, and
+// puts all the blocks and the MD for the revert.
+func (fbo *folderBranchOps) finishRevertLocked(ctx context.Context,
+	lState *lockState, rs *revertState, dir path, dblock *DirBlock) (
+	err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	md := rs.md
+	_, _, syncBps, err := fbo.syncBlockLocked(
+		ctx, lState, rs.uid, md, dblock, *dir.parentPath(), dir.tailName(),
+		Dir, true, true, zeroPtr, nil)
+	if err != nil {
+		return err
+	}
+	rs.bps.mergeOtherBps(syncBps)
+
+	// Point all the ops at the new block pointers, and put the
+	// resolutionOp in front of them.
+	updates := make(map[BlockPointer]BlockPointer, len(rs.resOp.Updates))
+	for _, update := range rs.resOp.Updates {
+		updates[update.Unref] = update.Ref
+	}
+	newOps, err := fixOpPointersForUpdate(rs.ops, updates, nil)
+	if err != nil {
+		return err
+	}
+	newOps[0] = rs.resOp
+	md.data.Changes.Ops = newOps
+
+	// Do the block changes need their own blocks?
+	bsplit := fbo.config.BlockSplitter()
+	if !bsplit.ShouldEmbedBlockChanges(&md.data.Changes) {
+		err = fbo.prepper.unembedBlockChanges(
+			ctx, rs.bps, md, &md.data.Changes, rs.uid)
+		if err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		if err != nil {
+			fbo.fbm.cleanUpBlockState(
+				md.ReadOnly(), rs.bps, blockDeleteOnMDFail)
+		}
+	}()
+
+	_, err = doBlockPuts(ctx, fbo.config.BlockServer(),
+		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, md.TlfID(),
+		md.GetTlfHandle().GetCanonicalName(), *rs.bps)
+	if err != nil {
+		return err
+	}
+	return fbo.finalizeMDWriteLocked(ctx, lState, md, rs.bps, NoExcl, nil)
+}
+
+func (fbo *folderBranchOps) revertToRevisionLocked(ctx context.Context,
+	lState *lockState, node Node, rev MetadataRevision,
+	reuseBlocks bool) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if fbo.blocks.GetState(lState) != cleanState {
+		return NotPermittedWhileDirtyError{}
+	}
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return err
+	}
+
+	nodePath, err := fbo.pathFromNodeForMDWriteLocked(lState, node)
+	if err != nil {
+		return err
+	}
+
+	if rev < MetadataRevisionInitial ||
+		rev > fbo.getLatestMergedRevision(lState) {
+		return NoSuchMDError{fbo.id(), rev, NullBranchID}
+	}
+
+	// Find the entry for the node as of the old revision.
+	oldDe, oldPath, err := fbo.getEntryAtRevisionLocked(
+		ctx, lState, md, nodePath, rev)
+	if err != nil {
+		return err
+	}
+
+	rs, err := fbo.startRevertLocked(ctx, lState, md, reuseBlocks)
+	if err != nil {
+		return err
+	}
+
+	// Modify the block of the node's parent directory (or the root
+	// block, if the node is the root), and sync it.
+	var dir path
+	var dblock *DirBlock
+	var changed bool
+	if nodePath.hasValidParent() {
+		dir = *nodePath.parentPath()
+		dblock, err = fbo.blocks.GetDir(
+			ctx, lState, md.ReadOnly(), dir, blockWrite)
+		if err != nil {
+			return err
+		}
+		changed, err = fbo.revertEntryLocked(
+			ctx, lState, rs, dir, dblock, *oldPath.parentPath(),
+			nodePath.tailName(), oldDe, true)
+	} else {
+		dir = nodePath
+		dblock, err = fbo.blocks.GetDir(
+			ctx, lState, md.ReadOnly(), dir, blockWrite)
+		if err != nil {
+			return err
+		}
+		oldDblock, err := fbo.blocks.GetDirBlockForReading(
+			ctx, lState, md.ReadOnly(), oldDe.BlockPointer, fbo.branch(),
+			oldPath)
+		if err != nil {
+			return err
+		}
+		changed, err = fbo.revertDirChildrenLocked(
+			ctx, lState, rs, dir, dblock, oldPath, oldDblock)
+	}
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fbo.log.CDebugf(ctx, "%s is unchanged since revision %d",
+			nodePath, rev)
+		return nil
+	}
+
+	return fbo.finishRevertLocked(ctx, lState, rs, dir, dblock)
+}
+
+// RevertToRevision implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) RevertToRevision(
+	ctx context.Context, node Node, rev MetadataRevision) (err error) {
+	fbo.log.CDebugf(ctx, "RevertToRevision %s %d", getNodeIDStr(node), rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RevertToRevision %s %d done: %+v",
+			getNodeIDStr(node), rev, err)
+	}()
+
+	err = fbo.checkNodeForWrite(node)
+	if err != nil {
+		return err
+	}
+
+	reuseBlocks := true
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			err := fbo.revertToRevisionLocked(
+				ctx, lState, node, rev, reuseBlocks)
+			if isRecoverableBlockError(err) {
+				// Some of the old blocks can't be referenced anymore
+				// (e.g., they've been archived), so upload fresh
+				// copies of them when retrying.
+				fbo.log.CDebugf(ctx, "Couldn't reuse old blocks: %+v", err)
+				reuseBlocks = false
+			}
+			return err
+		})
+}
+
+func (fbo *folderBranchOps) undeleteLocked(ctx context.Context,
+	lState *lockState, dir Node, name string, rev MetadataRevision,
+	reuseBlocks bool) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if fbo.blocks.GetState(lState) != cleanState {
+		return NotPermittedWhileDirtyError{}
+	}
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return err
+	}
+
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+	if err != nil {
+		return err
+	}
+
+	if rev <= MetadataRevisionInitial ||
+		rev > fbo.getLatestMergedRevision(lState) {
+		return NoSuchMDError{fbo.id(), rev, NullBranchID}
+	}
+	if rev <= md.data.LastGCRevision {
+		return DeletedEntryReclaimedError{name, rev, md.data.LastGCRevision}
+	}
+
+	dblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), dirPath, blockWrite)
+	if err != nil {
+		return err
+	}
+	if _, ok := dblock.Children[name]; ok {
+		return NameExistsError{name}
+	}
+
+	// The entry is restored as it was just before the revision
+	// that removed it.  The directory may have been renamed since
+	// then, so find its old version by following its block pointer
+	// back through the intervening revisions, rather than by name.
+	oldDirPath, err := fbo.getPathBeforeRevisionLocked(
+		ctx, lState, dirPath, rev)
+	if err != nil {
+		return err
+	}
+	oldDblock, err := fbo.blocks.GetDirBlockForReading(
+		ctx, lState, md.ReadOnly(), oldDirPath.tailPointer(), fbo.branch(),
+		oldDirPath)
+	if err != nil {
+		return err
+	}
+	oldDe, ok := oldDblock.Children[name]
+	if !ok {
+		fbo.log.CDebugf(ctx, "%s did not exist before revision %d",
+			oldDirPath.ChildPathNoPtr(name), rev)
+		return NoSuchNameError{name}
+	}
+
+	rs, err := fbo.startRevertLocked(ctx, lState, md, reuseBlocks)
+	if err != nil {
+		return err
+	}
+	_, err = fbo.revertEntryLocked(ctx, lState, rs, dirPath, dblock,
+		oldDirPath, name, oldDe, true)
+	if err != nil {
+		return err
+	}
+	return fbo.finishRevertLocked(ctx, lState, rs, dirPath, dblock)
+}
+
+// Undelete implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) Undelete(ctx context.Context, dir Node,
+	name string, rev MetadataRevision) (err error) {
+	fbo.log.CDebugf(ctx, "Undelete %s %s %d", getNodeIDStr(dir), name, rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "Undelete %s %s %d done: %+v",
+			getNodeIDStr(dir), name, rev, err)
+	}()
+
+	err = fbo.checkNodeForWrite(dir)
+	if err != nil {
+		return err
+	}
+
+	reuseBlocks := true
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			err := fbo.undeleteLocked(
+				ctx, lState, dir, name, rev, reuseBlocks)
+			if isRecoverableBlockError(err) {
+				if !reuseBlocks {
+					// Even the old block contents can't be read,
+					// so quota reclamation must have deleted them
+					// already.
+					fbo.log.CDebugf(ctx, "Couldn't copy old blocks: %+v",
+						err)
+					return DeletedEntryReclaimedError{Name: name, Revision: rev}
+				}
+				fbo.log.CDebugf(ctx, "Couldn't reuse old blocks: %+v", err)
+				reuseBlocks = false
+			}
+			return err
+		})
+}
+
+func (fbo *folderBranchOps) copyFileLocked(ctx context.Context,
+	lState *lockState, src Node, dir Node, name string,
+	reuseBlocks bool) (Node, DirEntry, error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	if err := checkDisallowedPrefixes(name); err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	if uint32(len(name)) > fbo.config.MaxNameBytes() {
+		return nil, DirEntry{},
+			NameTooLongError{name, fbo.config.MaxNameBytes()}
+	}
+
+	// verify we have permission to write
+	md, err := fbo.getMDForWriteLocked(ctx, lState)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	srcPath, err := fbo.pathFromNodeForMDWriteLocked(lState, src)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	if md.data.Dir.BlockPointer.ID != srcPath.path[0].BlockPointer.ID {
+		// The source file has been removed.
+		return nil, DirEntry{}, NoSuchNameError{srcPath.tailName()}
+	}
+	if fbo.blocks.IsDirty(lState, srcPath) {
+		// Only synced data can be shared with the copy, and more
+		// writes came in after the caller synced the source.
+		return nil, DirEntry{}, NotPermittedWhileDirtyError{}
+	}
+	srcDe, err := fbo.blocks.GetDirtyEntry(
+		ctx, lState, md.ReadOnly(), srcPath)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	if srcDe.Type != File && srcDe.Type != Exec {
+		return nil, DirEntry{}, NotFileError{srcPath}
+	}
+
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+
+	dblock, err := fbo.blocks.GetDir(
+		ctx, lState, md.ReadOnly(), dirPath, blockWrite)
 	if err != nil {
-		return true, err
+		return nil, DirEntry{}, err
 	}
 
-	bps.mergeOtherBps(newBps)
+	// does name already exist?
+	if _, ok := dblock.Children[name]; ok {
+		return nil, DirEntry{}, NameExistsError{name}
+	}
 
-	// Note: We explicitly don't call fbo.fbm.cleanUpBlockState here
-	// when there's an error, because it's possible some of the blocks
-	// will be reused in a future attempt at this same sync, and we
-	// don't want them cleaned up in that case.  Instead, the
-	// FinishSync call below will take care of that.
+	if err := fbo.checkNewDirSize(
+		ctx, lState, md.ReadOnly(), dirPath, name); err != nil {
+		return nil, DirEntry{}, err
+	}
 
-	blocksToRemove, err = doBlockPuts(ctx, fbo.config.BlockServer(),
-		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, md.TlfID(),
-		md.GetTlfHandle().GetCanonicalName(), *bps)
+	// Copying a file is just like restoring an old version of it
+	// under a new name: the new entry gets new references to the
+	// same blocks.
+	rs, err := fbo.startRevertLocked(ctx, lState, md, reuseBlocks)
 	if err != nil {
-		return true, err
+		return nil, DirEntry{}, err
 	}
+	newDe, err := fbo.copyEntryForRevertLocked(
+		ctx, lState, rs, srcPath, srcDe)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	rs.md.AddRefBlock(newDe.BlockInfo)
+	now := fbo.nowUnixNano()
+	newDe.Mtime = now
+	newDe.Ctime = now
+	newDe.Xattrs = nil
+	newDe.LockID = ""
+	dblock.Children[name] = newDe
 
-	// Call this under the same blockLock as when the pointers are
-	// updated, so there's never any point in time where a read or
-	// write might slip in after the pointers are updated, but before
-	// the deferred writes are re-applied.
-	afterUpdateFn := func() error {
-		stillDirty, err = fbo.blocks.FinishSyncLocked(
-			ctx, lState, file, newPath, md.ReadOnly(), syncState, fbo.fbm)
-		return err
+	co, err := newCreateOp(name, dirPath.tailPointer(), newDe.Type)
+	if err != nil {
+		return nil, DirEntry{}, err
 	}
+	rs.ops = append(rs.ops, co)
 
-	err = fbo.finalizeMDWriteLocked(ctx, lState, md, bps, NoExcl, afterUpdateFn)
+	err = fbo.finishRevertLocked(ctx, lState, rs, dirPath, dblock)
 	if err != nil {
-		return true, err
+		return nil, DirEntry{}, err
 	}
-	return stillDirty, err
+	node, err := fbo.nodeCache.GetOrCreate(newDe.BlockPointer, name, dir)
+	if err != nil {
+		return nil, DirEntry{}, err
+	}
+	return node, newDe, nil
 }
 
-func (fbo *folderBranchOps) Sync(ctx context.Context, file Node) (err error) {
-	fbo.log.CDebugf(ctx, "Sync %s", getNodeIDStr(file))
+// CopyFile implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) CopyFile(ctx context.Context, src Node,
+	dir Node, name string) (n Node, ei EntryInfo, err error) {
+	fbo.log.CDebugf(ctx, "CopyFile %s %s %s",
+		getNodeIDStr(src), getNodeIDStr(dir), name)
 	defer func() {
-		fbo.deferLog.CDebugf(ctx, "Sync %s done: %+v",
-			getNodeIDStr(file), err)
+		fbo.deferLog.CDebugf(ctx, "CopyFile %s %s %s done: %v %+v",
+			getNodeIDStr(src), getNodeIDStr(dir), name,
+			getNodeIDStr(n), err)
 	}()
 
-	err = fbo.checkNode(file)
+	err = fbo.checkNodeForWrite(dir)
 	if err != nil {
-		return
+		return nil, EntryInfo{}, err
 	}
 
-	var stillDirty bool
+	// Only synced data can be shared with the copy.
+	err = fbo.Sync(ctx, src)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	var retNode Node
+	var retEntryInfo EntryInfo
+	reuseBlocks := true
 	err = fbo.doMDWriteWithRetryUnlessCanceled(ctx,
 		func(lState *lockState) error {
-			filePath, err := fbo.pathFromNodeForMDWriteLocked(lState, file)
-			if err != nil {
-				return err
+			// Don't set node and ei directly, as that can cause a
+			// race when the copy is canceled.
+			node, de, err := fbo.copyFileLocked(
+				ctx, lState, src, dir, name, reuseBlocks)
+			if isRecoverableBlockError(err) {
+				// Some of the source blocks can't be referenced
+				// anymore, so upload fresh copies of them when
+				// retrying.
+				fbo.log.CDebugf(ctx, "Couldn't reuse blocks: %+v", err)
+				reuseBlocks = false
 			}
-
-			stillDirty, err = fbo.syncLocked(ctx, lState, filePath)
+			retNode = node
+			retEntryInfo = de.EntryInfo
 			return err
 		})
 	if err != nil {
-		return err
-	}
-
-	if !stillDirty {
-		fbo.status.rmDirtyNode(file)
+		return nil, EntryInfo{}, err
 	}
-
-	return nil
+	return retNode, retEntryInfo, nil
 }
 
 func (fbo *folderBranchOps) FolderStatus(
@@ -3623,10 +5074,22 @@ func (fbo *folderBranchOps) notifyBatchLocked(
 	afterUpdateFn func() error) error {
 	fbo.headLock.AssertLocked(lState)
 
-	lastOp := md.data.Changes.Ops[len(md.data.Changes.Ops)-1]
-	err := fbo.notifyOneOpLocked(ctx, lState, lastOp, md, false, afterUpdateFn)
-	if err != nil {
-		return err
+	// Usually only the last op represents the local change.  But a
+	// batch of changes led by a resolutionOp (e.g., from a revert)
+	// needs a notification for each of its ops.
+	ops := md.data.Changes.Ops
+	if _, isResOp := ops[0].(*resolutionOp); !isResOp {
+		ops = ops[len(ops)-1:]
+	}
+	for i, op := range ops {
+		var fn func() error
+		if i == len(ops)-1 {
+			fn = afterUpdateFn
+		}
+		err := fbo.notifyOneOpLocked(ctx, lState, op, md, false, fn)
+		if err != nil {
+			return err
+		}
 	}
 	fbo.editHistory.UpdateHistory(ctx, []ImmutableRootMetadata{md})
 	return nil
@@ -3870,6 +5333,31 @@ func (fbo *folderBranchOps) notifyOneOpLocked(ctx context.Context,
 			return nil // Nothing to do.
 		}
 
+		changes = append(changes, NodeChange{
+			Node: childNode,
+		})
+	case *setXattrOp:
+		node := fbo.nodeCache.Get(realOp.Dir.Ref.Ref())
+		if node == nil {
+			return nil // Nothing to do.
+		}
+		fbo.log.CDebugf(ctx, "notifyOneOp: setXattr %s for file %s in node %s",
+			realOp.Xattr, realOp.Name, getNodeIDStr(node))
+
+		p, err := fbo.pathFromNodeForRead(node)
+		if err != nil {
+			return err
+		}
+
+		childNode, err := fbo.blocks.UpdateCachedEntryAttributes(
+			ctx, lState, md.ReadOnly(), p, realOp)
+		if err != nil {
+			return err
+		}
+		if childNode == nil {
+			return nil // Nothing to do.
+		}
+
 		changes = append(changes, NodeChange{
 			Node: childNode,
 		})
@@ -5460,6 +6948,362 @@ func (fbo *folderBranchOps) GetEditHistory(ctx context.Context,
 	return fbo.editHistory.GetComplete(ctx, head)
 }
 
+// getWriterNameCached returns the normalized name of the last writer
+// of the given MD, looking it up in (and adding it to) `writerNames`.
+func (fbo *folderBranchOps) getWriterNameCached(ctx context.Context,
+	writerNames map[keybase1.UID]string, rmd ImmutableRootMetadata) (
+	string, error) {
+	uid := rmd.LastModifyingWriter()
+	if writer, ok := writerNames[uid]; ok {
+		return writer, nil
+	}
+	name, err := fbo.config.KBPKI().GetNormalizedUsername(ctx, uid)
+	if err != nil {
+		return "", err
+	}
+	writerNames[uid] = string(name)
+	return string(name), nil
+}
+
+// searchForPathsInMD returns the paths of the given pointers as of
+// the given merged MD, which must have been written by one of the
+// ops in that MD.  Pointers that can't be found are left out of the
+// returned map.
+func (fbo *folderBranchOps) searchForPathsInMD(ctx context.Context,
+	rmd ImmutableRootMetadata, ptrs []BlockPointer) (
+	map[BlockPointer]path, error) {
+	newPtrs := make(map[BlockPointer]bool)
+	for _, op := range rmd.data.Changes.Ops {
+		for _, update := range op.allUpdates() {
+			newPtrs[update.Ref] = true
+		}
+		for _, ref := range op.Refs() {
+			newPtrs[ref] = true
+		}
+	}
+	// Use a throwaway node cache, so these old paths don't affect
+	// any of the real nodes.
+	paths, err := fbo.blocks.SearchForPaths(ctx,
+		newNodeCacheStandard(fbo.folderBranch), ptrs, newPtrs,
+		rmd.ReadOnly(), rmd.data.Dir.BlockPointer)
+	if err != nil {
+		return nil, err
+	}
+	for ptr, p := range paths {
+		if !p.isValid() {
+			delete(paths, ptr)
+		}
+	}
+	return paths, nil
+}
+
+// maxDeletedEntriesRevisions is the maximum number of recent merged
+// revisions that GetDeletedEntries searches for removed entries.
+const maxDeletedEntriesRevisions = 100
+
+// GetDeletedEntries implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetDeletedEntries(ctx context.Context,
+	folderBranch FolderBranch) (entries []DeletedEntry, err error) {
+	fbo.log.CDebugf(ctx, "GetDeletedEntries")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetDeletedEntries done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	head, err := fbo.getMDForReadHelper(ctx, lState, mdReadNeedIdentify)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything removed at or before the last GC'd revision has
+	// already had its blocks reclaimed.
+	startRev := head.data.LastGCRevision + 1
+	latestRev := fbo.getLatestMergedRevision(lState)
+	if latestRev-maxDeletedEntriesRevisions > startRev {
+		startRev = latestRev - maxDeletedEntriesRevisions
+	}
+	rmds, err := getMergedMDUpdates(ctx, fbo.config, fbo.id(), startRev)
+	if err != nil {
+		return nil, err
+	}
+
+	writerNames := make(map[keybase1.UID]string)
+	// List the most recent removals first.
+	for i := len(rmds) - 1; i >= 0; i-- {
+		rmd := rmds[i]
+		var rmOps []*rmOp
+		var ptrs []BlockPointer
+		for _, op := range rmd.data.Changes.Ops {
+			if ro, ok := op.(*rmOp); ok {
+				rmOps = append(rmOps, ro)
+				ptrs = append(ptrs, ro.Dir.Ref)
+			}
+		}
+		if len(rmOps) == 0 {
+			continue
+		}
+
+		paths, err := fbo.searchForPathsInMD(ctx, rmd, ptrs)
+		if err != nil {
+			return nil, err
+		}
+		writer, err := fbo.getWriterNameCached(ctx, writerNames, rmd)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(rmOps) - 1; j >= 0; j-- {
+			ro := rmOps[j]
+			dirPath, ok := paths[ro.Dir.Ref]
+			if !ok {
+				fbo.log.CDebugf(ctx, "Couldn't find the directory of "+
+					"removed entry %s at revision %d", ro.OldName,
+					rmd.Revision())
+				continue
+			}
+			entry := DeletedEntry{
+				Path:     dirPath.ChildPathNoPtr(ro.OldName).pathWithinFolder(),
+				Revision: rmd.Revision(),
+				Date:     time.Unix(0, rmd.data.Dir.Mtime),
+				Writer:   writer,
+			}
+
+			// Fill in the details of the entry from the directory
+			// as it was before the removal, if it's still readable.
+			dblock, err := fbo.blocks.GetDirBlockForReading(ctx, lState,
+				rmd.ReadOnly(), ro.Dir.Unref, fbo.branch(), dirPath)
+			if err != nil {
+				fbo.log.CDebugf(ctx, "Couldn't read the old directory "+
+					"of %s: %+v", entry.Path, err)
+			} else if de, ok := dblock.Children[ro.OldName]; ok {
+				entry.Type = de.Type.String()
+				entry.Size = de.Size
+				entry.Mtime = time.Unix(0, de.Mtime)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// getPathChangesForMD returns the path-level changes made by the ops
+// in the given merged MD.
+func (fbo *folderBranchOps) getPathChangesForMD(ctx context.Context,
+	rmd ImmutableRootMetadata, writer string) ([]PathChange, error) {
+	var ptrs []BlockPointer
+	for _, op := range rmd.data.Changes.Ops {
+		switch realOp := op.(type) {
+		case *createOp:
+			ptrs = append(ptrs, realOp.Dir.Ref)
+		case *rmOp:
+			ptrs = append(ptrs, realOp.Dir.Ref)
+		case *renameOp:
+			ptrs = append(ptrs, realOp.OldDir.Ref)
+			if realOp.NewDir != (blockUpdate{}) {
+				ptrs = append(ptrs, realOp.NewDir.Ref)
+			}
+		case *syncOp:
+			ptrs = append(ptrs, realOp.File.Ref)
+		case *setAttrOp:
+			ptrs = append(ptrs, realOp.Dir.Ref)
+		case *setXattrOp:
+			ptrs = append(ptrs, realOp.Dir.Ref)
+		}
+	}
+	if len(ptrs) == 0 {
+		return nil, nil
+	}
+
+	paths, err := fbo.searchForPathsInMD(ctx, rmd, ptrs)
+	if err != nil {
+		return nil, err
+	}
+	// getPath returns the TLF-relative path for `name` in the
+	// directory (or for the file itself, if `name` is empty) with
+	// the given pointer.
+	getPath := func(ptr BlockPointer, name string) (string, bool) {
+		p, ok := paths[ptr]
+		if !ok {
+			fbo.log.CDebugf(ctx, "Couldn't find the path of %v at "+
+				"revision %d", ptr, rmd.Revision())
+			return "", false
+		}
+		if name != "" {
+			p = p.ChildPathNoPtr(name)
+		}
+		return p.pathWithinFolder(), true
+	}
+
+	changes := make([]PathChange, 0, len(rmd.data.Changes.Ops))
+	for _, op := range rmd.data.Changes.Ops {
+		change := PathChange{
+			Revision: rmd.Revision(),
+			Date:     time.Unix(0, rmd.data.Dir.Mtime),
+			Writer:   writer,
+		}
+		var ok bool
+		switch realOp := op.(type) {
+		case *createOp:
+			change.Type = PathAdded
+			change.EntryType = realOp.Type.String()
+			change.Path, ok = getPath(realOp.Dir.Ref, realOp.NewName)
+		case *rmOp:
+			change.Type = PathRemoved
+			change.Path, ok = getPath(realOp.Dir.Ref, realOp.OldName)
+		case *renameOp:
+			change.Type = PathRenamed
+			change.EntryType = realOp.RenamedType.String()
+			change.OldPath, ok = getPath(realOp.OldDir.Ref, realOp.OldName)
+			if !ok {
+				break
+			}
+			newDirPtr := realOp.OldDir.Ref
+			if realOp.NewDir != (blockUpdate{}) {
+				newDirPtr = realOp.NewDir.Ref
+			}
+			change.Path, ok = getPath(newDirPtr, realOp.NewName)
+		case *syncOp:
+			change.Type = PathModified
+			change.Writes = realOp.Writes
+			change.Path, ok = getPath(realOp.File.Ref, "")
+		case *setAttrOp:
+			change.Type = PathAttrChanged
+			change.Attr = realOp.Attr.String()
+			change.Path, ok = getPath(realOp.Dir.Ref, realOp.Name)
+		case *setXattrOp:
+			change.Type = PathAttrChanged
+			change.Attr = "xattr:" + realOp.Xattr
+			change.Path, ok = getPath(realOp.Dir.Ref, realOp.Name)
+		default:
+			// Other ops (e.g., rekeys and GC) don't change any paths.
+			continue
+		}
+		if !ok {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// DiffRevisions implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) DiffRevisions(ctx context.Context,
+	folderBranch FolderBranch, fromRev, toRev MetadataRevision) (
+	changes []PathChange, err error) {
+	fbo.log.CDebugf(ctx, "DiffRevisions %d %d", fromRev, toRev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "DiffRevisions %d %d done: %+v",
+			fromRev, toRev, err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	_, err = fbo.getMDForReadHelper(ctx, lState, mdReadNeedIdentify)
+	if err != nil {
+		return nil, err
+	}
+
+	if fromRev > toRev {
+		return nil, InvalidRevisionRangeError{fromRev, toRev}
+	}
+	latestRev := fbo.getLatestMergedRevision(lState)
+	if fromRev < MetadataRevisionInitial || fromRev > latestRev {
+		return nil, NoSuchMDError{fbo.id(), fromRev, NullBranchID}
+	}
+	if toRev > latestRev {
+		return nil, NoSuchMDError{fbo.id(), toRev, NullBranchID}
+	}
+	if fromRev == toRev {
+		return nil, nil
+	}
+
+	rmds, err := getMergedMDUpdatesWithEnd(
+		ctx, fbo.config, fbo.id(), fromRev+1, toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	writerNames := make(map[keybase1.UID]string)
+	for _, rmd := range rmds {
+		writer, err := fbo.getWriterNameCached(ctx, writerNames, rmd)
+		if err != nil {
+			return nil, err
+		}
+		mdChanges, err := fbo.getPathChangesForMD(ctx, rmd, writer)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, mdChanges...)
+	}
+	return changes, nil
+}
+
+// loadSyncedFolder fetches the head of the folder, if it hasn't been
+// fetched yet, which starts syncing any subtrees kept synced.
+func (fbo *folderBranchOps) loadSyncedFolder(ctx context.Context) error {
+	lState := makeFBOLockState()
+	fbo.mdWriterLock.Lock(lState)
+	defer fbo.mdWriterLock.Unlock(lState)
+	_, err := fbo.getMDForWriteOrRekeyLocked(ctx, lState, mdWrite)
+	return err
+}
+
+// SetKeepSynced implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) SetKeepSynced(
+	ctx context.Context, node Node, keepSynced bool) (err error) {
+	fbo.log.CDebugf(ctx, "SetKeepSynced %s %t", getNodeIDStr(node),
+		keepSynced)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "SetKeepSynced %s %t done: %+v",
+			getNodeIDStr(node), keepSynced, err)
+	}()
+
+	if fbo.isArchived() {
+		return errors.New("Archived folders can't be kept synced")
+	}
+
+	return runUnlessCanceled(ctx, func() error {
+		err := fbo.checkNode(node)
+		if err != nil {
+			return err
+		}
+		nodePath, err := fbo.pathFromNodeForRead(node)
+		if err != nil {
+			return err
+		}
+		lState := makeFBOLockState()
+		// verify we have permission to read
+		_, err = fbo.getMDForReadNeedIdentify(ctx, lState)
+		if err != nil {
+			return err
+		}
+		return fbo.syncer.setPath(
+			ctx, nodePath.pathWithinFolder(), keepSynced)
+	})
+}
+
+// GetSyncStatus implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) GetSyncStatus(ctx context.Context,
+	folderBranch FolderBranch) (status FolderSyncStatus, err error) {
+	fbo.log.CDebugf(ctx, "GetSyncStatus")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetSyncStatus done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return FolderSyncStatus{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	return fbo.syncer.getStatus(ctx)
+}
+
 // PushStatusChange forces a new status be fetched by status listeners.
 func (fbo *folderBranchOps) PushStatusChange() {
 	fbo.config.KBFSOps().PushStatusChange()
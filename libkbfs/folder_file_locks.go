@@ -0,0 +1,382 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// fileLockLeaseTTL is how long a file lock lease lasts on the MD
+	// server without being renewed.  If this device goes offline
+	// while holding a lock, other devices can take it after this
+	// long.
+	fileLockLeaseTTL = 1 * time.Minute
+	// fileLockLeaseRenewPeriod is how often held leases are renewed.
+	fileLockLeaseRenewPeriod = fileLockLeaseTTL / 3
+)
+
+// CtxFileLockTagKey is the type used for unique context tags within
+// folderFileLocks.
+type CtxFileLockTagKey int
+
+const (
+	// CtxFileLockIDKey is the type of the tag for unique operation
+	// IDs within folderFileLocks.
+	CtxFileLockIDKey CtxFileLockTagKey = iota
+)
+
+// CtxFileLockOpID is the display name for the unique operation
+// folderFileLocks ID tag.
+const CtxFileLockOpID = "FLID"
+
+// fileLockKey returns the key identifying the given file to the MD
+// server for lock leases.  Files with a lock ID are keyed by it, so
+// that leases survive renames.  Files without one, e.g. in folders
+// the user can't write to, fall back to their path within the
+// folder.
+func fileLockKey(p path, lockID string) string {
+	if lockID != "" {
+		return "lockid:" + lockID
+	}
+	return "path:" + p.pathWithinFolder()
+}
+
+type folderFileLockState struct {
+	// key identifies the file to the MD server, as of the time it
+	// was first locked.
+	key   string
+	table fileLockTable
+	// lost holds, for each owner, the first of its locks that was
+	// dropped because its lease couldn't be renewed.  It's returned
+	// from the owner's next lock or unlock call.
+	lost map[fileLockOwner]FileLockLostError
+}
+
+// fileLockLease is a lock whose lease is being renewed.
+type fileLockLease struct {
+	nodeID NodeID
+	key    string
+	lock   FileLock
+}
+
+// folderFileLocks keeps track of the advisory file locks held by
+// owners on this device within a single folder.  When the MD server
+// supports it, each lock is backed by a lease on the server so that
+// other devices see it too; the leases are renewed in the
+// background for as long as the locks are held.  If a lease can't
+// be renewed, another device may take the lock, so it's dropped
+// here too.  The MD server is never called while holding the lock.
+type folderFileLocks struct {
+	config     Config
+	id         tlf.ID
+	log        logger.Logger
+	reportLost func(context.Context, error)
+
+	// Protects everything below.
+	lock  sync.Mutex
+	files map[NodeID]*folderFileLockState
+	// Set once the MD server reports that it doesn't support leases,
+	// after which locks are only enforced on this device.
+	leasesUnsupported bool
+	// Cancels the lease renewal goroutine, if it's running.
+	renewCancel context.CancelFunc
+	isShutdown  bool
+}
+
+func newFolderFileLocks(config Config, id tlf.ID, log logger.Logger,
+	reportLost func(context.Context, error)) *folderFileLocks {
+	return &folderFileLocks{
+		config:     config,
+		id:         id,
+		log:        log,
+		reportLost: reportLost,
+		files:      make(map[NodeID]*folderFileLockState),
+	}
+}
+
+// checkLeaseErr filters an error returned by the MD server for a
+// lease operation, turning off leases (and returning nil) if the
+// server doesn't support them.
+func (ffl *folderFileLocks) checkLeaseErr(
+	ctx context.Context, err error) error {
+	if _, ok := err.(FileLeasesUnsupportedError); ok {
+		ffl.log.CDebugf(ctx, "File lock leases unsupported by the MD "+
+			"server; locking only on this device")
+		ffl.lock.Lock()
+		defer ffl.lock.Unlock()
+		ffl.leasesUnsupported = true
+		return nil
+	}
+	return err
+}
+
+// takeLostLocked returns, and forgets, the error for any of owner's
+// locks on the given file that were lost.
+func (ffl *folderFileLocks) takeLostLocked(
+	state *folderFileLockState, owner fileLockOwner) error {
+	err, ok := state.lost[owner]
+	if !ok {
+		return nil
+	}
+	delete(state.lost, owner)
+	return err
+}
+
+func (ffl *folderFileLocks) deleteIfUnusedLocked(
+	nodeID NodeID, state *folderFileLockState) {
+	if state.table.isEmpty() && len(state.lost) == 0 {
+		delete(ffl.files, nodeID)
+	}
+}
+
+// releaseLease releases a lease that was just taken for lock, but
+// is no longer wanted.  Failures are only logged, since the lease
+// expires on its own.
+func (ffl *folderFileLocks) releaseLease(
+	ctx context.Context, key string, lock FileLock) {
+	err := ffl.config.MDServer().UnlockFile(ctx, ffl.id, key, lock)
+	if err = ffl.checkLeaseErr(ctx, err); err != nil {
+		ffl.log.CDebugf(ctx, "Couldn't release lease for %s on %s: %+v",
+			lock, key, err)
+	}
+}
+
+func (ffl *folderFileLocks) lockFile(ctx context.Context, nodeID NodeID,
+	key string, lock FileLock) error {
+	owner := makeFileLockOwner(kbfscrypto.CryptPublicKey{}, lock)
+	for {
+		ffl.lock.Lock()
+		if state, ok := ffl.files[nodeID]; ok {
+			if err := ffl.takeLostLocked(state, owner); err != nil {
+				ffl.deleteIfUnusedLocked(nodeID, state)
+				ffl.lock.Unlock()
+				return err
+			}
+			if conflict, ok := state.table.conflict(owner, lock); ok {
+				ffl.lock.Unlock()
+				return FileLockConflictError{conflict}
+			}
+			key = state.key
+		}
+		useLeases := !ffl.leasesUnsupported
+		ffl.lock.Unlock()
+
+		if useLeases {
+			err := ffl.config.MDServer().LockFile(
+				ctx, ffl.id, key, lock, fileLockLeaseTTL)
+			if err = ffl.checkLeaseErr(ctx, err); err != nil {
+				return err
+			}
+		}
+
+		ffl.lock.Lock()
+		state, ok := ffl.files[nodeID]
+		if !ok {
+			state = &folderFileLockState{key: key}
+		} else if state.key != key {
+			// Someone else started tracking this file under a
+			// different key while we were talking to the server;
+			// start over with their key.
+			ffl.lock.Unlock()
+			if useLeases {
+				ffl.releaseLease(ctx, key, lock)
+			}
+			continue
+		} else if conflict, ok := state.table.conflict(owner, lock); ok {
+			ffl.lock.Unlock()
+			if useLeases {
+				ffl.releaseLease(ctx, key, lock)
+			}
+			return FileLockConflictError{conflict}
+		}
+
+		state.table.set(owner, lock, time.Time{})
+		ffl.files[nodeID] = state
+		if !ffl.leasesUnsupported && ffl.renewCancel == nil &&
+			!ffl.isShutdown {
+			renewCtx, cancel := context.WithCancel(
+				ctxWithRandomIDReplayable(context.Background(),
+					CtxFileLockIDKey, CtxFileLockOpID, ffl.log))
+			ffl.renewCancel = cancel
+			go ffl.renewLoop(renewCtx)
+		}
+		ffl.lock.Unlock()
+		return nil
+	}
+}
+
+func (ffl *folderFileLocks) unlockFile(ctx context.Context, nodeID NodeID,
+	lock FileLock) error {
+	owner := makeFileLockOwner(kbfscrypto.CryptPublicKey{}, lock)
+	ffl.lock.Lock()
+	state, ok := ffl.files[nodeID]
+	if !ok {
+		// Nothing is locked.
+		ffl.lock.Unlock()
+		return nil
+	}
+	lostErr := ffl.takeLostLocked(state, owner)
+	// Forget the lock locally before releasing the lease, so
+	// renewals stop; if the release fails, the lease just expires.
+	state.table.remove(owner, lock)
+	ffl.deleteIfUnusedLocked(nodeID, state)
+	key := state.key
+	useLeases := !ffl.leasesUnsupported
+	ffl.lock.Unlock()
+
+	if useLeases {
+		err := ffl.config.MDServer().UnlockFile(ctx, ffl.id, key, lock)
+		if err = ffl.checkLeaseErr(ctx, err); err != nil {
+			return err
+		}
+	}
+	return lostErr
+}
+
+func (ffl *folderFileLocks) getConflict(ctx context.Context, nodeID NodeID,
+	key string, lock FileLock) (FileLock, bool, error) {
+	ffl.lock.Lock()
+	if state, ok := ffl.files[nodeID]; ok {
+		conflict, ok := state.table.conflict(
+			makeFileLockOwner(kbfscrypto.CryptPublicKey{}, lock), lock)
+		if ok {
+			ffl.lock.Unlock()
+			return conflict, true, nil
+		}
+		key = state.key
+	}
+	useLeases := !ffl.leasesUnsupported
+	ffl.lock.Unlock()
+
+	if !useLeases {
+		return FileLock{}, false, nil
+	}
+	conflict, ok, err := ffl.config.MDServer().GetFileLockConflict(
+		ctx, ffl.id, key, lock)
+	if err = ffl.checkLeaseErr(ctx, err); err != nil {
+		return FileLock{}, false, err
+	}
+	return conflict, ok, nil
+}
+
+// getLeasesToRenew returns all the locks held on this device, or
+// nil if there are no more leases to renew.
+func (ffl *folderFileLocks) getLeasesToRenew() (leases []fileLockLease) {
+	ffl.lock.Lock()
+	defer ffl.lock.Unlock()
+	if ffl.isShutdown {
+		return nil
+	}
+	if !ffl.leasesUnsupported {
+		for nodeID, state := range ffl.files {
+			for _, lock := range state.table.locks(
+				kbfscrypto.CryptPublicKey{}) {
+				leases = append(leases,
+					fileLockLease{nodeID, state.key, lock})
+			}
+		}
+	}
+	if len(leases) == 0 && ffl.renewCancel != nil {
+		ffl.renewCancel()
+		ffl.renewCancel = nil
+	}
+	return leases
+}
+
+// dropLostLeases forgets the locks whose leases couldn't be renewed,
+// and returns the errors to report for them.
+func (ffl *folderFileLocks) dropLostLeases(ctx context.Context,
+	failed []fileLockLease, errs []error) (lostErrs []error) {
+	ffl.lock.Lock()
+	defer ffl.lock.Unlock()
+	for i, lease := range failed {
+		state, ok := ffl.files[lease.nodeID]
+		if !ok || state.key != lease.key {
+			continue
+		}
+		owner := makeFileLockOwner(kbfscrypto.CryptPublicKey{}, lease.lock)
+		if !state.table.holds(owner, lease.lock) {
+			// The owner changed the lock in the meantime.
+			continue
+		}
+		ffl.log.CWarningf(ctx, "Dropping lock %s on %s after failing "+
+			"to renew its lease: %+v", lease.lock, lease.key, errs[i])
+		state.table.remove(owner, lease.lock)
+		lostErr := FileLockLostError{lease.lock, errs[i]}
+		if _, ok := state.lost[owner]; !ok {
+			if state.lost == nil {
+				state.lost = make(map[fileLockOwner]FileLockLostError)
+			}
+			state.lost[owner] = lostErr
+		}
+		lostErrs = append(lostErrs, lostErr)
+	}
+	return lostErrs
+}
+
+// renewLeases renews all the leases for locks held on this device,
+// and returns false if there are no more leases to renew.  Locks
+// whose leases can't be renewed are dropped, since another device
+// may take them once the leases expire.
+func (ffl *folderFileLocks) renewLeases(ctx context.Context) bool {
+	leases := ffl.getLeasesToRenew()
+	if len(leases) == 0 {
+		return false
+	}
+
+	var failed []fileLockLease
+	var errs []error
+	for _, lease := range leases {
+		err := ffl.config.MDServer().LockFile(
+			ctx, ffl.id, lease.key, lease.lock, fileLockLeaseTTL)
+		if err = ffl.checkLeaseErr(ctx, err); err != nil {
+			failed = append(failed, lease)
+			errs = append(errs, err)
+		}
+	}
+	if ctx.Err() != nil {
+		// Shut down while renewing.
+		return false
+	}
+
+	for _, err := range ffl.dropLostLeases(ctx, failed, errs) {
+		ffl.reportLost(ctx, err)
+	}
+	return true
+}
+
+func (ffl *folderFileLocks) renewLoop(ctx context.Context) {
+	ticker := time.NewTicker(fileLockLeaseRenewPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !ffl.renewLeases(ctx) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// shutdown stops renewing leases.  Any leases still held on the MD
+// server expire on their own.
+func (ffl *folderFileLocks) shutdown() {
+	ffl.lock.Lock()
+	defer ffl.lock.Unlock()
+	ffl.isShutdown = true
+	if ffl.renewCancel != nil {
+		ffl.renewCancel()
+		ffl.renewCancel = nil
+	}
+}
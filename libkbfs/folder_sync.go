@@ -0,0 +1,741 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// syncedFolderPrefetchPriority is the priority of the requests that
+// fetch the blocks of folders that are kept synced.  It's lower than
+// any other prefetch, since keeping folders synced is background
+// work that nobody is waiting on.
+const syncedFolderPrefetchPriority int = defaultPrefetchPriority * 2
+
+// CtxFolderSyncTagKey is the type used for unique context tags within
+// folderSyncer.
+type CtxFolderSyncTagKey int
+
+const (
+	// CtxFolderSyncIDKey is the type of the tag for unique operation
+	// IDs within folderSyncer.
+	CtxFolderSyncIDKey CtxFolderSyncTagKey = iota
+)
+
+// CtxFolderSyncOpID is the display name for the unique operation
+// folderSyncer ID tag.
+const CtxFolderSyncOpID = "FSID"
+
+// FolderSyncStatus describes which parts of a folder are kept synced
+// in the disk block cache, and how far along the syncing is.
+type FolderSyncStatus struct {
+	// Paths are the subtrees kept synced, relative to the root of
+	// the folder; the empty path is the whole folder.  No paths
+	// means the folder isn't kept synced.
+	Paths []string
+	// SyncedRevision is the latest revision for which every block
+	// under Paths was pinned in the disk block cache, along with
+	// the number and total size of those blocks.
+	SyncedRevision MetadataRevision
+	SyncedBlocks   int
+	SyncedBytes    uint64
+	// SyncingRevision is the revision being synced right now, if
+	// any, along with the number and total size of its blocks that
+	// have been pinned so far.  Comparing them with the Synced*
+	// fields gives a rough measure of progress.
+	SyncingRevision MetadataRevision `json:",omitempty"`
+	SyncingBlocks   int              `json:",omitempty"`
+	SyncingBytes    uint64           `json:",omitempty"`
+	// LastError is the error that stopped the last sync, if any.
+	LastError string `json:",omitempty"`
+}
+
+// folderSyncConfig is the part of a folder's sync state that's saved
+// to disk, so that it persists across restarts.
+type folderSyncConfig struct {
+	// TlfID identifies the folder, since the name of the file may
+	// be obfuscated.
+	TlfID tlf.ID
+	Paths []string
+}
+
+// syncedBlock records a block pinned by a sync, and the blocks it
+// points to.
+type syncedBlock struct {
+	size     uint32
+	children []kbfsblock.ID
+}
+
+// folderSyncer keeps the blocks of selected subtrees of a folder
+// pinned in the disk block cache, so that they can be read even
+// while offline.  Every time the folder gets a new head, it fetches
+// any blocks of the subtrees that aren't in the cache yet, and
+// unpins the blocks that aren't part of the subtrees anymore.  Block
+// IDs are derived from block contents, so a subtree whose root block
+// was pinned by the previous sync is unchanged, and is kept without
+// walking it again.
+type folderSyncer struct {
+	config Config
+	id     tlf.ID
+	log    logger.Logger
+
+	// Protects everything below.
+	lock sync.Mutex
+	// Whether the saved config has been read yet.
+	loaded bool
+	paths  map[string]bool
+	status FolderSyncStatus
+	// The latest merged head, to sync when the paths change.
+	head ImmutableRootMetadata
+	// Incremented at the start of each sync, so that a sync that's
+	// been superseded doesn't update the status.
+	gen    int
+	cancel context.CancelFunc
+	// Closed when the latest sync (or unpinning) is done.  Each one
+	// waits for the one before it, so that only one at a time
+	// changes which blocks are pinned.
+	doneCh     chan struct{}
+	isShutdown bool
+	// The blocks pinned by the last successful sync.
+	syncedBlocks map[kbfsblock.ID]syncedBlock
+	// Encrypts the saved config, if local storage is encrypted.
+	// Only valid once loaded is true.
+	crypter *localStorageCrypter
+}
+
+// syncedFolderLoader describes a caller that can load a folder by
+// its ID, so that its synced subtrees start syncing.
+type syncedFolderLoader interface {
+	loadSyncedFolder(ctx context.Context, tlfID tlf.ID) error
+}
+
+// syncedFoldersDir returns the directory where the configs of folders
+// kept synced are saved.
+func syncedFoldersDir(storageRoot string) string {
+	return filepath.Join(
+		diskBlockCacheRootFromStorageRoot(storageRoot), "sync")
+}
+
+// syncedFolderConfigPath returns where the config for the given
+// folder is saved, with the name obfuscated if crypter is non-nil.
+func syncedFolderConfigPath(crypter *localStorageCrypter,
+	storageRoot string, tlfID tlf.ID) string {
+	name := crypter.obfuscateName(tlfID.String(), 32)
+	return filepath.Join(syncedFoldersDir(storageRoot), name+".json")
+}
+
+// startSyncedFolders loads every folder with a saved config that
+// keeps part of it synced, so that syncing resumes without waiting
+// for the folder to be accessed.  Errors for individual folders are
+// logged and skipped, as is everything if config.KBFSOps() can't load
+// folders by ID.
+func startSyncedFolders(ctx context.Context, config Config) error {
+	storageRoot := config.StorageRoot()
+	if storageRoot == "" {
+		return nil
+	}
+	log := config.MakeLogger("")
+	loader, ok := config.KBFSOps().(syncedFolderLoader)
+	if !ok {
+		log.CDebugf(ctx, "Not starting synced folders, since %T "+
+			"can't load folders by ID", config.KBFSOps())
+		return nil
+	}
+	fileInfos, err := ioutil.ReadDir(syncedFoldersDir(storageRoot))
+	switch {
+	case ioutil.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	crypter, err := makeLocalStorageCrypter(ctx, config)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fileInfos {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(syncedFoldersDir(storageRoot), fi.Name())
+		var syncConfig folderSyncConfig
+		err := crypter.deserializeFromJSONFile(path, &syncConfig)
+		if err != nil {
+			log.CDebugf(ctx, "Couldn't read synced folder config %s: %+v",
+				path, err)
+			continue
+		}
+		if len(syncConfig.Paths) == 0 || syncConfig.TlfID == tlf.NullID {
+			continue
+		}
+		err = loader.loadSyncedFolder(ctx, syncConfig.TlfID)
+		if err != nil {
+			log.CDebugf(ctx, "Couldn't load synced folder %s: %+v",
+				syncConfig.TlfID, err)
+		}
+	}
+	return nil
+}
+
+// encryptSyncedFolderConfigs encrypts any configs of folders kept
+// synced that were saved while local storage wasn't encrypted, so
+// that those folders stay synced without the configs giving away
+// which folders they are.
+func encryptSyncedFolderConfigs(codec kbfscodec.Codec,
+	crypter *localStorageCrypter, storageRoot string) error {
+	dir := syncedFoldersDir(storageRoot)
+	fileInfos, err := ioutil.ReadDir(dir)
+	switch {
+	case ioutil.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	for _, fi := range fileInfos {
+		path := filepath.Join(dir, fi.Name())
+		if fi.IsDir() || filepath.Ext(path) != ".json" ||
+			isLocalStorageEncryptedFile(codec, path) {
+			continue
+		}
+		// Unencrypted configs are named after the folder's ID.
+		tlfID, err := tlf.ParseID(strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			return err
+		}
+		var syncConfig folderSyncConfig
+		err = ioutil.DeserializeFromJSONFile(path, &syncConfig)
+		if err != nil {
+			return err
+		}
+		syncConfig.TlfID = tlfID
+		err = crypter.serializeToJSONFile(syncConfig,
+			syncedFolderConfigPath(crypter, storageRoot, tlfID))
+		if err != nil {
+			return err
+		}
+		err = ioutil.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newFolderSyncer(
+	config Config, id tlf.ID, log logger.Logger) *folderSyncer {
+	return &folderSyncer{
+		config: config,
+		id:     id,
+		log:    log,
+		paths:  make(map[string]bool),
+	}
+}
+
+// configPath returns where the config for this folder is saved, or
+// the empty string if it's only kept in memory.
+func (fs *folderSyncer) configPath() string {
+	storageRoot := fs.config.StorageRoot()
+	if storageRoot == "" {
+		return ""
+	}
+	return syncedFolderConfigPath(fs.crypter, storageRoot, fs.id)
+}
+
+func (fs *folderSyncer) loadLocked(ctx context.Context) error {
+	if fs.loaded {
+		return nil
+	}
+	crypter, err := makeLocalStorageCrypter(ctx, fs.config)
+	if err != nil {
+		return err
+	}
+	fs.crypter = crypter
+	path := fs.configPath()
+	if path != "" {
+		var config folderSyncConfig
+		err := fs.crypter.deserializeFromJSONFile(path, &config)
+		switch {
+		case ioutil.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			for _, p := range config.Paths {
+				fs.paths[p] = true
+			}
+		}
+	}
+	fs.loaded = true
+	return nil
+}
+
+func (fs *folderSyncer) sortedPathsLocked() []string {
+	paths := make([]string, 0, len(fs.paths))
+	for p := range fs.paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (fs *folderSyncer) saveLocked() error {
+	path := fs.configPath()
+	if path == "" {
+		return nil
+	}
+	err := ioutil.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	return fs.crypter.serializeToJSONFile(folderSyncConfig{
+		TlfID: fs.id,
+		Paths: fs.sortedPathsLocked(),
+	}, path)
+}
+
+// setPath starts or stops keeping the subtree at the given path
+// synced.
+func (fs *folderSyncer) setPath(
+	ctx context.Context, path string, keep bool) error {
+	dbc := fs.config.DiskBlockCache()
+	if dbc == nil {
+		return errors.New("Folders can only be kept synced when the " +
+			"disk block cache is enabled")
+	}
+
+	doneCh, errCh, err := func() (
+		doneCh <-chan struct{}, errCh <-chan error, err error) {
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		if err := fs.loadLocked(ctx); err != nil {
+			return nil, nil, err
+		}
+		if fs.paths[path] == keep {
+			return nil, nil, nil
+		}
+		if keep {
+			fs.paths[path] = true
+		} else {
+			delete(fs.paths, path)
+		}
+		if err := fs.saveLocked(); err != nil {
+			return nil, nil, err
+		}
+
+		if len(fs.paths) > 0 {
+			// The next sync unpins anything that's no longer under
+			// the remaining paths.
+			if fs.head != (ImmutableRootMetadata{}) {
+				fs.startLocked(fs.head)
+			}
+			return nil, nil, nil
+		}
+		doneCh, errCh = fs.startUnpinLocked(ctx, dbc)
+		return doneCh, errCh, nil
+	}()
+	if err != nil || doneCh == nil {
+		return err
+	}
+	select {
+	case <-doneCh:
+		return <-errCh
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startUnpinLocked cancels any sync in progress, and unpins all the
+// blocks of the folder once it's done, since nothing needs to stay
+// pinned anymore.
+func (fs *folderSyncer) startUnpinLocked(
+	ctx context.Context, dbc DiskBlockCache) (
+	<-chan struct{}, <-chan error) {
+	fs.cancelLocked()
+	fs.gen++
+	fs.status = FolderSyncStatus{}
+	prevDoneCh := fs.doneCh
+	doneCh := make(chan struct{})
+	fs.doneCh = doneCh
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(doneCh)
+		if prevDoneCh != nil {
+			<-prevDoneCh
+		}
+		_, err := dbc.UnpinTLF(ctx, fs.id, nil)
+		fs.lock.Lock()
+		defer fs.lock.Unlock()
+		fs.syncedBlocks = nil
+		errCh <- err
+	}()
+	return doneCh, errCh
+}
+
+// headChanged is called with each new merged head of the folder, and
+// starts syncing it if any part of the folder is kept synced.
+func (fs *folderSyncer) headChanged(md ImmutableRootMetadata) {
+	if fs.config.DiskBlockCache() == nil {
+		return
+	}
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.head = md
+	if !fs.loaded {
+		// Reading the saved config touches the disk, so leave that
+		// to a goroutine rather than holding up the caller.
+		go fs.loadAndStart(md)
+		return
+	}
+	if len(fs.paths) > 0 {
+		fs.startLocked(md)
+	}
+}
+
+func (fs *folderSyncer) loadAndStart(md ImmutableRootMetadata) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if fs.loaded {
+		// Whoever loaded the config has already started syncing, if
+		// needed.
+		return
+	}
+	if err := fs.loadLocked(context.Background()); err != nil {
+		fs.log.CWarningf(nil, "Couldn't read the sync config: %+v", err)
+		return
+	}
+	if len(fs.paths) > 0 && fs.head == md {
+		fs.startLocked(md)
+	}
+}
+
+func (fs *folderSyncer) cancelLocked() {
+	if fs.cancel != nil {
+		fs.cancel()
+		fs.cancel = nil
+	}
+}
+
+// startLocked cancels any sync in progress, and starts syncing the
+// given revision.
+func (fs *folderSyncer) startLocked(md ImmutableRootMetadata) {
+	if fs.isShutdown {
+		return
+	}
+	fs.cancelLocked()
+	fs.gen++
+	fs.status.Paths = fs.sortedPathsLocked()
+	fs.status.SyncingRevision = md.Revision()
+	fs.status.SyncingBlocks = 0
+	fs.status.SyncingBytes = 0
+	ctx, cancel := context.WithCancel(ctxWithRandomIDReplayable(
+		context.Background(), CtxFolderSyncIDKey, CtxFolderSyncOpID,
+		fs.log))
+	fs.cancel = cancel
+	prevDoneCh := fs.doneCh
+	fs.doneCh = make(chan struct{})
+	go fs.sync(ctx, fs.gen, md, fs.status.Paths, prevDoneCh, fs.doneCh)
+}
+
+// updateStatus applies f to the status, unless the sync with the
+// given generation has been superseded.
+func (fs *folderSyncer) updateStatus(gen int, f func(*FolderSyncStatus)) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if gen == fs.gen {
+		f(&fs.status)
+	}
+}
+
+// getDirEntry finds the named entry in the directory with the given
+// pointer, calling fn on every block of the directory it reads.
+func (fs *folderSyncer) getDirEntry(ctx context.Context,
+	md ImmutableRootMetadata, dirPtr BlockPointer, name string,
+	fn func(BlockPointer, Block) error) (DirEntry, error) {
+	ptrs := []BlockPointer{dirPtr}
+	for len(ptrs) > 0 {
+		ptr := ptrs[0]
+		ptrs = ptrs[1:]
+		block := &DirBlock{}
+		err := fs.config.BlockOps().Get(ctx, md, ptr, block, TransientEntry)
+		if err != nil {
+			return DirEntry{}, err
+		}
+		if err := fn(ptr, block); err != nil {
+			return DirEntry{}, err
+		}
+		if !block.IsInd {
+			if de, ok := block.Children[name]; ok {
+				return de, nil
+			}
+			continue
+		}
+		for _, iptr := range block.IPtrs {
+			ptrs = append(ptrs, iptr.BlockPointer)
+		}
+	}
+	return DirEntry{}, NoSuchNameError{name}
+}
+
+// pinOrFetch makes sure the block with the given pointer is pinned
+// in the disk cache.  The block may have been served from the
+// in-memory cache, or may still be on its way into the disk cache,
+// in which case this fetches it from the block server.
+func (fs *folderSyncer) pinOrFetch(
+	ctx context.Context, dbc DiskBlockCache, ptr BlockPointer) error {
+	err := dbc.Pin(ctx, fs.id, ptr.ID)
+	if _, ok := errors.Cause(err).(NoSuchBlockError); !ok {
+		return err
+	}
+	buf, serverHalf, err := fs.config.BlockServer().Get(
+		ctx, fs.id, ptr.ID, ptr.Context)
+	if err != nil {
+		return err
+	}
+	err = dbc.Put(ctx, fs.id, ptr.ID, buf, serverHalf)
+	if err != nil {
+		return err
+	}
+	return dbc.Pin(ctx, fs.id, ptr.ID)
+}
+
+func (fs *folderSyncer) sync(ctx context.Context, gen int,
+	md ImmutableRootMetadata, paths []string,
+	prevDoneCh <-chan struct{}, doneCh chan<- struct{}) {
+	defer close(doneCh)
+	if prevDoneCh != nil {
+		// The previous sync has been canceled, so this won't take
+		// long.
+		<-prevDoneCh
+	}
+	fs.log.CDebugf(ctx, "Syncing paths %v at revision %d", paths,
+		md.Revision())
+
+	fs.lock.Lock()
+	prev := fs.syncedBlocks
+	fs.lock.Unlock()
+
+	err := fs.doSync(ctx, gen, md, paths, prev)
+	if err != nil {
+		fs.log.CDebugf(ctx, "Sync of revision %d failed: %+v",
+			md.Revision(), err)
+		if ctx.Err() != nil {
+			// Superseded or shut down.
+			return
+		}
+	}
+	fs.updateStatus(gen, func(status *FolderSyncStatus) {
+		if err != nil {
+			status.LastError = err.Error()
+			return
+		}
+		status.SyncedRevision = status.SyncingRevision
+		status.SyncedBlocks = status.SyncingBlocks
+		status.SyncedBytes = status.SyncingBytes
+		status.SyncingRevision = MetadataRevisionUninitialized
+		status.SyncingBlocks = 0
+		status.SyncingBytes = 0
+		status.LastError = ""
+	})
+}
+
+// doSync pins every block under the given paths at the given
+// revision, and then unpins all the other blocks of the folder.
+// Subtrees whose root blocks are in prev, the blocks pinned by the
+// previous sync, are kept without being walked.
+func (fs *folderSyncer) doSync(ctx context.Context, gen int,
+	md ImmutableRootMetadata, paths []string,
+	prev map[kbfsblock.ID]syncedBlock) error {
+	dbc := fs.config.DiskBlockCache()
+	if dbc == nil {
+		return errors.New("The disk block cache is disabled")
+	}
+
+	pinned := make(map[kbfsblock.ID]syncedBlock)
+	var missing []BlockPointer
+	pin := func(ptr BlockPointer, block Block) error {
+		if _, ok := pinned[ptr.ID]; ok {
+			return nil
+		}
+		sb := syncedBlock{size: block.GetEncodedSize()}
+		childPtrs, _ := treeBlockChildren(block)
+		for _, childPtr := range childPtrs {
+			sb.children = append(sb.children, childPtr.ID)
+		}
+		pinned[ptr.ID] = sb
+		err := dbc.Pin(ctx, fs.id, ptr.ID)
+		switch errors.Cause(err).(type) {
+		case nil:
+		case NoSuchBlockError:
+			// Try again once the tree is done.
+			missing = append(missing, ptr)
+		default:
+			return err
+		}
+		fs.updateStatus(gen, func(status *FolderSyncStatus) {
+			status.SyncingBlocks++
+			status.SyncingBytes += uint64(block.GetEncodedSize())
+		})
+		return nil
+	}
+
+	// keepUnchanged keeps the subtree rooted at ptr pinned, without
+	// fetching any of it, if the previous sync pinned all of it.
+	keepUnchanged := func(ptr BlockPointer) bool {
+		if _, ok := prev[ptr.ID]; !ok {
+			return false
+		}
+		subtree := make(map[kbfsblock.ID]syncedBlock)
+		ids := []kbfsblock.ID{ptr.ID}
+		for len(ids) > 0 {
+			id := ids[len(ids)-1]
+			ids = ids[:len(ids)-1]
+			if _, ok := subtree[id]; ok {
+				continue
+			}
+			sb, ok := prev[id]
+			if !ok {
+				// Only part of this subtree was synced before (e.g.,
+				// it's a directory along the path to a synced
+				// subtree), so walk it after all.
+				return false
+			}
+			subtree[id] = sb
+			ids = append(ids, sb.children...)
+		}
+
+		var numBlocks int
+		var numBytes uint64
+		for id, sb := range subtree {
+			if _, ok := pinned[id]; ok {
+				continue
+			}
+			pinned[id] = sb
+			numBlocks++
+			numBytes += uint64(sb.size)
+		}
+		fs.updateStatus(gen, func(status *FolderSyncStatus) {
+			status.SyncingBlocks += numBlocks
+			status.SyncingBytes += numBytes
+		})
+		return true
+	}
+
+	rootPtr := md.data.Dir.BlockPointer
+	for _, p := range paths {
+		ptr := rootPtr
+		var block Block = &DirBlock{}
+		if p != "" {
+			// Find the root of the subtree, pinning all the
+			// directories along the way so the subtree can be
+			// reached while offline.
+			var de DirEntry
+			var err error
+			for _, name := range strings.Split(p, "/") {
+				de, err = fs.getDirEntry(ctx, md, ptr, name, pin)
+				if err != nil {
+					break
+				}
+				ptr = de.BlockPointer
+			}
+			if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+				fs.log.CDebugf(ctx, "Synced path %s no longer exists", p)
+				continue
+			} else if err != nil {
+				return err
+			}
+			switch de.Type {
+			case Dir:
+			case File, Exec:
+				block = &FileBlock{}
+			default:
+				// Nothing more to fetch for a symlink.
+				continue
+			}
+		}
+
+		if !keepUnchanged(ptr) {
+			err := fs.config.BlockOps().Prefetcher().PrefetchTree(
+				ctx, block, ptr, md, syncedFolderPrefetchPriority,
+				keepUnchanged, pin)
+			if err != nil {
+				return err
+			}
+		}
+		if p == "" {
+			// Every other path is under the root.
+			break
+		}
+	}
+
+	for _, ptr := range missing {
+		if err := fs.pinOrFetch(ctx, dbc, ptr); err != nil {
+			return err
+		}
+	}
+
+	// Anything else that's pinned is no longer part of a synced
+	// subtree.
+	except := make(map[kbfsblock.ID]bool, len(pinned))
+	for id := range pinned {
+		except[id] = true
+	}
+	_, err := dbc.UnpinTLF(ctx, fs.id, except)
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if err != nil {
+		// Some of the previously-pinned blocks might not be pinned
+		// anymore.
+		fs.syncedBlocks = nil
+		return err
+	}
+	fs.syncedBlocks = pinned
+	return nil
+}
+
+// getStatus returns the current sync status of the folder.
+func (fs *folderSyncer) getStatus(ctx context.Context) (
+	FolderSyncStatus, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	if err := fs.loadLocked(ctx); err != nil {
+		return FolderSyncStatus{}, err
+	}
+	status := fs.status
+	status.Paths = fs.sortedPathsLocked()
+	return status, nil
+}
+
+// waitForSync blocks until the current sync, if any, is done.
+func (fs *folderSyncer) waitForSync(ctx context.Context) error {
+	fs.lock.Lock()
+	doneCh := fs.doneCh
+	fs.lock.Unlock()
+	if doneCh == nil {
+		return nil
+	}
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown cancels any sync in progress.
+func (fs *folderSyncer) shutdown() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.isShutdown = true
+	fs.cancelLocked()
+}
@@ -879,6 +879,11 @@ func fixOpPointersForUpdate(oldOps []op, updates map[BlockPointer]BlockPointer,
 			ptrsToFix = append(ptrsToFix, &realOp.File)
 			// The leading resolutionOp will take care of the updates.
 			realOp.Updates = nil
+		case *setXattrOp:
+			updatesToFix = append(updatesToFix, &realOp.Dir)
+			ptrsToFix = append(ptrsToFix, &realOp.File)
+			// The leading resolutionOp will take care of the updates.
+			realOp.Updates = nil
 		}
 
 		for _, update := range updatesToFix {
@@ -30,6 +30,36 @@ const (
 	InitMinimalString = "minimal"
 )
 
+const (
+	// BlockSplitterSimpleString splits file data into blocks of a
+	// fixed maximum size.
+	BlockSplitterSimpleString = "simple"
+	// BlockSplitterCDCString splits file data into blocks using
+	// content-defined chunking, so that edited files share most of
+	// their blocks with previous revisions.
+	BlockSplitterCDCString = "cdc"
+)
+
+const (
+	// BlockEncryptionSecretboxString encrypts new blocks with
+	// EncryptionSecretbox.
+	BlockEncryptionSecretboxString = "secretbox"
+	// BlockEncryptionXChaCha20Poly1305String encrypts new blocks
+	// with EncryptionXChaCha20Poly1305.  Such blocks can't be read
+	// by older clients.
+	BlockEncryptionXChaCha20Poly1305String = "xchacha20poly1305"
+)
+
+const (
+	// DiskCacheEvictLRUString selects DiskCacheEvictLRU.
+	DiskCacheEvictLRUString = "lru"
+	// DiskCacheEvictLFUString selects DiskCacheEvictLFU.
+	DiskCacheEvictLFUString = "lfu"
+	// DiskCacheEvictSizeWeightedString selects
+	// DiskCacheEvictSizeWeighted.
+	DiskCacheEvictSizeWeightedString = "size"
+)
+
 // InitParams contains the initialization parameters for Init(). It is
 // usually filled in by the flags parser passed into AddFlags().
 type InitParams struct {
@@ -96,6 +126,60 @@ type InitParams struct {
 
 	// Mode describes how KBFS should initialize itself.
 	Mode string
+
+	// BlockSplitter selects how new file data is split into blocks;
+	// it is either BlockSplitterSimpleString or
+	// BlockSplitterCDCString.
+	BlockSplitter string
+
+	// BlockCompression, if true, compresses new blocks before they
+	// are encrypted, whenever that saves space.  Compressed blocks
+	// can't be read by older clients.
+	BlockCompression bool
+
+	// BlockEncryption selects the encryption version for new
+	// blocks; it is either BlockEncryptionSecretboxString or
+	// BlockEncryptionXChaCha20Poly1305String.
+	BlockEncryption string
+
+	// EncryptLocalStorage, if true, encrypts the disk cache
+	// metadata and journal info files under StorageRoot with a key
+	// local to the current device.  The disk cache can then only be
+	// enabled at startup if a user is already logged in.  Turning it
+	// on removes any unencrypted disk cache.  KBFS won't start while
+	// the current device has a journal with unflushed changes from
+	// before it was turned on or off.
+	EncryptLocalStorage bool
+
+	// DiskCacheEviction selects which blocks the disk cache evicts
+	// first; it is DiskCacheEvictLRUString,
+	// DiskCacheEvictLFUString or DiskCacheEvictSizeWeightedString.
+	DiskCacheEviction string
+
+	// DiskCacheTLFLimitBytes, if non-zero, is the most space that
+	// any one TLF may take up in the disk cache, unless a different
+	// limit is set for that TLF.
+	DiskCacheTLFLimitBytes int64
+
+	// CacheFlushedJournalBlocks, if true, puts the blocks flushed
+	// from a journal in the disk cache, so that reading recently
+	// written data doesn't have to fetch it from the server.
+	CacheFlushedJournalBlocks bool
+
+	// UploadLimitBytesPerSecond and DownloadLimitBytesPerSecond,
+	// if non-zero, limit the rate of journal flushes and
+	// prefetches, respectively.
+	UploadLimitBytesPerSecond   int64
+	DownloadLimitBytesPerSecond int64
+
+	// JournalFlushSchedule, if non-empty, is a comma-separated list
+	// of daily windows, like "22:00-06:00", outside of which
+	// journals don't flush in the background.
+	JournalFlushSchedule string
+
+	// MeteredConnection, if true, stops journals from flushing in
+	// the background, until it's turned off at runtime.
+	MeteredConnection bool
 }
 
 // defaultBServer returns the default value for the -bserver flag.
@@ -160,6 +244,8 @@ func DefaultInitParams(ctx Context) InitParams {
 		TLFJournalBackgroundWorkStatus: TLFJournalBackgroundWorkEnabled,
 		StorageRoot:                    ctx.GetDataDir(),
 		Mode:                           InitDefaultString,
+		BlockSplitter:                  BlockSplitterSimpleString,
+		BlockEncryption:                BlockEncryptionSecretboxString,
 	}
 }
 
@@ -225,6 +311,49 @@ func AddFlags(flags *flag.FlagSet, ctx Context) *InitParams {
 		fmt.Sprintf("Overall initialization mode for KBFS, indicating how "+
 			"heavy-weight it can be (%s or %s)", InitDefaultString,
 			InitMinimalString))
+	flags.StringVar(&params.BlockSplitter, "block-splitter",
+		defaultParams.BlockSplitter,
+		fmt.Sprintf("How to split file data into blocks (%s or %s)",
+			BlockSplitterSimpleString, BlockSplitterCDCString))
+	flags.BoolVar(&params.BlockCompression, "block-compression",
+		defaultParams.BlockCompression,
+		"Compress new blocks before encrypting them, when that saves space")
+	flags.StringVar(&params.BlockEncryption, "block-encryption",
+		defaultParams.BlockEncryption,
+		fmt.Sprintf("How to encrypt new blocks (%s or %s)",
+			BlockEncryptionSecretboxString,
+			BlockEncryptionXChaCha20Poly1305String))
+	flags.BoolVar(&params.EncryptLocalStorage, "encrypt-local-storage",
+		defaultParams.EncryptLocalStorage,
+		"Encrypt the disk cache and journal metadata with a device-local key")
+	flags.StringVar(&params.DiskCacheEviction, "disk-cache-eviction",
+		defaultParams.DiskCacheEviction,
+		fmt.Sprintf("Which blocks the disk cache evicts first (%s, %s or %s)",
+			DiskCacheEvictLRUString, DiskCacheEvictLFUString,
+			DiskCacheEvictSizeWeightedString))
+	flags.Int64Var(&params.DiskCacheTLFLimitBytes, "disk-cache-tlf-limit",
+		defaultParams.DiskCacheTLFLimitBytes,
+		"The most bytes any one folder may use in the disk cache (0 for "+
+			"no limit)")
+	flags.BoolVar(&params.CacheFlushedJournalBlocks,
+		"cache-flushed-journal-blocks",
+		defaultParams.CacheFlushedJournalBlocks,
+		"Move blocks flushed from the journal into the disk cache")
+	flags.Int64Var(&params.UploadLimitBytesPerSecond, "upload-limit",
+		defaultParams.UploadLimitBytesPerSecond,
+		"Limit journal flushes to this many bytes per second (0 for no "+
+			"limit)")
+	flags.Int64Var(&params.DownloadLimitBytesPerSecond, "download-limit",
+		defaultParams.DownloadLimitBytesPerSecond,
+		"Limit prefetches to this many bytes per second (0 for no limit)")
+	flags.StringVar(&params.JournalFlushSchedule, "journal-flush-schedule",
+		defaultParams.JournalFlushSchedule,
+		"Only flush journals in the background during these daily "+
+			"windows, e.g. 22:00-06:00,12:00-13:00")
+	flags.BoolVar(&params.MeteredConnection, "metered",
+		defaultParams.MeteredConnection,
+		"Don't flush journals in the background, since the connection "+
+			"is metered")
 
 	return &params
 }
@@ -503,12 +632,62 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 	}
 	config.SetBlockOps(NewBlockOpsStandard(config, workers))
 
-	bsplitter, err := NewBlockSplitterSimple(MaxBlockSizeBytesDefault, 8*1024,
-		config.Codec())
+	var bsplitter BlockSplitter
+	var err error
+	switch params.BlockSplitter {
+	case BlockSplitterSimpleString, "":
+		bsplitter, err = NewBlockSplitterSimple(
+			MaxBlockSizeBytesDefault, 8*1024, config.Codec())
+	case BlockSplitterCDCString:
+		log.Debug("Using content-defined chunking for file blocks")
+		bsplitter, err = NewBlockSplitterCDC(
+			MaxBlockSizeBytesDefault, 8*1024, config.Codec())
+	default:
+		err = fmt.Errorf("Unexpected block splitter: %s",
+			params.BlockSplitter)
+	}
 	if err != nil {
 		return nil, err
 	}
 	config.SetBlockSplitter(bsplitter)
+	config.SetBlockCompression(params.BlockCompression)
+	switch params.BlockEncryption {
+	case BlockEncryptionSecretboxString, "":
+		config.SetBlockEncryptionVer(EncryptionSecretbox)
+	case BlockEncryptionXChaCha20Poly1305String:
+		log.Debug("Encrypting new blocks with XChaCha20-Poly1305")
+		config.SetBlockEncryptionVer(EncryptionXChaCha20Poly1305)
+	default:
+		return nil, fmt.Errorf("Unexpected block encryption: %s",
+			params.BlockEncryption)
+	}
+	config.SetEncryptLocalStorage(params.EncryptLocalStorage)
+	if params.DiskCacheEviction == "" {
+		config.SetDiskCacheEvictionPolicy(DiskCacheEvictLRU)
+	} else {
+		policy, err := ParseDiskCacheEvictionPolicy(params.DiskCacheEviction)
+		if err != nil {
+			return nil, err
+		}
+		config.SetDiskCacheEvictionPolicy(policy)
+	}
+	config.SetDiskCacheTLFLimit(params.DiskCacheTLFLimitBytes)
+	config.SetCacheFlushedJournalBlocks(params.CacheFlushedJournalBlocks)
+
+	bandwidth := config.BandwidthLimiter()
+	err = bandwidth.SetLimits(BandwidthLimits{
+		UploadBytesPerSecond:   params.UploadLimitBytesPerSecond,
+		DownloadBytesPerSecond: params.DownloadLimitBytesPerSecond,
+	})
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := ParseBandwidthSchedule(params.JournalFlushSchedule)
+	if err != nil {
+		return nil, err
+	}
+	bandwidth.SetSchedule(schedule)
+	bandwidth.SetMetered(params.MeteredConnection)
 
 	if registry := config.MetricsRegistry(); registry != nil {
 		keyCache := config.KeyCache()
@@ -603,25 +782,52 @@ func doInit(ctx Context, params InitParams, keybaseServiceCn KeybaseServiceCn,
 	// TODO: Don't turn on journaling if either -bserver or
 	// -mdserver point to local implementations.
 	if params.EnableJournal && config.Mode() != InitMinimal {
-		journalRoot := filepath.Join(params.StorageRoot, "kbfs_journal")
+		journalRoot := journalDirFromStorageRoot(params.StorageRoot)
 		err = config.EnableJournaling(context.Background(), journalRoot,
 			params.TLFJournalBackgroundWorkStatus)
-		if err != nil {
+		if _, ok := err.(UnflushedJournalInOtherModeError); ok {
+			// Don't start without those changes, which would look
+			// lost; the user has to go back to the old mode until
+			// they're flushed.
+			log.Warning("Could not enable existing journals: %+v", err)
+			return nil, err
+		} else if err != nil {
 			log.Warning("Could not initialize journal server: %+v", err)
 		}
 		log.Debug("Journaling enabled")
 	}
 	session, err := k.GetCurrentSession(gocontext.TODO())
-	if params.EnableDiskCache || (err == nil && adminFeatureList[session.UID]) {
-		dbc, err := newDiskBlockCacheStandard(config,
-			diskBlockCacheRootFromStorageRoot(params.StorageRoot))
-		if err != nil {
-			log.Warning("Could not initialize disk cache: %+v", err)
-			// TODO: Make this error less fatal later.
-			return nil, err
+	loggedIn := err == nil
+	if params.EnableDiskCache || (loggedIn && adminFeatureList[session.UID]) {
+		if params.EncryptLocalStorage && !loggedIn {
+			// The key for an encrypted disk cache comes from the
+			// current device, so there has to be a logged-in user.
+			log.Warning("Not enabling the encrypted disk cache, since " +
+				"no user is logged in")
+		} else {
+			dbc, err := newDiskBlockCacheStandardForConfig(
+				gocontext.TODO(), config, params.StorageRoot)
+			if err != nil {
+				log.Warning("Could not initialize disk cache: %+v", err)
+				// TODO: Make this error less fatal later.
+				return nil, err
+			}
+			config.SetDiskBlockCache(dbc)
+			log.Debug("Disk cache enabled")
+
+			if loggedIn && config.Mode() != InitMinimal {
+				go func() {
+					ctx := ctxWithRandomIDReplayable(
+						context.Background(), CtxFolderSyncIDKey,
+						CtxFolderSyncOpID, log)
+					err := startSyncedFolders(ctx, config)
+					if err != nil {
+						log.CWarningf(ctx,
+							"Couldn't start synced folders: %+v", err)
+					}
+				}()
+			}
 		}
-		config.SetDiskBlockCache(dbc)
-		log.Debug("Disk cache enabled")
 	}
 
 	return config, nil
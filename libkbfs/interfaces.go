@@ -75,6 +75,52 @@ type diskLimiterGetter interface {
 	DiskLimiter() DiskLimiter
 }
 
+type blockEncodingGetter interface {
+	// BlockCompression says whether new blocks should be compressed
+	// before they are encrypted, whenever that saves space.
+	BlockCompression() bool
+	// BlockEncryptionVer is the encryption version for new blocks.
+	BlockEncryptionVer() EncryptionVer
+}
+
+type diskCachePolicyGetter interface {
+	// DiskCacheEvictionPolicy says which blocks the disk block
+	// cache evicts first.
+	DiskCacheEvictionPolicy() DiskCacheEvictionPolicy
+	// DiskCacheTLFLimit is the most bytes that any one TLF may use
+	// in the disk block cache, unless the cache has a different
+	// limit for that TLF.  0 means no limit.
+	DiskCacheTLFLimit() int64
+}
+
+type flushedBlockCachingGetter interface {
+	// CacheFlushedJournalBlocks says whether blocks flushed from a
+	// TLF journal should be put in the disk block cache, rather
+	// than just discarded.
+	CacheFlushedJournalBlocks() bool
+}
+
+type bandwidthLimiterGetter interface {
+	// BandwidthLimiter returns the limiter for the background
+	// traffic of KBFS.
+	BandwidthLimiter() *BandwidthLimiter
+}
+
+type localStorageEncryptionGetter interface {
+	// EncryptLocalStorage says whether the metadata that KBFS keeps
+	// under its storage root should be encrypted with a key that's
+	// local to the current device.
+	EncryptLocalStorage() bool
+}
+
+type metricsRegistryGetter interface {
+	// MetricsRegistry may be nil, which should be interpreted as
+	// not using metrics at all. (i.e., as if UseNilMetrics were
+	// set). This differs from how go-metrics treats nil Registry
+	// objects, which is to use the default registry.
+	MetricsRegistry() metrics.Registry
+}
+
 // Block just needs to be (de)serialized using msgpack
 type Block interface {
 	dataVersioner
@@ -190,6 +236,16 @@ type KBFSOps interface {
 	GetRootNode(
 		ctx context.Context, h *TlfHandle, branch BranchName) (
 		node Node, ei EntryInfo, err error)
+	// GetRootNodeAtRevision returns the root node and root entry
+	// info of the given TLF, as of the given merged revision, if the
+	// logged-in user has read permissions to the top-level folder.
+	// The returned Node, and every Node looked up beneath it, belongs
+	// to a read-only branch (see MakeRevBranchName), and any attempt
+	// to modify it will fail with WriteToReadonlyNodeError.  This is
+	// a remote-access operation.
+	GetRootNodeAtRevision(
+		ctx context.Context, h *TlfHandle, rev MetadataRevision) (
+		node Node, ei EntryInfo, err error)
 	// GetDirChildren returns a map of children in the directory,
 	// mapped to their EntryInfo, if the logged-in user has read
 	// permission for the top-level folder.  This is a remote-access
@@ -226,6 +282,16 @@ type KBFSOps interface {
 	// is a remote-sync operation.
 	CreateLink(ctx context.Context, dir Node, fromName string, toPath string) (
 		EntryInfo, error)
+	// CopyFile creates a new file under the given directory node,
+	// with the same contents as the given source file, if the
+	// logged-in user has write permission to the top-level folder.
+	// Both nodes must be in the same top-level folder.  Rather than
+	// uploading the data again, the new file gets new references to
+	// the source file's existing blocks, when possible.  Any dirty
+	// data in the source file is synced first.  Returns the node and
+	// entry info for the new file.  This is a remote-sync operation.
+	CopyFile(ctx context.Context, src Node, dir Node, name string) (
+		Node, EntryInfo, error)
 	// RemoveDir removes the subdirectory represented by the given
 	// node, if the logged-in user has write permission to the
 	// top-level folder.  Will return an error if the subdirectory is
@@ -275,6 +341,44 @@ type KBFSOps interface {
 	// on whether or not the necessary blocks have been locally
 	// cached.  This is a remote-access operation.
 	Truncate(ctx context.Context, file Node, size uint64) error
+	// PunchHole deallocates the given range of the file at the given
+	// node, if the logged-in user has write permission to the
+	// top-level folder.  The range reads back as 0s afterward, and
+	// the size of the file doesn't change.  This is a remote-access
+	// operation.
+	PunchHole(ctx context.Context, file Node, off, length int64) error
+	// NextDataOffset returns the offset of the first byte of data at
+	// or after the given offset in the file at the given node, or -1
+	// if there is no more data before the end of the file.  This is
+	// a remote-access operation.
+	NextDataOffset(ctx context.Context, file Node, off int64) (int64, error)
+	// NextHoleOffset returns the offset of the start of the first
+	// hole at or after the given offset in the file at the given
+	// node, or -1 if the offset is past the end of the file.  The end
+	// of the file counts as a hole.  This is a remote-access
+	// operation.
+	NextHoleOffset(ctx context.Context, file Node, off int64) (int64, error)
+	// LockFile takes a POSIX-style advisory lock on a byte range of
+	// the file represented by a given node, on behalf of lock.Owner,
+	// replacing any lock that owner already holds on the same
+	// range.  It never blocks: if another owner holds a conflicting
+	// lock, on this device or (if the MD server supports leases) on
+	// another device, it returns FileLockConflictError.  Leases on
+	// other devices expire if those devices go offline, and if this
+	// device can't renew its own leases, it drops the locks and
+	// returns FileLockLostError from the owner's next lock call.
+	// This is a remote-access operation.
+	LockFile(ctx context.Context, file Node, lock FileLock) error
+	// UnlockFile releases all the advisory locks held by lock.Owner
+	// on the byte range of the file covered by lock.  This is a
+	// remote-access operation.
+	UnlockFile(ctx context.Context, file Node, lock FileLock) error
+	// GetFileLockConflict returns a lock held by another owner that
+	// would prevent lock from being taken on the file represented by
+	// a given node; the returned bool is false if there is none.
+	// This is a remote-access operation.
+	GetFileLockConflict(ctx context.Context, file Node, lock FileLock) (
+		FileLock, bool, error)
 	// SetEx turns on or off the executable bit on the file
 	// represented by a given node, if the logged-in user has write
 	// permissions to the top-level folder.  This is a remote-sync
@@ -285,12 +389,55 @@ type KBFSOps interface {
 	// the top-level folder.  If mtime is nil, it is a noop.  This is
 	// a remote-sync operation.
 	SetMtime(ctx context.Context, file Node, mtime *time.Time) error
+	// GetXattr returns the value of the named extended attribute of
+	// the file or directory represented by a given node, or
+	// NoSuchXattrError if it isn't set.
+	GetXattr(ctx context.Context, node Node, xattr string) ([]byte, error)
+	// SetXattr sets the named extended attribute of the file or
+	// directory represented by a given node, if the logged-in user
+	// has write permissions to the top-level folder.  Extended
+	// attributes are stored in the parent directory's entry, so the
+	// root of a folder can't have any.  It returns
+	// XattrTooBigError if the value is too big, and
+	// XattrsTooBigError if all the entry's extended attributes
+	// together would be.  This is a remote-sync operation.
+	SetXattr(ctx context.Context, node Node, xattr string,
+		value []byte) error
+	// ListXattrs returns the sorted names of all the extended
+	// attributes set on the file or directory represented by a given
+	// node.
+	ListXattrs(ctx context.Context, node Node) ([]string, error)
+	// RemoveXattr removes the named extended attribute from the file
+	// or directory represented by a given node, or returns
+	// NoSuchXattrError if it isn't set.  This is a remote-sync
+	// operation.
+	RemoveXattr(ctx context.Context, node Node, xattr string) error
 	// Sync flushes all outstanding writes and truncates for the given
 	// file to the KBFS servers, if the logged-in user has write
 	// permissions to the top-level folder.  If done through a file
 	// system interface, this may include modifications done via
 	// multiple file handles.  This is a remote-sync operation.
 	Sync(ctx context.Context, file Node) error
+	// RevertToRevision restores the file or directory subtree
+	// represented by the given node to its contents as of the given
+	// merged revision of its top-level folder, if the logged-in user
+	// has write permissions to the top-level folder.  Existing data
+	// blocks are reused by adding new references to them, rather
+	// than by uploading their contents again, unless the server no
+	// longer allows new references to them.  The node must have
+	// existed at the given revision.  This is a remote-sync
+	// operation.
+	RevertToRevision(ctx context.Context, node Node,
+		rev MetadataRevision) error
+	// Undelete restores the entry `name` in the given directory, as
+	// it was just before it was removed by the given merged
+	// revision, if the logged-in user has write permissions to the
+	// top-level folder.  Like RevertToRevision, it adds new
+	// references to the entry's old blocks.  It returns a
+	// DeletedEntryReclaimedError if quota reclamation has already
+	// deleted those blocks.  This is a remote-sync operation.
+	Undelete(ctx context.Context, dir Node, name string,
+		rev MetadataRevision) error
 	// FolderStatus returns the status of a particular folder/branch, along
 	// with a channel that will be closed when the status has been
 	// updated (to eliminate the need for polling this method).
@@ -332,6 +479,31 @@ type KBFSOps interface {
 	// for the folder.
 	GetEditHistory(ctx context.Context, folderBranch FolderBranch) (
 		edits TlfWriterEdits, err error)
+	// GetDeletedEntries returns the entries that were removed from
+	// the given folder within its recent merged history, most
+	// recent first, and that haven't had their blocks reclaimed yet.
+	// Each of them can be restored with Undelete.
+	GetDeletedEntries(ctx context.Context, folderBranch FolderBranch) (
+		entries []DeletedEntry, err error)
+	// DiffRevisions returns the path-level changes made to the given
+	// folder by each of the merged revisions after `fromRev`, up to
+	// and including `toRev`, in order.  Unlike GetUpdateHistory,
+	// the changes describe paths rather than block pointers.  Like
+	// GetUpdateHistory, this can be an expensive operation.
+	DiffRevisions(ctx context.Context, folderBranch FolderBranch,
+		fromRev, toRev MetadataRevision) (changes []PathChange, err error)
+	// SetKeepSynced starts or stops keeping the subtree rooted at
+	// the given node (the whole folder, for its root node) synced
+	// for offline use: every block in it is fetched into the disk
+	// block cache and pinned there, exempt from eviction, and the
+	// same happens for each new revision of the folder.  The
+	// setting persists across restarts, and requires the disk block
+	// cache to be enabled.
+	SetKeepSynced(ctx context.Context, node Node, keepSynced bool) error
+	// GetSyncStatus returns which parts of the given folder are kept
+	// synced, and how far along the syncing is.
+	GetSyncStatus(ctx context.Context, folderBranch FolderBranch) (
+		FolderSyncStatus, error)
 
 	// GetNodeMetadata gets metadata associated with a Node.
 	GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error)
@@ -845,6 +1017,34 @@ type DiskBlockCache interface {
 		sizeRemoved int64, err error)
 	// UpdateLRUTime updates the LRU time to Now() for a given block.
 	UpdateLRUTime(ctx context.Context, blockID kbfsblock.ID) error
+	// Pin marks a block that's already in the disk cache as pinned,
+	// so that it's never evicted to make room for other blocks.  It
+	// returns NoSuchBlockError if the block isn't in the cache.
+	Pin(ctx context.Context, tlfID tlf.ID, blockID kbfsblock.ID) error
+	// UnpinTLF unpins all the pinned blocks of the given TLF, except
+	// for those in `except`, making them subject to eviction again.
+	UnpinTLF(ctx context.Context, tlfID tlf.ID,
+		except map[kbfsblock.ID]bool) (numUnpinned int, err error)
+	// ClearTLF removes all the unpinned blocks of the given TLF
+	// from the disk cache.
+	ClearTLF(ctx context.Context, tlfID tlf.ID) (numRemoved int,
+		sizeRemoved int64, err error)
+	// SetTLFLimit sets the most bytes the given TLF may use in the
+	// disk cache, overriding the config's DiskCacheTLFLimit.  A
+	// limit of 0 goes back to the config's limit.
+	SetTLFLimit(ctx context.Context, tlfID tlf.ID, limit int64) error
+	// SetTLFEvictionPolicy sets the policy for picking which blocks
+	// of the given TLF to evict first, overriding the config's
+	// DiskCacheEvictionPolicy.  The policy is one of
+	// DiskCacheEvictLRUString, DiskCacheEvictLFUString or
+	// DiskCacheEvictSizeWeightedString, or empty to go back to the
+	// config's policy.
+	SetTLFEvictionPolicy(ctx context.Context, tlfID tlf.ID,
+		policy string) error
+	// TLFStatus returns how much of the disk cache the given TLF
+	// uses, and how often its blocks are found there.
+	TLFStatus(ctx context.Context, tlfID tlf.ID) (
+		DiskBlockCacheTLFStatus, error)
 	// Size returns the size in bytes of the disk cache.
 	Size() int64
 	// Shutdown cleanly shuts down the disk block cache.
@@ -925,9 +1125,23 @@ type cryptoPure interface {
 	EncryptBlock(block Block, key kbfscrypto.BlockCryptKey) (
 		plainSize int, encryptedBlock EncryptedBlock, err error)
 
-	// DecryptBlock decrypts a block. Similar to EncryptBlock(),
-	// DecryptBlock() must guarantee that (size of the decrypted
-	// block) <= len(encryptedBlock).
+	// EncryptBlockWithParams is like EncryptBlock, but encrypts
+	// with params.Version, and if params.Compress is set, first
+	// compresses the encoded block if that makes the encrypted
+	// block smaller.  uncompressedSize is the size of the encoded
+	// block before compression, and plainSize is the size of what
+	// was actually encrypted; they differ only if the block was
+	// compressed, in which case it must only be referenced by
+	// pointers with at least CompressedBlocksDataVer.
+	EncryptBlockWithParams(block Block, key kbfscrypto.BlockCryptKey,
+		params BlockEncryptionParams) (
+		plainSize, uncompressedSize int, encryptedBlock EncryptedBlock,
+		err error)
+
+	// DecryptBlock decrypts a block, decompressing it if
+	// needed. Similar to EncryptBlock(), DecryptBlock() must
+	// guarantee that (size of the decrypted block) <=
+	// len(encryptedBlock), unless the block was compressed.
 	DecryptBlock(encryptedBlock EncryptedBlock,
 		key kbfscrypto.BlockCryptKey, block Block) error
 
@@ -1096,6 +1310,17 @@ type Prefetcher interface {
 	// block.
 	PrefetchAfterBlockRetrieved(b Block, blockPtr BlockPointer,
 		kmd KeyMetadata)
+	// PrefetchTree retrieves every block in the tree rooted at the
+	// given block, with no limit on the number of pointers followed
+	// from each block, and calls fn on each one once it's been
+	// retrieved.  It blocks until the whole tree has been retrieved,
+	// and stops at the first error, including any returned by fn.  If
+	// skip is non-nil, it's called on every block below the root
+	// before retrieving it, and the block and its subtree are skipped
+	// if it returns true.
+	PrefetchTree(ctx context.Context, block Block, blockPtr BlockPointer,
+		kmd KeyMetadata, priority int, skip func(BlockPointer) bool,
+		fn func(BlockPointer, Block) error) error
 	// Shutdown shuts down the prefetcher idempotently. Future calls to
 	// the various Prefetch* methods will return io.EOF. The returned channel
 	// allows upstream components to block until all pending prefetches are
@@ -1242,6 +1467,25 @@ type MDServer interface {
 	// released.
 	TruncateUnlock(ctx context.Context, id tlf.ID) (bool, error)
 
+	// LockFile takes, or renews, a lease on an advisory lock on
+	// the file identified by key within this folder, on behalf of
+	// lock.Owner on the current device.  The lease lasts for ttl
+	// unless it is renewed by another call to LockFile.  If another
+	// owner holds an unexpired conflicting lease, it returns
+	// FileLockConflictError.  Servers without lease support return
+	// FileLeasesUnsupportedError.
+	LockFile(ctx context.Context, id tlf.ID, key string, lock FileLock,
+		ttl time.Duration) error
+	// UnlockFile releases the leases held by lock.Owner on the
+	// current device over the range covered by lock.
+	UnlockFile(ctx context.Context, id tlf.ID, key string,
+		lock FileLock) error
+	// GetFileLockConflict returns an unexpired lease, held by some
+	// other owner, that conflicts with lock.  The returned bool is
+	// false if there is no such lease.
+	GetFileLockConflict(ctx context.Context, id tlf.ID, key string,
+		lock FileLock) (FileLock, bool, error)
+
 	// DisableRekeyUpdatesForTesting disables processing rekey updates
 	// received from the mdserver while testing.
 	DisableRekeyUpdatesForTesting()
@@ -1506,6 +1750,12 @@ type Config interface {
 	diskBlockCacheSetter
 	clockGetter
 	diskLimiterGetter
+	diskCachePolicyGetter
+	SetDiskCacheEvictionPolicy(DiskCacheEvictionPolicy)
+	SetDiskCacheTLFLimit(int64)
+	flushedBlockCachingGetter
+	SetCacheFlushedJournalBlocks(bool)
+	bandwidthLimiterGetter
 	KBFSOps() KBFSOps
 	SetKBFSOps(KBFSOps)
 	KBPKI() KBPKI
@@ -1563,6 +1813,9 @@ type Config interface {
 	// be true except for during some testing.
 	DoBackgroundFlushes() bool
 	SetDoBackgroundFlushes(bool)
+	blockEncodingGetter
+	SetBlockCompression(bool)
+	SetBlockEncryptionVer(EncryptionVer)
 	// RekeyWithPromptWaitTime indicates how long to wait, after
 	// setting the rekey bit, before prompting for a paper key.
 	RekeyWithPromptWaitTime() time.Duration
@@ -1604,12 +1857,13 @@ type Config interface {
 
 	// StorageRoot returns the path to the storage root for this config.
 	StorageRoot() string
+	localStorageEncryptionGetter
+	// SetEncryptLocalStorage sets whether local storage is
+	// encrypted.  It only takes effect for journals and disk caches
+	// enabled after the call.
+	SetEncryptLocalStorage(bool)
 
-	// MetricsRegistry may be nil, which should be interpreted as
-	// not using metrics at all. (i.e., as if UseNilMetrics were
-	// set). This differs from how go-metrics treats nil Registry
-	// objects, which is to use the default registry.
-	MetricsRegistry() metrics.Registry
+	metricsRegistryGetter
 	SetMetricsRegistry(metrics.Registry)
 	// TLFValidDuration is the time TLFs are valid before identification needs to be redone.
 	TLFValidDuration() time.Duration
@@ -1657,6 +1911,9 @@ type NodeCache interface {
 	PathFromNode(node Node) path
 	// AllNodes returns the complete set of nodes currently in the cache.
 	AllNodes() []Node
+	// IsEmpty returns true if no nodes are currently in the cache.
+	// Unlike AllNodes, it doesn't create any new references.
+	IsEmpty() bool
 }
 
 // fileBlockDeepCopier fetches a file block, makes a deep copy of it
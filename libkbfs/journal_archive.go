@@ -0,0 +1,726 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// journalArchiveVersion is the version of the archive format written
+// by ExportJournal.  ImportJournal refuses archives with any other
+// version.
+const journalArchiveVersion = 1
+
+// journalArchiveBlockEntry is a single block journal entry in a
+// journal archive, along with the block data for puts.
+type journalArchiveBlockEntry struct {
+	Op       blockOpType
+	Contexts kbfsblock.ContextMap `codec:",omitempty"`
+	Revision MetadataRevision     `codec:",omitempty"`
+	// Only set for blockPutOp.
+	Data       []byte `codec:",omitempty"`
+	ServerHalf kbfscrypto.BlockCryptKeyServerHalf
+}
+
+func (e journalArchiveBlockEntry) journalEntry() blockJournalEntry {
+	return blockJournalEntry{
+		Op:       e.Op,
+		Contexts: e.Contexts,
+		Revision: e.Revision,
+	}
+}
+
+// journalArchiveMD is a single MD journal entry in a journal
+// archive.
+type journalArchiveMD struct {
+	// ID is the ID of the MD on the exporting device, and is
+	// checked against Data on import.
+	ID      MdID
+	Version MetadataVer
+	// Data is the encoded BareRootMetadata.
+	Data []byte
+	// WKB and RKB are the encoded key bundles, for MDs that have
+	// them.
+	WKB    []byte `codec:",omitempty"`
+	RKB    []byte `codec:",omitempty"`
+	WKBNew bool   `codec:",omitempty"`
+	RKBNew bool   `codec:",omitempty"`
+}
+
+// journalArchiveHeader is the first record of a journal archive.
+type journalArchiveHeader struct {
+	Version int
+	UID     keybase1.UID
+	TlfID   tlf.ID
+}
+
+// journalArchiveRecord is each record after the header.  Block and
+// MD entries are written in the order they have to be replayed in on
+// import, and exactly one field of each record is set.
+type journalArchiveRecord struct {
+	Block *journalArchiveBlockEntry `codec:",omitempty"`
+	MD    *journalArchiveMD         `codec:",omitempty"`
+	// Checksum is only set in the last record, and is the SHA-256
+	// hash of every record before it, including the header, as
+	// written out.  On top of that, every block and MD in the
+	// archive is checked against its own ID on import.
+	Checksum []byte `codec:",omitempty"`
+}
+
+// maxJournalArchiveRecordSize bounds the size of a single record, so
+// that a corrupted length can't make the reader allocate an
+// arbitrary amount of memory.  Records hold at most one block.
+const maxJournalArchiveRecordSize = 4 * MaxBlockSizeBytesDefault
+
+// journalArchiveWriter writes out a journal archive one record at a
+// time.  Each record is its encoded length, as a big-endian uint32,
+// followed by the encoded record.
+type journalArchiveWriter struct {
+	codec kbfscodec.Codec
+	w     io.Writer
+	hash  hash.Hash
+}
+
+func newJournalArchiveWriter(
+	codec kbfscodec.Codec, w io.Writer) *journalArchiveWriter {
+	h := sha256.New()
+	return &journalArchiveWriter{codec, io.MultiWriter(w, h), h}
+}
+
+func (aw *journalArchiveWriter) write(obj interface{}) error {
+	buf, err := aw.codec.Encode(obj)
+	if err != nil {
+		return err
+	}
+	if len(buf) > maxJournalArchiveRecordSize {
+		return errors.Errorf(
+			"Journal archive record is too big: %d bytes", len(buf))
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(buf)))
+	_, err = aw.w.Write(size[:])
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = aw.w.Write(buf)
+	return errors.WithStack(err)
+}
+
+// finish writes out the checksum of everything written so far.
+func (aw *journalArchiveWriter) finish() error {
+	return aw.write(journalArchiveRecord{Checksum: aw.hash.Sum(nil)})
+}
+
+// journalArchiveReader reads a journal archive written by
+// journalArchiveWriter.
+type journalArchiveReader struct {
+	codec kbfscodec.Codec
+	r     io.Reader
+	hash  hash.Hash
+}
+
+func newJournalArchiveReader(
+	codec kbfscodec.Codec, r io.Reader) *journalArchiveReader {
+	return &journalArchiveReader{codec, r, sha256.New()}
+}
+
+func (ar *journalArchiveReader) read(obj interface{}) error {
+	var size [4]byte
+	_, err := io.ReadFull(ar.r, size[:])
+	if err == io.EOF {
+		return errors.New("Journal archive is truncated")
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxJournalArchiveRecordSize {
+		return errors.Errorf(
+			"Journal archive record is too big: %d bytes", n)
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(ar.r, buf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, _ = ar.hash.Write(size[:])
+	_, _ = ar.hash.Write(buf)
+	return ar.codec.Decode(buf, obj)
+}
+
+// readHeader reads the header record, which must come first.
+func (ar *journalArchiveReader) readHeader() (journalArchiveHeader, error) {
+	var header journalArchiveHeader
+	err := ar.read(&header)
+	if err != nil {
+		return journalArchiveHeader{}, err
+	}
+	if header.Version != journalArchiveVersion {
+		return journalArchiveHeader{}, errors.Errorf(
+			"Unsupported journal archive version %d", header.Version)
+	}
+	return header, nil
+}
+
+// readRecord reads the next record after the header.  When it gets
+// to the checksum record, it checks it and returns done=true, and
+// the archive mustn't have anything after that.
+func (ar *journalArchiveReader) readRecord() (
+	record journalArchiveRecord, done bool, err error) {
+	checksum := ar.hash.Sum(nil)
+	err = ar.read(&record)
+	if err != nil {
+		return journalArchiveRecord{}, false, err
+	}
+	if record.Checksum == nil {
+		return record, false, nil
+	}
+	if subtle.ConstantTimeCompare(checksum, record.Checksum) != 1 {
+		return journalArchiveRecord{}, false,
+			errors.New("Journal archive checksum mismatch")
+	}
+	var extra [1]byte
+	_, err = io.ReadFull(ar.r, extra[:])
+	if err != io.EOF {
+		return journalArchiveRecord{}, false,
+			errors.New("Journal archive has data after its checksum")
+	}
+	return journalArchiveRecord{}, true, nil
+}
+
+func makeJournalArchiveMD(codec kbfscodec.Codec,
+	ibrmd ImmutableBareRootMetadata) (journalArchiveMD, error) {
+	data, err := codec.Encode(ibrmd.BareRootMetadata)
+	if err != nil {
+		return journalArchiveMD{}, err
+	}
+	md := journalArchiveMD{
+		ID:      ibrmd.mdID,
+		Version: ibrmd.Version(),
+		Data:    data,
+	}
+	if ibrmd.extra == nil {
+		return md, nil
+	}
+
+	extraV3, ok := ibrmd.extra.(*ExtraMetadataV3)
+	if !ok {
+		return journalArchiveMD{}, errors.New("Invalid extra metadata")
+	}
+	md.WKB, err = codec.Encode(extraV3.wkb)
+	if err != nil {
+		return journalArchiveMD{}, err
+	}
+	md.RKB, err = codec.Encode(extraV3.rkb)
+	if err != nil {
+		return journalArchiveMD{}, err
+	}
+	md.WKBNew = extraV3.wkbNew
+	md.RKBNew = extraV3.rkbNew
+	return md, nil
+}
+
+// decode returns the MD and its extra metadata, after checking that
+// they match the ID they had on the exporting device.
+func (md journalArchiveMD) decode(codec kbfscodec.Codec, crypto cryptoPure,
+	tlfID tlf.ID, maxVer MetadataVer) (
+	MutableBareRootMetadata, ExtraMetadata, error) {
+	brmd, err := DecodeRootMetadata(codec, tlfID, md.Version, maxVer, md.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	mdID, err := crypto.MakeMdID(brmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mdID != md.ID {
+		return nil, nil, errors.Errorf(
+			"Metadata ID mismatch: expected %s, got %s", md.ID, mdID)
+	}
+	if brmd.TlfID() != tlfID {
+		return nil, nil, errors.Errorf(
+			"MD %s is for TLF %s, not %s", md.ID, brmd.TlfID(), tlfID)
+	}
+	if brmd.MergedStatus() != Merged {
+		return nil, nil, errors.Errorf("MD %s is unmerged", md.ID)
+	}
+
+	if len(md.WKB) == 0 && len(md.RKB) == 0 {
+		return brmd, nil, nil
+	}
+	var wkb TLFWriterKeyBundleV3
+	err = codec.Decode(md.WKB, &wkb)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkWKBID(crypto, brmd.GetTLFWriterKeyBundleID(), wkb)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rkb TLFReaderKeyBundleV3
+	err = codec.Decode(md.RKB, &rkb)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkRKBID(crypto, brmd.GetTLFReaderKeyBundleID(), rkb)
+	if err != nil {
+		return nil, nil, err
+	}
+	return brmd, NewExtraMetadataV3(wkb, rkb, md.WKBNew, md.RKBNew), nil
+}
+
+// writeArchive writes everything in the journal that hasn't been
+// flushed yet to aw, in the order it has to be replayed in on
+// import.  Each MD comes right after the block entries that come
+// before its revision marker, just like when it was originally
+// written, so the importing journal ends up flushing things in the
+// same order as this one would have.  The journal must not be on a
+// conflict branch, and the caller must hold j.flushLock so that
+// nothing gets flushed while it's being exported.
+func (j *tlfJournal) writeArchive(
+	ctx context.Context, aw *journalArchiveWriter) (
+	numBlocks, numMDs int, err error) {
+	j.journalLock.RLock()
+	defer j.journalLock.RUnlock()
+	if err := j.checkEnabledLocked(); err != nil {
+		return 0, 0, err
+	}
+
+	if bid := j.mdJournal.getBranchID(); bid != NullBranchID {
+		return 0, 0, errors.Errorf(
+			"Can't export the journal for %s while it's on branch %s",
+			j.tlfID, bid)
+	}
+
+	err = aw.write(journalArchiveHeader{
+		Version: journalArchiveVersion,
+		UID:     j.uid,
+		TlfID:   j.tlfID,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	nextRevision, err := j.mdJournal.readEarliestRevision()
+	if err != nil {
+		return 0, 0, err
+	}
+	latestRevision, err := j.mdJournal.readLatestRevision()
+	if err != nil {
+		return 0, 0, err
+	}
+	writeMDsThrough := func(rev MetadataRevision) error {
+		if nextRevision == MetadataRevisionUninitialized {
+			return nil
+		}
+		for ; nextRevision <= latestRevision; nextRevision++ {
+			if rev != MetadataRevisionUninitialized &&
+				nextRevision > rev {
+				return nil
+			}
+			ibrmds, err := j.mdJournal.getRange(
+				NullBranchID, nextRevision, nextRevision)
+			if err != nil {
+				return err
+			}
+			if len(ibrmds) != 1 {
+				return errors.Errorf(
+					"Expected one MD for revision %s, got %d",
+					nextRevision, len(ibrmds))
+			}
+			md, err := makeJournalArchiveMD(j.config.Codec(), ibrmds[0])
+			if err != nil {
+				return err
+			}
+			err = aw.write(journalArchiveRecord{MD: &md})
+			if err != nil {
+				return err
+			}
+			numMDs++
+		}
+		return nil
+	}
+
+	first, err := j.blockJournal.j.readEarliestOrdinal()
+	switch {
+	case ioutil.IsNotExist(err):
+		// The block journal is empty.
+	case err != nil:
+		return 0, 0, err
+	default:
+		end, err := j.blockJournal.end()
+		if err != nil {
+			return 0, 0, err
+		}
+		for i := first; i < end; i++ {
+			entry, err := j.blockJournal.readJournalEntry(i)
+			if err != nil {
+				return 0, 0, err
+			}
+			if entry.Ignore {
+				// Ignored entries won't ever be flushed, so
+				// there's no point in exporting them.
+				continue
+			}
+
+			archiveEntry := journalArchiveBlockEntry{
+				Op:       entry.Op,
+				Contexts: entry.Contexts,
+				Revision: entry.Revision,
+			}
+			switch entry.Op {
+			case blockPutOp:
+				id, context, err := entry.getSingleContext()
+				if err != nil {
+					return 0, 0, err
+				}
+				archiveEntry.Data, archiveEntry.ServerHalf, err =
+					j.blockJournal.getDataWithContext(id, context)
+				if err != nil {
+					return 0, 0, err
+				}
+				err = checkJournalArchiveBlock(id, archiveEntry.Data)
+				if err != nil {
+					return 0, 0, err
+				}
+			case addRefOp, archiveRefsOp:
+				// Nothing else to export.
+			case mdRevMarkerOp:
+				// Importing the MD puts a new marker in its place.
+				err := writeMDsThrough(entry.Revision)
+				if err != nil {
+					return 0, 0, err
+				}
+				continue
+			default:
+				return 0, 0, errors.Errorf(
+					"Can't export block journal entry with op %s",
+					entry.Op)
+			}
+			err = aw.write(journalArchiveRecord{Block: &archiveEntry})
+			if err != nil {
+				return 0, 0, err
+			}
+			numBlocks++
+		}
+	}
+
+	// Write any MDs whose blocks have all been flushed already.
+	err = writeMDsThrough(MetadataRevisionUninitialized)
+	if err != nil {
+		return 0, 0, err
+	}
+	return numBlocks, numMDs, aw.finish()
+}
+
+func checkJournalArchiveBlock(id kbfsblock.ID, buf []byte) error {
+	computedID, err := kbfsblock.MakePermanentID(buf)
+	if err != nil {
+		return err
+	}
+	if computedID != id {
+		return errors.Errorf(
+			"Block ID mismatch: expected %s, got %s", id, computedID)
+	}
+	return nil
+}
+
+// importArchivedMD puts the given archived MD into the journal, with
+// its previous root set to prevID if that's non-zero.  Since the MD
+// is re-signed by this device, its ID changes, so each imported MD
+// has to be relinked to the new ID of the one before it.
+func (j *tlfJournal) importArchivedMD(ctx context.Context,
+	md journalArchiveMD, prevID MdID) (MdID, error) {
+	brmd, extra, err := md.decode(j.config.Codec(), j.config.Crypto(),
+		j.tlfID, j.config.MetadataVersion())
+	if err != nil {
+		return MdID{}, err
+	}
+	if prevID != (MdID{}) {
+		brmd.SetPrevRoot(prevID)
+	}
+
+	bareHandle, err := brmd.MakeBareTlfHandle(extra)
+	if err != nil {
+		return MdID{}, err
+	}
+	handle, err := MakeTlfHandle(
+		ctx, bareHandle, j.config.usernameGetter())
+	if err != nil {
+		return MdID{}, err
+	}
+	rmd := makeRootMetadata(brmd, extra, handle)
+
+	// Assume, since journal is running, that we're in default mode.
+	pmd, err := decryptMDPrivateData(
+		ctx, j.config.Codec(), j.config.Crypto(),
+		j.config.BlockCache(), j.config.BlockOps(),
+		j.config.mdDecryptionKeyGetter(), InitDefault, j.uid,
+		rmd.GetSerializedPrivateMetadata(), rmd, rmd, j.log)
+	if err != nil {
+		return MdID{}, err
+	}
+	rmd.data = pmd
+
+	return j.putMD(ctx, rmd)
+}
+
+// checkJournalArchive reads the whole archive from ar, and checks
+// everything in it that can be checked without touching the journal.
+// It returns the archive's header.
+func checkJournalArchive(codec kbfscodec.Codec, crypto cryptoPure,
+	maxVer MetadataVer, ar *journalArchiveReader) (
+	journalArchiveHeader, error) {
+	header, err := ar.readHeader()
+	if err != nil {
+		return journalArchiveHeader{}, err
+	}
+	for {
+		record, done, err := ar.readRecord()
+		if err != nil {
+			return journalArchiveHeader{}, err
+		}
+		if done {
+			return header, nil
+		}
+		switch {
+		case record.Block != nil && record.Block.Op == blockPutOp:
+			id, _, err := record.Block.journalEntry().getSingleContext()
+			if err != nil {
+				return journalArchiveHeader{}, err
+			}
+			err = checkJournalArchiveBlock(id, record.Block.Data)
+			if err != nil {
+				return journalArchiveHeader{}, err
+			}
+		case record.MD != nil:
+			_, _, err := record.MD.decode(codec, crypto, header.TlfID, maxVer)
+			if err != nil {
+				return journalArchiveHeader{}, err
+			}
+		}
+	}
+}
+
+// importArchive replays the archive read from ar, whose header has
+// already been read, into the journal, which must be empty.  The
+// archive should already have been checked with checkJournalArchive.
+func (j *tlfJournal) importArchive(ctx context.Context,
+	header journalArchiveHeader, ar *journalArchiveReader) (
+	numBlocks, numMDs int, err error) {
+	if header.UID != j.uid {
+		return 0, 0, errors.Errorf("Archive is for user %s, not %s",
+			header.UID, j.uid)
+	}
+	if header.TlfID != j.tlfID {
+		return 0, 0, errors.Errorf("Archive is for TLF %s, not %s",
+			header.TlfID, j.tlfID)
+	}
+
+	blockEntryCount, mdEntryCount, err := j.getJournalEntryCounts()
+	if err != nil {
+		return 0, 0, err
+	}
+	if blockEntryCount != 0 || mdEntryCount != 0 {
+		return 0, 0, errors.Errorf("The journal for %s still has %d "+
+			"block entries and %d MD entries; flush it before importing",
+			j.tlfID, blockEntryCount, mdEntryCount)
+	}
+
+	var prevID MdID
+	for {
+		record, done, err := ar.readRecord()
+		if err != nil {
+			return 0, 0, err
+		}
+		if done {
+			return numBlocks, numMDs, nil
+		}
+
+		if record.MD != nil {
+			prevID, err = j.importArchivedMD(ctx, *record.MD, prevID)
+			if err != nil {
+				return 0, 0, err
+			}
+			numMDs++
+			continue
+		}
+		if record.Block == nil {
+			return 0, 0, errors.New("Empty journal archive record")
+		}
+
+		e := record.Block
+		switch e.Op {
+		case blockPutOp:
+			id, context, err := e.journalEntry().getSingleContext()
+			if err != nil {
+				return 0, 0, err
+			}
+			err = j.putBlockData(ctx, id, context, e.Data, e.ServerHalf)
+			if err != nil {
+				return 0, 0, err
+			}
+		case addRefOp:
+			id, context, err := e.journalEntry().getSingleContext()
+			if err != nil {
+				return 0, 0, err
+			}
+			err = j.addBlockReference(ctx, id, context)
+			if err != nil {
+				return 0, 0, err
+			}
+		case archiveRefsOp:
+			err := j.archiveBlockReferences(ctx, e.Contexts)
+			if err != nil {
+				return 0, 0, err
+			}
+		default:
+			return 0, 0, errors.Errorf(
+				"Can't import block journal entry with op %s", e.Op)
+		}
+		numBlocks++
+	}
+}
+
+// ExportJournal writes everything in the journal for the given TLF
+// that hasn't been flushed yet to w, as an archive that ImportJournal
+// can read on another device of the same user.  Once the archive has
+// been imported elsewhere, this device must not flush the same
+// writes itself, so if clear is true, the journal is removed from
+// this device once it's been written out, after syncing w if it has
+// a Sync method, like *os.File; nothing else should be using the TLF
+// then.  Otherwise the journal is left as it is, and
+// its background work should be paused while exporting.
+func (j *JournalServer) ExportJournal(ctx context.Context, tlfID tlf.ID,
+	w io.Writer, clear bool) (err error) {
+	j.log.CDebugf(ctx, "Exporting journal for %s (clear=%t)", tlfID, clear)
+	defer func() {
+		if err != nil {
+			j.deferLog.CDebugf(ctx,
+				"Error when exporting journal for %s: %+v", tlfID, err)
+		}
+	}()
+
+	j.lock.RLock()
+	tlfJournal, ok := j.tlfJournals[tlfID]
+	j.lock.RUnlock()
+	if !ok {
+		return errors.Errorf("Journal not enabled for %s", tlfID)
+	}
+
+	if clear {
+		// Stop the background work for good, so that it can't
+		// flush anything between the export and the removal.
+		// This has to happen before taking flushLock, which a
+		// background flush might be waiting for.
+		tlfJournal.stopBackgroundWork()
+	}
+	tlfJournal.flushLock.Lock()
+	defer tlfJournal.flushLock.Unlock()
+
+	numBlocks, numMDs, err := tlfJournal.writeArchive(
+		ctx, newJournalArchiveWriter(j.config.Codec(), w))
+	if err != nil {
+		return err
+	}
+	j.log.CDebugf(ctx, "Exported %d block entries and %d MDs for %s",
+		numBlocks, numMDs, tlfID)
+	if !clear {
+		return nil
+	}
+
+	if s, ok := w.(interface {
+		Sync() error
+	}); ok {
+		err = s.Sync()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	tlfJournal.shutdown(ctx)
+	if j.tlfJournals[tlfID] == tlfJournal {
+		delete(j.tlfJournals, tlfID)
+	}
+	err = ioutil.RemoveAll(tlfJournal.dir)
+	if err != nil {
+		return err
+	}
+	j.log.CDebugf(ctx, "Removed the exported journal for %s", tlfID)
+	return nil
+}
+
+// ImportJournal reads an archive written by ExportJournal from r,
+// checks its integrity, and puts its contents into the journal for
+// the archive's TLF on this device, which is enabled with the given
+// background work status if needed.  That journal must be empty.
+// The archive is read twice, so that nothing is put into the journal
+// unless all of it checks out, but it's never held in memory all at
+// once.  The imported MDs are re-signed by this device, so that it
+// can flush them as if it had written them itself.  It returns the
+// ID of the TLF.
+func (j *JournalServer) ImportJournal(
+	ctx context.Context, r io.ReadSeeker, bws TLFJournalBackgroundWorkStatus) (
+	tlfID tlf.ID, err error) {
+	j.log.CDebugf(ctx, "Importing journal")
+	defer func() {
+		if err != nil {
+			j.deferLog.CDebugf(ctx,
+				"Error when importing journal: %+v", err)
+		}
+	}()
+
+	codec := j.config.Codec()
+	header, err := checkJournalArchive(codec, j.config.Crypto(),
+		j.config.MetadataVersion(), newJournalArchiveReader(codec, r))
+	if err != nil {
+		return tlf.ID{}, err
+	}
+	tlfID = header.TlfID
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return tlf.ID{}, errors.WithStack(err)
+	}
+	ar := newJournalArchiveReader(codec, r)
+	_, err = ar.readHeader()
+	if err != nil {
+		return tlf.ID{}, err
+	}
+
+	err = j.Enable(ctx, tlfID, bws)
+	if err != nil {
+		return tlf.ID{}, err
+	}
+	j.lock.RLock()
+	tlfJournal, ok := j.tlfJournals[tlfID]
+	j.lock.RUnlock()
+	if !ok {
+		return tlf.ID{}, errors.Errorf("Journal not enabled for %s", tlfID)
+	}
+
+	numBlocks, numMDs, err := tlfJournal.importArchive(ctx, header, ar)
+	if err != nil {
+		return tlf.ID{}, err
+	}
+
+	j.log.CDebugf(ctx, "Imported %d block entries and %d MDs for %s",
+		numBlocks, numMDs, tlfID)
+	return tlfID, nil
+}
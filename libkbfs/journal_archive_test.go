@@ -0,0 +1,154 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournalServerExportImport(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	tlfID := tlf.FakeID(2, false)
+	err := jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	blockServer := config.BlockServer()
+	mdOps := config.MDOps()
+
+	h, err := ParseTlfHandle(ctx, config.KBPKI(), "test_user1", false)
+	require.NoError(t, err)
+	uid := h.ResolvedWriters()[0]
+
+	// Put a block and two MDs.
+
+	bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = blockServer.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	rmd, err := makeInitialRootMetadata(config.MetadataVersion(), tlfID, h)
+	require.NoError(t, err)
+	rekeyDone, _, err := config.KeyManager().Rekey(ctx, rmd, false)
+	require.NoError(t, err)
+	require.True(t, rekeyDone)
+	mdID, err := mdOps.Put(ctx, rmd)
+	require.NoError(t, err)
+
+	rmd2, err := rmd.MakeSuccessor(ctx, config.MetadataVersion(),
+		config.Codec(), config.Crypto(), config.KeyManager(), mdID, true)
+	require.NoError(t, err)
+	_, err = mdOps.Put(ctx, rmd2)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = jServer.ExportJournal(ctx, tlfID, &buf, false)
+	require.NoError(t, err)
+
+	// Import into a journal server with its own directory, which
+	// stands in for another device.
+
+	tempdir2, err := ioutil.TempDir(os.TempDir(), "journal_server")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir2)
+		assert.NoError(t, err)
+	}()
+	jServer2 := makeJournalServer(
+		config, jServer.log, tempdir2, jServer.delegateBlockCache,
+		jServer.delegateDirtyBlockCache,
+		jServer.delegateBlockServer, jServer.delegateMDOps, nil, nil)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	err = jServer2.EnableExistingJournals(
+		ctx, session.UID, session.VerifyingKey, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	defer jServer2.shutdownExistingJournals(ctx)
+
+	// Corrupted and truncated archives are rejected before anything
+	// is imported.
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	_, err = jServer2.ImportJournal(
+		ctx, bytes.NewReader(corrupted), TLFJournalBackgroundWorkPaused)
+	require.Error(t, err)
+	_, err = jServer2.ImportJournal(ctx,
+		bytes.NewReader(buf.Bytes()[:buf.Len()-1]),
+		TLFJournalBackgroundWorkPaused)
+	require.Error(t, err)
+	require.False(t, jServer2.hasTLFJournal(tlfID))
+
+	importedID, err := jServer2.ImportJournal(
+		ctx, bytes.NewReader(buf.Bytes()), TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	require.Equal(t, tlfID, importedID)
+
+	tlfJournal, ok := jServer.getTLFJournal(tlfID)
+	require.True(t, ok)
+	tlfJournal2, ok := jServer2.getTLFJournal(tlfID)
+	require.True(t, ok)
+	blockCount, mdCount, err := tlfJournal.getJournalEntryCounts()
+	require.NoError(t, err)
+	blockCount2, mdCount2, err := tlfJournal2.getJournalEntryCounts()
+	require.NoError(t, err)
+	require.Equal(t, blockCount, blockCount2)
+	require.Equal(t, mdCount, mdCount2)
+
+	buf2, key2, err := tlfJournal2.getBlockData(bID)
+	require.NoError(t, err)
+	require.Equal(t, data, buf2)
+	require.Equal(t, serverHalf, key2)
+
+	// The MDs were re-signed, and are still chained together.
+	ibrmds, err := tlfJournal2.getMDRange(
+		ctx, NullBranchID, rmd.Revision(), rmd2.Revision())
+	require.NoError(t, err)
+	require.Len(t, ibrmds, 2)
+	require.Equal(t, ibrmds[0].mdID, ibrmds[1].GetPrevRoot())
+
+	// The journal isn't empty anymore, so it can't be imported
+	// into again.
+	_, err = jServer2.ImportJournal(
+		ctx, bytes.NewReader(buf.Bytes()), TLFJournalBackgroundWorkPaused)
+	require.Error(t, err)
+
+	// Exporting with clear set writes out the same archive, and then
+	// removes the journal, so this device can't flush it too.
+	var buf3 bytes.Buffer
+	err = jServer.ExportJournal(ctx, tlfID, &buf3, true)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), buf3.Bytes())
+	require.False(t, jServer.hasTLFJournal(tlfID))
+	_, err = ioutil.Stat(tlfJournal.dir)
+	require.True(t, ioutil.IsNotExist(err))
+
+	// And the imported journal can be flushed.
+	err = jServer2.Flush(ctx, tlfID)
+	require.NoError(t, err)
+	rmds, err := config.MDServer().GetForTLF(
+		ctx, tlfID, NullBranchID, Merged)
+	require.NoError(t, err)
+	require.Equal(t, rmd2.Revision(), rmds.MD.RevisionNumber())
+	buf2, key2, err = jServer.delegateBlockServer.Get(
+		ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf2)
+	require.Equal(t, serverHalf, key2)
+}
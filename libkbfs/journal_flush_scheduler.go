@@ -0,0 +1,326 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// JournalFlushPriority is the user-set priority of a TLF's journal,
+// which decides which journals get to put their blocks and MDs to
+// the servers first when several of them are flushing at once.
+type JournalFlushPriority int
+
+const (
+	// JournalFlushPriorityLow journals only flush once no other
+	// journal is waiting to.
+	JournalFlushPriorityLow JournalFlushPriority = -1
+	// JournalFlushPriorityNormal is the priority of journals
+	// that the user hasn't set one for.
+	JournalFlushPriorityNormal JournalFlushPriority = 0
+	// JournalFlushPriorityHigh journals flush ahead of all others.
+	JournalFlushPriorityHigh JournalFlushPriority = 1
+)
+
+func (p JournalFlushPriority) String() string {
+	switch p {
+	case JournalFlushPriorityLow:
+		return "low"
+	case JournalFlushPriorityNormal:
+		return "normal"
+	case JournalFlushPriorityHigh:
+		return "high"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseJournalFlushPriority parses "low", "normal" or "high".
+func ParseJournalFlushPriority(s string) (JournalFlushPriority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return JournalFlushPriorityLow, nil
+	case "normal":
+		return JournalFlushPriorityNormal, nil
+	case "high":
+		return JournalFlushPriorityHigh, nil
+	default:
+		return 0, errors.Errorf("Unknown journal flush priority %q", s)
+	}
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for
+// JournalFlushPriority, so that it shows up by name in JSON.
+func (p JournalFlushPriority) MarshalText() ([]byte, error) {
+	if p < JournalFlushPriorityLow || p > JournalFlushPriorityHigh {
+		return nil, errors.Errorf("Unknown journal flush priority %d", p)
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+// for JournalFlushPriority.
+func (p *JournalFlushPriority) UnmarshalText(data []byte) error {
+	parsed, err := ParseJournalFlushPriority(string(data))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+const (
+	// journalFlushSmallUnflushedBytes is the number of unflushed
+	// bytes below which a journal is considered small, and so is
+	// let through ahead of bigger journals with the same
+	// user-set priority.
+	journalFlushSmallUnflushedBytes = 1 << 20
+)
+
+// journalFlushAutoPriority returns the automatic priority of a
+// journal with the given number of unflushed bytes.  Journals with
+// only MDs (and block references) left to flush come first, since
+// they're quick to finish and usually hold small, urgent edits;
+// then small journals; then everything else.
+func journalFlushAutoPriority(unflushedBytes int64) int {
+	switch {
+	case unflushedBytes <= 0:
+		return 2
+	case unflushedBytes < journalFlushSmallUnflushedBytes:
+		return 1
+	default:
+		return 0
+	}
+}
+
+type journalFlushRequest struct {
+	tlfID        tlf.ID
+	slots        int
+	priority     JournalFlushPriority
+	autoPriority int
+	seq          uint64
+	grantedCh    chan struct{}
+}
+
+// before returns whether r should be let through ahead of other.
+func (r *journalFlushRequest) before(other *journalFlushRequest) bool {
+	if r.priority != other.priority {
+		return r.priority > other.priority
+	}
+	if r.autoPriority != other.autoPriority {
+		return r.autoPriority > other.autoPriority
+	}
+	return r.seq < other.seq
+}
+
+// JournalFlushQueueEntry describes a journal that is waiting for its
+// turn to flush, for display in diagnostics.
+type JournalFlushQueueEntry struct {
+	TlfID tlf.ID
+	// Position is 1 for the journal that will be let through next.
+	Position     int
+	Priority     JournalFlushPriority
+	AutoPriority int
+	// Slots is the number of block or MD puts the journal is
+	// waiting to start.
+	Slots int
+}
+
+// journalFlushScheduler shares a fixed number of in-flight block and
+// MD puts between the journals of all TLFs.  Each journal asks for
+// as many slots as the batch it's about to flush, and waiting
+// journals are let through in order of their user-set priority,
+// then their automatic priority, then how long they've been
+// waiting.  Since a journal has to queue up again after each batch,
+// journals with the same priorities take turns.
+type journalFlushScheduler struct {
+	maxInFlight int
+
+	lock       sync.Mutex
+	priorities map[tlf.ID]JournalFlushPriority
+	inFlight   int
+	nextSeq    uint64
+	// waiting is kept in the order the requests will be granted.
+	waiting []*journalFlushRequest
+}
+
+func newJournalFlushScheduler(maxInFlight int) *journalFlushScheduler {
+	return &journalFlushScheduler{
+		maxInFlight: maxInFlight,
+		priorities:  make(map[tlf.ID]JournalFlushPriority),
+	}
+}
+
+func (s *journalFlushScheduler) insertLocked(r *journalFlushRequest) {
+	i := len(s.waiting)
+	for i > 0 && r.before(s.waiting[i-1]) {
+		i--
+	}
+	s.waiting = append(s.waiting, nil)
+	copy(s.waiting[i+1:], s.waiting[i:])
+	s.waiting[i] = r
+}
+
+func (s *journalFlushScheduler) removeLocked(r *journalFlushRequest) bool {
+	for i, w := range s.waiting {
+		if w == r {
+			s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// grantLocked lets through as many waiting requests, in order, as
+// there are free slots for.  A request that wants more slots than
+// there are in total is let through once nothing else is in flight.
+func (s *journalFlushScheduler) grantLocked() {
+	for len(s.waiting) > 0 {
+		r := s.waiting[0]
+		if s.inFlight > 0 && s.inFlight+r.slots > s.maxInFlight {
+			return
+		}
+		s.waiting = s.waiting[1:]
+		s.inFlight += r.slots
+		close(r.grantedCh)
+	}
+}
+
+// acquire blocks until the journal for the given TLF, which has the
+// given number of unflushed bytes, may start the given number of
+// block or MD puts.  If it returns nil, the caller must call
+// release with the same number of slots once the puts are done.  A
+// nil scheduler lets everything through right away.
+func (s *journalFlushScheduler) acquire(ctx context.Context, tlfID tlf.ID,
+	slots int, unflushedBytes int64) error {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	r := &journalFlushRequest{
+		tlfID:        tlfID,
+		slots:        slots,
+		priority:     s.priorities[tlfID],
+		autoPriority: journalFlushAutoPriority(unflushedBytes),
+		seq:          s.nextSeq,
+		grantedCh:    make(chan struct{}),
+	}
+	s.nextSeq++
+	s.insertLocked(r)
+	s.grantLocked()
+	s.lock.Unlock()
+
+	select {
+	case <-r.grantedCh:
+		return nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if !s.removeLocked(r) {
+			// Granted in the meantime, so give the slots back.
+			s.inFlight -= r.slots
+		}
+		s.grantLocked()
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// release returns slots taken by a successful acquire.
+func (s *journalFlushScheduler) release(slots int) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.inFlight -= slots
+	if s.inFlight < 0 {
+		panic("Released more journal flush slots than were acquired")
+	}
+	s.grantLocked()
+}
+
+// setPriorities replaces all the user-set priorities.
+func (s *journalFlushScheduler) setPriorities(
+	priorities map[tlf.ID]JournalFlushPriority) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.priorities = make(map[tlf.ID]JournalFlushPriority)
+	for tlfID, p := range priorities {
+		if p != JournalFlushPriorityNormal {
+			s.priorities[tlfID] = p
+		}
+	}
+}
+
+// setPriority sets the priority of the given TLF's journal,
+// including for any request it already has waiting.
+func (s *journalFlushScheduler) setPriority(
+	tlfID tlf.ID, p JournalFlushPriority) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if p == JournalFlushPriorityNormal {
+		delete(s.priorities, tlfID)
+	} else {
+		s.priorities[tlfID] = p
+	}
+
+	var changed []*journalFlushRequest
+	for _, r := range s.waiting {
+		if r.tlfID == tlfID {
+			changed = append(changed, r)
+		}
+	}
+	for _, r := range changed {
+		s.removeLocked(r)
+		r.priority = p
+		s.insertLocked(r)
+	}
+	s.grantLocked()
+}
+
+func (s *journalFlushScheduler) getPriority(
+	tlfID tlf.ID) JournalFlushPriority {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.priorities[tlfID]
+}
+
+// getStatus returns the number of puts in flight, and the waiting
+// journals in the order they'll be let through.
+func (s *journalFlushScheduler) getStatus() (
+	inFlight int, queue []JournalFlushQueueEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, r := range s.waiting {
+		queue = append(queue, JournalFlushQueueEntry{
+			TlfID:        r.tlfID,
+			Position:     i + 1,
+			Priority:     r.priority,
+			AutoPriority: r.autoPriority,
+			Slots:        r.slots,
+		})
+	}
+	return s.inFlight, queue
+}
+
+// getQueuePosition returns the position of the given TLF's journal
+// in the queue, or 0 if it isn't waiting.
+func (s *journalFlushScheduler) getQueuePosition(tlfID tlf.ID) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, r := range s.waiting {
+		if r.tlfID == tlfID {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,171 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestJournalFlushPriorityParse(t *testing.T) {
+	p, err := ParseJournalFlushPriority(" High\n")
+	require.NoError(t, err)
+	require.Equal(t, JournalFlushPriorityHigh, p)
+	_, err = ParseJournalFlushPriority("urgent")
+	require.Error(t, err)
+
+	buf, err := json.Marshal(map[tlf.ID]JournalFlushPriority{
+		tlf.FakeID(1, false): JournalFlushPriorityLow,
+	})
+	require.NoError(t, err)
+	var priorities map[tlf.ID]JournalFlushPriority
+	err = json.Unmarshal(buf, &priorities)
+	require.NoError(t, err)
+	require.Equal(t, JournalFlushPriorityLow, priorities[tlf.FakeID(1, false)])
+}
+
+// acquireInBackground starts an acquire for the given TLF, and
+// waits until it's in the queue.
+func acquireInBackground(t *testing.T, s *journalFlushScheduler,
+	ctx context.Context, tlfID tlf.ID, slots int,
+	unflushedBytes int64) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.acquire(ctx, tlfID, slots, unflushedBytes)
+	}()
+	for s.getQueuePosition(tlfID) == 0 {
+		select {
+		case err := <-errCh:
+			errCh <- err
+			return errCh
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return errCh
+}
+
+func requireNotGranted(t *testing.T, errCh <-chan error) {
+	select {
+	case err := <-errCh:
+		t.Fatalf("Unexpectedly granted: %+v", err)
+	default:
+	}
+}
+
+func TestJournalFlushSchedulerOrder(t *testing.T) {
+	s := newJournalFlushScheduler(10)
+	ctx := context.Background()
+	bigID := tlf.FakeID(1, false)
+	smallID := tlf.FakeID(2, false)
+	mdOnlyID := tlf.FakeID(3, false)
+	highID := tlf.FakeID(4, false)
+
+	// Fill up all the slots.
+	err := s.acquire(ctx, bigID, 10, 1<<30)
+	require.NoError(t, err)
+
+	bigCh := acquireInBackground(t, s, ctx, bigID, 10, 1<<30)
+	smallCh := acquireInBackground(t, s, ctx, smallID, 5, 1<<10)
+	mdOnlyCh := acquireInBackground(t, s, ctx, mdOnlyID, 1, 0)
+	highCh := acquireInBackground(t, s, ctx, highID, 5, 1<<30)
+
+	inFlight, queue := s.getStatus()
+	require.Equal(t, 10, inFlight)
+	require.Len(t, queue, 4)
+	var order []tlf.ID
+	for i, e := range queue {
+		require.Equal(t, i+1, e.Position)
+		order = append(order, e.TlfID)
+	}
+	require.Equal(t, []tlf.ID{mdOnlyID, smallID, bigID, highID}, order,
+		"Nothing has a user-set priority yet, so by auto-priority")
+	require.Equal(t, 4, s.getQueuePosition(highID))
+
+	// Raising the priority of a waiting journal moves it up.
+	s.setPriority(highID, JournalFlushPriorityHigh)
+	require.Equal(t, 1, s.getQueuePosition(highID))
+	require.Equal(t, 2, s.getQueuePosition(mdOnlyID))
+	require.Equal(t, 3, s.getQueuePosition(smallID))
+	require.Equal(t, 4, s.getQueuePosition(bigID))
+
+	// Freeing up slots lets waiters through in order, as long as
+	// they fit.
+	s.release(10)
+	require.NoError(t, <-highCh)
+	require.NoError(t, <-mdOnlyCh)
+	requireNotGranted(t, smallCh)
+	require.Equal(t, 1, s.getQueuePosition(smallID))
+
+	s.release(5)
+	require.NoError(t, <-smallCh)
+	requireNotGranted(t, bigCh)
+
+	// A request for more than fits still goes through once nothing
+	// else is in flight.
+	s.release(5)
+	requireNotGranted(t, bigCh)
+	s.release(1)
+	require.NoError(t, <-bigCh)
+	s.release(10)
+
+	inFlight, queue = s.getStatus()
+	require.Equal(t, 0, inFlight)
+	require.Len(t, queue, 0)
+}
+
+func TestJournalFlushSchedulerTakeTurns(t *testing.T) {
+	s := newJournalFlushScheduler(1)
+	ctx := context.Background()
+	id1 := tlf.FakeID(1, false)
+	id2 := tlf.FakeID(2, false)
+
+	err := s.acquire(ctx, id1, 1, 1<<30)
+	require.NoError(t, err)
+	ch2 := acquireInBackground(t, s, ctx, id2, 1, 1<<30)
+	ch1 := acquireInBackground(t, s, ctx, id1, 1, 1<<30)
+
+	// The second journal has been waiting longer, so it goes
+	// before the first journal's next batch.
+	s.release(1)
+	require.NoError(t, <-ch2)
+	requireNotGranted(t, ch1)
+	s.release(1)
+	require.NoError(t, <-ch1)
+	s.release(1)
+}
+
+func TestJournalFlushSchedulerCancel(t *testing.T) {
+	s := newJournalFlushScheduler(1)
+	ctx := context.Background()
+	id1 := tlf.FakeID(1, false)
+	id2 := tlf.FakeID(2, false)
+
+	err := s.acquire(ctx, id1, 1, 0)
+	require.NoError(t, err)
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	canceledCh := acquireInBackground(t, s, cancelCtx, id1, 1, 0)
+	ch2 := acquireInBackground(t, s, ctx, id2, 1, 1<<30)
+	cancel()
+	err = <-canceledCh
+	require.Equal(t, context.Canceled, errors.Cause(err))
+	require.Equal(t, 0, s.getQueuePosition(id1))
+	require.Equal(t, 1, s.getQueuePosition(id2))
+
+	s.release(1)
+	require.NoError(t, <-ch2)
+	s.release(1)
+
+	// A nil scheduler lets everything through.
+	var nilScheduler *journalFlushScheduler
+	require.NoError(t, nilScheduler.acquire(ctx, id1, 1000, 0))
+	nilScheduler.release(1000)
+}
@@ -0,0 +1,592 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// JournalInspector reads the write journals under a storage root,
+// for inspection while no KBFS instance is using them.  It never
+// changes anything on disk.
+type JournalInspector struct {
+	codec  kbfscodec.Codec
+	crypto cryptoPure
+	log    logger.Logger
+	root   string
+}
+
+// OpenJournalsOffline opens the write journals under the given
+// storage root for inspection.  Only unencrypted journals can be
+// read this way, since the key for encrypted ones comes from the
+// logged-in device.
+func OpenJournalsOffline(storageRoot string, log logger.Logger) (
+	*JournalInspector, error) {
+	return newJournalInspector(journalDirFromStorageRoot(storageRoot), log)
+}
+
+// newJournalInspector returns a JournalInspector for the journals of
+// the journal server with the given directory.
+func newJournalInspector(dir string, log logger.Logger) (
+	*JournalInspector, error) {
+	root := journalServerRootPath(dir)
+	_, err := ioutil.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	codec := kbfscodec.NewMsgpack()
+	RegisterOps(codec)
+	return &JournalInspector{
+		codec:  codec,
+		crypto: MakeCryptoCommon(codec),
+		log:    log,
+		root:   root,
+	}, nil
+}
+
+// JournalTLFInfo identifies the journal of a TLF for a particular
+// user and device.
+type JournalTLFInfo struct {
+	TlfID        tlf.ID
+	UID          keybase1.UID
+	VerifyingKey kbfscrypto.VerifyingKey
+	Dir          string
+}
+
+// TLFs returns the TLF journals under the storage root, along with
+// the directories that look like journals but whose info can't be
+// read, e.g. because they're encrypted.
+func (ji *JournalInspector) TLFs(ctx context.Context) (
+	infos []JournalTLFInfo, unreadable []string, err error) {
+	fileInfos, err := ioutil.ReadDir(ji.root)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, fi := range fileInfos {
+		if !fi.IsDir() {
+			continue
+		}
+		dir := filepath.Join(ji.root, fi.Name())
+		uid, key, tlfID, err := readTLFJournalInfoFile(dir, nil)
+		if err != nil {
+			ji.log.CDebugf(ctx, "Couldn't read the info of %q: %+v", dir, err)
+			unreadable = append(unreadable, dir)
+			continue
+		}
+		infos = append(infos, JournalTLFInfo{
+			TlfID:        tlfID,
+			UID:          uid,
+			VerifyingKey: key,
+			Dir:          dir,
+		})
+	}
+	return infos, unreadable, nil
+}
+
+// JournalEntryState says how far a journal entry has got towards
+// being flushed.
+type JournalEntryState string
+
+const (
+	// JournalEntryUnflushed entries are waiting to be flushed.
+	JournalEntryUnflushed JournalEntryState = "unflushed"
+	// JournalEntryIgnored entries will never be flushed, because
+	// their MD revision was squashed away.
+	JournalEntryIgnored JournalEntryState = "ignored"
+	// JournalEntryFlushed entries have been flushed, and their
+	// blocks are waiting to be cleaned up after the next MD flush.
+	JournalEntryFlushed JournalEntryState = "flushed"
+	// JournalEntryOnBranch MDs are on a branch, and can only be
+	// flushed once it's resolved.
+	JournalEntryOnBranch JournalEntryState = "on branch"
+)
+
+// JournalBlockEntryInfo describes an entry in the block journal of a
+// TLF.
+type JournalBlockEntryInfo struct {
+	Ordinal uint64
+	Op      string
+	IDs     []kbfsblock.ID
+	// Revision is only set for MD revision markers.
+	Revision MetadataRevision
+	// Bytes is the size of the block data, for block puts.
+	Bytes       int64
+	LocalSquash bool
+	State       JournalEntryState
+	// Error is set if the entry couldn't be read completely.
+	Error string
+}
+
+// JournalMDEntryInfo describes an entry in the MD journal of a TLF.
+type JournalMDEntryInfo struct {
+	Revision    MetadataRevision
+	ID          MdID
+	BID         BranchID
+	PrevRoot    MdID
+	Bytes       int64
+	Timestamp   time.Time
+	LocalSquash bool
+	State       JournalEntryState
+	// Error is set if the MD couldn't be read or verified.
+	Error string
+}
+
+// JournalListing is every entry in the journal of a TLF.
+type JournalListing struct {
+	Blocks []JournalBlockEntryInfo
+	MDs    []JournalMDEntryInfo
+}
+
+func (ji *JournalInspector) open(ctx context.Context, info JournalTLFInfo) (
+	*blockJournal, *mdJournal, error) {
+	blocks, err := makeBlockJournal(ctx, ji.codec, info.Dir, nil, ji.log)
+	if err != nil {
+		return nil, nil, err
+	}
+	idJournal, err := makeMdIDJournal(ji.codec, mdJournalPath(info.Dir))
+	if err != nil {
+		return nil, nil, err
+	}
+	// Build the MD journal directly instead of with makeMDJournal,
+	// which refuses to open journals with bad MDs at either end.
+	mds := &mdJournal{
+		uid:      info.UID,
+		key:      info.VerifyingKey,
+		codec:    ji.codec,
+		crypto:   ji.crypto,
+		clock:    wallClock{},
+		tlfID:    info.TlfID,
+		mdVer:    defaultClientMetadataVer,
+		dir:      info.Dir,
+		log:      ji.log,
+		deferLog: ji.log.CloneWithAddedDepth(1),
+		j:        idJournal,
+	}
+	return blocks, mds, nil
+}
+
+// diskJournalRange returns the first ordinal of the given journal
+// and the one past its last, which are equal if it's empty.
+func diskJournalRange(j *diskJournal) (
+	first, end journalOrdinal, err error) {
+	first, err = j.readEarliestOrdinal()
+	if ioutil.IsNotExist(err) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+	last, err := j.readLatestOrdinal()
+	if err != nil {
+		return 0, 0, err
+	}
+	return first, last + 1, nil
+}
+
+func sortedContextIDs(contexts kbfsblock.ContextMap) []kbfsblock.ID {
+	idStrs := make([]string, 0, len(contexts))
+	ids := make(map[string]kbfsblock.ID, len(contexts))
+	for id := range contexts {
+		idStrs = append(idStrs, id.String())
+		ids[id.String()] = id
+	}
+	sort.Strings(idStrs)
+	sorted := make([]kbfsblock.ID, 0, len(idStrs))
+	for _, s := range idStrs {
+		sorted = append(sorted, ids[s])
+	}
+	return sorted
+}
+
+func listBlockJournalEntries(blocks *blockJournal, j *diskJournal,
+	flushed bool) ([]JournalBlockEntryInfo, error) {
+	first, end, err := diskJournalRange(j)
+	if err != nil {
+		return nil, err
+	}
+	var infos []JournalBlockEntryInfo
+	for i := first; i < end; i++ {
+		info := JournalBlockEntryInfo{
+			Ordinal: uint64(i),
+			State:   JournalEntryUnflushed,
+		}
+		e, err := j.readJournalEntry(i)
+		if err != nil {
+			info.Error = err.Error()
+			infos = append(infos, info)
+			continue
+		}
+		entry := e.(blockJournalEntry)
+		info.Op = entry.Op.String()
+		info.IDs = sortedContextIDs(entry.Contexts)
+		info.Revision = entry.Revision
+		info.LocalSquash = entry.IsLocalSquash
+		switch {
+		case flushed:
+			info.State = JournalEntryFlushed
+		case entry.Ignore:
+			info.State = JournalEntryIgnored
+		}
+		// Flushed blocks may already have been cleaned up, so
+		// only look for the data of unflushed ones.
+		if entry.Op == blockPutOp && len(info.IDs) == 1 && !flushed {
+			hasData, err := blocks.s.hasData(info.IDs[0])
+			if err == nil && !hasData {
+				err = errors.New("The block data is missing")
+			}
+			if err == nil {
+				info.Bytes, err = blocks.getDataSize(info.IDs[0])
+			}
+			if err != nil {
+				info.Error = err.Error()
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (ji *JournalInspector) listMDs(mds *mdJournal) (
+	[]JournalMDEntryInfo, error) {
+	first, err := mds.readEarliestRevision()
+	if err != nil {
+		return nil, err
+	}
+	if first == MetadataRevisionUninitialized {
+		return nil, nil
+	}
+	last, err := mds.readLatestRevision()
+	if err != nil {
+		return nil, err
+	}
+	var infos []JournalMDEntryInfo
+	for rev := first; rev <= last; rev++ {
+		info := JournalMDEntryInfo{
+			Revision: rev,
+			State:    JournalEntryUnflushed,
+		}
+		entry, err := mds.j.readJournalEntry(rev)
+		if err != nil {
+			info.Error = err.Error()
+			infos = append(infos, info)
+			continue
+		}
+		info.ID = entry.ID
+		info.LocalSquash = entry.IsLocalSquash
+		if fi, err := ioutil.Stat(mds.mdDataPath(entry.ID)); err == nil {
+			info.Bytes = fi.Size()
+		}
+		brmd, _, ts, err := mds.getMDAndExtra(entry, false)
+		if err != nil {
+			info.Error = err.Error()
+			infos = append(infos, info)
+			continue
+		}
+		info.BID = brmd.BID()
+		info.PrevRoot = brmd.GetPrevRoot()
+		info.Timestamp = ts
+		if info.BID != NullBranchID {
+			info.State = JournalEntryOnBranch
+		}
+		if brmd.RevisionNumber() != rev {
+			info.Error = fmt.Sprintf(
+				"Stored under revision %d, but has revision %d",
+				rev, brmd.RevisionNumber())
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// List describes every entry in the given TLF journal, including the
+// flushed block entries that are waiting to be cleaned up.
+func (ji *JournalInspector) List(ctx context.Context,
+	info JournalTLFInfo) (JournalListing, error) {
+	blocks, mds, err := ji.open(ctx, info)
+	if err != nil {
+		return JournalListing{}, err
+	}
+	flushed, err := listBlockJournalEntries(blocks, blocks.deferredGC, true)
+	if err != nil {
+		return JournalListing{}, err
+	}
+	unflushed, err := listBlockJournalEntries(blocks, blocks.j, false)
+	if err != nil {
+		return JournalListing{}, err
+	}
+	mdInfos, err := ji.listMDs(mds)
+	if err != nil {
+		return JournalListing{}, err
+	}
+	return JournalListing{
+		Blocks: append(flushed, unflushed...),
+		MDs:    mdInfos,
+	}, nil
+}
+
+// JournalMDDetail describes a journaled MD, along with the ops it
+// contains.
+type JournalMDDetail struct {
+	JournalMDEntryInfo
+	Writer keybase1.UID
+	// Ops describes each op in the MD, along with the blocks it
+	// references.  It's empty if Encrypted is true, or if the ops
+	// are stored in ChangesBlock.
+	Ops []string
+	// Encrypted is true for the MDs of private TLFs, whose ops can
+	// only be read by a logged-in device.
+	Encrypted    bool
+	ChangesBlock BlockPointer
+}
+
+// decodeJournaledPrivateMetadata returns the private metadata of the
+// given MD, if it isn't encrypted.
+func (ji *JournalInspector) decodeJournaledPrivateMetadata(
+	brmd BareRootMetadata) (pmd PrivateMetadata, encrypted bool, err error) {
+	if !brmd.TlfID().IsPublic() {
+		return PrivateMetadata{}, true, nil
+	}
+	err = ji.codec.Decode(brmd.GetSerializedPrivateMetadata(), &pmd)
+	if err != nil {
+		return PrivateMetadata{}, false, err
+	}
+	return pmd, false, nil
+}
+
+// ShowMD decodes the MD with the given revision in the given TLF
+// journal.
+func (ji *JournalInspector) ShowMD(ctx context.Context,
+	info JournalTLFInfo, rev MetadataRevision) (JournalMDDetail, error) {
+	_, mds, err := ji.open(ctx, info)
+	if err != nil {
+		return JournalMDDetail{}, err
+	}
+	mdInfos, err := ji.listMDs(mds)
+	if err != nil {
+		return JournalMDDetail{}, err
+	}
+	var detail JournalMDDetail
+	found := false
+	for _, mdInfo := range mdInfos {
+		if mdInfo.Revision == rev {
+			detail.JournalMDEntryInfo = mdInfo
+			found = true
+			break
+		}
+	}
+	if !found {
+		return JournalMDDetail{}, errors.Errorf(
+			"Revision %d isn't in the journal for %s", rev, info.TlfID)
+	}
+	if detail.Error != "" {
+		return detail, nil
+	}
+
+	entry, err := mds.j.readJournalEntry(rev)
+	if err != nil {
+		return JournalMDDetail{}, err
+	}
+	brmd, _, _, err := mds.getMDAndExtra(entry, false)
+	if err != nil {
+		return JournalMDDetail{}, err
+	}
+	detail.Writer = brmd.LastModifyingWriter()
+	pmd, encrypted, err := ji.decodeJournaledPrivateMetadata(brmd)
+	if err != nil {
+		return JournalMDDetail{}, err
+	}
+	detail.Encrypted = encrypted
+	detail.ChangesBlock = pmd.Changes.Info.BlockPointer
+	for _, op := range pmd.Changes.Ops {
+		detail.Ops = append(
+			detail.Ops, strings.TrimSuffix(op.StringWithRefs(1), "\n"))
+	}
+	return detail, nil
+}
+
+// JournalVerifyResult is the outcome of verifying a TLF journal.
+type JournalVerifyResult struct {
+	BlocksChecked int
+	MDsChecked    int
+	RefsChecked   int
+	// EncryptedMDs is the number of MDs whose block references
+	// couldn't be checked, because their ops are encrypted.
+	EncryptedMDs int
+	Problems     []string
+}
+
+func (r *JournalVerifyResult) addProblem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// journaledMDRefs returns the IDs of the new blocks referenced by
+// the ops in the given private metadata.
+func journaledMDRefs(pmd PrivateMetadata) []kbfsblock.ID {
+	var ids []kbfsblock.ID
+	addPtr := func(ptr BlockPointer) {
+		if ptr.IsInitialized() {
+			ids = append(ids, ptr.ID)
+		}
+	}
+	addPtr(pmd.Changes.Info.BlockPointer)
+	for _, op := range pmd.Changes.Ops {
+		for _, ptr := range op.Refs() {
+			addPtr(ptr)
+		}
+		for _, update := range op.allUpdates() {
+			addPtr(update.Ref)
+		}
+	}
+	return ids
+}
+
+// Verify checks every block in the given TLF journal against its ID,
+// that every MD is intact and signed, that the MD revisions chain
+// together through their PrevRoot, and that the blocks referenced by
+// the MDs are in the block journal.
+//
+// The blocks of an MD are only checked once none of them can have
+// been flushed, i.e. while the block journal still has the marker of
+// an earlier revision, since flushed blocks may already have been
+// cleaned up.  The ops of private TLFs are encrypted, so their block
+// references can't be checked at all.
+func (ji *JournalInspector) Verify(ctx context.Context,
+	info JournalTLFInfo) (JournalVerifyResult, error) {
+	var result JournalVerifyResult
+	blocks, mds, err := ji.open(ctx, info)
+	if err != nil {
+		return JournalVerifyResult{}, err
+	}
+
+	first, end, err := diskJournalRange(blocks.j)
+	if err != nil {
+		return JournalVerifyResult{}, err
+	}
+	journaledIDs := make(map[kbfsblock.ID]bool)
+	minMarker := MetadataRevisionUninitialized
+	for i := first; i < end; i++ {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		default:
+		}
+		entry, err := blocks.readJournalEntry(i)
+		if err != nil {
+			result.addProblem("Block journal entry %d: %v", i, err)
+			continue
+		}
+		for id := range entry.Contexts {
+			journaledIDs[id] = true
+		}
+		switch entry.Op {
+		case blockPutOp:
+			id, _, err := entry.getSingleContext()
+			if err != nil {
+				result.addProblem("Block journal entry %d: %v", i, err)
+				continue
+			}
+			result.BlocksChecked++
+			// getData checks the data against the ID.
+			_, _, err = blocks.s.getData(id)
+			if err != nil {
+				result.addProblem("Block %s (entry %d): %v", id, i, err)
+			}
+		case mdRevMarkerOp:
+			if minMarker == MetadataRevisionUninitialized ||
+				entry.Revision < minMarker {
+				minMarker = entry.Revision
+			}
+		}
+	}
+
+	firstRev, err := mds.readEarliestRevision()
+	if err != nil {
+		return JournalVerifyResult{}, err
+	}
+	if firstRev == MetadataRevisionUninitialized {
+		return result, nil
+	}
+	lastRev, err := mds.readLatestRevision()
+	if err != nil {
+		return JournalVerifyResult{}, err
+	}
+	var prevID MdID
+	for rev := firstRev; rev <= lastRev; rev++ {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		default:
+		}
+		result.MDsChecked++
+		entry, err := mds.j.readJournalEntry(rev)
+		if err != nil {
+			result.addProblem("MD journal entry %d: %v", rev, err)
+			prevID = MdID{}
+			continue
+		}
+		// getMDAndExtra checks the ID and signature of the MD.
+		brmd, _, _, err := mds.getMDAndExtra(entry, false)
+		if err != nil {
+			result.addProblem("MD %s (revision %d): %v", entry.ID, rev, err)
+			prevID = MdID{}
+			continue
+		}
+		if brmd.RevisionNumber() != rev {
+			result.addProblem("MD %s is stored under revision %d, "+
+				"but has revision %d", entry.ID, rev, brmd.RevisionNumber())
+		}
+		if prevID != (MdID{}) && brmd.GetPrevRoot() != prevID {
+			result.addProblem("MD %s (revision %d) has PrevRoot %s, "+
+				"but the previous revision is %s",
+				entry.ID, rev, brmd.GetPrevRoot(), prevID)
+		}
+		prevID = entry.ID
+
+		pmd, encrypted, err := ji.decodeJournaledPrivateMetadata(brmd)
+		if err != nil {
+			result.addProblem("MD %s (revision %d): couldn't decode "+
+				"private metadata: %v", entry.ID, rev, err)
+			continue
+		}
+		if encrypted {
+			result.EncryptedMDs++
+			continue
+		}
+		if minMarker == MetadataRevisionUninitialized || rev <= minMarker {
+			// Some of this revision's blocks may have been
+			// flushed already.
+			continue
+		}
+		for _, id := range journaledMDRefs(pmd) {
+			result.RefsChecked++
+			if journaledIDs[id] {
+				continue
+			}
+			hasData, err := blocks.s.hasData(id)
+			if err != nil {
+				result.addProblem("MD %s (revision %d) references "+
+					"block %s: %v", entry.ID, rev, id, err)
+			} else if !hasData {
+				result.addProblem("MD %s (revision %d) references "+
+					"block %s, which isn't in the block journal",
+					entry.ID, rev, id)
+			}
+		}
+	}
+	return result, nil
+}
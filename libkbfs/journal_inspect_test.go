@@ -0,0 +1,144 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func putBlockForJournalInspectTest(ctx context.Context, t *testing.T,
+	config Config, tlfID tlf.ID, uid keybase1.UID,
+	data []byte) BlockPointer {
+	bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = config.BlockServer().Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	return BlockPointer{ID: bID, KeyGen: PublicKeyGen,
+		DataVer: FirstValidDataVer, Context: bCtx}
+}
+
+func TestJournalInspector(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	tlfID := tlf.FakeID(2, true)
+	err := jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	h, err := ParseTlfHandle(ctx, config.KBPKI(), "test_user1", true)
+	require.NoError(t, err)
+	uid := h.ResolvedWriters()[0]
+
+	// Put two revisions, each with a new directory block and a
+	// create op that updates the directory to it.
+	ptr1 := putBlockForJournalInspectTest(
+		ctx, t, config, tlfID, uid, []byte{1, 2, 3, 4})
+	rmd, err := makeInitialRootMetadata(config.MetadataVersion(), tlfID, h)
+	require.NoError(t, err)
+	oldDir := makeFakeBlockPointer(t)
+	co, err := newCreateOp("a", oldDir, File)
+	require.NoError(t, err)
+	co.AddUpdate(oldDir, ptr1)
+	rmd.AddOp(co)
+	mdID, err := config.MDOps().Put(ctx, rmd)
+	require.NoError(t, err)
+
+	ptr2 := putBlockForJournalInspectTest(
+		ctx, t, config, tlfID, uid, []byte{5, 6, 7, 8})
+	rmd2, err := rmd.MakeSuccessor(ctx, config.MetadataVersion(),
+		config.Codec(), config.Crypto(), config.KeyManager(), mdID, true)
+	require.NoError(t, err)
+	co2, err := newCreateOp("b", ptr1, File)
+	require.NoError(t, err)
+	co2.AddUpdate(ptr1, ptr2)
+	rmd2.AddOp(co2)
+	mdID2, err := config.MDOps().Put(ctx, rmd2)
+	require.NoError(t, err)
+
+	ji, err := newJournalInspector(tempdir, jServer.log)
+	require.NoError(t, err)
+	infos, unreadable, err := ji.TLFs(ctx)
+	require.NoError(t, err)
+	require.Len(t, unreadable, 0)
+	require.Len(t, infos, 1)
+	info := infos[0]
+	require.Equal(t, tlfID, info.TlfID)
+	require.Equal(t, uid, info.UID)
+
+	listing, err := ji.List(ctx, info)
+	require.NoError(t, err)
+	var ops []string
+	for _, b := range listing.Blocks {
+		require.Equal(t, JournalEntryUnflushed, b.State)
+		require.Equal(t, "", b.Error)
+		ops = append(ops, b.Op)
+	}
+	require.Equal(t, []string{"blockPut", "mdRevisionMarker",
+		"blockPut", "mdRevisionMarker"}, ops)
+	require.Equal(t, ptr1.ID, listing.Blocks[0].IDs[0])
+	require.Equal(t, int64(4), listing.Blocks[0].Bytes)
+	require.Equal(t, rmd2.Revision(), listing.Blocks[3].Revision)
+	require.Len(t, listing.MDs, 2)
+	require.Equal(t, mdID, listing.MDs[0].ID)
+	require.Equal(t, mdID2, listing.MDs[1].ID)
+	require.Equal(t, mdID, listing.MDs[1].PrevRoot)
+	require.Equal(t, JournalEntryUnflushed, listing.MDs[1].State)
+
+	detail, err := ji.ShowMD(ctx, info, rmd2.Revision())
+	require.NoError(t, err)
+	require.False(t, detail.Encrypted)
+	require.Equal(t, uid, detail.Writer)
+	require.Len(t, detail.Ops, 1)
+	require.True(t, strings.HasPrefix(detail.Ops[0], "create b"))
+	require.Contains(t, detail.Ops[0], ptr2.ID.String())
+	_, err = ji.ShowMD(ctx, info, rmd2.Revision()+1)
+	require.Error(t, err)
+
+	result, err := ji.Verify(ctx, info)
+	require.NoError(t, err)
+	require.Len(t, result.Problems, 0)
+	require.Equal(t, 2, result.BlocksChecked)
+	require.Equal(t, 2, result.MDsChecked)
+	// Only the second revision's references are checked, since
+	// there's no marker before the first one.
+	require.Equal(t, 1, result.RefsChecked)
+
+	// Corrupt the second block and the first MD.
+	blocks, mds, err := ji.open(ctx, info)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(blocks.s.dataPath(ptr2.ID), []byte{0}, 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(mds.mdDataPath(mdID), []byte{0}, 0600)
+	require.NoError(t, err)
+
+	result, err = ji.Verify(ctx, info)
+	require.NoError(t, err)
+	require.Len(t, result.Problems, 2)
+	require.Contains(t, result.Problems[0], ptr2.ID.String())
+	require.Contains(t, result.Problems[1], mdID.String())
+
+	// Missing block data shows up in the listing.
+	err = ioutil.RemoveAll(blocks.s.blockPath(ptr2.ID))
+	require.NoError(t, err)
+	result, err = ji.Verify(ctx, info)
+	require.NoError(t, err)
+	require.Len(t, result.Problems, 2)
+	listing, err = ji.List(ctx, info)
+	require.NoError(t, err)
+	require.NotEqual(t, "", listing.Blocks[2].Error)
+	require.NotEqual(t, "", listing.MDs[0].Error)
+}
@@ -5,9 +5,9 @@
 package libkbfs
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +31,9 @@ type journalServerConfig struct {
 	// EnableAutoSetByUser means the user has explicitly set the
 	// value of EnableAuto (after this field was added).
 	EnableAutoSetByUser bool
+	// FlushPriorities holds the flush priorities the user has set
+	// for the journals of particular TLFs.
+	FlushPriorities map[tlf.ID]JournalFlushPriority `json:",omitempty"`
 }
 
 func (jsc journalServerConfig) getEnableAuto(currentUID keybase1.UID) (
@@ -71,6 +74,20 @@ type JournalServerStatus struct {
 	UnflushedBytes    int64
 	UnflushedPaths    []string
 	DiskLimiterStatus interface{}
+	// FlushesInFlight is the number of block and MD puts that
+	// journals are currently making, and FlushQueue lists the
+	// journals waiting for their turn to make more.
+	FlushesInFlight int
+	FlushQueue      []JournalFlushQueueEntry
+}
+
+// JournalFlushPriorityStatus describes where the journal of one TLF
+// stands relative to the others when it comes to flushing.  It is
+// suitable for encoding directly as JSON.
+type JournalFlushPriorityStatus struct {
+	Priority JournalFlushPriority
+	// QueuePosition is 0 if the journal isn't waiting to flush.
+	QueuePosition int
 }
 
 // branchChangeListener describes a caller that will get updates via
@@ -121,6 +138,7 @@ type JournalServer struct {
 	delegateMDOps           MDOps
 	onBranchChange          branchChangeListener
 	onMDFlush               mdFlushListener
+	flushScheduler          *journalFlushScheduler
 
 	// Just protects lastQuotaError.
 	lastQuotaErrorLock sync.Mutex
@@ -134,6 +152,9 @@ type JournalServer struct {
 	dirtyOps            uint
 	dirtyOpsDone        *sync.Cond
 	serverConfig        journalServerConfig
+	// crypter, if non-nil, encrypts the info files of the journals
+	// of the current device.
+	crypter *localStorageCrypter
 }
 
 func makeJournalServer(
@@ -155,14 +176,27 @@ func makeJournalServer(
 		delegateMDOps:           mdOps,
 		onBranchChange:          onBranchChange,
 		onMDFlush:               onMDFlush,
+		flushScheduler:          newJournalFlushScheduler(maxParallelBlockPuts),
 		tlfJournals:             make(map[tlf.ID]*tlfJournal),
 	}
 	jServer.dirtyOpsDone = sync.NewCond(&jServer.lock)
 	return &jServer
 }
 
+// journalDirFromStorageRoot returns the directory of the journal
+// server for the given storage root.
+func journalDirFromStorageRoot(storageRoot string) string {
+	return filepath.Join(storageRoot, "kbfs_journal")
+}
+
+// journalServerRootPath returns the directory under the given
+// journal server directory that holds the TLF journals.
+func journalServerRootPath(dir string) string {
+	return filepath.Join(dir, "v1")
+}
+
 func (j *JournalServer) rootPath() string {
-	return filepath.Join(j.dir, "v1")
+	return journalServerRootPath(j.dir)
 }
 
 func (j *JournalServer) configPath() string {
@@ -199,13 +233,21 @@ func (j *JournalServer) tlfJournalPathLocked(tlfID tlf.ID) string {
 	// 16 characters of the TLF ID gives us 64 random bits, which
 	// means that the expected number of TLFs associated to that
 	// device before getting a collision in the second part of the
-	// path is 2^32.
-	shortDeviceIDStr := j.currentVerifyingKey.String()[:36]
-	shortTlfIDStr := tlfID.String()[:16]
-	dir := fmt.Sprintf("%s-%s", shortDeviceIDStr, shortTlfIDStr)
+	// path is 2^32.  The same goes for the first 16 characters of
+	// the obfuscated TLF ID, which is used instead when local
+	// storage is encrypted.
+	shortTlfIDStr := j.crypter.obfuscateName(tlfID.String(), 16)[:16]
+	dir := j.tlfJournalDirPrefixLocked() + shortTlfIDStr
 	return filepath.Join(j.rootPath(), dir)
 }
 
+// tlfJournalDirPrefixLocked returns the prefix of the names of the
+// journal directories of the current device.
+func (j *JournalServer) tlfJournalDirPrefixLocked() string {
+	shortDeviceIDStr := j.currentVerifyingKey.String()[:36]
+	return shortDeviceIDStr + "-"
+}
+
 func (j *JournalServer) getEnableAutoLocked() (
 	enableAuto, enableAutoSetByUser bool) {
 	return j.serverConfig.getEnableAuto(j.currentUID)
@@ -265,6 +307,13 @@ func (j *JournalServer) EnableExistingJournals(
 	// users/devices so that we can take into account their
 	// journal usage.
 
+	// Get the key (if any) before taking the lock, since it may
+	// need to talk to the service.
+	crypter, err := makeLocalStorageCrypter(ctx, j.config)
+	if err != nil {
+		return err
+	}
+
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
@@ -279,6 +328,7 @@ func (j *JournalServer) EnableExistingJournals(
 	case err != nil:
 		return err
 	}
+	j.flushScheduler.setPriorities(j.serverConfig.FlushPriorities)
 
 	if j.currentUID != keybase1.UID("") {
 		return errors.Errorf("Trying to set current UID from %s to %s",
@@ -301,6 +351,7 @@ func (j *JournalServer) EnableExistingJournals(
 	// enableLocked depend on it.
 	j.currentUID = currentUID
 	j.currentVerifyingKey = currentVerifyingKey
+	j.crypter = crypter
 
 	enableSucceeded := false
 	defer func() {
@@ -319,6 +370,8 @@ func (j *JournalServer) EnableExistingJournals(
 		return err
 	}
 
+	devicePrefix := j.tlfJournalDirPrefixLocked()
+
 	eg, groupCtx := errgroup.WithContext(ctx)
 
 	fileCh := make(chan os.FileInfo, len(fileInfos))
@@ -336,7 +389,17 @@ func (j *JournalServer) EnableExistingJournals(
 			}
 
 			dir := filepath.Join(j.rootPath(), name)
-			uid, key, tlfID, err := readTLFJournalInfoFile(dir)
+			uid, key, tlfID, err := readTLFJournalInfoFile(dir, crypter)
+			if err != nil && strings.HasPrefix(name, devicePrefix) &&
+				isLocalStorageEncryptedFile(j.config.Codec(),
+					getTLFJournalInfoFilePath(dir)) != (crypter != nil) {
+				err := j.removeJournalFromOtherModeLocked(
+					groupCtx, dir, crypter == nil)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			if err != nil {
 				j.log.CDebugf(
 					groupCtx, "Skipping non-TLF dir %q: %+v", name, err)
@@ -397,12 +460,15 @@ func (j *JournalServer) EnableExistingJournals(
 	close(fileCh)
 
 	err = eg.Wait()
+	close(journalCh)
 	if err != nil {
-		// None of the workers return an error so this should never
-		// happen...
+		// A worker found a journal that can't be used in this mode;
+		// shut down the ones that were already enabled.
+		for r := range journalCh {
+			r.journal.shutdown(ctx)
+		}
 		return err
 	}
-	close(journalCh)
 
 	for r := range journalCh {
 		j.tlfJournals[r.id] = r.journal
@@ -414,6 +480,25 @@ func (j *JournalServer) EnableExistingJournals(
 	return nil
 }
 
+// removeJournalFromOtherModeLocked deals with a journal of the current
+// device in dir that was written with local storage encryption turned
+// the other way, and so can't be used in the current mode.  If it has
+// nothing left to flush, it's just removed.  Otherwise it's kept, and
+// an UnflushedJournalInOtherModeError is returned.
+func (j *JournalServer) removeJournalFromOtherModeLocked(
+	ctx context.Context, dir string, encrypted bool) error {
+	unflushed, err := tlfJournalHasUnflushedEntries(j.config.Codec(), dir)
+	if err != nil {
+		return err
+	}
+	if unflushed {
+		return UnflushedJournalInOtherModeError{dir, encrypted}
+	}
+	j.log.CDebugf(ctx, "Removing flushed journal %q written with "+
+		"local storage encryption=%t", dir, encrypted)
+	return ioutil.RemoveAll(dir)
+}
+
 // enabledLocked returns an enabled journal; it is the caller's
 // responsibility to add it to `j.tlfJournals`.  This allows this
 // method to be called in parallel during initialization, if desired.
@@ -469,7 +554,8 @@ func (j *JournalServer) enableLocked(
 	tj, err = makeTLFJournal(
 		ctx, j.currentUID, j.currentVerifyingKey, tlfDir,
 		tlfID, tlfJournalConfigAdapter{j.config}, j.delegateBlockServer,
-		bws, nil, j.onBranchChange, j.onMDFlush, j.config.DiskLimiter())
+		bws, nil, j.onBranchChange, j.onMDFlush, j.config.DiskLimiter(),
+		j.flushScheduler, j.crypter)
 	if err != nil {
 		return nil, err
 	}
@@ -523,6 +609,43 @@ func (j *JournalServer) DisableAuto(ctx context.Context) error {
 	return j.writeConfig()
 }
 
+// SetFlushPriority sets, persistently, the priority with which the
+// write journal for the given TLF flushes relative to those of other
+// TLFs.
+func (j *JournalServer) SetFlushPriority(ctx context.Context,
+	tlfID tlf.ID, priority JournalFlushPriority) error {
+	if _, err := priority.MarshalText(); err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.log.CDebugf(ctx, "Setting the flush priority for %s to %s",
+		tlfID, priority)
+	if priority == JournalFlushPriorityNormal {
+		delete(j.serverConfig.FlushPriorities, tlfID)
+	} else {
+		if j.serverConfig.FlushPriorities == nil {
+			j.serverConfig.FlushPriorities =
+				make(map[tlf.ID]JournalFlushPriority)
+		}
+		j.serverConfig.FlushPriorities[tlfID] = priority
+	}
+	j.flushScheduler.setPriority(tlfID, priority)
+	return j.writeConfig()
+}
+
+// FlushPriorityStatus returns the flush priority of the write journal
+// for the given TLF, and its position in the queue of journals
+// waiting to flush.
+func (j *JournalServer) FlushPriorityStatus(
+	tlfID tlf.ID) JournalFlushPriorityStatus {
+	return JournalFlushPriorityStatus{
+		Priority:      j.flushScheduler.getPriority(tlfID),
+		QueuePosition: j.flushScheduler.getQueuePosition(tlfID),
+	}
+}
+
 func (j *JournalServer) dirtyOpStart(tlfID tlf.ID) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
@@ -580,6 +703,19 @@ func (j *JournalServer) Flush(ctx context.Context, tlfID tlf.ID) (err error) {
 	return nil
 }
 
+// Squash asks for the unflushed revisions in the write journal for
+// the given TLF to be squashed into a single revision before they're
+// flushed.  The squash itself happens in the background.
+func (j *JournalServer) Squash(ctx context.Context, tlfID tlf.ID) error {
+	j.log.CDebugf(ctx, "Squashing journal for %s", tlfID)
+	if tlfJournal, ok := j.getTLFJournal(tlfID); ok {
+		return tlfJournal.requestSquash(ctx)
+	}
+
+	j.log.CDebugf(ctx, "Journal not enabled for %s", tlfID)
+	return nil
+}
+
 // Wait blocks until the write journal has finished flushing
 // everything.  It is essentially the same as Flush() when the journal
 // is enabled and unpaused, except that it is safe to cancel the
@@ -704,6 +840,7 @@ func (j *JournalServer) Status(
 		tlfIDs = append(tlfIDs, tlfJournal.tlfID)
 	}
 	enableAuto, enableAutoSetByUser := j.getEnableAutoLocked()
+	flushesInFlight, flushQueue := j.flushScheduler.getStatus()
 	return JournalServerStatus{
 		RootDir:             j.rootPath(),
 		Version:             1,
@@ -716,6 +853,8 @@ func (j *JournalServer) Status(
 		StoredFiles:         totalStoredFiles,
 		UnflushedBytes:      totalUnflushedBytes,
 		DiskLimiterStatus:   j.config.DiskLimiter().getStatus(),
+		FlushesInFlight:     flushesInFlight,
+		FlushQueue:          flushQueue,
 	}, tlfIDs
 }
 
@@ -767,6 +906,7 @@ func (j *JournalServer) shutdownExistingJournalsLocked(ctx context.Context) {
 	j.tlfJournals = make(map[tlf.ID]*tlfJournal)
 	j.currentUID = keybase1.UID("")
 	j.currentVerifyingKey = kbfscrypto.VerifyingKey{}
+	j.crypter = nil
 }
 
 // shutdownExistingJournals shuts down all write journals, sets the
@@ -5,7 +5,9 @@
 package libkbfs
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -233,6 +235,262 @@ func TestJournalServerRestart(t *testing.T) {
 	require.Equal(t, rmd.Revision(), head.Revision())
 }
 
+// tlfNameRecordingReporter is a Reporter that also records the TLF
+// name that each error is reported for, by error message.
+type tlfNameRecordingReporter struct {
+	Reporter
+
+	lock  sync.Mutex
+	names map[string]CanonicalTlfName
+}
+
+func (r *tlfNameRecordingReporter) ReportErr(ctx context.Context,
+	tlfName CanonicalTlfName, public bool, mode ErrorModeType, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.names[err.Error()] = tlfName
+	r.Reporter.ReportErr(ctx, tlfName, public, mode, err)
+}
+
+func TestJournalServerRestartAfterTornWrite(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	jServer.delegateBlockServer = shutdownOnlyBlockServer{}
+
+	tlfID := tlf.FakeID(2, false)
+	err := jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	uid := session.UID
+
+	// Put an MD, so that the repair can be reported with the TLF's
+	// name, and then two blocks.
+	h, err := ParseTlfHandle(ctx, config.KBPKI(), "test_user1", false)
+	require.NoError(t, err)
+	rmd, err := makeInitialRootMetadata(config.MetadataVersion(), tlfID, h)
+	require.NoError(t, err)
+	rekeyDone, _, err := config.KeyManager().Rekey(ctx, rmd, false)
+	require.NoError(t, err)
+	require.True(t, rekeyDone)
+	_, err = config.MDOps().Put(ctx, rmd)
+	require.NoError(t, err)
+
+	blockServer := config.BlockServer()
+	for _, data := range [][]byte{{1, 2, 3, 4}, {5, 6, 7, 8}} {
+		bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+		bID, err := kbfsblock.MakePermanentID(data)
+		require.NoError(t, err)
+		serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+		require.NoError(t, err)
+		err = blockServer.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+		require.NoError(t, err)
+	}
+
+	// Simulate a crash in the middle of writing the second
+	// block's journal entry, as an older version might have left
+	// it.
+	tlfJournal, ok := jServer.getTLFJournal(tlfID)
+	require.True(t, ok)
+	dir := tlfJournal.dir
+	blockCount := tlfJournal.blockJournal.length()
+	last := tlfJournal.blockJournal.j.latest
+	p := tlfJournal.blockJournal.j.journalEntryPath(last)
+	buf, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(p, buf[:len(buf)/2], 0600)
+	require.NoError(t, err)
+
+	// Simulate a restart.
+	reporter := &tlfNameRecordingReporter{
+		Reporter: config.Reporter(),
+		names:    make(map[string]CanonicalTlfName),
+	}
+	config.SetReporter(reporter)
+	jServer = makeJournalServer(
+		config, jServer.log, tempdir, jServer.delegateBlockCache,
+		jServer.delegateDirtyBlockCache,
+		jServer.delegateBlockServer, jServer.delegateMDOps, nil, nil)
+	err = jServer.EnableExistingJournals(
+		ctx, session.UID, session.VerifyingKey, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	// The torn entry should have been dropped and reported.
+	status, err := jServer.JournalStatus(tlfID)
+	require.NoError(t, err)
+	require.Equal(t, blockCount-1, status.BlockOpCount)
+
+	var repairErr *JournalRepairedError
+	for _, re := range config.Reporter().AllKnownErrors() {
+		if e, ok := re.Error.(JournalRepairedError); ok {
+			repairErr = &e
+		}
+	}
+	require.NotNil(t, repairErr)
+	require.Equal(t, tlfID, repairErr.TlfID)
+	require.Equal(t, h.GetCanonicalName(), reporter.names[repairErr.Error()])
+	require.Equal(t, tlfJournalQuarantinePath(dir),
+		filepath.Dir(repairErr.QuarantineDir))
+	_, err = ioutil.Stat(filepath.Join(repairErr.QuarantineDir,
+		filepath.Base(blockJournalDir(dir)), last.String()))
+	require.NoError(t, err)
+	var repairs []diskJournalRepair
+	err = ioutil.DeserializeFromJSONFile(
+		filepath.Join(repairErr.QuarantineDir, "report.json"), &repairs)
+	require.NoError(t, err)
+	require.Len(t, repairs, 1)
+	require.Equal(t, []journalOrdinal{last}, repairs[0].Quarantined)
+}
+
+func TestJournalServerRestartEncrypted(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	// Re-enable the journals with local storage encryption on.
+	config.SetEncryptLocalStorage(true)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	jServer.shutdownExistingJournals(ctx)
+	err = jServer.EnableExistingJournals(
+		ctx, session.UID, session.VerifyingKey, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	jServer.delegateBlockServer = shutdownOnlyBlockServer{}
+
+	tlfID := tlf.FakeID(2, false)
+	err = jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	blockServer := config.BlockServer()
+	mdOps := config.MDOps()
+
+	h, err := ParseTlfHandle(ctx, config.KBPKI(), "test_user1", false)
+	require.NoError(t, err)
+	uid := h.ResolvedWriters()[0]
+
+	bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = blockServer.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	rmd, err := makeInitialRootMetadata(config.MetadataVersion(), tlfID, h)
+	require.NoError(t, err)
+	rekeyDone, _, err := config.KeyManager().Rekey(ctx, rmd, false)
+	require.NoError(t, err)
+	require.True(t, rekeyDone)
+	_, err = mdOps.Put(ctx, rmd)
+	require.NoError(t, err)
+
+	// Neither the journal's path nor its info files should give
+	// away the TLF ID or when it was written to.
+	dir := jServer.tlfJournalPathLocked(tlfID)
+	require.NotContains(t, filepath.Base(dir), tlfID.String()[:16])
+	err = filepath.Walk(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			require.False(t, bytes.Contains(buf, []byte(tlfID.String())),
+				"%s contains the TLF ID", path)
+			require.False(t, bytes.Contains(buf, []byte("Timestamp")),
+				"%s contains a timestamp", path)
+			return nil
+		})
+	require.NoError(t, err)
+
+	// Simulate a restart.
+
+	jServer = makeJournalServer(
+		config, jServer.log, tempdir, jServer.delegateBlockCache,
+		jServer.delegateDirtyBlockCache,
+		jServer.delegateBlockServer, jServer.delegateMDOps, nil, nil)
+	err = jServer.EnableExistingJournals(
+		ctx, session.UID, session.VerifyingKey, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	require.True(t, jServer.hasTLFJournal(tlfID))
+	config.SetBlockCache(jServer.blockCache())
+	config.SetBlockServer(jServer.blockServer())
+	config.SetMDOps(jServer.mdOps())
+
+	buf, key, err := blockServer.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+	require.Equal(t, serverHalf, key)
+
+	head, err := mdOps.GetForTLF(ctx, tlfID)
+	require.NoError(t, err)
+	require.Equal(t, rmd.Revision(), head.Revision())
+}
+
+func TestJournalServerRestartOtherEncryptionMode(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	restart := func(encrypt bool) error {
+		jServer.shutdownExistingJournals(ctx)
+		config.SetEncryptLocalStorage(encrypt)
+		jServer = makeJournalServer(
+			config, jServer.log, tempdir, jServer.delegateBlockCache,
+			jServer.delegateDirtyBlockCache,
+			jServer.delegateBlockServer, jServer.delegateMDOps, nil, nil)
+		return jServer.EnableExistingJournals(ctx, session.UID,
+			session.VerifyingKey, TLFJournalBackgroundWorkPaused)
+	}
+	err = restart(true)
+	require.NoError(t, err)
+	jServer.delegateBlockServer = shutdownOnlyBlockServer{}
+
+	t.Log("An encrypted journal with nothing to flush is removed " +
+		"when encryption is turned off.")
+	tlfID := tlf.FakeID(2, false)
+	err = jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	dir := jServer.tlfJournalPathLocked(tlfID)
+	err = restart(false)
+	require.NoError(t, err)
+	require.False(t, jServer.hasTLFJournal(tlfID))
+	_, err = ioutil.Stat(dir)
+	require.True(t, ioutil.IsNotExist(err))
+
+	t.Log("An encrypted journal with unflushed blocks stops the " +
+		"journals from being enabled when encryption is turned off.")
+	err = restart(true)
+	require.NoError(t, err)
+	err = jServer.Enable(ctx, tlfID, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	h, err := ParseTlfHandle(ctx, config.KBPKI(), "test_user1", false)
+	require.NoError(t, err)
+	bCtx := kbfsblock.MakeFirstContext(
+		h.ResolvedWriters()[0], keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = jServer.blockServer().Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	err = restart(false)
+	require.Equal(t, UnflushedJournalInOtherModeError{
+		Dir: dir, Encrypted: true}, err)
+
+	t.Log("Going back to the old mode still works.")
+	err = restart(true)
+	require.NoError(t, err)
+	require.True(t, jServer.hasTLFJournal(tlfID))
+}
+
 func TestJournalServerLogOutLogIn(t *testing.T) {
 	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
 	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
@@ -541,3 +799,41 @@ func TestJournalServerEnableAuto(t *testing.T) {
 	require.Equal(t, 1, status.JournalCount)
 	require.Len(t, tlfIDs, 1)
 }
+
+func TestJournalServerFlushPriority(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	tlfID := tlf.FakeID(2, false)
+	err := jServer.SetFlushPriority(ctx, tlfID, JournalFlushPriorityHigh)
+	require.NoError(t, err)
+	require.Equal(t, JournalFlushPriorityStatus{
+		Priority: JournalFlushPriorityHigh,
+	}, jServer.FlushPriorityStatus(tlfID))
+	err = jServer.SetFlushPriority(ctx, tlfID, JournalFlushPriority(5))
+	require.Error(t, err)
+
+	status, _ := jServer.Status(ctx)
+	require.Zero(t, status.FlushesInFlight)
+	require.Len(t, status.FlushQueue, 0)
+
+	// Simulate a restart; the priority should stick.
+	jServer.shutdownExistingJournals(ctx)
+	jServer = makeJournalServer(
+		config, jServer.log, tempdir, jServer.delegateBlockCache,
+		jServer.delegateDirtyBlockCache,
+		jServer.delegateBlockServer, jServer.delegateMDOps, nil, nil)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	err = jServer.EnableExistingJournals(
+		ctx, session.UID, session.VerifyingKey, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+	require.Equal(t, JournalFlushPriorityHigh,
+		jServer.FlushPriorityStatus(tlfID).Priority)
+
+	err = jServer.SetFlushPriority(ctx, tlfID, JournalFlushPriorityNormal)
+	require.NoError(t, err)
+	require.Equal(t, JournalFlushPriorityNormal,
+		jServer.FlushPriorityStatus(tlfID).Priority)
+	require.Len(t, jServer.serverConfig.FlushPriorities, 0)
+}
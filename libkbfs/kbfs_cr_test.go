@@ -1665,3 +1665,113 @@ func TestUnmergedPutAfterCanceledUnmergedPut(t *testing.T) {
 		assert.True(t, ok)
 	}
 }
+
+// Tests that extended attributes set by two users while forked are
+// merged by conflict resolution, with the merged value winning when
+// both users set the same attribute.
+func TestBasicCRXattrConflict(t *testing.T) {
+	// simulate two users
+	var userName1, userName2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := userName1.String() + "," + userName2.String()
+
+	// user1 creates a file and a directory in a shared dir
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(
+		ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	dirNode1, _, err := kbfsOps1.CreateDir(ctx, rootNode1, "d")
+	require.NoError(t, err)
+
+	// look them up on user2
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	dirNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "d")
+	require.NoError(t, err)
+
+	// disable updates on user 2
+	c, err := DisableUpdatesForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// User 1 sets some xattrs
+	err = kbfsOps1.SetXattr(ctx, fileNode1, "user.x", []byte("1"))
+	require.NoError(t, err)
+	err = kbfsOps1.SetXattr(ctx, fileNode1, "user.y", []byte("1"))
+	require.NoError(t, err)
+	err = kbfsOps1.SetXattr(ctx, dirNode1, "user.x", []byte("1"))
+	require.NoError(t, err)
+
+	// User 2 sets some overlapping xattrs, and writes to the file.
+	err = kbfsOps2.SetXattr(ctx, fileNode2, "user.x", []byte("2"))
+	require.NoError(t, err)
+	err = kbfsOps2.SetXattr(ctx, fileNode2, "user.z", []byte("2"))
+	require.NoError(t, err)
+	err = kbfsOps2.SetXattr(ctx, dirNode2, "user.z", []byte("2"))
+	require.NoError(t, err)
+	data := []byte{1, 2, 3}
+	err = kbfsOps2.Write(ctx, fileNode2, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.Sync(ctx, fileNode2)
+	require.NoError(t, err)
+
+	// re-enable updates, and wait for CR to complete
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2,
+		rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServerForTesting(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	expectedFile := map[string]string{
+		"user.x": "1",
+		"user.y": "1",
+		"user.z": "2",
+	}
+	expectedDir := map[string]string{
+		"user.x": "1",
+		"user.z": "2",
+	}
+	checkXattrs := func(kbfsOps KBFSOps, node Node,
+		expected map[string]string) {
+		names, err := kbfsOps.ListXattrs(ctx, node)
+		require.NoError(t, err)
+		require.Len(t, names, len(expected))
+		for name, value := range expected {
+			v, err := kbfsOps.GetXattr(ctx, node, name)
+			require.NoError(t, err)
+			require.Equal(t, value, string(v))
+		}
+	}
+	for _, kbfsOps := range []KBFSOps{kbfsOps1, kbfsOps2} {
+		fileNode := fileNode1
+		dirNode := dirNode1
+		if kbfsOps == kbfsOps2 {
+			fileNode = fileNode2
+			dirNode = dirNode2
+		}
+		checkXattrs(kbfsOps, fileNode, expectedFile)
+		checkXattrs(kbfsOps, dirNode, expectedDir)
+		buf := make([]byte, len(data))
+		n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, data, buf[:n])
+	}
+
+	// No conflict copies should have been made.
+	children, err := kbfsOps1.GetDirChildren(ctx, rootNode1)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+}
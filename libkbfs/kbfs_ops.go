@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
@@ -26,6 +27,13 @@ type KBFSOpsStandard struct {
 	ops      map[FolderBranch]*folderBranchOps
 	opsByFav map[Favorite]*folderBranchOps
 	opsLock  sync.RWMutex
+	// archivedOps holds the FolderBranch of each archived
+	// folderBranchOps in ops, in LRU order.  Archived branches
+	// are made on demand for every revision someone browses, so
+	// the least-recently used ones are shut down and dropped from
+	// ops once there are too many, as soon as none of their nodes
+	// are in use anymore.
+	archivedOps *lru.Cache
 	// reIdentifyControlChan controls reidentification.
 	// Sending a value to this channel forces all fbos
 	// to be marked for revalidation.
@@ -41,6 +49,10 @@ type KBFSOpsStandard struct {
 
 var _ KBFSOps = (*KBFSOpsStandard)(nil)
 
+// archivedFolderBranchOpsMax is the number of archived
+// folderBranchOps that are kept around at once.
+const archivedFolderBranchOpsMax = 10
+
 // NewKBFSOpsStandard constructs a new KBFSOpsStandard object.
 func NewKBFSOpsStandard(config Config) *KBFSOpsStandard {
 	log := config.MakeLogger("")
@@ -54,6 +66,13 @@ func NewKBFSOpsStandard(config Config) *KBFSOpsStandard {
 		favs:       NewFavorites(config),
 		quotaUsage: NewEventuallyConsistentQuotaUsage(config, "KBFSOps"),
 	}
+	var err error
+	kops.archivedOps, err = lru.NewWithEvict(
+		archivedFolderBranchOpsMax, kops.onArchivedOpsEvict)
+	if err != nil {
+		// This only happens if the size is non-positive.
+		panic(err)
+	}
 	kops.currentStatus.Init()
 	go kops.markForReIdentifyIfNeededLoop()
 	return kops
@@ -235,8 +254,17 @@ func (fs *KBFSOpsStandard) getOpsNoAdd(fb FolderBranch) *folderBranchOps {
 
 	fs.opsLock.RLock()
 	if ops, ok := fs.ops[fb]; ok {
-		fs.opsLock.RUnlock()
-		return ops
+		if !ops.isArchived() {
+			fs.opsLock.RUnlock()
+			return ops
+		}
+		// Mark it as recently used.  If it was evicted while its
+		// nodes were in use, adding it back below needs the write
+		// lock.
+		if _, ok := fs.archivedOps.Get(fb); ok {
+			fs.opsLock.RUnlock()
+			return ops
+		}
 	}
 
 	fs.opsLock.RUnlock()
@@ -246,13 +274,68 @@ func (fs *KBFSOpsStandard) getOpsNoAdd(fb FolderBranch) *folderBranchOps {
 	ops, ok := fs.ops[fb]
 	if !ok {
 		// TODO: add some interface for specifying the type of the
-		// branch; for now assume online and read-write.
-		ops = newFolderBranchOps(fs.config, fb, standard)
+		// branch; for now assume online, and read-write unless the
+		// branch names an archived revision.
+		bType := standard
+		if fb.Branch.IsArchived() {
+			bType = archive
+		}
+		ops = newFolderBranchOps(fs.config, fb, bType)
 		fs.ops[fb] = ops
 	}
+	if ops.isArchived() && !fs.archivedOps.Contains(fb) {
+		// This may evict another archived branch, which
+		// onArchivedOpsEvict removes from fs.ops while we still
+		// hold opsLock.
+		fs.archivedOps.Add(fb, nil)
+		fs.sweepArchivedOpsLocked()
+	}
 	return ops
 }
 
+// onArchivedOpsEvict is called, with opsLock held, when an archived
+// branch is evicted from fs.archivedOps.
+func (fs *KBFSOpsStandard) onArchivedOpsEvict(key interface{}, _ interface{}) {
+	fs.shutdownArchivedOpsIfUnusedLocked(key.(FolderBranch))
+}
+
+// sweepArchivedOpsLocked shuts down the archived branches that were
+// evicted from fs.archivedOps while their nodes were still in use,
+// if they aren't anymore.  opsLock must be held for writing.
+func (fs *KBFSOpsStandard) sweepArchivedOpsLocked() {
+	for fb, ops := range fs.ops {
+		if ops.isArchived() && !fs.archivedOps.Contains(fb) {
+			fs.shutdownArchivedOpsIfUnusedLocked(fb)
+		}
+	}
+}
+
+// shutdownArchivedOpsIfUnusedLocked drops the given archived
+// branch's folderBranchOps from fs.ops and shuts it down in the
+// background, unless some of its nodes are still in use (e.g., by
+// the kernel), in which case it's kept until a later sweep.  opsLock
+// must be held for writing.
+func (fs *KBFSOpsStandard) shutdownArchivedOpsIfUnusedLocked(
+	fb FolderBranch) {
+	ops, ok := fs.ops[fb]
+	if !ok {
+		return
+	}
+	if !ops.nodeCache.IsEmpty() {
+		fs.log.CDebugf(context.Background(), "Keeping archived branch "+
+			"%s until its nodes are no longer in use", fb)
+		return
+	}
+	delete(fs.ops, fb)
+	go func() {
+		ctx := context.Background()
+		if err := ops.Shutdown(ctx); err != nil {
+			fs.log.CDebugf(ctx, "Couldn't shut down archived branch %s: %+v",
+				fb, err)
+		}
+	}()
+}
+
 func (fs *KBFSOpsStandard) getOps(ctx context.Context,
 	fb FolderBranch, fop FavoritesOp) *folderBranchOps {
 	ops := fs.getOpsNoAdd(fb)
@@ -524,6 +607,57 @@ func (fs *KBFSOpsStandard) GetRootNode(
 	return fs.getMaybeCreateRootNode(ctx, h, branch, false)
 }
 
+// GetRootNodeAtRevision implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetRootNodeAtRevision(
+	ctx context.Context, h *TlfHandle, rev MetadataRevision) (
+	node Node, ei EntryInfo, err error) {
+	fs.log.CDebugf(ctx, "GetRootNodeAtRevision(%s, %d)",
+		h.GetCanonicalPath(), rev)
+	defer func() { fs.deferLog.CDebugf(ctx, "Done: %#v", err) }()
+
+	// Make sure the master branch is initialized and identified
+	// first, so we know the TLF ID and that the user can read it.
+	rmd, err := fs.getMDByHandle(ctx, h, FavoritesOpNoChange)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	id := rmd.TlfID()
+
+	latest, err := fs.config.MDOps().GetForTLF(ctx, id)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	if rev < MetadataRevisionInitial || latest == (ImmutableRootMetadata{}) ||
+		rev > latest.Revision() {
+		return nil, EntryInfo{}, NoSuchMDError{id, rev, NullBranchID}
+	}
+
+	md, err := getSingleMD(ctx, fs.config, id, NullBranchID, rev, Merged)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	// The device might have been added after this revision was
+	// written, in which case it needs the latest keys to read it.
+	md, err = makeMDReadable(ctx, fs.config, md, latest)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	fb := FolderBranch{Tlf: id, Branch: MakeRevBranchName(rev)}
+	ops := fs.getOpsNoAdd(fb)
+	err = ops.SetInitialHeadFromServer(ctx, md)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
+	node, ei, _, err = ops.getRootNode(ctx)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	return node, ei, nil
+}
+
 // GetDirChildren implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) GetDirChildren(ctx context.Context, dir Node) (
 	map[string]EntryInfo, error) {
@@ -568,6 +702,19 @@ func (fs *KBFSOpsStandard) CreateLink(
 	return ops.CreateLink(ctx, dir, fromName, toPath)
 }
 
+// CopyFile implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) CopyFile(
+	ctx context.Context, src Node, dir Node, name string) (
+	Node, EntryInfo, error) {
+	// only works for nodes within the same topdir
+	if src.GetFolderBranch() != dir.GetFolderBranch() {
+		return nil, EntryInfo{}, CopyAcrossFoldersError{}
+	}
+
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.CopyFile(ctx, src, dir, name)
+}
+
 // RemoveDir implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) RemoveDir(
 	ctx context.Context, dir Node, name string) error {
@@ -620,6 +767,49 @@ func (fs *KBFSOpsStandard) Truncate(
 	return ops.Truncate(ctx, file, size)
 }
 
+// PunchHole implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) PunchHole(
+	ctx context.Context, file Node, off, length int64) error {
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.PunchHole(ctx, file, off, length)
+}
+
+// NextDataOffset implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) NextDataOffset(
+	ctx context.Context, file Node, off int64) (int64, error) {
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.NextDataOffset(ctx, file, off)
+}
+
+// NextHoleOffset implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) NextHoleOffset(
+	ctx context.Context, file Node, off int64) (int64, error) {
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.NextHoleOffset(ctx, file, off)
+}
+
+// LockFile implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) LockFile(
+	ctx context.Context, file Node, lock FileLock) error {
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.LockFile(ctx, file, lock)
+}
+
+// UnlockFile implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) UnlockFile(
+	ctx context.Context, file Node, lock FileLock) error {
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.UnlockFile(ctx, file, lock)
+}
+
+// GetFileLockConflict implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetFileLockConflict(
+	ctx context.Context, file Node, lock FileLock) (FileLock, bool, error) {
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.GetFileLockConflict(ctx, file, lock)
+}
+
 // SetEx implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) SetEx(
 	ctx context.Context, file Node, ex bool) error {
@@ -634,12 +824,55 @@ func (fs *KBFSOpsStandard) SetMtime(
 	return ops.SetMtime(ctx, file, mtime)
 }
 
+// GetXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetXattr(
+	ctx context.Context, node Node, xattr string) ([]byte, error) {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.GetXattr(ctx, node, xattr)
+}
+
+// SetXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) SetXattr(
+	ctx context.Context, node Node, xattr string, value []byte) error {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.SetXattr(ctx, node, xattr, value)
+}
+
+// ListXattrs implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) ListXattrs(
+	ctx context.Context, node Node) ([]string, error) {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.ListXattrs(ctx, node)
+}
+
+// RemoveXattr implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RemoveXattr(
+	ctx context.Context, node Node, xattr string) error {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.RemoveXattr(ctx, node, xattr)
+}
+
 // Sync implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Sync(ctx context.Context, file Node) error {
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.Sync(ctx, file)
 }
 
+// RevertToRevision implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) RevertToRevision(
+	ctx context.Context, node Node, rev MetadataRevision) error {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.RevertToRevision(ctx, node, rev)
+}
+
+// Undelete implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) Undelete(ctx context.Context, dir Node,
+	name string, rev MetadataRevision) error {
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.Undelete(ctx, dir, name, rev)
+}
+
 // FolderStatus implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) FolderStatus(
 	ctx context.Context, folderBranch FolderBranch) (
@@ -730,6 +963,35 @@ func (fs *KBFSOpsStandard) GetEditHistory(ctx context.Context,
 	return ops.GetEditHistory(ctx, folderBranch)
 }
 
+// GetDeletedEntries implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetDeletedEntries(ctx context.Context,
+	folderBranch FolderBranch) (entries []DeletedEntry, err error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
+	return ops.GetDeletedEntries(ctx, folderBranch)
+}
+
+// DiffRevisions implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) DiffRevisions(ctx context.Context,
+	folderBranch FolderBranch, fromRev, toRev MetadataRevision) (
+	changes []PathChange, err error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
+	return ops.DiffRevisions(ctx, folderBranch, fromRev, toRev)
+}
+
+// SetKeepSynced implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) SetKeepSynced(
+	ctx context.Context, node Node, keepSynced bool) error {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.SetKeepSynced(ctx, node, keepSynced)
+}
+
+// GetSyncStatus implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetSyncStatus(ctx context.Context,
+	folderBranch FolderBranch) (FolderSyncStatus, error) {
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetSyncStatus(ctx, folderBranch)
+}
+
 // GetNodeMetadata implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) GetNodeMetadata(ctx context.Context, node Node) (
 	NodeMetadata, error) {
@@ -789,6 +1051,13 @@ func (fs *KBFSOpsStandard) onMDFlush(tlfID tlf.ID, bid BranchID,
 	ops.onMDFlush(bid, rev) // folderBranchOps makes a goroutine
 }
 
+func (fs *KBFSOpsStandard) loadSyncedFolder(
+	ctx context.Context, tlfID tlf.ID) error {
+	ops := fs.getOps(ctx,
+		FolderBranch{Tlf: tlfID, Branch: MasterBranch}, FavoritesOpNoChange)
+	return ops.loadSyncedFolder(ctx)
+}
+
 // kbfsOpsFavoriteObserver deals with a handle change for a particular
 // favorites.  It ignores local and batch changes.
 type kbfsOpsFavoriteObserver struct {
@@ -8,13 +8,19 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
@@ -5697,3 +5703,1147 @@ func TestForceFastForwardOnEmptyTLF(t *testing.T) {
 		t.Fatalf("Couldn't wait for fast forward: %+v", err)
 	}
 }
+
+func TestKBFSOpsGetRootNodeAtRevision(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	// TODO: Use kbfsTestShutdownNoMocks.
+	defer kbfsTestShutdownNoMocksNoCheck(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte("old"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	oldRev := ops.getCurrMDRevision(makeFBOLockState())
+
+	err = kbfsOps.Write(ctx, fileNode, []byte("new"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+
+	h := parseTlfHandleOrBust(t, config, "test_user", false)
+	oldRoot, _, err := kbfsOps.GetRootNodeAtRevision(ctx, h, oldRev)
+	require.NoError(t, err)
+	require.Equal(t, MakeRevBranchName(oldRev),
+		oldRoot.GetFolderBranch().Branch)
+
+	children, err := kbfsOps.GetDirChildren(ctx, oldRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "a")
+
+	oldFile, _, err := kbfsOps.Lookup(ctx, oldRoot, "a")
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	n, err := kbfsOps.Read(ctx, oldFile, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, "old", string(buf[:n]))
+
+	// The archived view must be read-only.
+	err = kbfsOps.Write(ctx, oldFile, []byte("bad"), 0)
+	require.IsType(t, WriteToReadonlyNodeError{}, err)
+	_, _, err = kbfsOps.CreateDir(ctx, oldRoot, "c")
+	require.IsType(t, WriteToReadonlyNodeError{}, err)
+
+	// The head is unaffected.
+	n, err = kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(buf[:n]))
+
+	// Revisions in the future don't exist.
+	_, _, err = kbfsOps.GetRootNodeAtRevision(ctx, h, oldRev+100)
+	require.IsType(t, NoSuchMDError{}, err)
+}
+
+func TestKBFSOpsArchivedOpsEviction(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	// TODO: Use kbfsTestShutdownNoMocks.
+	defer kbfsTestShutdownNoMocksNoCheck(t, config, ctx, cancel)
+
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	archivedOps, err := lru.NewWithEvict(1, kbfsOps.onArchivedOpsEvict)
+	require.NoError(t, err)
+	kbfsOps.archivedOps = archivedOps
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	rev := ops.getCurrMDRevision(makeFBOLockState())
+
+	h := parseTlfHandleOrBust(t, config, "test_user", false)
+	oldRoot, _, err := kbfsOps.GetRootNodeAtRevision(ctx, h, rev-2)
+	require.NoError(t, err)
+	oldFB := oldRoot.GetFolderBranch()
+	newRoot, _, err := kbfsOps.GetRootNodeAtRevision(ctx, h, rev-1)
+	require.NoError(t, err)
+	newFB := newRoot.GetFolderBranch()
+
+	hasOps := func(fb FolderBranch) bool {
+		kbfsOps.opsLock.RLock()
+		defer kbfsOps.opsLock.RUnlock()
+		_, ok := kbfsOps.ops[fb]
+		return ok
+	}
+
+	// The least recently used archived branch is evicted, but kept
+	// while its nodes are still in use.
+	require.True(t, hasOps(newFB))
+	require.True(t, hasOps(oldFB))
+	require.False(t, kbfsOps.archivedOps.Contains(oldFB))
+	children, err := kbfsOps.GetDirChildren(ctx, oldRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+
+	// Once its nodes are gone, browsing another revision shuts it
+	// down.
+	kbfsOps.opsLock.RLock()
+	oldOps := kbfsOps.ops[oldFB]
+	kbfsOps.opsLock.RUnlock()
+	oldRoot = nil
+	for i := 0; !oldOps.nodeCache.IsEmpty(); i++ {
+		require.True(t, i < 100, "Old nodes were never collected")
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, _, err = kbfsOps.GetRootNodeAtRevision(ctx, h, rev)
+	require.NoError(t, err)
+	require.False(t, hasOps(oldFB))
+
+	// The evicted revision can still be browsed again.
+	oldRoot, _, err = kbfsOps.GetRootNodeAtRevision(ctx, h, rev-2)
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, oldRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+	runtime.KeepAlive(newRoot)
+}
+
+func TestGetMDRevisionByTime(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	// TODO: Use kbfsTestShutdownNoMocks.
+	defer kbfsTestShutdownNoMocksNoCheck(t, config, ctx, cancel)
+
+	clock, t0 := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+
+	// Make a few revisions, one minute apart.
+	var revs []MetadataRevision
+	var times []time.Time
+	for _, name := range []string{"a", "b", "c", "d"} {
+		clock.Add(1 * time.Minute)
+		_, _, err := kbfsOps.CreateDir(ctx, rootNode, name)
+		require.NoError(t, err)
+		revs = append(revs, ops.getCurrMDRevision(makeFBOLockState()))
+		times = append(times, clock.Now())
+	}
+
+	h := parseTlfHandleOrBust(t, config, "test_user", false)
+	for i := range revs {
+		rev, err := GetMDRevisionByTime(ctx, config, h, times[i])
+		require.NoError(t, err)
+		require.Equal(t, revs[i], rev)
+
+		rev, err = GetMDRevisionByTime(
+			ctx, config, h, times[i].Add(30*time.Second))
+		require.NoError(t, err)
+		require.Equal(t, revs[i], rev)
+	}
+
+	_, err := GetMDRevisionByTime(ctx, config, h, t0.Add(-1*time.Hour))
+	require.IsType(t, NoMDRevisionBeforeTimeError{}, err)
+}
+
+func TestKBFSOpsRevertToRevision(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	// Make the blocks small, so that the file has indirect blocks.
+	bsplit := &BlockSplitterSimple{5, 2, 100 * 1024}
+	config.SetBlockSplitter(bsplit)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	oldData := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	err = kbfsOps.Write(ctx, fileNode, oldData, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, dirNode, "c", false, NoExcl)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	oldRev := ops.getCurrMDRevision(makeFBOLockState())
+
+	// Change everything.
+	err = kbfsOps.Write(ctx, fileNode, []byte{20, 21, 22}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Truncate(ctx, fileNode, 3)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, dirNode, "c")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, dirNode, "d")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "e", false, NoExcl)
+	require.NoError(t, err)
+
+	// Revert just the file first.
+	err = kbfsOps.RevertToRevision(ctx, fileNode, oldRev)
+	require.NoError(t, err)
+	ei, err := kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(oldData)), ei.Size)
+	buf := make([]byte, len(oldData))
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, oldData, buf[:n])
+
+	// Now revert the whole TLF.
+	err = kbfsOps.RevertToRevision(ctx, rootNode, oldRev)
+	require.NoError(t, err)
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Contains(t, children, "a")
+	require.Contains(t, children, "b")
+	children, err = kbfsOps.GetDirChildren(ctx, dirNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "c")
+
+	// Reverting to the current revision is a no-op.
+	rev := ops.getCurrMDRevision(makeFBOLockState())
+	err = kbfsOps.RevertToRevision(ctx, rootNode, rev)
+	require.NoError(t, err)
+	require.Equal(t, rev, ops.getCurrMDRevision(makeFBOLockState()))
+
+	// The reverted data survives a fresh read from the server.
+	err = kbfsOps.SyncFromServerForTesting(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	config.BlockCache().(*BlockCacheStandard).cleanTransient.Purge()
+	n, err = kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, oldData, buf[:n])
+
+	// Undo a rename, which can reuse the still-live file blocks.
+	err = kbfsOps.Rename(ctx, rootNode, "a", rootNode, "z")
+	require.NoError(t, err)
+	err = kbfsOps.RevertToRevision(ctx, rootNode, rev)
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Contains(t, children, "a")
+	fileNode, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	n, err = kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, oldData, buf[:n])
+
+	// Revisions in the future don't exist.
+	err = kbfsOps.RevertToRevision(ctx, rootNode, rev+100)
+	require.IsType(t, NoSuchMDError{}, err)
+}
+
+func TestKBFSOpsUndelete(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte{1, 2, 3, 4, 5}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveEntry(ctx, dirNode, "a")
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	rmRev := ops.getCurrMDRevision(makeFBOLockState())
+	entries, err := kbfsOps.GetDeletedEntries(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "b/a", entries[0].Path)
+	require.Equal(t, File.String(), entries[0].Type)
+	require.Equal(t, uint64(len(data)), entries[0].Size)
+	require.Equal(t, rmRev, entries[0].Revision)
+	require.Equal(t, "test_user", entries[0].Writer)
+
+	// Restore it and read it back.
+	err = kbfsOps.Undelete(ctx, dirNode, "a", rmRev)
+	require.NoError(t, err)
+	fileNode, _, err = kbfsOps.Lookup(ctx, dirNode, "a")
+	require.NoError(t, err)
+	buf := make([]byte, len(data))
+	n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+
+	// The name is taken now.
+	err = kbfsOps.Undelete(ctx, dirNode, "a", rmRev)
+	require.IsType(t, NameExistsError{}, err)
+
+	// The directory is found even after it's been renamed, and
+	// another directory has taken its old name.
+	err = kbfsOps.RemoveEntry(ctx, dirNode, "a")
+	require.NoError(t, err)
+	rmRev = ops.getCurrMDRevision(makeFBOLockState())
+	err = kbfsOps.Rename(ctx, rootNode, "b", rootNode, "d")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.Undelete(ctx, dirNode, "a", rmRev)
+	require.NoError(t, err)
+	fileNode, _, err = kbfsOps.Lookup(ctx, dirNode, "a")
+	require.NoError(t, err)
+	n, err = kbfsOps.Read(ctx, fileNode, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, data, buf[:n])
+
+	// Once quota reclamation deletes the blocks, the entry can't be
+	// restored anymore.
+	err = kbfsOps.RemoveEntry(ctx, dirNode, "a")
+	require.NoError(t, err)
+	rmRev = ops.getCurrMDRevision(makeFBOLockState())
+	clock.Set(now.Add(2 * config.QuotaReclamationMinUnrefAge()))
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "c")
+	require.NoError(t, err)
+	ops.fbm.forceQuotaReclamation()
+	err = ops.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+
+	entries, err = kbfsOps.GetDeletedEntries(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+	err = kbfsOps.Undelete(ctx, dirNode, "a", rmRev)
+	require.IsType(t, DeletedEntryReclaimedError{}, err)
+}
+
+func TestKBFSOpsDiffRevisions(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	fromRev := ops.getCurrMDRevision(makeFBOLockState())
+
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 2)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	err = kbfsOps.SetEx(ctx, fileNode, true)
+	require.NoError(t, err)
+	err = kbfsOps.Rename(ctx, dirNode, "a", rootNode, "c")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	toRev := ops.getCurrMDRevision(makeFBOLockState())
+
+	changes, err := kbfsOps.DiffRevisions(
+		ctx, rootNode.GetFolderBranch(), fromRev, toRev)
+	require.NoError(t, err)
+	require.Len(t, changes, 6)
+	for i, change := range changes {
+		require.Equal(t, fromRev+MetadataRevision(i+1), change.Revision)
+		require.Equal(t, "test_user", change.Writer)
+	}
+	require.Equal(t, PathAdded, changes[0].Type)
+	require.Equal(t, "b", changes[0].Path)
+	require.Equal(t, Dir.String(), changes[0].EntryType)
+	require.Equal(t, PathAdded, changes[1].Type)
+	require.Equal(t, "b/a", changes[1].Path)
+	require.Equal(t, PathModified, changes[2].Type)
+	require.Equal(t, "b/a", changes[2].Path)
+	require.Len(t, changes[2].Writes, 1)
+	require.Equal(t, uint64(2), changes[2].Writes[0].Off)
+	require.Equal(t, uint64(3), changes[2].Writes[0].Len)
+	require.Equal(t, PathAttrChanged, changes[3].Type)
+	require.Equal(t, "b/a", changes[3].Path)
+	require.Equal(t, exAttr.String(), changes[3].Attr)
+	require.Equal(t, PathRenamed, changes[4].Type)
+	require.Equal(t, "b/a", changes[4].OldPath)
+	require.Equal(t, "c", changes[4].Path)
+	require.Equal(t, PathRemoved, changes[5].Type)
+	require.Equal(t, "b", changes[5].Path)
+
+	// A sub-range only includes the later changes.
+	changes, err = kbfsOps.DiffRevisions(
+		ctx, rootNode.GetFolderBranch(), toRev-1, toRev)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, PathRemoved, changes[0].Type)
+
+	// A range that ends before the head stops at its end.
+	changes, err = kbfsOps.DiffRevisions(
+		ctx, rootNode.GetFolderBranch(), fromRev, fromRev+2)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, "b/a", changes[1].Path)
+
+	_, err = kbfsOps.DiffRevisions(
+		ctx, rootNode.GetFolderBranch(), toRev, fromRev)
+	require.IsType(t, InvalidRevisionRangeError{}, err)
+	_, err = kbfsOps.DiffRevisions(
+		ctx, rootNode.GetFolderBranch(), fromRev, toRev+1)
+	require.IsType(t, NoSuchMDError{}, err)
+}
+
+func TestKBFSOpsXattrs(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	names, err := kbfsOps.ListXattrs(ctx, fileNode)
+	require.NoError(t, err)
+	require.Len(t, names, 0)
+	_, err = kbfsOps.GetXattr(ctx, fileNode, "user.x")
+	require.IsType(t, NoSuchXattrError{}, err)
+
+	err = kbfsOps.SetXattr(ctx, fileNode, "user.y", []byte("y"))
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(ctx, fileNode, "user.x", []byte{})
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(ctx, dirNode, "user.z", []byte("z"))
+	require.NoError(t, err)
+
+	// Set an xattr while the file has dirty writes, and make sure
+	// the sync of the file doesn't clobber it.
+	data := []byte{1, 2, 3}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(ctx, fileNode, "user.y", []byte("yy"))
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	checkXattrs := func(config Config, expected map[string][]byte) {
+		kbfsOps := config.KBFSOps()
+		rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+		dirNode, _, err := kbfsOps.Lookup(ctx, rootNode, "b")
+		require.NoError(t, err)
+		fileNode, ei, err := kbfsOps.Lookup(ctx, dirNode, "a")
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(data)), ei.Size)
+
+		names, err := kbfsOps.ListXattrs(ctx, fileNode)
+		require.NoError(t, err)
+		var expectedNames []string
+		for name, value := range expected {
+			expectedNames = append(expectedNames, name)
+			v, err := kbfsOps.GetXattr(ctx, fileNode, name)
+			require.NoError(t, err)
+			require.Equal(t, value, v)
+		}
+		sort.Strings(expectedNames)
+		require.Equal(t, expectedNames, names)
+
+		v, err := kbfsOps.GetXattr(ctx, dirNode, "user.z")
+		require.NoError(t, err)
+		require.Equal(t, []byte("z"), v)
+	}
+	checkXattrs(config, map[string][]byte{
+		"user.x": {},
+		"user.y": []byte("yy"),
+	})
+
+	// Another device sees the same attributes.
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	checkXattrs(config2, map[string][]byte{
+		"user.x": {},
+		"user.y": []byte("yy"),
+	})
+
+	err = kbfsOps.RemoveXattr(ctx, fileNode, "user.x")
+	require.NoError(t, err)
+	err = kbfsOps.RemoveXattr(ctx, fileNode, "user.x")
+	require.IsType(t, NoSuchXattrError{}, err)
+	err = config2.KBFSOps().SyncFromServerForTesting(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	checkXattrs(config2, map[string][]byte{"user.y": []byte("yy")})
+
+	// The root has no entry to hold xattrs, and values are limited
+	// in size.
+	err = kbfsOps.SetXattr(ctx, rootNode, "user.x", []byte("x"))
+	require.IsType(t, InvalidParentPathError{}, err)
+	err = kbfsOps.SetXattr(
+		ctx, fileNode, "user.big", make([]byte, maxXattrValueBytes+1))
+	require.IsType(t, XattrTooBigError{}, err)
+
+	// So are all of an entry's values together, though they can
+	// still be shrunk or removed.
+	err = kbfsOps.SetXattr(
+		ctx, fileNode, "user.big1", make([]byte, maxXattrValueBytes))
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(
+		ctx, fileNode, "user.big2", make([]byte, maxXattrValueBytes))
+	require.IsType(t, XattrsTooBigError{}, err)
+	err = kbfsOps.SetXattr(
+		ctx, fileNode, "user.big1", make([]byte, maxXattrValueBytes/2))
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(
+		ctx, fileNode, "user.big2", make([]byte, maxXattrValueBytes))
+	require.NoError(t, err)
+	err = kbfsOps.SetXattr(
+		ctx, fileNode, "user.z", make([]byte, maxXattrValueBytes/2))
+	require.IsType(t, XattrsTooBigError{}, err)
+	err = kbfsOps.RemoveXattr(ctx, fileNode, "user.big1")
+	require.NoError(t, err)
+	checkXattrs(config, map[string][]byte{
+		"user.y":    []byte("yy"),
+		"user.big2": make([]byte, maxXattrValueBytes),
+	})
+}
+
+func TestKBFSOpsCopyFile(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	// Make the blocks small, so that the file has indirect blocks.
+	bsplit := &BlockSplitterSimple{5, 2, 100 * 1024}
+	config.SetBlockSplitter(bsplit)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", true, NoExcl)
+	require.NoError(t, err)
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+
+	// Copy the file while it still has dirty writes.
+	copyNode, ei, err := kbfsOps.CopyFile(ctx, fileNode, dirNode, "c")
+	require.NoError(t, err)
+	require.Equal(t, Exec, ei.Type)
+	require.Equal(t, uint64(len(data)), ei.Size)
+	require.NotEqual(t, fileNode.GetID(), copyNode.GetID())
+
+	// The name must be free, and the copy must stay within the TLF.
+	_, _, err = kbfsOps.CopyFile(ctx, fileNode, dirNode, "c")
+	require.IsType(t, NameExistsError{}, err)
+	_, _, err = kbfsOps.CopyFile(ctx, dirNode, rootNode, "d")
+	require.IsType(t, NotFileError{}, err)
+	pubNode := GetRootNodeOrBust(ctx, t, config, "test_user", true)
+	_, _, err = kbfsOps.CopyFile(ctx, fileNode, pubNode, "c")
+	require.IsType(t, CopyAcrossFoldersError{}, err)
+
+	// Writing to the copy leaves the original alone.
+	err = kbfsOps.Write(ctx, copyNode, []byte{20, 21}, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, copyNode)
+	require.NoError(t, err)
+	copyData := append([]byte{20, 21}, data[2:]...)
+
+	checkFile := func(
+		config Config, dirNames []string, name string, expected []byte) {
+		kbfsOps := config.KBFSOps()
+		n := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+		for _, dirName := range dirNames {
+			n, _, err = kbfsOps.Lookup(ctx, n, dirName)
+			require.NoError(t, err)
+		}
+		n, ei, err := kbfsOps.Lookup(ctx, n, name)
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(expected)), ei.Size)
+		gotData := make([]byte, len(expected))
+		nr, err := kbfsOps.Read(ctx, n, gotData, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(expected)), nr)
+		require.Equal(t, expected, gotData)
+	}
+	checkFile(config, nil, "a", data)
+	checkFile(config, []string{"b"}, "c", copyData)
+
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	checkFile(config2, nil, "a", data)
+	checkFile(config2, []string{"b"}, "c", copyData)
+
+	// Removing the original doesn't affect the shared blocks of the
+	// copy.
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = config2.KBFSOps().SyncFromServerForTesting(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	checkFile(config2, []string{"b"}, "c", copyData)
+}
+
+func TestKBFSOpsPunchHole(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	// Make the blocks small, so that the file has several levels of
+	// indirect blocks.
+	bsplit := &BlockSplitterSimple{5, 3, 100 * 1024}
+	config.SetBlockSplitter(bsplit)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 30)
+	for i := range data {
+		data[i] = byte(i + 1)
+	}
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	// Punch a hole that empties one block, and covers the tail and
+	// the head of its neighbors.
+	err = kbfsOps.PunchHole(ctx, fileNode, 7, 10)
+	require.NoError(t, err)
+	for i := 7; i < 17; i++ {
+		data[i] = 0
+	}
+
+	checkFile := func(config Config, expected []byte,
+		expectedOffs map[int64][2]int64) {
+		kbfsOps := config.KBFSOps()
+		rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+		n, ei, err := kbfsOps.Lookup(ctx, rootNode, "a")
+		require.NoError(t, err)
+		require.Equal(t, uint64(len(expected)), ei.Size)
+		gotData := make([]byte, len(expected))
+		nr, err := kbfsOps.Read(ctx, n, gotData, 0)
+		require.NoError(t, err)
+		require.Equal(t, int64(len(expected)), nr)
+		require.Equal(t, expected, gotData)
+		for off, expectedOff := range expectedOffs {
+			dataOff, err := kbfsOps.NextDataOffset(ctx, n, off)
+			require.NoError(t, err)
+			require.Equal(t, expectedOff[0], dataOff, "data at %d", off)
+			holeOff, err := kbfsOps.NextHoleOffset(ctx, n, off)
+			require.NoError(t, err)
+			require.Equal(t, expectedOff[1], holeOff, "hole at %d", off)
+		}
+	}
+	// The zeroed head of the block at offset 15 still counts as data.
+	expectedOffs := map[int64][2]int64{
+		0:  {0, 7},
+		7:  {15, 7},
+		12: {15, 12},
+		15: {15, 30},
+		29: {29, 30},
+		30: {-1, -1},
+	}
+	checkFile(config, data, expectedOffs)
+
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	checkFile(config2, data, expectedOffs)
+
+	// Punching past the end of the file leaves the size alone.
+	err = kbfsOps.PunchHole(ctx, fileNode, 25, 100)
+	require.NoError(t, err)
+	for i := 25; i < 30; i++ {
+		data[i] = 0
+	}
+	expectedOffs[15] = [2]int64{15, 25}
+	expectedOffs[29] = [2]int64{-1, 29}
+	checkFile(config, data, expectedOffs)
+
+	// Writing into the hole fills it back in.
+	err = kbfsOps.Write(ctx, fileNode, []byte{40, 41}, 10)
+	require.NoError(t, err)
+	data[10], data[11] = 40, 41
+	expectedOffs[7] = [2]int64{10, 7}
+	expectedOffs[12] = [2]int64{15, 12}
+	checkFile(config, data, expectedOffs)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	err = config2.KBFSOps().SyncFromServerForTesting(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	checkFile(config2, data, expectedOffs)
+
+	_, err = kbfsOps.NextDataOffset(ctx, fileNode, -1)
+	require.IsType(t, InvalidRangeError{}, err)
+	err = kbfsOps.PunchHole(ctx, fileNode, 0, -1)
+	require.IsType(t, InvalidRangeError{}, err)
+}
+
+func TestKBFSOpsFileLocks(t *testing.T) {
+	var u1, u2 libkb.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1, u2)
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+	clock := newTestClockNow()
+	config1.SetClock(clock)
+
+	config2 := ConfigAsUser(config1, u2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	name := u1.String() + "," + u2.String()
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode1, _, err := kbfsOps1.CreateFile(
+		ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode1)
+	require.NoError(t, err)
+
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+
+	// Owners on the same device conflict with each other.
+	lock1 := FileLock{Type: FileLockWrite, Owner: 1, Start: 0, Length: 10}
+	err = kbfsOps1.LockFile(ctx, fileNode1, lock1)
+	require.NoError(t, err)
+	// The first lock gives the file a lock ID, which the other
+	// device needs to see to find the lease.
+	err = kbfsOps2.SyncFromServerForTesting(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps1.LockFile(ctx, fileNode1,
+		FileLock{Type: FileLockRead, Owner: 2, Start: 5, Length: 1})
+	require.Equal(t, FileLockConflictError{lock1}, err)
+	err = kbfsOps1.LockFile(ctx, fileNode1,
+		FileLock{Type: FileLockRead, Owner: 2, Start: 10})
+	require.NoError(t, err)
+
+	// The locks are visible from the other device.
+	whole := FileLock{Type: FileLockWrite, Owner: 1}
+	err = kbfsOps2.LockFile(ctx, fileNode2, whole)
+	require.IsType(t, FileLockConflictError{}, err)
+	conflict, conflicted, err := kbfsOps2.GetFileLockConflict(
+		ctx, fileNode2, FileLock{Type: FileLockRead, Owner: 1, Length: 5})
+	require.NoError(t, err)
+	require.True(t, conflicted)
+	require.Equal(t, lock1, conflict)
+
+	// Shared locks don't conflict with each other.
+	err = kbfsOps2.LockFile(ctx, fileNode2,
+		FileLock{Type: FileLockRead, Owner: 1, Start: 20, Length: 5})
+	require.NoError(t, err)
+
+	// Unlocking part of a lock leaves the rest locked.
+	err = kbfsOps1.UnlockFile(ctx, fileNode1,
+		FileLock{Owner: 1, Start: 0, Length: 5})
+	require.NoError(t, err)
+	_, conflicted, err = kbfsOps2.GetFileLockConflict(
+		ctx, fileNode2, FileLock{Type: FileLockWrite, Owner: 1, Length: 5})
+	require.NoError(t, err)
+	require.False(t, conflicted)
+	err = kbfsOps2.LockFile(ctx, fileNode2,
+		FileLock{Type: FileLockWrite, Owner: 1, Start: 4, Length: 2})
+	require.IsType(t, FileLockConflictError{}, err)
+
+	err = kbfsOps1.UnlockFile(ctx, fileNode1, FileLock{Owner: 1})
+	require.NoError(t, err)
+	err = kbfsOps1.UnlockFile(ctx, fileNode1, FileLock{Owner: 2})
+	require.NoError(t, err)
+	err = kbfsOps2.UnlockFile(ctx, fileNode2, FileLock{Owner: 1})
+	require.NoError(t, err)
+
+	err = kbfsOps2.LockFile(ctx, fileNode2, whole)
+	require.NoError(t, err)
+	err = kbfsOps2.UnlockFile(ctx, fileNode2, whole)
+	require.NoError(t, err)
+
+	// Leases follow the file when it's renamed.
+	err = kbfsOps1.LockFile(ctx, fileNode1, whole)
+	require.NoError(t, err)
+	err = kbfsOps1.Rename(ctx, rootNode1, "a", rootNode1, "b")
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.LockFile(ctx, fileNode2, whole)
+	require.IsType(t, FileLockConflictError{}, err)
+
+	// flock locks are held separately from POSIX locks with the same
+	// owner, but the two still conflict.
+	wholeFlock := whole
+	wholeFlock.Flock = true
+	err = kbfsOps1.LockFile(ctx, fileNode1, wholeFlock)
+	require.Equal(t, FileLockConflictError{whole}, err)
+	err = kbfsOps1.UnlockFile(ctx, fileNode1, wholeFlock)
+	require.NoError(t, err)
+	err = kbfsOps2.LockFile(ctx, fileNode2, whole)
+	require.IsType(t, FileLockConflictError{}, err)
+	err = kbfsOps1.UnlockFile(ctx, fileNode1, whole)
+	require.NoError(t, err)
+
+	// If a device stops renewing its lease, e.g. because it went
+	// offline, the lock expires for everyone else.
+	err = kbfsOps1.LockFile(ctx, fileNode1, whole)
+	require.NoError(t, err)
+	err = kbfsOps2.LockFile(ctx, fileNode2, whole)
+	require.IsType(t, FileLockConflictError{}, err)
+	clock.Add(fileLockLeaseTTL)
+	err = kbfsOps2.LockFile(ctx, fileNode2, whole)
+	require.NoError(t, err)
+
+	// When the first device comes back, it can't renew the lease, so
+	// it drops the lock and tells the owner.
+	ops1 := getOps(config1, rootNode1.GetFolderBranch().Tlf)
+	require.True(t, ops1.fileLocks.renewLeases(ctx))
+	err = kbfsOps1.LockFile(ctx, fileNode1, whole)
+	require.IsType(t, FileLockLostError{}, err)
+	err = kbfsOps1.LockFile(ctx, fileNode1, whole)
+	require.IsType(t, FileLockConflictError{}, err)
+	require.False(t, ops1.fileLocks.renewLeases(ctx))
+
+	err = kbfsOps1.LockFile(ctx, fileNode1,
+		FileLock{Type: FileLockRead, Owner: 1, Start: -1})
+	require.IsType(t, InvalidRangeError{}, err)
+}
+
+func TestKBFSOpsCDCDedupAfterInsert(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	// Small blocks with few pointers, so the file has several levels
+	// of indirection.
+	config.SetBlockSplitter(newBlockSplitterCDCWithSizes(64, 256, 4, 100*1024))
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 8*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	getIDs := func() map[kbfsblock.ID]bool {
+		lState := makeFBOLockState()
+		p, err := ops.pathFromNodeForRead(fileNode)
+		require.NoError(t, err)
+		md, err := ops.getMDForReadNeedIdentify(ctx, lState)
+		require.NoError(t, err)
+		infos, err := ops.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, md.ReadOnly(), p)
+		require.NoError(t, err)
+		ids := make(map[kbfsblock.ID]bool)
+		for _, info := range infos {
+			ids[info.ID] = true
+		}
+		return ids
+	}
+	oldIDs := getIDs()
+
+	// Insert a byte near the start of the file by rewriting it, as
+	// an editor would.
+	newData := append([]byte{}, data[:100]...)
+	newData = append(newData, 0xff)
+	newData = append(newData, data[100:]...)
+	err = kbfsOps.Truncate(ctx, fileNode, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, newData, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	newIDs := getIDs()
+	shared := 0
+	for id := range newIDs {
+		if oldIDs[id] {
+			shared++
+		}
+	}
+	// Only the blocks around the insertion, and the indirect blocks
+	// above them, should differ.
+	require.True(t, shared > len(newIDs)/2,
+		"Only %d of %d blocks shared", shared, len(newIDs))
+
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "test_user", false)
+	fileNode2, _, err := config2.KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	gotData := make([]byte, len(newData))
+	nr, err := config2.KBFSOps().Read(ctx, fileNode2, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(newData)), nr)
+	require.Equal(t, newData, gotData)
+}
+
+func TestKBFSOpsBlockCompression(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetBlockCompression(true)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("some very compressible text\n"), 1000)
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	p, err := ops.pathFromNodeForRead(fileNode)
+	require.NoError(t, err)
+	require.Equal(t, CompressedBlocksDataVer, p.tailPointer().DataVer)
+
+	// A device that doesn't compress blocks can still read them.
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	require.False(t, config2.BlockCompression())
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "test_user", false)
+	fileNode2, _, err := config2.KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	gotData := make([]byte, len(data))
+	nr, err := config2.KBFSOps().Read(ctx, fileNode2, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), nr)
+	require.Equal(t, data, gotData)
+}
+
+func TestKBFSOpsChunkedBlockEncryption(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetBlockEncryptionVer(EncryptionXChaCha20Poly1305)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 3*chunkedPlaintextSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	p, err := ops.pathFromNodeForRead(fileNode)
+	require.NoError(t, err)
+	require.Equal(t, ChunkedEncryptionDataVer, p.tailPointer().DataVer)
+
+	// A device that still encrypts with secretbox can read the
+	// file.
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	require.Equal(t, EncryptionSecretbox, config2.BlockEncryptionVer())
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "test_user", false)
+	fileNode2, _, err := config2.KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	gotData := make([]byte, len(data))
+	nr, err := config2.KBFSOps().Read(ctx, fileNode2, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), nr)
+	require.Equal(t, data, gotData)
+}
+
+// pinCountingDiskBlockCache counts how many times each block is
+// pinned.
+type pinCountingDiskBlockCache struct {
+	DiskBlockCache
+	lock sync.Mutex
+	pins map[kbfsblock.ID]int
+}
+
+func (c *pinCountingDiskBlockCache) Pin(
+	ctx context.Context, tlfID tlf.ID, blockID kbfsblock.ID) error {
+	c.lock.Lock()
+	c.pins[blockID]++
+	c.lock.Unlock()
+	return c.DiskBlockCache.Pin(ctx, tlfID, blockID)
+}
+
+func (c *pinCountingDiskBlockCache) resetPins() map[kbfsblock.ID]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pins := c.pins
+	c.pins = make(map[kbfsblock.ID]int)
+	return pins
+}
+
+func TestKBFSOpsKeepSynced(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", false)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	subdirNode, _, err := kbfsOps.CreateDir(ctx, dirNode, "b")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, fileNode, []byte("offline"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, fileNode)
+	require.NoError(t, err)
+	otherNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "x", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, otherNode, []byte("online"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, otherNode)
+	require.NoError(t, err)
+
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	getID := func(n Node) kbfsblock.ID {
+		p, err := ops.pathFromNodeForRead(n)
+		require.NoError(t, err)
+		return p.tailPointer().ID
+	}
+	fileID := getID(fileNode)
+	subdirID := getID(subdirNode)
+	otherID := getID(otherNode)
+
+	t.Log("Keep just \"a\" synced on a second device with a disk cache.")
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "kbfs_ops_keep_synced")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	config2.storageRoot = tempdir
+	_, err = config2.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	dbc, err := newDiskBlockCacheStandardForTest(
+		newTestDiskBlockCacheConfig(t), testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+	pinCounter := &pinCountingDiskBlockCache{
+		DiskBlockCache: dbc,
+		pins:           make(map[kbfsblock.ID]int),
+	}
+	config2.SetDiskBlockCache(pinCounter)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "test_user", false)
+	kbfsOps2 := config2.KBFSOps()
+	dirNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	err = kbfsOps2.SetKeepSynced(ctx, dirNode2, true)
+	require.NoError(t, err)
+	ops2 := getOps(config2, rootNode.GetFolderBranch().Tlf)
+	err = ops2.syncer.waitForSync(ctx)
+	require.NoError(t, err)
+
+	checkPinned := func(id kbfsblock.ID, expected bool) {
+		md, err := dbc.getMetadata(id)
+		if !expected && err != nil {
+			return
+		}
+		require.NoError(t, err)
+		require.Equal(t, expected, md.Pinned)
+	}
+	fb := rootNode.GetFolderBranch()
+	status, err := kbfsOps2.GetSyncStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, status.Paths)
+	require.Equal(t, ops2.getCurrMDRevision(makeFBOLockState()),
+		status.SyncedRevision)
+	// The root directory, "a", "a/b", and "a/f".
+	require.Equal(t, 4, status.SyncedBlocks)
+	require.Equal(t, "", status.LastError)
+	checkPinned(fileID, true)
+	checkPinned(otherID, false)
+	require.NotZero(t, pinCounter.resetPins()[fileID])
+
+	t.Log("New revisions get synced too, without walking the " +
+		"unchanged subtrees again.")
+	newNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "g", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, newNode, []byte("new"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Sync(ctx, newNode)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServerForTesting(ctx, fb)
+	require.NoError(t, err)
+	err = ops2.syncer.waitForSync(ctx)
+	require.NoError(t, err)
+	status, err = kbfsOps2.GetSyncStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, ops2.getCurrMDRevision(makeFBOLockState()),
+		status.SyncedRevision)
+	require.Equal(t, 5, status.SyncedBlocks)
+	checkPinned(getID(newNode), true)
+	checkPinned(fileID, true)
+	pins := pinCounter.resetPins()
+	require.NotZero(t, pins[getID(newNode)])
+	require.Equal(t, 0, pins[fileID])
+	require.Equal(t, 0, pins[subdirID])
+
+	t.Log("Syncing resumes at startup.")
+	config3 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config3)
+	config3.storageRoot = tempdir
+	_, err = config3.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	dbc3, err := newDiskBlockCacheStandardForTest(
+		newTestDiskBlockCacheConfig(t), testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+	config3.SetDiskBlockCache(dbc3)
+	err = startSyncedFolders(ctx, config3)
+	require.NoError(t, err)
+	err = getOps(config3, fb.Tlf).syncer.waitForSync(ctx)
+	require.NoError(t, err)
+	status, err = config3.KBFSOps().GetSyncStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, status.Paths)
+	require.Equal(t, ops2.getCurrMDRevision(makeFBOLockState()),
+		status.SyncedRevision)
+	require.Equal(t, 5, status.SyncedBlocks)
+
+	t.Log("Startup skips syncing if KBFSOps can't load folders by ID.")
+	kbfsOps3 := config3.KBFSOps()
+	config3.SetKBFSOps(struct{ KBFSOps }{kbfsOps3})
+	err = startSyncedFolders(ctx, config3)
+	config3.SetKBFSOps(kbfsOps3)
+	require.NoError(t, err)
+
+	t.Log("Stop keeping \"a\" synced.")
+	err = kbfsOps2.SetKeepSynced(ctx, dirNode2, false)
+	require.NoError(t, err)
+	status, err = kbfsOps2.GetSyncStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Len(t, status.Paths, 0)
+	checkPinned(fileID, false)
+}
@@ -55,8 +55,8 @@ func serviceLoggedIn(ctx context.Context, config Config, name string,
 		}
 	}
 	if config.DiskBlockCache() == nil && adminFeatureList[session.UID] {
-		dbc, err := newDiskBlockCacheStandard(config,
-			diskBlockCacheRootFromStorageRoot(config.StorageRoot()))
+		dbc, err := newDiskBlockCacheStandardForConfig(
+			ctx, config, config.StorageRoot())
 		if err == nil {
 			config.SetDiskBlockCache(dbc)
 		}
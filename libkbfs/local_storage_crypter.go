@@ -0,0 +1,227 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// localStorageKeyMessage is signed by the current device's signing
+// key to derive the key for local storage.  Since ed25519 signatures
+// are deterministic, each device always gets the same key, and since
+// nothing else ever signs this message, the signature (and hence
+// the key) never leaves the device.
+var localStorageKeyMessage = []byte("Keybase KBFS local storage key v1")
+
+// localStorageCrypterConfig is the subset of the Config interface
+// needed to make a localStorageCrypter.
+type localStorageCrypterConfig interface {
+	codecGetter
+	cryptoGetter
+	localStorageEncryptionGetter
+}
+
+// localStorageCrypter encrypts the metadata that KBFS keeps under
+// its storage root (disk block cache metadata and journal info
+// files), so that someone with access to the disk can't tell which
+// folders were accessed, and when, without the device's key.  All of
+// its methods may be called on a nil *localStorageCrypter, in which
+// case they pass the data through unencrypted.
+type localStorageCrypter struct {
+	codec   kbfscodec.Codec
+	crypto  CryptoCommon
+	dataKey [32]byte
+	nameKey [32]byte
+}
+
+// deriveLocalStorageKey derives a key for a particular use from the
+// secret for local storage.
+func deriveLocalStorageKey(secret []byte, use string) (key [32]byte) {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(use))
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+func newLocalStorageCrypter(
+	codec kbfscodec.Codec, secret []byte) *localStorageCrypter {
+	return &localStorageCrypter{
+		codec:   codec,
+		crypto:  MakeCryptoCommon(codec),
+		dataKey: deriveLocalStorageKey(secret, "data"),
+		nameKey: deriveLocalStorageKey(secret, "name"),
+	}
+}
+
+// makeLocalStorageCrypter returns a localStorageCrypter keyed by the
+// current device, or nil if the config doesn't ask for local storage
+// to be encrypted.  It fails if nobody is logged in.
+func makeLocalStorageCrypter(ctx context.Context,
+	config localStorageCrypterConfig) (*localStorageCrypter, error) {
+	if !config.EncryptLocalStorage() {
+		return nil, nil
+	}
+	sigInfo, err := config.Crypto().Sign(ctx, localStorageKeyMessage)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't make local storage key")
+	}
+	return newLocalStorageCrypter(config.Codec(), sigInfo.Signature), nil
+}
+
+func (c *localStorageCrypter) encrypt(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	ed, err := c.crypto.encryptData(data, c.dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return c.codec.Encode(ed)
+}
+
+func (c *localStorageCrypter) decrypt(buf []byte) ([]byte, error) {
+	if c == nil {
+		return buf, nil
+	}
+	var ed encryptedData
+	err := c.codec.Decode(buf, &ed)
+	if err != nil {
+		return nil, err
+	}
+	return c.crypto.decryptData(ed, c.dataKey)
+}
+
+// obfuscate returns a keyed hash of the given data, for use in place
+// of identifying data (like a TLF ID) in file names and database
+// keys.  Equal inputs give equal outputs, so it still works as a
+// lookup key.
+func (c *localStorageCrypter) obfuscate(data []byte) []byte {
+	if c == nil {
+		return data
+	}
+	mac := hmac.New(sha256.New, c.nameKey[:])
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// obfuscateName is like obfuscate, except that it returns n (or
+// fewer) hex characters suitable for a file name, or name itself if
+// c is nil.
+func (c *localStorageCrypter) obfuscateName(name string, n int) string {
+	if c == nil {
+		return name
+	}
+	hexName := hex.EncodeToString(c.obfuscate([]byte(name)))
+	if len(hexName) > n {
+		hexName = hexName[:n]
+	}
+	return hexName
+}
+
+// serializeToJSONFile is like ioutil.SerializeToJSONFile, except
+// that it encrypts the file.
+func (c *localStorageCrypter) serializeToJSONFile(
+	obj interface{}, path string) error {
+	if c == nil {
+		return ioutil.SerializeToJSONFile(obj, path)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %q as JSON", path)
+	}
+	return c.writeFile(path, data)
+}
+
+// deserializeFromJSONFile is like ioutil.DeserializeFromJSONFile,
+// except that it decrypts the file first.
+func (c *localStorageCrypter) deserializeFromJSONFile(
+	path string, objPtr interface{}) error {
+	if c == nil {
+		return ioutil.DeserializeFromJSONFile(path, objPtr)
+	}
+	data, err := c.readFile(path)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, objPtr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %q as JSON", path)
+	}
+	return nil
+}
+
+// serializeToFile is like kbfscodec.SerializeToFile, except that it
+// encrypts the file.
+func (c *localStorageCrypter) serializeToFile(
+	codec kbfscodec.Codec, obj interface{}, path string) error {
+	if c == nil {
+		return kbfscodec.SerializeToFile(codec, obj, path)
+	}
+	data, err := codec.Encode(obj)
+	if err != nil {
+		return err
+	}
+	return c.writeFile(path, data)
+}
+
+// deserializeFromFile is like kbfscodec.DeserializeFromFile, except
+// that it decrypts the file first.
+func (c *localStorageCrypter) deserializeFromFile(
+	codec kbfscodec.Codec, path string, objPtr interface{}) error {
+	if c == nil {
+		return kbfscodec.DeserializeFromFile(codec, path, objPtr)
+	}
+	data, err := c.readFile(path)
+	if err != nil {
+		return err
+	}
+	return codec.Decode(data, objPtr)
+}
+
+// isLocalStorageEncryptedFile returns whether the file at the given
+// path was written by a non-nil localStorageCrypter.  It doesn't need
+// the key, so it works no matter which mode local storage is in now.
+func isLocalStorageEncryptedFile(codec kbfscodec.Codec, path string) bool {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var ed encryptedData
+	err = codec.Decode(buf, &ed)
+	return err == nil && len(ed.EncryptedData) > 0 && len(ed.Nonce) > 0
+}
+
+func (c *localStorageCrypter) writeFile(path string, data []byte) error {
+	err := ioutil.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	buf, err := c.encrypt(data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf, 0600)
+}
+
+func (c *localStorageCrypter) readFile(path string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.decrypt(buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt %q", path)
+	}
+	return data, nil
+}
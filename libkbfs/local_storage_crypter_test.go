@@ -0,0 +1,99 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestLocalStorageCrypterKey(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "test_user")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	ctx := context.Background()
+
+	crypter, err := makeLocalStorageCrypter(ctx, config)
+	require.NoError(t, err)
+	require.Nil(t, crypter)
+
+	config.SetEncryptLocalStorage(true)
+	crypter, err = makeLocalStorageCrypter(ctx, config)
+	require.NoError(t, err)
+	require.NotNil(t, crypter)
+
+	// The same device always gets the same key.
+	crypter2, err := makeLocalStorageCrypter(ctx, config)
+	require.NoError(t, err)
+	require.Equal(t, crypter.dataKey, crypter2.dataKey)
+	require.Equal(t, crypter.nameKey, crypter2.nameKey)
+	require.NotEqual(t, crypter.dataKey, crypter.nameKey)
+}
+
+func TestLocalStorageCrypterFiles(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "local_storage_crypter")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	codec := kbfscodec.NewMsgpack()
+	crypter := newLocalStorageCrypter(codec, []byte("secret"))
+	type info struct {
+		Name string
+	}
+	in := info{"secret name"}
+
+	path := filepath.Join(tempdir, "dir", "info.json")
+	err = crypter.serializeToJSONFile(in, path)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(buf), in.Name)
+
+	var out info
+	err = crypter.deserializeFromJSONFile(path, &out)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+
+	// A plain reader, or one with another key, can't read it.
+	err = ioutil.DeserializeFromJSONFile(path, &out)
+	require.Error(t, err)
+	other := newLocalStorageCrypter(codec, []byte("other"))
+	err = other.deserializeFromJSONFile(path, &out)
+	require.Error(t, err)
+
+	path = filepath.Join(tempdir, "info")
+	err = crypter.serializeToFile(codec, in, path)
+	require.NoError(t, err)
+	out = info{}
+	err = crypter.deserializeFromFile(codec, path, &out)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+
+	// A missing file still looks like one.
+	err = crypter.deserializeFromFile(
+		codec, filepath.Join(tempdir, "missing"), &out)
+	require.True(t, ioutil.IsNotExist(err))
+
+	// A nil crypter passes everything through.
+	var nilCrypter *localStorageCrypter
+	err = nilCrypter.serializeToJSONFile(in, path)
+	require.NoError(t, err)
+	out = info{}
+	err = ioutil.DeserializeFromJSONFile(path, &out)
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+	require.Equal(t, "name", nilCrypter.obfuscateName("name", 2))
+	require.Len(t, crypter.obfuscateName("name", 16), 16)
+	require.NotEqual(t, crypter.obfuscateName("name", 16),
+		other.obfuscateName("name", 16))
+}
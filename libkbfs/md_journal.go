@@ -132,6 +132,8 @@ type mdJournal struct {
 	tlfID  tlf.ID
 	mdVer  MetadataVer
 	dir    string
+	// crypter, if non-nil, encrypts the info for each MD.
+	crypter *localStorageCrypter
 
 	log      logger.Logger
 	deferLog logger.Logger
@@ -159,7 +161,7 @@ func makeMDJournalWithIDJournal(
 	ctx context.Context, uid keybase1.UID, key kbfscrypto.VerifyingKey,
 	codec kbfscodec.Codec, crypto cryptoPure, clock Clock, tlfID tlf.ID,
 	mdVer MetadataVer, dir string, idJournal mdIDJournal,
-	log logger.Logger) (*mdJournal, error) {
+	crypter *localStorageCrypter, log logger.Logger) (*mdJournal, error) {
 	if uid == keybase1.UID("") {
 		return nil, errors.New("Empty user")
 	}
@@ -177,6 +179,7 @@ func makeMDJournalWithIDJournal(
 		tlfID:    tlfID,
 		mdVer:    mdVer,
 		dir:      dir,
+		crypter:  crypter,
 		log:      log,
 		deferLog: deferLog,
 		j:        idJournal,
@@ -220,7 +223,7 @@ func mdJournalPath(dir string) string {
 func makeMDJournal(
 	ctx context.Context, uid keybase1.UID, key kbfscrypto.VerifyingKey,
 	codec kbfscodec.Codec, crypto cryptoPure, clock Clock, tlfID tlf.ID,
-	mdVer MetadataVer, dir string,
+	mdVer MetadataVer, dir string, crypter *localStorageCrypter,
 	log logger.Logger) (*mdJournal, error) {
 	journalDir := mdJournalPath(dir)
 	idJournal, err := makeMdIDJournal(codec, journalDir)
@@ -229,7 +232,7 @@ func makeMDJournal(
 	}
 	return makeMDJournalWithIDJournal(
 		ctx, uid, key, codec, crypto, clock, tlfID, mdVer, dir,
-		idJournal, log)
+		idJournal, crypter, log)
 }
 
 // The functions below are for building various paths.
@@ -296,7 +299,7 @@ type mdInfo struct {
 
 func (j mdJournal) getMDInfo(id MdID) (time.Time, MetadataVer, error) {
 	var info mdInfo
-	err := ioutil.DeserializeFromJSONFile(j.mdInfoPath(id), &info)
+	err := j.crypter.deserializeFromJSONFile(j.mdInfoPath(id), &info)
 	if err != nil {
 		return time.Time{}, MetadataVer(-1), err
 	}
@@ -309,7 +312,7 @@ func (j mdJournal) getMDInfo(id MdID) (time.Time, MetadataVer, error) {
 func (j mdJournal) putMDInfo(
 	id MdID, timestamp time.Time, version MetadataVer) error {
 	info := mdInfo{timestamp, version}
-	return ioutil.SerializeToJSONFile(info, j.mdInfoPath(id))
+	return j.crypter.serializeToJSONFile(info, j.mdInfoPath(id))
 }
 
 // getExtraMetadata gets the extra metadata corresponding to the given
@@ -1467,7 +1470,7 @@ func (j *mdJournal) resolveAndClear(
 
 	otherJournal, err := makeMDJournalWithIDJournal(
 		ctx, j.uid, j.key, j.codec, j.crypto, j.clock, j.tlfID, j.mdVer, j.dir,
-		otherIDJournal, j.log)
+		otherIDJournal, j.crypter, j.log)
 	if err != nil {
 		return MdID{}, err
 	}
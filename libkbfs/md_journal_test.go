@@ -71,7 +71,7 @@ func setupMDJournalTest(t testing.TB, ver MetadataVer) (
 	ctx := context.Background()
 	j, err = makeMDJournal(
 		ctx, uid, verifyingKey, codec, crypto, wallClock{},
-		tlfID, ver, tempdir, log)
+		tlfID, ver, tempdir, nil, log)
 	require.NoError(t, err)
 
 	bsplit = &BlockSplitterSimple{64 * 1024, int(64 * 1024 / bpSize), 8 * 1024}
@@ -982,7 +982,7 @@ func testMDJournalRestart(t *testing.T, ver MetadataVer) {
 	// Restart journal.
 	ctx := context.Background()
 	j, err := makeMDJournal(ctx, j.uid, j.key, codec, crypto, j.clock,
-		j.tlfID, j.mdVer, j.dir, j.log)
+		j.tlfID, j.mdVer, j.dir, nil, j.log)
 	require.NoError(t, err)
 
 	require.Equal(t, uint64(mdCount), j.length())
@@ -1024,7 +1024,7 @@ func testMDJournalRestartAfterBranchConversion(t *testing.T, ver MetadataVer) {
 	// Restart journal.
 
 	j, err = makeMDJournal(ctx, j.uid, j.key, codec, crypto, j.clock,
-		j.tlfID, j.mdVer, j.dir, j.log)
+		j.tlfID, j.mdVer, j.dir, nil, j.log)
 	require.NoError(t, err)
 
 	require.Equal(t, uint64(mdCount), j.length())
@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/logger"
@@ -180,6 +181,55 @@ func getSingleMD(ctx context.Context, config Config, id tlf.ID, bid BranchID,
 	return rmds[0], nil
 }
 
+// GetMDRevisionByTime returns the latest merged revision of the
+// given TLF that was written at or before the given time, according
+// to the (offset-corrected) server timestamps of its MDs.  It
+// returns NoMDRevisionBeforeTimeError if the TLF has no revision
+// that old.
+func GetMDRevisionByTime(ctx context.Context, config Config,
+	handle *TlfHandle, t time.Time) (MetadataRevision, error) {
+	id, err := config.KBFSOps().GetTLFID(ctx, handle)
+	if err != nil {
+		return MetadataRevisionUninitialized, err
+	}
+
+	head, err := config.MDOps().GetForTLF(ctx, id)
+	if err != nil {
+		return MetadataRevisionUninitialized, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return MetadataRevisionUninitialized,
+			errors.WithStack(NoMergedMDError{id})
+	}
+	if !head.LocalTimestamp().After(t) {
+		return head.Revision(), nil
+	}
+
+	// Binary search for the last revision that isn't after t.  The
+	// invariant is that `low` (if valid) is at or before t, and
+	// `high` is after t.
+	low := MetadataRevisionUninitialized
+	high := head.Revision()
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		rmd, err := getSingleMD(ctx, config, id, NullBranchID, mid, Merged)
+		if err != nil {
+			return MetadataRevisionUninitialized, err
+		}
+		if rmd.LocalTimestamp().After(t) {
+			high = mid
+		} else {
+			low = mid
+		}
+	}
+
+	if low < MetadataRevisionInitial {
+		return MetadataRevisionUninitialized,
+			NoMDRevisionBeforeTimeError{handle.GetCanonicalName(), t}
+	}
+	return low, nil
+}
+
 // getMergedMDUpdates returns a slice of all the merged MDs for a TLF,
 // starting from the given startRev.  The returned MDs are the same
 // instances that are stored in the MD cache, so they should be
@@ -189,15 +239,34 @@ func getSingleMD(ctx context.Context, config Config, id tlf.ID, bid BranchID,
 // instead of the cached versions.
 func getMergedMDUpdates(ctx context.Context, config Config, id tlf.ID,
 	startRev MetadataRevision) (mergedRmds []ImmutableRootMetadata, err error) {
+	return getMergedMDUpdatesWithEnd(
+		ctx, config, id, startRev, MetadataRevisionUninitialized)
+}
+
+// getMergedMDUpdatesWithEnd is like getMergedMDUpdates, except that
+// if endRev is valid, it stops fetching at endRev (inclusive) instead
+// of at the latest revision.  Like getMergedMDUpdates, it makes the
+// MDs readable with the keys in the latest merged MD, which it
+// fetches separately if it's past endRev.
+func getMergedMDUpdatesWithEnd(ctx context.Context, config Config,
+	id tlf.ID, startRev, endRev MetadataRevision) (
+	mergedRmds []ImmutableRootMetadata, err error) {
 	// We don't yet know about any revisions yet, so there's no range
 	// to get.
 	if startRev < MetadataRevisionInitial {
 		return nil, nil
 	}
+	bounded := endRev != MetadataRevisionUninitialized
+	if bounded && endRev < startRev {
+		return nil, nil
+	}
 
 	start := startRev
 	for {
 		end := start + maxMDsAtATime - 1 // range is inclusive
+		if bounded && end > endRev {
+			end = endRev
+		}
 		rmds, err := getMDRange(ctx, config, id, NullBranchID, start, end,
 			Merged)
 		if err != nil {
@@ -208,61 +277,85 @@ func getMergedMDUpdates(ctx context.Context, config Config, id tlf.ID,
 
 		// TODO: limit the number of MDs we're allowed to hold in
 		// memory at any one time?
-		if len(rmds) < maxMDsAtATime {
+		if len(rmds) < maxMDsAtATime || (bounded && end == endRev) {
 			break
 		}
 		start = end + 1
 	}
 
-	var uid keybase1.UID
 	// Check the readability of each MD.  Because rekeys can append a
 	// MD revision with the new key, older revisions might not be
 	// readable until the newer revision, containing the key for this
 	// device, is processed.
+	var latestRmd ImmutableRootMetadata
+	haveLatest := false
 	for i, rmd := range mergedRmds {
-		if err := isReadableOrError(ctx, config.KBPKI(), rmd.ReadOnly()); err != nil {
-			// The right secret key for the given rmd's
-			// key generation may only be present in the
-			// most recent rmd.
-			latestRmd := mergedRmds[len(mergedRmds)-1]
-
-			if uid == keybase1.UID("") {
-				session, err := config.KBPKI().GetCurrentSession(ctx)
+		if isReadableOrError(ctx, config.KBPKI(), rmd.ReadOnly()) == nil {
+			continue
+		}
+		// The right secret key for the given rmd's key generation
+		// may only be present in the most recent rmd, which may be
+		// past the end of the range.
+		if !haveLatest {
+			latestRmd = mergedRmds[len(mergedRmds)-1]
+			if bounded {
+				latestRmd, err = config.MDOps().GetForTLF(ctx, id)
 				if err != nil {
 					return nil, err
 				}
-				uid = session.UID
-			}
-
-			pmd, err := decryptMDPrivateData(
-				ctx, config.Codec(), config.Crypto(),
-				config.BlockCache(), config.BlockOps(),
-				config.KeyManager(), config.Mode(), uid,
-				rmd.GetSerializedPrivateMetadata(),
-				rmd, latestRmd, config.MakeLogger(""))
-			if err != nil {
-				return nil, err
-			}
-
-			rmdCopy, err := rmd.deepCopy(config.Codec())
-			if err != nil {
-				return nil, err
 			}
-			rmdCopy.data = pmd
-
-			// Overwrite the cached copy with the new copy
-			irmdCopy := MakeImmutableRootMetadata(rmdCopy,
-				rmd.LastModifyingWriterVerifyingKey(), rmd.MdID(),
-				rmd.LocalTimestamp())
-			if err := config.MDCache().Put(irmdCopy); err != nil {
-				return nil, err
-			}
-			mergedRmds[i] = irmdCopy
+			haveLatest = true
+		}
+		mergedRmds[i], err = makeMDReadable(ctx, config, rmd, latestRmd)
+		if err != nil {
+			return nil, err
 		}
 	}
 	return mergedRmds, nil
 }
 
+// makeMDReadable returns rmd unchanged if it is already readable by
+// the current device.  Otherwise, it decrypts rmd's private data
+// using the keys found in latestRmd, overwrites the cached copy of
+// rmd with the decrypted version, and returns it.
+func makeMDReadable(ctx context.Context, config Config,
+	rmd, latestRmd ImmutableRootMetadata) (ImmutableRootMetadata, error) {
+	if err := isReadableOrError(
+		ctx, config.KBPKI(), rmd.ReadOnly()); err == nil {
+		return rmd, nil
+	}
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+
+	pmd, err := decryptMDPrivateData(
+		ctx, config.Codec(), config.Crypto(),
+		config.BlockCache(), config.BlockOps(),
+		config.KeyManager(), config.Mode(), session.UID,
+		rmd.GetSerializedPrivateMetadata(),
+		rmd, latestRmd, config.MakeLogger(""))
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+
+	rmdCopy, err := rmd.deepCopy(config.Codec())
+	if err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+	rmdCopy.data = pmd
+
+	// Overwrite the cached copy with the new copy
+	irmdCopy := MakeImmutableRootMetadata(rmdCopy,
+		rmd.LastModifyingWriterVerifyingKey(), rmd.MdID(),
+		rmd.LocalTimestamp())
+	if err := config.MDCache().Put(irmdCopy); err != nil {
+		return ImmutableRootMetadata{}, err
+	}
+	return irmdCopy, nil
+}
+
 // getUnmergedMDUpdates returns a slice of the unmerged MDs for a TLF
 // and unmerged branch, between the merge point for that branch and
 // startRev (inclusive).  The returned MDs are the same instances that
@@ -24,8 +24,8 @@ import (
 type mdServerDiskShared struct {
 	dirPath string
 
-	// Protects handleDb, branchDb, tlfStorage, and
-	// truncateLockManager. After Shutdown() is called, handleDb,
+	// Protects handleDb, branchDb, tlfStorage, truncateLockManager,
+	// and fileLeaseManager. After Shutdown() is called, handleDb,
 	// branchDb, tlfStorage, and truncateLockManager are nil.
 	lock sync.RWMutex
 	// Bare TLF handle -> TLF ID
@@ -36,6 +36,9 @@ type mdServerDiskShared struct {
 	// Always use memory for the lock storage, so it gets wiped
 	// after a restart.
 	truncateLockManager *mdServerLocalTruncateLockManager
+	// Leases are also kept only in memory, since they expire
+	// anyway.
+	fileLeaseManager *mdServerLocalFileLeaseManager
 
 	updateManager *mdServerLocalUpdateManager
 
@@ -68,12 +71,14 @@ func newMDServerDisk(config mdServerLocalConfig, dirPath string,
 	}
 	log := config.MakeLogger("MDSD")
 	truncateLockManager := newMDServerLocalTruncatedLockManager()
+	fileLeaseManager := newMDServerLocalFileLeaseManager()
 	shared := mdServerDiskShared{
 		dirPath:             dirPath,
 		handleDb:            handleDb,
 		branchDb:            branchDb,
 		tlfStorage:          make(map[tlf.ID]*mdServerTlfStorage),
 		truncateLockManager: &truncateLockManager,
+		fileLeaseManager:    &fileLeaseManager,
 		updateManager:       newMDServerLocalUpdateManager(),
 		shutdownFunc:        shutdownFunc,
 	}
@@ -530,6 +535,78 @@ func (md *MDServerDisk) TruncateUnlock(ctx context.Context, id tlf.ID) (
 	return md.truncateLockManager.truncateUnlock(session.CryptPublicKey, id)
 }
 
+// LockFile implements the MDServer interface for MDServerDisk.
+func (md *MDServerDisk) LockFile(ctx context.Context, id tlf.ID,
+	key string, lock FileLock, ttl time.Duration) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	session, err := md.config.currentSessionGetter().GetCurrentSession(ctx)
+	if err != nil {
+		return MDServerError{err}
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	err = md.checkShutdownLocked()
+	if err != nil {
+		return err
+	}
+
+	return md.fileLeaseManager.lockFile(session.CryptPublicKey, id, key,
+		lock, md.config.Clock().Now(), ttl)
+}
+
+// UnlockFile implements the MDServer interface for MDServerDisk.
+func (md *MDServerDisk) UnlockFile(ctx context.Context, id tlf.ID,
+	key string, lock FileLock) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	session, err := md.config.currentSessionGetter().GetCurrentSession(ctx)
+	if err != nil {
+		return MDServerError{err}
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	err = md.checkShutdownLocked()
+	if err != nil {
+		return err
+	}
+
+	md.fileLeaseManager.unlockFile(
+		session.CryptPublicKey, id, key, lock, md.config.Clock().Now())
+	return nil
+}
+
+// GetFileLockConflict implements the MDServer interface for
+// MDServerDisk.
+func (md *MDServerDisk) GetFileLockConflict(ctx context.Context,
+	id tlf.ID, key string, lock FileLock) (FileLock, bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return FileLock{}, false, err
+	}
+
+	session, err := md.config.currentSessionGetter().GetCurrentSession(ctx)
+	if err != nil {
+		return FileLock{}, false, MDServerError{err}
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	err = md.checkShutdownLocked()
+	if err != nil {
+		return FileLock{}, false, err
+	}
+
+	conflict, ok := md.fileLeaseManager.getFileLockConflict(
+		session.CryptPublicKey, id, key, lock, md.config.Clock().Now())
+	return conflict, ok, nil
+}
+
 // Shutdown implements the MDServer interface for MDServerDisk.
 func (md *MDServerDisk) Shutdown() {
 	md.lock.Lock()
@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"sync"
+	"time"
 
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfscodec"
@@ -99,6 +100,80 @@ func (m mdServerLocalTruncateLockManager) truncateUnlock(
 	return false, MDServerErrorLocked{}
 }
 
+type mdServerLocalFileLeaseKey struct {
+	tlfID tlf.ID
+	key   string
+}
+
+// mdServerLocalFileLeaseManager manages the file lock leases for a
+// set of TLFs.  Note that it is not goroutine-safe.
+type mdServerLocalFileLeaseManager struct {
+	// (TLF ID, file key) -> leases on that file.
+	leasesDb map[mdServerLocalFileLeaseKey]*fileLockTable
+}
+
+func newMDServerLocalFileLeaseManager() mdServerLocalFileLeaseManager {
+	return mdServerLocalFileLeaseManager{
+		leasesDb: make(map[mdServerLocalFileLeaseKey]*fileLockTable),
+	}
+}
+
+// getTable returns the unexpired leases for the given file, or nil
+// if there are none.
+func (m mdServerLocalFileLeaseManager) getTable(
+	id tlf.ID, key string, now time.Time) *fileLockTable {
+	k := mdServerLocalFileLeaseKey{id, key}
+	table, ok := m.leasesDb[k]
+	if !ok {
+		return nil
+	}
+	table.expire(now)
+	if table.isEmpty() {
+		delete(m.leasesDb, k)
+		return nil
+	}
+	return table
+}
+
+func (m mdServerLocalFileLeaseManager) lockFile(
+	deviceKey kbfscrypto.CryptPublicKey, id tlf.ID, key string,
+	lock FileLock, now time.Time, ttl time.Duration) error {
+	owner := makeFileLockOwner(deviceKey, lock)
+	table := m.getTable(id, key, now)
+	if table == nil {
+		table = &fileLockTable{}
+		m.leasesDb[mdServerLocalFileLeaseKey{id, key}] = table
+	} else if conflict, ok := table.conflict(owner, lock); ok {
+		return FileLockConflictError{conflict}
+	}
+	table.set(owner, lock, now.Add(ttl))
+	return nil
+}
+
+func (m mdServerLocalFileLeaseManager) unlockFile(
+	deviceKey kbfscrypto.CryptPublicKey, id tlf.ID, key string,
+	lock FileLock, now time.Time) {
+	table := m.getTable(id, key, now)
+	if table == nil {
+		// Already unlocked.
+		return
+	}
+	table.remove(makeFileLockOwner(deviceKey, lock), lock)
+	if table.isEmpty() {
+		delete(m.leasesDb, mdServerLocalFileLeaseKey{id, key})
+	}
+}
+
+func (m mdServerLocalFileLeaseManager) getFileLockConflict(
+	deviceKey kbfscrypto.CryptPublicKey, id tlf.ID, key string,
+	lock FileLock, now time.Time) (FileLock, bool) {
+	table := m.getTable(id, key, now)
+	if table == nil {
+		return FileLock{}, false
+	}
+	return table.conflict(makeFileLockOwner(deviceKey, lock), lock)
+}
+
 // mdServerLocalUpdateManager manages the observers for a set of TLFs
 // referenced by multiple mdServerLocal instances sharing the same
 // data. It is goroutine-safe.
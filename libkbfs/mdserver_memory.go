@@ -54,9 +54,9 @@ type mdBlockMemList struct {
 }
 
 type mdServerMemShared struct {
-	// Protects all *db variables and truncateLockManager. After
-	// Shutdown() is called, all *db variables and
-	// truncateLockManager are nil.
+	// Protects all *db variables, truncateLockManager, and
+	// fileLeaseManager. After Shutdown() is called, all *db
+	// variables, truncateLockManager, and fileLeaseManager are nil.
 	lock sync.RWMutex
 	// Bare TLF handle -> TLF ID
 	handleDb map[mdHandleKey]tlf.ID
@@ -71,6 +71,7 @@ type mdServerMemShared struct {
 	// (TLF ID, crypt public key) -> branch ID
 	branchDb            map[mdBranchKey]BranchID
 	truncateLockManager *mdServerLocalTruncateLockManager
+	fileLeaseManager    *mdServerLocalFileLeaseManager
 
 	updateManager *mdServerLocalUpdateManager
 }
@@ -96,6 +97,7 @@ func NewMDServerMemory(config mdServerLocalConfig) (*MDServerMemory, error) {
 	readerKeyBundleDb := make(map[mdExtraReaderKey]TLFReaderKeyBundleV3)
 	log := config.MakeLogger("MDSM")
 	truncateLockManager := newMDServerLocalTruncatedLockManager()
+	fileLeaseManager := newMDServerLocalFileLeaseManager()
 	shared := mdServerMemShared{
 		handleDb:            handleDb,
 		latestHandleDb:      latestHandleDb,
@@ -104,6 +106,7 @@ func NewMDServerMemory(config mdServerLocalConfig) (*MDServerMemory, error) {
 		writerKeyBundleDb:   writerKeyBundleDb,
 		readerKeyBundleDb:   readerKeyBundleDb,
 		truncateLockManager: &truncateLockManager,
+		fileLeaseManager:    &fileLeaseManager,
 		updateManager:       newMDServerLocalUpdateManager(),
 	}
 	mdserv := &MDServerMemory{config, log, &shared}
@@ -668,6 +671,78 @@ func (md *MDServerMemory) TruncateUnlock(ctx context.Context, id tlf.ID) (
 	return md.truncateLockManager.truncateUnlock(myKey, id)
 }
 
+// LockFile implements the MDServer interface for MDServerMemory.
+func (md *MDServerMemory) LockFile(ctx context.Context, id tlf.ID,
+	key string, lock FileLock, ttl time.Duration) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	err := md.checkShutdownLocked()
+	if err != nil {
+		return err
+	}
+
+	myKey, err := md.getCurrentDeviceKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	return md.fileLeaseManager.lockFile(
+		myKey, id, key, lock, md.config.Clock().Now(), ttl)
+}
+
+// UnlockFile implements the MDServer interface for MDServerMemory.
+func (md *MDServerMemory) UnlockFile(ctx context.Context, id tlf.ID,
+	key string, lock FileLock) error {
+	if err := checkContext(ctx); err != nil {
+		return err
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	err := md.checkShutdownLocked()
+	if err != nil {
+		return err
+	}
+
+	myKey, err := md.getCurrentDeviceKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	md.fileLeaseManager.unlockFile(
+		myKey, id, key, lock, md.config.Clock().Now())
+	return nil
+}
+
+// GetFileLockConflict implements the MDServer interface for
+// MDServerMemory.
+func (md *MDServerMemory) GetFileLockConflict(ctx context.Context,
+	id tlf.ID, key string, lock FileLock) (FileLock, bool, error) {
+	if err := checkContext(ctx); err != nil {
+		return FileLock{}, false, err
+	}
+
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	err := md.checkShutdownLocked()
+	if err != nil {
+		return FileLock{}, false, err
+	}
+
+	myKey, err := md.getCurrentDeviceKey(ctx)
+	if err != nil {
+		return FileLock{}, false, err
+	}
+
+	conflict, ok := md.fileLeaseManager.getFileLockConflict(
+		myKey, id, key, lock, md.config.Clock().Now())
+	return conflict, ok, nil
+}
+
 // Shutdown implements the MDServer interface for MDServerMemory.
 func (md *MDServerMemory) Shutdown() {
 	md.lock.Lock()
@@ -676,6 +751,7 @@ func (md *MDServerMemory) Shutdown() {
 	md.latestHandleDb = nil
 	md.branchDb = nil
 	md.truncateLockManager = nil
+	md.fileLeaseManager = nil
 }
 
 // IsConnected implements the MDServer interface for MDServerMemory.
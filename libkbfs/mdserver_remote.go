@@ -732,6 +732,27 @@ func (md *MDServerRemote) TruncateUnlock(ctx context.Context, id tlf.ID) (
 	return md.getClient().TruncateUnlock(ctx, id.String())
 }
 
+// LockFile implements the MDServer interface for MDServerRemote.
+// The mdserver protocol has no lease RPCs yet, so file locks taken
+// with a remote server are only enforced locally.
+func (md *MDServerRemote) LockFile(ctx context.Context, id tlf.ID,
+	key string, lock FileLock, ttl time.Duration) error {
+	return FileLeasesUnsupportedError{}
+}
+
+// UnlockFile implements the MDServer interface for MDServerRemote.
+func (md *MDServerRemote) UnlockFile(ctx context.Context, id tlf.ID,
+	key string, lock FileLock) error {
+	return FileLeasesUnsupportedError{}
+}
+
+// GetFileLockConflict implements the MDServer interface for
+// MDServerRemote.
+func (md *MDServerRemote) GetFileLockConflict(ctx context.Context,
+	id tlf.ID, key string, lock FileLock) (FileLock, bool, error) {
+	return FileLock{}, false, FileLeasesUnsupportedError{}
+}
+
 // GetLatestHandleForTLF implements the MDServer interface for MDServerRemote.
 func (md *MDServerRemote) GetLatestHandleForTLF(ctx context.Context, id tlf.ID) (
 	tlf.Handle, error) {
@@ -715,6 +715,18 @@ func (_mr *_MockKBFSOpsRecorder) GetRootNode(arg0, arg1, arg2 interface{}) *gomo
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRootNode", arg0, arg1, arg2)
 }
 
+func (_m *MockKBFSOps) GetRootNodeAtRevision(ctx context.Context, h *TlfHandle, rev MetadataRevision) (Node, EntryInfo, error) {
+	ret := _m.ctrl.Call(_m, "GetRootNodeAtRevision", ctx, h, rev)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetRootNodeAtRevision(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRootNodeAtRevision", arg0, arg1, arg2)
+}
+
 func (_m *MockKBFSOps) GetDirChildren(ctx context.Context, dir Node) (map[string]EntryInfo, error) {
 	ret := _m.ctrl.Call(_m, "GetDirChildren", ctx, dir)
 	ret0, _ := ret[0].(map[string]EntryInfo)
@@ -784,6 +796,18 @@ func (_mr *_MockKBFSOpsRecorder) CreateLink(arg0, arg1, arg2, arg3 interface{})
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateLink", arg0, arg1, arg2, arg3)
 }
 
+func (_m *MockKBFSOps) CopyFile(ctx context.Context, src Node, dir Node, name string) (Node, EntryInfo, error) {
+	ret := _m.ctrl.Call(_m, "CopyFile", ctx, src, dir, name)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockKBFSOpsRecorder) CopyFile(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CopyFile", arg0, arg1, arg2, arg3)
+}
+
 func (_m *MockKBFSOps) RemoveDir(ctx context.Context, dir Node, dirName string) error {
 	ret := _m.ctrl.Call(_m, "RemoveDir", ctx, dir, dirName)
 	ret0, _ := ret[0].(error)
@@ -845,6 +869,70 @@ func (_mr *_MockKBFSOpsRecorder) Truncate(arg0, arg1, arg2 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Truncate", arg0, arg1, arg2)
 }
 
+func (_m *MockKBFSOps) PunchHole(ctx context.Context, file Node, off int64, length int64) error {
+	ret := _m.ctrl.Call(_m, "PunchHole", ctx, file, off, length)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) PunchHole(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PunchHole", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockKBFSOps) NextDataOffset(ctx context.Context, file Node, off int64) (int64, error) {
+	ret := _m.ctrl.Call(_m, "NextDataOffset", ctx, file, off)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) NextDataOffset(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NextDataOffset", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) NextHoleOffset(ctx context.Context, file Node, off int64) (int64, error) {
+	ret := _m.ctrl.Call(_m, "NextHoleOffset", ctx, file, off)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) NextHoleOffset(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NextHoleOffset", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) LockFile(ctx context.Context, file Node, lock FileLock) error {
+	ret := _m.ctrl.Call(_m, "LockFile", ctx, file, lock)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) LockFile(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LockFile", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) UnlockFile(ctx context.Context, file Node, lock FileLock) error {
+	ret := _m.ctrl.Call(_m, "UnlockFile", ctx, file, lock)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) UnlockFile(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnlockFile", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) GetFileLockConflict(ctx context.Context, file Node, lock FileLock) (FileLock, bool, error) {
+	ret := _m.ctrl.Call(_m, "GetFileLockConflict", ctx, file, lock)
+	ret0, _ := ret[0].(FileLock)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetFileLockConflict(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetFileLockConflict", arg0, arg1, arg2)
+}
+
 func (_m *MockKBFSOps) SetEx(ctx context.Context, file Node, ex bool) error {
 	ret := _m.ctrl.Call(_m, "SetEx", ctx, file, ex)
 	ret0, _ := ret[0].(error)
@@ -865,6 +953,48 @@ func (_mr *_MockKBFSOpsRecorder) SetMtime(arg0, arg1, arg2 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetMtime", arg0, arg1, arg2)
 }
 
+func (_m *MockKBFSOps) GetXattr(ctx context.Context, node Node, xattr string) ([]byte, error) {
+	ret := _m.ctrl.Call(_m, "GetXattr", ctx, node, xattr)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetXattr(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetXattr", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) SetXattr(ctx context.Context, node Node, xattr string, value []byte) error {
+	ret := _m.ctrl.Call(_m, "SetXattr", ctx, node, xattr, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetXattr(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetXattr", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockKBFSOps) ListXattrs(ctx context.Context, node Node) ([]string, error) {
+	ret := _m.ctrl.Call(_m, "ListXattrs", ctx, node)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) ListXattrs(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListXattrs", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) RemoveXattr(ctx context.Context, node Node, xattr string) error {
+	ret := _m.ctrl.Call(_m, "RemoveXattr", ctx, node, xattr)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) RemoveXattr(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveXattr", arg0, arg1, arg2)
+}
+
 func (_m *MockKBFSOps) Sync(ctx context.Context, file Node) error {
 	ret := _m.ctrl.Call(_m, "Sync", ctx, file)
 	ret0, _ := ret[0].(error)
@@ -875,6 +1005,26 @@ func (_mr *_MockKBFSOpsRecorder) Sync(arg0, arg1 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Sync", arg0, arg1)
 }
 
+func (_m *MockKBFSOps) RevertToRevision(ctx context.Context, node Node, rev MetadataRevision) error {
+	ret := _m.ctrl.Call(_m, "RevertToRevision", ctx, node, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) RevertToRevision(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RevertToRevision", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) Undelete(ctx context.Context, dir Node, name string, rev MetadataRevision) error {
+	ret := _m.ctrl.Call(_m, "Undelete", ctx, dir, name, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) Undelete(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Undelete", arg0, arg1, arg2, arg3)
+}
+
 func (_m *MockKBFSOps) FolderStatus(ctx context.Context, folderBranch FolderBranch) (FolderBranchStatus, <-chan StatusUpdate, error) {
 	ret := _m.ctrl.Call(_m, "FolderStatus", ctx, folderBranch)
 	ret0, _ := ret[0].(FolderBranchStatus)
@@ -949,6 +1099,49 @@ func (_mr *_MockKBFSOpsRecorder) GetEditHistory(arg0, arg1 interface{}) *gomock.
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetEditHistory", arg0, arg1)
 }
 
+func (_m *MockKBFSOps) GetDeletedEntries(ctx context.Context, folderBranch FolderBranch) ([]DeletedEntry, error) {
+	ret := _m.ctrl.Call(_m, "GetDeletedEntries", ctx, folderBranch)
+	ret0, _ := ret[0].([]DeletedEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetDeletedEntries(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDeletedEntries", arg0, arg1)
+}
+
+func (_m *MockKBFSOps) DiffRevisions(ctx context.Context, folderBranch FolderBranch, fromRev MetadataRevision, toRev MetadataRevision) ([]PathChange, error) {
+	ret := _m.ctrl.Call(_m, "DiffRevisions", ctx, folderBranch, fromRev, toRev)
+	ret0, _ := ret[0].([]PathChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) DiffRevisions(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DiffRevisions", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockKBFSOps) SetKeepSynced(ctx context.Context, node Node, keepSynced bool) error {
+	ret := _m.ctrl.Call(_m, "SetKeepSynced", ctx, node, keepSynced)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockKBFSOpsRecorder) SetKeepSynced(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetKeepSynced", arg0, arg1, arg2)
+}
+
+func (_m *MockKBFSOps) GetSyncStatus(ctx context.Context, folderBranch FolderBranch) (FolderSyncStatus, error) {
+	ret := _m.ctrl.Call(_m, "GetSyncStatus", ctx, folderBranch)
+	ret0, _ := ret[0].(FolderSyncStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockKBFSOpsRecorder) GetSyncStatus(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetSyncStatus", arg0, arg1)
+}
+
 func (_m *MockKBFSOps) GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error) {
 	ret := _m.ctrl.Call(_m, "GetNodeMetadata", ctx, node)
 	ret0, _ := ret[0].(NodeMetadata)
@@ -2319,6 +2512,70 @@ func (_mr *_MockDiskBlockCacheRecorder) UpdateLRUTime(arg0, arg1 interface{}) *g
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateLRUTime", arg0, arg1)
 }
 
+func (_m *MockDiskBlockCache) Pin(ctx context.Context, tlfID tlf.ID, blockID kbfsblock.ID) error {
+	ret := _m.ctrl.Call(_m, "Pin", ctx, tlfID, blockID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDiskBlockCacheRecorder) Pin(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "Pin", arg0, arg1, arg2)
+}
+
+func (_m *MockDiskBlockCache) UnpinTLF(ctx context.Context, tlfID tlf.ID, except map[kbfsblock.ID]bool) (int, error) {
+	ret := _m.ctrl.Call(_m, "UnpinTLF", ctx, tlfID, except)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDiskBlockCacheRecorder) UnpinTLF(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnpinTLF", arg0, arg1, arg2)
+}
+
+func (_m *MockDiskBlockCache) ClearTLF(ctx context.Context, tlfID tlf.ID) (int, int64, error) {
+	ret := _m.ctrl.Call(_m, "ClearTLF", ctx, tlfID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockDiskBlockCacheRecorder) ClearTLF(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ClearTLF", arg0, arg1)
+}
+
+func (_m *MockDiskBlockCache) SetTLFLimit(ctx context.Context, tlfID tlf.ID, limit int64) error {
+	ret := _m.ctrl.Call(_m, "SetTLFLimit", ctx, tlfID, limit)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDiskBlockCacheRecorder) SetTLFLimit(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTLFLimit", arg0, arg1, arg2)
+}
+
+func (_m *MockDiskBlockCache) SetTLFEvictionPolicy(ctx context.Context, tlfID tlf.ID, policy string) error {
+	ret := _m.ctrl.Call(_m, "SetTLFEvictionPolicy", ctx, tlfID, policy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockDiskBlockCacheRecorder) SetTLFEvictionPolicy(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTLFEvictionPolicy", arg0, arg1, arg2)
+}
+
+func (_m *MockDiskBlockCache) TLFStatus(ctx context.Context, tlfID tlf.ID) (DiskBlockCacheTLFStatus, error) {
+	ret := _m.ctrl.Call(_m, "TLFStatus", ctx, tlfID)
+	ret0, _ := ret[0].(DiskBlockCacheTLFStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockDiskBlockCacheRecorder) TLFStatus(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "TLFStatus", arg0, arg1)
+}
+
 func (_m *MockDiskBlockCache) Size() int64 {
 	ret := _m.ctrl.Call(_m, "Size")
 	ret0, _ := ret[0].(int64)
@@ -2516,6 +2773,19 @@ func (_mr *_MockcryptoPureRecorder) EncryptBlock(arg0, arg1 interface{}) *gomock
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "EncryptBlock", arg0, arg1)
 }
 
+func (_m *MockcryptoPure) EncryptBlockWithParams(block Block, key kbfscrypto.BlockCryptKey, params BlockEncryptionParams) (int, int, EncryptedBlock, error) {
+	ret := _m.ctrl.Call(_m, "EncryptBlockWithParams", block, key, params)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(EncryptedBlock)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+func (_mr *_MockcryptoPureRecorder) EncryptBlockWithParams(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "EncryptBlockWithParams", arg0, arg1, arg2)
+}
+
 func (_m *MockcryptoPure) DecryptBlock(encryptedBlock EncryptedBlock, key kbfscrypto.BlockCryptKey, block Block) error {
 	ret := _m.ctrl.Call(_m, "DecryptBlock", encryptedBlock, key, block)
 	ret0, _ := ret[0].(error)
@@ -2792,6 +3062,19 @@ func (_mr *_MockCryptoRecorder) EncryptBlock(arg0, arg1 interface{}) *gomock.Cal
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "EncryptBlock", arg0, arg1)
 }
 
+func (_m *MockCrypto) EncryptBlockWithParams(block Block, key kbfscrypto.BlockCryptKey, params BlockEncryptionParams) (int, int, EncryptedBlock, error) {
+	ret := _m.ctrl.Call(_m, "EncryptBlockWithParams", block, key, params)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(EncryptedBlock)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+func (_mr *_MockCryptoRecorder) EncryptBlockWithParams(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "EncryptBlockWithParams", arg0, arg1, arg2)
+}
+
 func (_m *MockCrypto) DecryptBlock(encryptedBlock EncryptedBlock, key kbfscrypto.BlockCryptKey, block Block) error {
 	ret := _m.ctrl.Call(_m, "DecryptBlock", encryptedBlock, key, block)
 	ret0, _ := ret[0].(error)
@@ -3175,6 +3458,16 @@ func (_mr *_MockPrefetcherRecorder) PrefetchAfterBlockRetrieved(arg0, arg1, arg2
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "PrefetchAfterBlockRetrieved", arg0, arg1, arg2)
 }
 
+func (_m *MockPrefetcher) PrefetchTree(ctx context.Context, block Block, blockPtr BlockPointer, kmd KeyMetadata, priority int, skip func(BlockPointer) bool, fn func(BlockPointer, Block) error) error {
+	ret := _m.ctrl.Call(_m, "PrefetchTree", ctx, block, blockPtr, kmd, priority, skip, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockPrefetcherRecorder) PrefetchTree(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "PrefetchTree", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
 func (_m *MockPrefetcher) Shutdown() <-chan struct{} {
 	ret := _m.ctrl.Call(_m, "Shutdown")
 	ret0, _ := ret[0].(<-chan struct{})
@@ -3462,6 +3755,38 @@ func (_mr *_MockMDServerRecorder) TruncateUnlock(arg0, arg1 interface{}) *gomock
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "TruncateUnlock", arg0, arg1)
 }
 
+func (_m *MockMDServer) LockFile(ctx context.Context, id tlf.ID, key string, lock FileLock, ttl time.Duration) error {
+	ret := _m.ctrl.Call(_m, "LockFile", ctx, id, key, lock, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockMDServerRecorder) LockFile(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "LockFile", arg0, arg1, arg2, arg3, arg4)
+}
+
+func (_m *MockMDServer) UnlockFile(ctx context.Context, id tlf.ID, key string, lock FileLock) error {
+	ret := _m.ctrl.Call(_m, "UnlockFile", ctx, id, key, lock)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockMDServerRecorder) UnlockFile(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UnlockFile", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockMDServer) GetFileLockConflict(ctx context.Context, id tlf.ID, key string, lock FileLock) (FileLock, bool, error) {
+	ret := _m.ctrl.Call(_m, "GetFileLockConflict", ctx, id, key, lock)
+	ret0, _ := ret[0].(FileLock)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockMDServerRecorder) GetFileLockConflict(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetFileLockConflict", arg0, arg1, arg2, arg3)
+}
+
 func (_m *MockMDServer) DisableRekeyUpdatesForTesting() {
 	_m.ctrl.Call(_m, "DisableRekeyUpdatesForTesting")
 }
@@ -5093,6 +5418,16 @@ func (_mr *_MockNodeCacheRecorder) AllNodes() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "AllNodes")
 }
 
+func (_m *MockNodeCache) IsEmpty() bool {
+	ret := _m.ctrl.Call(_m, "IsEmpty")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockNodeCacheRecorder) IsEmpty() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "IsEmpty")
+}
+
 // Mock of crAction interface
 type MockcrAction struct {
 	ctrl     *gomock.Controller
@@ -281,6 +281,13 @@ func (ncs *nodeCacheStandard) PathFromNode(node Node) (p path) {
 	return
 }
 
+// IsEmpty implements the NodeCache interface for nodeCacheStandard.
+func (ncs *nodeCacheStandard) IsEmpty() bool {
+	ncs.lock.RLock()
+	defer ncs.lock.RUnlock()
+	return len(ncs.nodes) == 0
+}
+
 // AllNodes implements the NodeCache interface for nodeCacheStandard.
 func (ncs *nodeCacheStandard) AllNodes() []Node {
 	ncs.lock.Lock()
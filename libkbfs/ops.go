@@ -61,6 +61,7 @@ const (
 	resolutionOpCode
 	rekeyOpCode
 	gcOpCode // for deleting old blocks during an MD history truncation
+	setXattrOpCode
 )
 
 // blockUpdate represents a block that was updated to have a new
@@ -786,7 +787,7 @@ func (so *syncOp) checkConflict(
 			mergedParentMostRecent: mergedOp.getFinalPath().parentPath().
 				tailPointer(),
 		}, nil
-	case *setAttrOp:
+	case *setAttrOp, *setXattrOp:
 		// Someone on the merged path explicitly set an attribute, so
 		// just copy the size and blockpointer over.
 		return &copyUnmergedAttrAction{
@@ -924,6 +925,7 @@ const (
 	exAttr attrChange = iota
 	mtimeAttr
 	sizeAttr // only used during conflict resolution
+	lockIDAttr
 )
 
 func (ac attrChange) String() string {
@@ -934,6 +936,8 @@ func (ac attrChange) String() string {
 		return "mtime"
 	case sizeAttr:
 		return "size"
+	case lockIDAttr:
+		return "lockID"
 	}
 	return "<invalid attrChange>"
 }
@@ -1015,7 +1019,12 @@ func (sao *setAttrOp) checkConflict(
 	isFile bool) (crAction, error) {
 	switch realMergedOp := mergedOp.(type) {
 	case *setAttrOp:
-		if realMergedOp.Attr == sao.Attr {
+		if realMergedOp.Attr == sao.Attr && sao.Attr == lockIDAttr {
+			// Two devices assigned a lock ID at once.  That's not
+			// worth a conflict copy; the unmerged ID wins, and any
+			// leases taken under the merged one just expire.
+			return nil, nil
+		} else if realMergedOp.Attr == sao.Attr {
 			var symPath string
 			var causedByAttr attrChange
 			if !isFile {
@@ -1060,6 +1069,97 @@ func (sao *setAttrOp) getDefaultAction(mergedPath path) crAction {
 	}
 }
 
+// setXattrOp is an op that represents setting or removing an
+// extended attribute of a file/subdirectory within a directory.  The
+// new value itself lives in the directory entry, so the op only
+// records which attribute changed.
+type setXattrOp struct {
+	OpCommon
+	Name  string       `codec:"n"`
+	Dir   blockUpdate  `codec:"d"`
+	Xattr string       `codec:"x"`
+	File  BlockPointer `codec:"f"`
+}
+
+func newSetXattrOp(name string, oldDir BlockPointer,
+	xattr string, file BlockPointer) (*setXattrOp, error) {
+	sxo := &setXattrOp{
+		Name: name,
+	}
+	err := sxo.Dir.setUnref(oldDir)
+	if err != nil {
+		return nil, err
+	}
+	sxo.Xattr = xattr
+	sxo.File = file
+	return sxo, nil
+}
+
+func (sxo *setXattrOp) AddUpdate(oldPtr BlockPointer, newPtr BlockPointer) {
+	if oldPtr == sxo.Dir.Unref {
+		err := sxo.Dir.setRef(newPtr)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+	sxo.OpCommon.AddUpdate(oldPtr, newPtr)
+}
+
+func (sxo *setXattrOp) SizeExceptUpdates() uint64 {
+	return uint64(len(sxo.Name) + len(sxo.Xattr))
+}
+
+func (sxo *setXattrOp) allUpdates() []blockUpdate {
+	updates := make([]blockUpdate, len(sxo.Updates))
+	copy(updates, sxo.Updates)
+	return append(updates, sxo.Dir)
+}
+
+func (sxo *setXattrOp) checkValid() error {
+	err := sxo.Dir.checkValid()
+	if err != nil {
+		return fmt.Errorf("setXattrOp.Dir=%v got error: %v", sxo.Dir, err)
+	}
+	return sxo.checkUpdatesValid()
+}
+
+func (sxo *setXattrOp) String() string {
+	return fmt.Sprintf("setXattr %s (%s)", sxo.Name, sxo.Xattr)
+}
+
+func (sxo *setXattrOp) StringWithRefs(numRefIndents int) string {
+	res := sxo.String() + "\n"
+	indent := strings.Repeat("\t", numRefIndents)
+	res += indent + fmt.Sprintf("Dir: %v -> %v\n", sxo.Dir.Unref, sxo.Dir.Ref)
+	res += indent + fmt.Sprintf("File: %v\n", sxo.File)
+	res += sxo.stringWithRefs(numRefIndents)
+	return res
+}
+
+func (sxo *setXattrOp) checkConflict(
+	ctx context.Context, renamer ConflictRenamer, mergedOp op,
+	isFile bool) (crAction, error) {
+	switch realMergedOp := mergedOp.(type) {
+	case *setXattrOp:
+		if realMergedOp.Xattr == sxo.Xattr {
+			// Extended attributes are small pieces of metadata
+			// that aren't worth forking a whole file over, so the
+			// merged value simply wins.
+			return &dropUnmergedAction{op: sxo}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (sxo *setXattrOp) getDefaultAction(mergedPath path) crAction {
+	return &copyUnmergedAttrAction{
+		fromName: sxo.getFinalPath().tailName(),
+		toName:   mergedPath.tailName(),
+		xattrs:   []string{sxo.Xattr},
+	}
+}
+
 // resolutionOp is an op that represents the block changes that took
 // place as part of a conflict resolution.
 type resolutionOp struct {
@@ -1250,6 +1350,11 @@ func invertOpForLocalNotifications(oldOp op) (newOp op, err error) {
 		if err != nil {
 			return nil, err
 		}
+	case *setXattrOp:
+		newOp, err = newSetXattrOp(op.Name, op.Dir.Ref, op.Xattr, op.File)
+		if err != nil {
+			return nil, err
+		}
 	case *GCOp:
 		newOp = op
 	}
@@ -1291,6 +1396,8 @@ func opPointerizer(iface interface{}) reflect.Value {
 		return reflect.ValueOf(&op)
 	case GCOp:
 		return reflect.ValueOf(&op)
+	case setXattrOp:
+		return reflect.ValueOf(&op)
 	}
 }
 
@@ -1304,6 +1411,7 @@ func RegisterOps(codec kbfscodec.Codec) {
 	codec.RegisterType(reflect.TypeOf(resolutionOp{}), resolutionOpCode)
 	codec.RegisterType(reflect.TypeOf(rekeyOp{}), rekeyOpCode)
 	codec.RegisterType(reflect.TypeOf(GCOp{}), gcOpCode)
+	codec.RegisterType(reflect.TypeOf(setXattrOp{}), setXattrOpCode)
 	codec.RegisterIfaceSliceType(reflect.TypeOf(opsList{}), opsListCode,
 		opPointerizer)
 }
@@ -179,6 +179,8 @@ func opPointerizerFuture(iface interface{}) reflect.Value {
 		return reflect.ValueOf(&op)
 	case gcOpFuture:
 		return reflect.ValueOf(&op)
+	case setXattrOpFuture:
+		return reflect.ValueOf(&op)
 	}
 }
 
@@ -191,6 +193,7 @@ func registerOpsFuture(codec kbfscodec.Codec) {
 	codec.RegisterType(reflect.TypeOf(resolutionOpFuture{}), resolutionOpCode)
 	codec.RegisterType(reflect.TypeOf(rekeyOpFuture{}), rekeyOpCode)
 	codec.RegisterType(reflect.TypeOf(gcOpFuture{}), gcOpCode)
+	codec.RegisterType(reflect.TypeOf(setXattrOpFuture{}), setXattrOpCode)
 	codec.RegisterIfaceSliceType(reflect.TypeOf(opsList{}), opsListCode,
 		opPointerizerFuture)
 }
@@ -470,6 +473,37 @@ func TestGcOpUnknownFields(t *testing.T) {
 	testStructUnknownFields(t, makeFakeGcOpFuture(t))
 }
 
+type setXattrOpFuture struct {
+	setXattrOp
+	kbfscodec.Extra
+}
+
+func (sxof setXattrOpFuture) toCurrent() setXattrOp {
+	return sxof.setXattrOp
+}
+
+func (sxof setXattrOpFuture) ToCurrentStruct() kbfscodec.CurrentStruct {
+	return sxof.toCurrent()
+}
+
+func makeFakeSetXattrOpFuture(t *testing.T) setXattrOpFuture {
+	sxof := setXattrOpFuture{
+		setXattrOp{
+			makeFakeOpCommon(t, true),
+			"name",
+			makeFakeBlockUpdate(t),
+			"user.xattr",
+			makeFakeBlockPointer(t),
+		},
+		kbfscodec.MakeExtraOrBust("setXattrOp", t),
+	}
+	return sxof
+}
+
+func TestSetXattrOpUnknownFields(t *testing.T) {
+	testStructUnknownFields(t, makeFakeSetXattrOpFuture(t))
+}
+
 type testOps struct {
 	Ops []interface{}
 }
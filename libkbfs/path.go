@@ -134,6 +134,17 @@ func (p path) String() string {
 	return strings.Join(names, "/")
 }
 
+// pathWithinFolder returns the names along the path, below the root
+// of its top-level folder, joined by slashes.  It's empty for the
+// root itself.
+func (p path) pathWithinFolder() string {
+	names := make([]string, 0, len(p.path)-1)
+	for _, node := range p.path[1:] {
+		names = append(names, node.Name)
+	}
+	return strings.Join(names, "/")
+}
+
 // CanonicalPathString returns canonical representation of the full path,
 // always prefaced by /keybase. This may require conversion to a platform
 // specific path, for example, by replacing /keybase with the appropriate drive
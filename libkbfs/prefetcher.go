@@ -10,6 +10,7 @@ import (
 	"sync"
 
 	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
@@ -20,6 +21,13 @@ const (
 	dirEntryPrefetchPriority            int = -200
 	updatePointerPrefetchPriority       int = 0
 	defaultPrefetchPriority             int = -1024
+	// maxTreePrefetchRequests is the maximum number of block
+	// requests PrefetchTree keeps outstanding at once.
+	maxTreePrefetchRequests int = 10
+	// readAheadPrefetchPriority is the priority of the blocks
+	// prefetched ahead of sequential file reads, which are likely
+	// to be needed soon.
+	readAheadPrefetchPriority int = -50
 )
 
 type prefetcherConfig interface {
@@ -124,12 +132,20 @@ func (p *blockPrefetcher) request(priority int, kmd KeyMetadata, ptr BlockPointe
 }
 
 func (p *blockPrefetcher) prefetchIndirectFileBlock(b *FileBlock, kmd KeyMetadata) {
-	// Prefetch the first <n> indirect block pointers.
-	// TODO: do something smart with subsequent blocks.
+	// Prefetch the first <n> indirect block pointers, as long as
+	// they're within the initial read-ahead window of this block.
+	// Anything past that is prefetched by folderBlockOps only if
+	// the file is actually being read sequentially.
 	numIPtrs := len(b.IPtrs)
 	if numIPtrs > defaultIndirectPointerPrefetchCount {
 		numIPtrs = defaultIndirectPointerPrefetchCount
 	}
+	for i := 1; i < numIPtrs; i++ {
+		if b.IPtrs[i].Off >= b.IPtrs[0].Off+minReadAheadBytes {
+			numIPtrs = i
+			break
+		}
+	}
 	p.log.CDebugf(context.TODO(), "Prefetching pointers for indirect file block. Num pointers to prefetch: %d", numIPtrs)
 	for _, ptr := range b.IPtrs[:numIPtrs] {
 		p.request(fileIndirectBlockPrefetchPriority, kmd,
@@ -202,6 +218,117 @@ func (p *blockPrefetcher) PrefetchAfterBlockRetrieved(
 	}
 }
 
+// treeBlockChildren returns the pointers to the blocks directly under
+// the given block in a folder's tree, along with empty blocks of the
+// right types to retrieve them into.
+func treeBlockChildren(b Block) (ptrs []BlockPointer, blocks []Block) {
+	switch b := b.(type) {
+	case *FileBlock:
+		if b.IsInd {
+			for _, iptr := range b.IPtrs {
+				ptrs = append(ptrs, iptr.BlockPointer)
+				blocks = append(blocks, b.NewEmpty())
+			}
+		}
+	case *DirBlock:
+		if b.IsInd {
+			for _, iptr := range b.IPtrs {
+				ptrs = append(ptrs, iptr.BlockPointer)
+				blocks = append(blocks, b.NewEmpty())
+			}
+			break
+		}
+		for _, entry := range b.Children {
+			switch entry.Type {
+			case Dir:
+				blocks = append(blocks, &DirBlock{})
+			case File, Exec:
+				blocks = append(blocks, &FileBlock{})
+			default:
+				// Symlinks have no blocks.
+				continue
+			}
+			ptrs = append(ptrs, entry.BlockPointer)
+		}
+	}
+	return ptrs, blocks
+}
+
+// PrefetchTree implements the Prefetcher interface for blockPrefetcher.
+func (p *blockPrefetcher) PrefetchTree(ctx context.Context, block Block,
+	ptr BlockPointer, kmd KeyMetadata, priority int,
+	skip func(BlockPointer) bool, fn func(BlockPointer, Block) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type treeResult struct {
+		ptr   BlockPointer
+		block Block
+		err   error
+	}
+	// Every outstanding request sends exactly one result, so this
+	// never blocks.
+	resultCh := make(chan treeResult, maxTreePrefetchRequests)
+	pendingPtrs := []BlockPointer{ptr}
+	pendingBlocks := []Block{block}
+	// The same block can be referenced more than once in a tree;
+	// only fetch it once.
+	seen := map[kbfsblock.ID]bool{ptr.ID: true}
+	outstanding := 0
+	for len(pendingPtrs) > 0 || outstanding > 0 {
+		select {
+		case <-p.shutdownCh:
+			return errors.Wrapf(io.EOF, "Stopping tree prefetch for block "+
+				"%v since the prefetcher is shutdown", ptr.ID)
+		default:
+		}
+		for len(pendingPtrs) > 0 && outstanding < maxTreePrefetchRequests {
+			// Fetch depth-first, to keep the pending list short.
+			last := len(pendingPtrs) - 1
+			ptr, block := pendingPtrs[last], pendingBlocks[last]
+			pendingPtrs, pendingBlocks = pendingPtrs[:last], pendingBlocks[:last]
+			if err := checkDataVersion(p.config, path{}, ptr); err != nil {
+				return err
+			}
+			errCh := p.retriever.Request(
+				ctx, priority, kmd, ptr, block, TransientEntry)
+			outstanding++
+			go func() {
+				resultCh <- treeResult{ptr, block, <-errCh}
+			}()
+		}
+
+		select {
+		case res := <-resultCh:
+			outstanding--
+			if res.err != nil {
+				return res.err
+			}
+			if err := fn(res.ptr, res.block); err != nil {
+				return err
+			}
+			childPtrs, childBlocks := treeBlockChildren(res.block)
+			for i, childPtr := range childPtrs {
+				if seen[childPtr.ID] {
+					continue
+				}
+				seen[childPtr.ID] = true
+				if skip != nil && skip(childPtr) {
+					continue
+				}
+				pendingPtrs = append(pendingPtrs, childPtr)
+				pendingBlocks = append(pendingBlocks, childBlocks[i])
+			}
+		case <-p.shutdownCh:
+			return errors.Wrapf(io.EOF, "Stopping tree prefetch for block "+
+				"%v since the prefetcher is shutdown", ptr.ID)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 // Shutdown implements the Prefetcher interface for blockPrefetcher.
 func (p *blockPrefetcher) Shutdown() <-chan struct{} {
 	select {
@@ -29,6 +29,8 @@ func makeRandomDirEntry(t *testing.T, typ EntryType, size uint64, path string) D
 			101,
 			102,
 		},
+		nil,
+		"",
 		codec.UnknownFieldSetHandler{},
 	}
 }
@@ -436,3 +438,79 @@ func TestPrefetcherEmptyDirectDirBlock(t *testing.T) {
 	testPrefetcherCheckGet(
 		t, config.BlockCache(), ptr1, dir1, true, TransientEntry)
 }
+
+func TestPrefetcherPrefetchTree(t *testing.T) {
+	t.Log("Test prefetching every block in a tree.")
+	q, bg, config := initPrefetcherTest(t)
+	defer shutdownPrefetcherTest(q)
+
+	t.Log("Initialize a tree with a subdirectory, an indirect file, a " +
+		"symlink, and a block referenced twice.")
+	filePtrs := []IndirectFilePtr{
+		makeFakeIndirectFilePtr(t, 0),
+		makeFakeIndirectFilePtr(t, 150),
+	}
+	file1 := &FileBlock{IPtrs: filePtrs}
+	file1.IsInd = true
+	file2 := makeFakeFileBlock(t, true)
+	file3 := makeFakeFileBlock(t, true)
+	file4 := makeFakeFileBlock(t, true)
+	ptr1 := makeRandomBlockPointer(t)
+	dir1 := &DirBlock{Children: map[string]DirEntry{
+		"a": makeRandomDirEntry(t, File, 300, "a"),
+		"b": makeRandomDirEntry(t, Dir, 60, "b"),
+		"c": makeRandomDirEntry(t, Sym, 0, "c"),
+	}}
+	dir2 := &DirBlock{Children: map[string]DirEntry{
+		"d": makeRandomDirEntry(t, Exec, 100, "d"),
+	}}
+	// "e" has the same block as "d".
+	dir2.Children["e"] = dir2.Children["d"]
+
+	blocks := map[BlockPointer]Block{
+		ptr1:                            dir1,
+		dir1.Children["a"].BlockPointer: file1,
+		filePtrs[0].BlockPointer:        file2,
+		filePtrs[1].BlockPointer:        file3,
+		dir1.Children["b"].BlockPointer: dir2,
+		dir2.Children["d"].BlockPointer: file4,
+	}
+	for ptr, block := range blocks {
+		_, continueCh := bg.setBlockToReturn(ptr, block)
+		go func() {
+			continueCh <- nil
+		}()
+	}
+
+	fetched := make(map[BlockPointer]Block)
+	err := q.Prefetcher().PrefetchTree(context.Background(), &DirBlock{},
+		ptr1, makeKMD(), defaultPrefetchPriority, nil,
+		func(ptr BlockPointer, block Block) error {
+			fetched[ptr] = block
+			return nil
+		})
+	require.NoError(t, err)
+	require.Equal(t, blocks, fetched)
+
+	t.Log("Ensure that the fetched blocks are in the cache.")
+	for ptr, block := range blocks {
+		testPrefetcherCheckGet(
+			t, config.BlockCache(), ptr, block, false, TransientEntry)
+	}
+
+	t.Log("Skip the subdirectory.")
+	fetched = make(map[BlockPointer]Block)
+	err = q.Prefetcher().PrefetchTree(context.Background(), &DirBlock{},
+		ptr1, makeKMD(), defaultPrefetchPriority,
+		func(ptr BlockPointer) bool {
+			return ptr == dir1.Children["b"].BlockPointer
+		},
+		func(ptr BlockPointer, block Block) error {
+			fetched[ptr] = block
+			return nil
+		})
+	require.NoError(t, err)
+	delete(blocks, dir1.Children["b"].BlockPointer)
+	delete(blocks, dir2.Children["d"].BlockPointer)
+	require.Equal(t, blocks, fetched)
+}
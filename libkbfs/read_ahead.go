@@ -0,0 +1,161 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+const (
+	// minReadAheadBytes is the size of the read-ahead window after
+	// the first sequential read of a file.  It's also how much of
+	// each indirect file block the prefetcher fetches on its own,
+	// before anyone has read from it.
+	minReadAheadBytes int64 = 512 * 1024
+	// maxReadAheadBytes is the largest the read-ahead window gets,
+	// however long a file is read sequentially.
+	maxReadAheadBytes int64 = 32 * 1024 * 1024
+	// maxReadPatternFiles is the number of files whose read
+	// patterns are remembered at once, per folder.
+	maxReadPatternFiles int = 128
+)
+
+// fileReadPattern is what readAheadTracker remembers about the
+// reads from a single file.
+type fileReadPattern struct {
+	// nextOff is the offset at which the next read would be
+	// sequential.
+	nextOff int64
+	// window is the number of bytes past the end of the last read
+	// that should be prefetched.  It's 0 for files that are being
+	// read randomly.
+	window int64
+	// prefetchedEnd is the offset up to which blocks have already
+	// been prefetched.
+	prefetchedEnd int64
+}
+
+// readAheadTracker watches the reads from each file in a folder, and
+// decides how far ahead of each read to prefetch.  When a file is
+// read sequentially, the window doubles with every read, from
+// minReadAheadBytes up to maxReadAheadBytes; as soon as a read
+// doesn't start where the last one ended, the file is treated as
+// randomly accessed and nothing is prefetched for it until it's read
+// sequentially again.  It is goroutine-safe.
+type readAheadTracker struct {
+	sequentialReads  metrics.Meter
+	randomReads      metrics.Meter
+	prefetchedBlocks metrics.Meter
+	windowBytes      metrics.Histogram
+
+	lock  sync.Mutex
+	files *lru.Cache // BlockPointer -> *fileReadPattern
+
+	// Counts the read-aheads running in the background.
+	running sync.WaitGroup
+}
+
+func newReadAheadTracker(registry metrics.Registry) *readAheadTracker {
+	files, err := lru.New(maxReadPatternFiles)
+	if err != nil {
+		// Only happens for a non-positive size.
+		panic(err)
+	}
+	t := &readAheadTracker{
+		sequentialReads:  metrics.NilMeter{},
+		randomReads:      metrics.NilMeter{},
+		prefetchedBlocks: metrics.NilMeter{},
+		windowBytes:      metrics.NilHistogram{},
+		files:            files,
+	}
+	if registry != nil {
+		t.sequentialReads = metrics.GetOrRegisterMeter(
+			"ReadAhead.SequentialReads", registry)
+		t.randomReads = metrics.GetOrRegisterMeter(
+			"ReadAhead.RandomReads", registry)
+		t.prefetchedBlocks = metrics.GetOrRegisterMeter(
+			"ReadAhead.PrefetchedBlocks", registry)
+		t.windowBytes = metrics.GetOrRegisterHistogram(
+			"ReadAhead.WindowBytes", registry,
+			metrics.NewExpDecaySample(1028, 0.015))
+	}
+	return t
+}
+
+// onRead records that n bytes were read at offset off from the file
+// whose top block is ptr, and returns the range of the file, [start,
+// end), that should be prefetched next.  The range is empty if
+// nothing should be.
+func (t *readAheadTracker) onRead(
+	ptr BlockPointer, off, n int64) (start, end int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	p := &fileReadPattern{}
+	if v, ok := t.files.Get(ptr); ok {
+		p = v.(*fileReadPattern)
+	} else {
+		t.files.Add(ptr, p)
+	}
+
+	readEnd := off + n
+	if off != p.nextOff || n == 0 {
+		// A random read (or a read past the end of the file)
+		// shrinks the window back down to nothing.
+		t.randomReads.Mark(1)
+		p.nextOff = readEnd
+		p.window = 0
+		p.prefetchedEnd = 0
+		return 0, 0
+	}
+
+	t.sequentialReads.Mark(1)
+	p.nextOff = readEnd
+	switch {
+	case p.window == 0:
+		p.window = minReadAheadBytes
+	case p.window < maxReadAheadBytes:
+		p.window *= 2
+		if p.window > maxReadAheadBytes {
+			p.window = maxReadAheadBytes
+		}
+	}
+	t.windowBytes.Update(p.window)
+
+	start = readEnd
+	if p.prefetchedEnd > start {
+		start = p.prefetchedEnd
+	}
+	end = readEnd + p.window
+	if start >= end {
+		return 0, 0
+	}
+	p.prefetchedEnd = end
+	return start, end
+}
+
+// markPrefetched records that n blocks were prefetched as read-ahead.
+func (t *readAheadTracker) markPrefetched(n int) {
+	t.prefetchedBlocks.Mark(int64(n))
+}
+
+// start records that a read-ahead is starting in the background.
+func (t *readAheadTracker) start() {
+	t.running.Add(1)
+}
+
+// done records that a read-ahead started with start is done.
+func (t *readAheadTracker) done() {
+	t.running.Done()
+}
+
+// wait waits for all the read-aheads running in the background to
+// be done.
+func (t *readAheadTracker) wait() {
+	t.running.Wait()
+}
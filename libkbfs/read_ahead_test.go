@@ -0,0 +1,145 @@
+// Copyright 2017 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAheadTrackerWindow(t *testing.T) {
+	registry := metrics.NewRegistry()
+	tracker := newReadAheadTracker(registry)
+	ptr := makeRandomBlockPointer(t)
+
+	// The first read from the start of the file opens the minimum
+	// window after it.
+	start, end := tracker.onRead(ptr, 0, 100)
+	require.Equal(t, int64(100), start)
+	require.Equal(t, 100+minReadAheadBytes, end)
+
+	// Each sequential read doubles the window, and only asks for
+	// what hasn't already been prefetched.
+	start, end = tracker.onRead(ptr, 100, 100)
+	require.Equal(t, 100+minReadAheadBytes, start)
+	require.Equal(t, 200+2*minReadAheadBytes, end)
+
+	off := int64(200)
+	for i := 0; i < 10; i++ {
+		_, end = tracker.onRead(ptr, off, 100)
+		off += 100
+	}
+	require.Equal(t, off+maxReadAheadBytes, end)
+
+	// A random read closes the window.
+	start, end = tracker.onRead(ptr, 5, 10)
+	require.Equal(t, int64(0), start)
+	require.Equal(t, int64(0), end)
+	start, end = tracker.onRead(ptr, 100, 10)
+	require.Equal(t, int64(0), start)
+	require.Equal(t, int64(0), end)
+
+	// But reading sequentially again reopens it, starting small.
+	start, end = tracker.onRead(ptr, 110, 10)
+	require.Equal(t, int64(120), start)
+	require.Equal(t, 120+minReadAheadBytes, end)
+
+	// A file first read from the middle isn't sequential yet.
+	ptr2 := makeRandomBlockPointer(t)
+	start, end = tracker.onRead(ptr2, 1000, 10)
+	require.Equal(t, int64(0), start)
+	require.Equal(t, int64(0), end)
+
+	tracker.markPrefetched(3)
+	require.Equal(t, int64(13),
+		registry.Get("ReadAhead.SequentialReads").(metrics.Meter).Count())
+	require.Equal(t, int64(3),
+		registry.Get("ReadAhead.RandomReads").(metrics.Meter).Count())
+	require.Equal(t, int64(3),
+		registry.Get("ReadAhead.PrefetchedBlocks").(metrics.Meter).Count())
+
+	// Trackers work without a registry too.
+	tracker = newReadAheadTracker(nil)
+	_, end = tracker.onRead(ptr, 0, 100)
+	require.Equal(t, 100+minReadAheadBytes, end)
+}
+
+func TestKBFSOpsReadAheadSequentialRead(t *testing.T) {
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	blockSize := int64(64 * 1024)
+	bsplitter, err := NewBlockSplitterSimple(
+		blockSize, 8*1024, config1.Codec())
+	require.NoError(t, err)
+	config1.SetBlockSplitter(bsplitter)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config1, "test_user", false)
+	kbfsOps1 := config1.KBFSOps()
+	fileNode, _, err := kbfsOps1.CreateFile(
+		ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	data := make([]byte, 4*minReadAheadBytes)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	err = kbfsOps1.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.Sync(ctx, fileNode)
+	require.NoError(t, err)
+
+	// Read the file from a fresh block cache.
+	config2 := ConfigAsUser(config1, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	config2.SetBlockSplitter(bsplitter)
+	registry := config2.MetricsRegistry()
+	require.NotNil(t, registry)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "test_user", false)
+	kbfsOps2 := config2.KBFSOps()
+	fileNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+
+	prefetched := func() int64 {
+		return registry.Get(
+			"ReadAhead.PrefetchedBlocks").(metrics.Meter).Count()
+	}
+	buf := make([]byte, blockSize)
+	tracker := getOps(config2, rootNode2.GetFolderBranch().Tlf).
+		blocks.readAhead
+	n, err := kbfsOps2.Read(ctx, fileNode2, buf, 0)
+	require.NoError(t, err)
+	require.Equal(t, blockSize, n)
+	require.Equal(t, data[:blockSize], buf)
+	tracker.wait()
+	// At least the blocks in the minimum window were prefetched.
+	firstPrefetched := prefetched()
+	require.True(t, firstPrefetched >= minReadAheadBytes/blockSize,
+		"Only %d blocks prefetched", firstPrefetched)
+
+	// Reading on sequentially prefetches more.
+	n, err = kbfsOps2.Read(ctx, fileNode2, buf, blockSize)
+	require.NoError(t, err)
+	require.Equal(t, blockSize, n)
+	require.Equal(t, data[blockSize:2*blockSize], buf)
+	tracker.wait()
+	require.True(t, prefetched() > firstPrefetched)
+
+	// A random read doesn't.
+	before := prefetched()
+	n, err = kbfsOps2.Read(ctx, fileNode2, buf, 2*minReadAheadBytes)
+	require.NoError(t, err)
+	require.Equal(t, blockSize, n)
+	require.Equal(t,
+		data[2*minReadAheadBytes:2*minReadAheadBytes+blockSize], buf)
+	tracker.wait()
+	require.Equal(t, before, prefetched())
+	require.Equal(t, int64(1),
+		registry.Get("ReadAhead.RandomReads").(metrics.Meter).Count())
+
+	// Wait for the prefetches to finish before shutting down.
+	<-config2.BlockOps().Prefetcher().Shutdown()
+}
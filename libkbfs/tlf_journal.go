@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"sync"
 	"time"
 
@@ -42,6 +43,9 @@ type tlfJournalConfig interface {
 	usernameGetter() normalizedUsernameGetter
 	MakeLogger(module string) logger.Logger
 	diskLimitTimeout() time.Duration
+	DiskBlockCache() DiskBlockCache
+	CacheFlushedJournalBlocks() bool
+	BandwidthLimiter() *BandwidthLimiter
 }
 
 // tlfJournalConfigWrapper is an adapter for Config objects to the
@@ -197,6 +201,10 @@ type tlfJournal struct {
 	// blockJournal.getStoredFiles() until shutdown.
 	diskLimiter DiskLimiter
 
+	// flushScheduler, if non-nil, decides when this journal gets
+	// to put its blocks and MDs, relative to other journals.
+	flushScheduler *journalFlushScheduler
+
 	// All the channels below are used as simple on/off
 	// signals. They're buffered for one object, and all sends are
 	// asynchronous, so multiple sends get collapsed into one
@@ -237,6 +245,10 @@ type tlfJournal struct {
 	// An estimate of how many bytes have been written since the last
 	// squash.
 	unsquashedBytes uint64
+	// Set when a squash has been asked for explicitly, and cleared
+	// once the unflushed revisions have been converted to a local
+	// squash branch.
+	squashRequested bool
 	flushingBlocks  map[kbfsblock.ID]bool
 
 	bwDelegate tlfJournalBWDelegate
@@ -254,10 +266,10 @@ type tlfJournalInfo struct {
 	TlfID        tlf.ID
 }
 
-func readTLFJournalInfoFile(dir string) (
+func readTLFJournalInfoFile(dir string, crypter *localStorageCrypter) (
 	keybase1.UID, kbfscrypto.VerifyingKey, tlf.ID, error) {
 	var info tlfJournalInfo
-	err := ioutil.DeserializeFromJSONFile(
+	err := crypter.deserializeFromJSONFile(
 		getTLFJournalInfoFilePath(dir), &info)
 	if err != nil {
 		return keybase1.UID(""), kbfscrypto.VerifyingKey{}, tlf.ID{}, err
@@ -267,9 +279,249 @@ func readTLFJournalInfoFile(dir string) (
 }
 
 func writeTLFJournalInfoFile(dir string, uid keybase1.UID,
-	key kbfscrypto.VerifyingKey, tlfID tlf.ID) error {
+	key kbfscrypto.VerifyingKey, tlfID tlf.ID,
+	crypter *localStorageCrypter) error {
 	info := tlfJournalInfo{uid, key, tlfID}
-	return ioutil.SerializeToJSONFile(info, getTLFJournalInfoFilePath(dir))
+	return crypter.serializeToJSONFile(info, getTLFJournalInfoFilePath(dir))
+}
+
+// tlfJournalHasUnflushedEntries returns whether the TLF journal in
+// the given directory has any block or MD entries left to flush.  It
+// doesn't read any info files, so it works whether or not they're
+// encrypted.
+func tlfJournalHasUnflushedEntries(
+	codec kbfscodec.Codec, dir string) (bool, error) {
+	blockJournal, err := makeDiskJournal(codec, blockJournalDir(dir),
+		reflect.TypeOf(blockJournalEntry{}))
+	if err != nil {
+		return false, err
+	}
+	if blockJournal.length() > 0 {
+		return true, nil
+	}
+	mdJournal, err := makeMdIDJournal(codec, mdJournalPath(dir))
+	if err != nil {
+		return false, err
+	}
+	return mdJournal.length() > 0, nil
+}
+
+func tlfJournalQuarantinePath(dir string) string {
+	return filepath.Join(dir, "quarantine")
+}
+
+// firstMDRevisionToQuarantine returns the first MD revision whose
+// blocks may have been lost when blockRepair quarantined entries of
+// the block journal in blockDir, or MetadataRevisionUninitialized if
+// there isn't one.  An MD's revision marker is added to the block
+// journal after its blocks, so the blocks of every MD after the last
+// marker that's left may have been among the quarantined entries.
+// If no marker is left, only the MDs from the first quarantined
+// marker on are known to have lost blocks, since the markers of
+// earlier MDs may have been flushed.
+func firstMDRevisionToQuarantine(codec kbfscodec.Codec, blockDir string,
+	blockRepair *diskJournalRepair, blockQuarantineDir string) (
+	MetadataRevision, error) {
+	if blockRepair == nil || len(blockRepair.Quarantined) == 0 {
+		return MetadataRevisionUninitialized, nil
+	}
+
+	j, err := makeDiskJournal(
+		codec, blockDir, reflect.TypeOf(blockJournalEntry{}))
+	if err != nil {
+		return MetadataRevisionUninitialized, err
+	}
+	if !j.empty() {
+		for o := j.latest; o >= j.earliest; o-- {
+			e, err := j.readJournalEntry(o)
+			if err != nil {
+				return MetadataRevisionUninitialized, err
+			}
+			entry := e.(blockJournalEntry)
+			if entry.Op == mdRevMarkerOp {
+				return entry.Revision + 1, nil
+			}
+			if o == j.earliest {
+				break
+			}
+		}
+	}
+
+	// The quarantined entries are in order, and the first one
+	// usually can't be read, but the ones after it may be.
+	for _, o := range blockRepair.Quarantined {
+		buf, err := readChecksummedFile(
+			filepath.Join(blockQuarantineDir, o.String()))
+		if err != nil {
+			continue
+		}
+		var entry blockJournalEntry
+		err = codec.Decode(buf, &entry)
+		if err != nil {
+			continue
+		}
+		if entry.Op == mdRevMarkerOp {
+			return entry.Revision, nil
+		}
+	}
+	return MetadataRevisionUninitialized, nil
+}
+
+// quarantineMDsForRepair cuts the MD journal in dir down to the
+// revisions before firstRev, moving the others to quarantineDir, and
+// returns what it did, or nil if there was nothing to cut.
+func quarantineMDsForRepair(codec kbfscodec.Codec, dir string,
+	firstRev MetadataRevision, quarantineDir string) (
+	*diskJournalRepair, error) {
+	j, err := makeDiskJournal(
+		codec, dir, reflect.TypeOf(mdIDJournalEntry{}))
+	if err != nil {
+		return nil, err
+	}
+	end, err := revisionToOrdinal(firstRev)
+	if err != nil {
+		return nil, err
+	}
+	if j.empty() || j.latest < end {
+		return nil, nil
+	}
+
+	repair := &diskJournalRepair{Dir: dir}
+	err = j.truncateForRepair(
+		j.earliest, j.latest, end, quarantineDir, repair)
+	if err != nil {
+		return nil, err
+	}
+	repair.addProblem("the block journal entries for revisions %s and "+
+		"later were lost; quarantined %d MDs", firstRev,
+		len(repair.Quarantined))
+	return repair, nil
+}
+
+// repairTLFJournal repairs each disk journal under dir that a crash
+// left inconsistent (see repairDiskJournal), before it's opened.  If
+// any block journal entries have to be dropped, the MDs whose blocks
+// may have been among them are dropped too (see
+// firstMDRevisionToQuarantine), so that they aren't flushed without
+// their blocks.  Dropped entries are moved to a new subdirectory of
+// the quarantine directory, named after the current time, along with
+// a report of all the repairs.  That subdirectory is returned as
+// quarantineDir if it was made.
+func repairTLFJournal(codec kbfscodec.Codec, clock Clock, dir string) (
+	repairs []diskJournalRepair, quarantineDir string, err error) {
+	quarantineDir = filepath.Join(tlfJournalQuarantinePath(dir),
+		clock.Now().UTC().Format("20060102T150405.000000000Z"))
+	journalQuarantineDir := func(journalDir string) string {
+		return filepath.Join(quarantineDir, filepath.Base(journalDir))
+	}
+	quarantined := false
+	addRepair := func(repair *diskJournalRepair) {
+		if repair != nil {
+			repairs = append(repairs, *repair)
+			quarantined = quarantined || len(repair.Quarantined) > 0
+		}
+	}
+
+	blockDir := blockJournalDir(dir)
+	blockRepair, err := repairDiskJournal(
+		codec, blockDir, reflect.TypeOf(blockJournalEntry{}),
+		journalQuarantineDir(blockDir))
+	if err != nil {
+		return nil, "", err
+	}
+	addRepair(blockRepair)
+
+	// Losing deferred GC entries only leaks local block data, so
+	// they don't affect the other journals.
+	gcDir := deferredGCBlockJournalDir(dir)
+	gcRepair, err := repairDiskJournal(
+		codec, gcDir, reflect.TypeOf(blockJournalEntry{}),
+		journalQuarantineDir(gcDir))
+	if err != nil {
+		return nil, "", err
+	}
+	addRepair(gcRepair)
+
+	mdDir := mdJournalPath(dir)
+	mdRepair, err := repairDiskJournal(
+		codec, mdDir, reflect.TypeOf(mdIDJournalEntry{}),
+		journalQuarantineDir(mdDir))
+	if err != nil {
+		return nil, "", err
+	}
+	addRepair(mdRepair)
+
+	firstRev, err := firstMDRevisionToQuarantine(
+		codec, blockDir, blockRepair, journalQuarantineDir(blockDir))
+	if err != nil {
+		return nil, "", err
+	}
+	if firstRev != MetadataRevisionUninitialized {
+		mdRepair, err := quarantineMDsForRepair(
+			codec, mdDir, firstRev, journalQuarantineDir(mdDir))
+		if err != nil {
+			return nil, "", err
+		}
+		addRepair(mdRepair)
+	}
+
+	if !quarantined {
+		return repairs, "", nil
+	}
+	err = ioutil.SerializeToJSONFile(
+		repairs, filepath.Join(quarantineDir, "report.json"))
+	if err != nil {
+		return nil, "", err
+	}
+	return repairs, quarantineDir, nil
+}
+
+// tlfJournalNameTimeout bounds how long getTLFJournalTlfName waits
+// for the MD server.
+const tlfJournalNameTimeout = 10 * time.Second
+
+// getTLFJournalTlfName returns the canonical name of the given TLF,
+// from the head of its MD journal, or if that's empty, from the
+// latest merged MD on the server.
+func getTLFJournalTlfName(ctx context.Context, config tlfJournalConfig,
+	tlfID tlf.ID, mdJournal *mdJournal) (CanonicalTlfName, error) {
+	head, err := mdJournal.getHead(mdJournal.getBranchID())
+	if err != nil {
+		return "", err
+	}
+	brmd, extra := head.BareRootMetadata, head.extra
+	if head == (ImmutableBareRootMetadata{}) {
+		ctx, cancel := context.WithTimeout(ctx, tlfJournalNameTimeout)
+		defer cancel()
+		mdserv := config.MDServer()
+		rmds, err := mdserv.GetForTLF(ctx, tlfID, NullBranchID, Merged)
+		if err != nil {
+			return "", err
+		}
+		if rmds == nil {
+			return "", errors.Errorf("No MD found for %s", tlfID)
+		}
+		brmd = rmds.MD
+		extra, err = getExtraMetadata(
+			func(tlfID tlf.ID, wkbID TLFWriterKeyBundleID,
+				rkbID TLFReaderKeyBundleID) (*TLFWriterKeyBundleV3,
+				*TLFReaderKeyBundleV3, error) {
+				return mdserv.GetKeyBundles(ctx, tlfID, wkbID, rkbID)
+			}, brmd)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	bareHandle, err := brmd.MakeBareTlfHandle(extra)
+	if err != nil {
+		return "", err
+	}
+	handle, err := MakeTlfHandle(ctx, bareHandle, config.usernameGetter())
+	if err != nil {
+		return "", err
+	}
+	return handle.GetCanonicalName(), nil
 }
 
 func makeTLFJournal(
@@ -277,8 +529,9 @@ func makeTLFJournal(
 	dir string, tlfID tlf.ID, config tlfJournalConfig,
 	delegateBlockServer BlockServer, bws TLFJournalBackgroundWorkStatus,
 	bwDelegate tlfJournalBWDelegate, onBranchChange branchChangeListener,
-	onMDFlush mdFlushListener, diskLimiter DiskLimiter) (
-	*tlfJournal, error) {
+	onMDFlush mdFlushListener, diskLimiter DiskLimiter,
+	flushScheduler *journalFlushScheduler,
+	crypter *localStorageCrypter) (*tlfJournal, error) {
 	if uid == keybase1.UID("") {
 		return nil, errors.New("Empty user")
 	}
@@ -289,11 +542,11 @@ func makeTLFJournal(
 		return nil, errors.New("Empty tlf.ID")
 	}
 
-	readUID, readKey, readTlfID, err := readTLFJournalInfoFile(dir)
+	readUID, readKey, readTlfID, err := readTLFJournalInfoFile(dir, crypter)
 	switch {
 	case ioutil.IsNotExist(err):
 		// Info file doesn't exist, so write it.
-		err := writeTLFJournalInfoFile(dir, uid, key, tlfID)
+		err := writeTLFJournalInfoFile(dir, uid, key, tlfID, crypter)
 		if err != nil {
 			return nil, err
 		}
@@ -323,18 +576,46 @@ func makeTLFJournal(
 
 	log := config.MakeLogger("TLFJ")
 
-	blockJournal, err := makeBlockJournal(ctx, config.Codec(), dir, log)
+	repairs, quarantineDir, err := repairTLFJournal(
+		config.Codec(), config.Clock(), dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blockJournal, err := makeBlockJournal(
+		ctx, config.Codec(), dir, crypter, log)
 	if err != nil {
 		return nil, err
 	}
 
 	mdJournal, err := makeMDJournal(
 		ctx, uid, key, config.Codec(), config.Crypto(), config.Clock(),
-		tlfID, config.MetadataVersion(), dir, log)
+		tlfID, config.MetadataVersion(), dir, crypter, log)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(repairs) > 0 {
+		repairErr := JournalRepairedError{
+			TlfID:         tlfID,
+			QuarantineDir: quarantineDir,
+		}
+		for _, r := range repairs {
+			for _, p := range r.Problems {
+				repairErr.Problems = append(repairErr.Problems,
+					filepath.Base(r.Dir)+": "+p)
+			}
+		}
+		log.CWarningf(ctx, "%v", repairErr)
+		tlfName, err := getTLFJournalTlfName(ctx, config, tlfID, mdJournal)
+		if err != nil {
+			log.CDebugf(ctx, "Couldn't get the name of %s to report "+
+				"the journal repair: %+v", tlfID, err)
+		}
+		config.Reporter().ReportErr(
+			ctx, tlfName, tlfID.IsPublic(), WriteMode, repairErr)
+	}
+
 	j := &tlfJournal{
 		uid:                  uid,
 		key:                  key,
@@ -348,6 +629,7 @@ func makeTLFJournal(
 		onMDFlush:            onMDFlush,
 		forcedSquashByBytes:  ForcedBranchSquashBytesThresholdDefault,
 		diskLimiter:          diskLimiter,
+		flushScheduler:       flushScheduler,
 		hasWorkCh:            make(chan struct{}, 1),
 		needPauseCh:          make(chan struct{}, 1),
 		needResumeCh:         make(chan struct{}, 1),
@@ -590,8 +872,9 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 	}
 }
 
-// doBackgroundWork currently only does auto-flushing. It assumes that
-// ctx is canceled when the background processing should stop.
+// doBackgroundWork currently only does auto-flushing, once the
+// bandwidth limiter's flush schedule allows it. It assumes that ctx
+// is canceled when the background processing should stop.
 //
 // TODO: Handle garbage collection too.
 func (j *tlfJournal) doBackgroundWork(ctx context.Context) <-chan error {
@@ -599,12 +882,36 @@ func (j *tlfJournal) doBackgroundWork(ctx context.Context) <-chan error {
 	// TODO: Handle panics.
 	go func() {
 		defer j.wg.Done()
-		errCh <- j.flush(ctx)
+		errCh <- j.flushWhenAllowed(ctx)
 		close(errCh)
 	}()
 	return errCh
 }
 
+// flushWhenAllowed waits until background flushes are allowed, and
+// then flushes the journal.  If the schedule stops allowing the
+// flush part way through, the flush gives up its locks and this
+// waits for the next window before trying again.
+func (j *tlfJournal) flushWhenAllowed(ctx context.Context) error {
+	bandwidth := j.config.BandwidthLimiter()
+	for {
+		if !bandwidth.flushesAllowed() {
+			j.log.CDebugf(ctx,
+				"Waiting for the flush schedule for %s", j.tlfID)
+		}
+		err := bandwidth.waitForFlushWindow(ctx)
+		if err != nil {
+			return err
+		}
+		err = j.flush(context.WithValue(ctx, ctxBackgroundFlushKey, true))
+		if _, ok := errors.Cause(err).(errFlushWindowClosed); !ok {
+			return err
+		}
+		j.log.CDebugf(ctx, "Flush schedule closed while flushing %s",
+			j.tlfID)
+	}
+}
+
 // We don't guarantee that background pause/resume requests will be
 // processed in strict FIFO order. In particular, multiple pause
 // requests are collapsed into one (also multiple resume requests), so
@@ -688,6 +995,14 @@ func (j *tlfJournal) getJournalEnds(ctx context.Context) (
 }
 
 func (j *tlfJournal) flush(ctx context.Context) (err error) {
+	// Background flushes must not wait on the flush schedule while
+	// holding flushLock, or they'd hold up explicit flushes.
+	bandwidth := j.config.BandwidthLimiter()
+	err = bandwidth.checkFlushWindow(ctx)
+	if err != nil {
+		return err
+	}
+
 	j.flushLock.Lock()
 	defer j.flushLock.Unlock()
 
@@ -701,6 +1016,10 @@ func (j *tlfJournal) flush(ctx context.Context) (err error) {
 				flushedBlockEntries, flushedMDEntries,
 				j.tlfID, err)
 		}
+		if _, ok := errors.Cause(err).(errFlushWindowClosed); ok {
+			// The flush will resume in the next window.
+			return
+		}
 		j.journalLock.Lock()
 		j.lastFlushErr = err
 		j.journalLock.Unlock()
@@ -717,6 +1036,11 @@ func (j *tlfJournal) flush(ctx context.Context) (err error) {
 		default:
 		}
 
+		err := bandwidth.checkFlushWindow(ctx)
+		if err != nil {
+			return err
+		}
+
 		isConflict, err := j.isOnConflictBranch()
 		if err != nil {
 			return err
@@ -858,6 +1182,52 @@ func (j *tlfJournal) removeFlushedBlockEntries(ctx context.Context,
 	return nil
 }
 
+// cacheFlushedBlocks puts the given just-flushed blocks in the disk
+// block cache, if the config asks for that, since whoever wrote them
+// will probably read them again soon.  The journal still deletes its
+// own copies once they're no longer referenced.  Errors are only
+// logged, since the blocks are already safe on the server.
+func (j *tlfJournal) cacheFlushedBlocks(
+	ctx context.Context, entries blockEntriesToFlush) {
+	if !j.config.CacheFlushedJournalBlocks() {
+		return
+	}
+	dbc := j.config.DiskBlockCache()
+	if dbc == nil {
+		return
+	}
+
+	for _, bs := range entries.puts.blockStates {
+		// The disk block cache does its own disk limiter
+		// accounting for the blocks it takes.
+		err := dbc.Put(ctx, j.tlfID, bs.blockPtr.ID,
+			bs.readyBlockData.buf, bs.readyBlockData.serverHalf)
+		if err != nil {
+			// The cache is probably full, so don't bother with
+			// the rest.
+			j.log.CDebugf(ctx, "Couldn't put flushed block %s in the "+
+				"disk cache: %+v", bs.blockPtr.ID, err)
+			return
+		}
+	}
+}
+
+// waitForFlushTurn waits until the flush scheduler lets this journal
+// start the given number of block or MD puts.  The caller must
+// release them from j.flushScheduler once it's done.  Background
+// flushes fail instead if the flush schedule doesn't allow them.
+func (j *tlfJournal) waitForFlushTurn(ctx context.Context, slots int) error {
+	err := j.config.BandwidthLimiter().checkFlushWindow(ctx)
+	if err != nil {
+		return err
+	}
+	_, _, unflushedBytes, err := j.getByteCounts()
+	if err != nil {
+		return err
+	}
+	return j.flushScheduler.acquire(ctx, j.tlfID, slots, unflushedBytes)
+}
+
 func (j *tlfJournal) flushBlockEntries(
 	ctx context.Context, end journalOrdinal) (
 	numFlushed int, maxMDRevToFlush MetadataRevision,
@@ -871,6 +1241,12 @@ func (j *tlfJournal) flushBlockEntries(
 		return 0, maxMDRevToFlush, false, nil
 	}
 
+	err = j.waitForFlushTurn(ctx, entries.length())
+	if err != nil {
+		return 0, MetadataRevisionUninitialized, false, err
+	}
+	defer j.flushScheduler.release(entries.length())
+
 	j.log.CDebugf(ctx, "Flushing %d blocks, up to rev %d",
 		len(entries.puts.blockStates), maxMDRevToFlush)
 
@@ -909,7 +1285,9 @@ func (j *tlfJournal) flushBlockEntries(
 	// end, and we need to make sure `maxMDRevToFlush` is still valid.
 	eg.Go(func() error {
 		defer convertCancel()
-		return flushBlockEntries(groupCtx, j.log, j.delegateBlockServer,
+		bserver := throttledBlockServer{
+			j.delegateBlockServer, j.config.BandwidthLimiter()}
+		return flushBlockEntries(groupCtx, j.log, bserver,
 			j.config.BlockCache(), j.config.Reporter(),
 			j.tlfID, tlfName, entries)
 	})
@@ -955,6 +1333,8 @@ func (j *tlfJournal) flushBlockEntries(
 		return 0, MetadataRevisionUninitialized, false, err
 	}
 
+	j.cacheFlushedBlocks(ctx, entries)
+
 	// TODO: If both the block and MD journals are empty, nuke the
 	// entire TLF journal directory.
 
@@ -1030,6 +1410,7 @@ func (j *tlfJournal) convertMDsToBranchIfOverThreshold(ctx context.Context,
 
 	if j.mdJournal.getBranchID() != NullBranchID {
 		// Already on a conflict branch, so nothing to do.
+		j.squashRequested = false
 		return false, nil
 	}
 
@@ -1040,6 +1421,7 @@ func (j *tlfJournal) convertMDsToBranchIfOverThreshold(ctx context.Context,
 	if !atLeastOneRev {
 		// If there isn't at least one non-local-squash revision, we can
 		// bail early since there's definitely nothing to do.
+		j.squashRequested = false
 		return false, nil
 	}
 
@@ -1054,17 +1436,18 @@ func (j *tlfJournal) convertMDsToBranchIfOverThreshold(ctx context.Context,
 	// to disk before this tlfJournal instance started.  But it should
 	// be close enough to work for the purposes of this optimization.
 	squashByBytes := j.unsquashedBytes >= j.forcedSquashByBytes
-	if !squashByRev && !squashByBytes {
-		// Not over either threshold yet.
+	if !squashByRev && !squashByBytes && !j.squashRequested {
+		// Not over either threshold yet, and nobody asked.
 		return false, nil
 	}
 
 	j.log.CDebugf(ctx, "Converting journal with %d unsquashed bytes "+
-		"to a branch", j.unsquashedBytes)
+		"to a branch (requested=%t)", j.unsquashedBytes, j.squashRequested)
+	j.squashRequested = false
 
-	// If we're squashing by bytes, and there's exactly one
-	// non-local-squash revision, just directly mark it as squashed to
-	// avoid the CR overhead.
+	// If we're squashing by bytes or by request, and there's exactly
+	// one non-local-squash revision, just directly mark it as
+	// squashed to avoid the CR overhead.
 	if !squashByRev {
 		moreThanOneRev, err := j.mdJournal.atLeastNNonLocalSquashes(2)
 		if err != nil {
@@ -1096,6 +1479,31 @@ func (j *tlfJournal) convertMDsToBranchIfOverThreshold(ctx context.Context,
 	return true, nil
 }
 
+// requestSquash asks for all the unflushed revisions in the journal
+// to be squashed into one the next time the journal checks whether
+// it's over the squash thresholds, which happens while it's flushing.
+// Just like when a threshold is crossed, the revisions are converted
+// to a local squash branch, which the conflict resolver then
+// collapses into a single revision, dropping the blocks that were
+// both referenced and unreferenced within them from the block
+// journal.
+func (j *tlfJournal) requestSquash(ctx context.Context) error {
+	j.journalLock.Lock()
+	defer j.journalLock.Unlock()
+	if err := j.checkEnabledLocked(); err != nil {
+		return err
+	}
+
+	j.log.CDebugf(ctx, "Squash requested")
+	j.squashRequested = true
+	j.signalWork()
+	select {
+	case j.needBranchCheckCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
 func (j *tlfJournal) getMDFlushRange() (
 	blockJournal *blockJournal, length int, earliest, latest journalOrdinal,
 	err error) {
@@ -1227,6 +1635,12 @@ func (j *tlfJournal) flushOneMDOp(
 		return false, nil
 	}
 
+	err = j.waitForFlushTurn(ctx, 1)
+	if err != nil {
+		return false, err
+	}
+	defer j.flushScheduler.release(1)
+
 	j.log.CDebugf(ctx, "Flushing MD for TLF=%s with id=%s, rev=%s, bid=%s",
 		rmds.MD.TlfID(), mdID, rmds.MD.RevisionNumber(), rmds.MD.BID())
 	pushErr := mdServer.Put(ctx, rmds, extra)
@@ -1529,13 +1943,19 @@ func (j *tlfJournal) getByteCounts() (
 		j.blockJournal.getUnflushedBytes(), nil
 }
 
-func (j *tlfJournal) shutdown(ctx context.Context) {
+// stopBackgroundWork makes the background work goroutine exit, and
+// waits for it to do so.  It can't be restarted afterwards.
+func (j *tlfJournal) stopBackgroundWork() {
 	select {
 	case j.needShutdownCh <- struct{}{}:
 	default:
 	}
 
 	<-j.backgroundShutdownCh
+}
+
+func (j *tlfJournal) shutdown(ctx context.Context) {
+	j.stopBackgroundWork()
 
 	j.journalLock.Lock()
 	defer j.journalLock.Unlock()
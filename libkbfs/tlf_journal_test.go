@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"math"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
@@ -86,6 +87,9 @@ type testTLFJournalConfig struct {
 	nug          normalizedUsernameGetter
 	mdserver     MDServer
 	dlTimeout    time.Duration
+	dbc          DiskBlockCache
+	cacheFlushed bool
+	bandwidth    *BandwidthLimiter
 }
 
 func (c testTLFJournalConfig) BlockSplitter() BlockSplitter {
@@ -144,6 +148,18 @@ func (c testTLFJournalConfig) diskLimitTimeout() time.Duration {
 	return c.dlTimeout
 }
 
+func (c testTLFJournalConfig) DiskBlockCache() DiskBlockCache {
+	return c.dbc
+}
+
+func (c testTLFJournalConfig) CacheFlushedJournalBlocks() bool {
+	return c.cacheFlushed
+}
+
+func (c testTLFJournalConfig) BandwidthLimiter() *BandwidthLimiter {
+	return c.bandwidth
+}
+
 func (c testTLFJournalConfig) makeBlock(data []byte) (
 	kbfsblock.ID, kbfsblock.Context, kbfscrypto.BlockCryptKeyServerHalf) {
 	id, err := kbfsblock.MakePermanentID(data)
@@ -220,6 +236,7 @@ func setupTLFJournalTest(
 		newTestCodecGetter(), newTestLogMaker(t), t, tlf.FakeID(1, false), bsplitter, crypto,
 		nil, nil, NewMDCacheStandard(10), ver,
 		NewReporterSimple(newTestClockNow(), 10), uid, verifyingKey, ekg, nil, mdserver, defaultDiskLimitMaxDelay + time.Second,
+		nil, false, nil,
 	}
 
 	ctx, cancel = context.WithTimeout(
@@ -258,7 +275,7 @@ func setupTLFJournalTest(
 		math.MaxInt64, math.MaxInt64, math.MaxInt64)
 	tlfJournal, err = makeTLFJournal(ctx, uid, verifyingKey,
 		tempdir, config.tlfID, config, delegateBlockServer,
-		bwStatus, delegate, nil, nil, diskLimitSemaphore)
+		bwStatus, delegate, nil, nil, diskLimitSemaphore, nil, nil)
 	require.NoError(t, err)
 
 	switch bwStatus {
@@ -411,6 +428,87 @@ func testTLFJournalBlockOpBasic(t *testing.T, ver MetadataVer) {
 	require.False(t, converted)
 }
 
+func testTLFJournalFlushCachesBlocks(t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	dbc, err := newDiskBlockCacheStandardForTest(
+		newTestDiskBlockCacheConfig(t), testDiskBlockCacheMaxBytes, nil)
+	require.NoError(t, err)
+	defer dbc.Shutdown(ctx)
+	config.dbc = dbc
+
+	// Without the option, flushed blocks aren't cached.
+	data1 := []byte{1, 2, 3, 4}
+	id1, bCtx1, serverHalf1 := config.makeBlock(data1)
+	err = tlfJournal.putBlockData(ctx, id1, bCtx1, data1, serverHalf1)
+	require.NoError(t, err)
+	numFlushed, _, _, err :=
+		tlfJournal.flushBlockEntries(ctx, firstValidJournalOrdinal+1)
+	require.NoError(t, err)
+	require.Equal(t, 1, numFlushed)
+	_, _, err = dbc.Get(ctx, config.tlfID, id1)
+	require.IsType(t, NoSuchBlockError{}, err)
+
+	// With it, they are.
+	config.cacheFlushed = true
+	data2 := []byte{5, 6, 7, 8}
+	id2, bCtx2, serverHalf2 := config.makeBlock(data2)
+	err = tlfJournal.putBlockData(ctx, id2, bCtx2, data2, serverHalf2)
+	require.NoError(t, err)
+	blockEnd, _, err := tlfJournal.getJournalEnds(ctx)
+	require.NoError(t, err)
+	numFlushed, _, _, err = tlfJournal.flushBlockEntries(ctx, blockEnd)
+	require.NoError(t, err)
+	require.Equal(t, 1, numFlushed)
+	buf, serverHalf, err := dbc.Get(ctx, config.tlfID, id2)
+	require.NoError(t, err)
+	require.Equal(t, data2, buf)
+	require.Equal(t, serverHalf2, serverHalf)
+}
+
+func testTLFJournalFlushMetered(t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkEnabled)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	config.bandwidth = newBandwidthLimiter(config)
+	config.bandwidth.SetMetered(true)
+
+	// The background work waits for the connection to be unmetered.
+	data := []byte{1, 2, 3, 4}
+	id, bCtx, serverHalf := config.makeBlock(data)
+	err := tlfJournal.putBlockData(ctx, id, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	delegate.requireNextState(ctx, bwBusy)
+	blockEntries, _, err := tlfJournal.getJournalEntryCounts()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), blockEntries)
+
+	// A background flush gives up right away instead of waiting for
+	// the window while holding the flush locks, and doesn't record
+	// an error.
+	err = tlfJournal.flush(context.WithValue(ctx, ctxBackgroundFlushKey, true))
+	require.IsType(t, errFlushWindowClosed{}, errors.Cause(err))
+	status, err := tlfJournal.getJournalStatus()
+	require.NoError(t, err)
+	require.Equal(t, "", status.LastFlushErr)
+
+	// An explicit flush still goes through.
+	err = tlfJournal.flush(ctx)
+	require.NoError(t, err)
+	blockEntries, _, err = tlfJournal.getJournalEntryCounts()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), blockEntries)
+
+	// Once unmetered, the background work finishes.
+	config.bandwidth.SetMetered(false)
+	delegate.requireNextState(ctx, bwIdle)
+}
+
 func testTLFJournalBlockOpBusyPause(t *testing.T, ver MetadataVer) {
 	tempdir, config, ctx, cancel, tlfJournal, delegate :=
 		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkEnabled)
@@ -1636,6 +1734,39 @@ func testTLFJournalSquashByBytes(t *testing.T, ver MetadataVer) {
 		t, PendingLocalSquashBranchID, tlfJournal.mdJournal.getBranchID())
 }
 
+func testTLFJournalSquashRequested(t *testing.T, ver MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	firstRevision := MetadataRevision(10)
+	firstPrevRoot := fakeMdID(1)
+	mdCount := 3
+
+	prevRoot := firstPrevRoot
+	for i := 0; i < mdCount; i++ {
+		revision := firstRevision + MetadataRevision(i)
+		md := config.makeMD(revision, prevRoot)
+		mdID, err := tlfJournal.putMD(ctx, md)
+		require.NoError(t, err)
+		prevRoot = mdID
+	}
+
+	// This is under both thresholds, but the squash was asked for,
+	// so flushing should convert it to a branch anyway.
+	err := tlfJournal.requestSquash(ctx)
+	require.NoError(t, err)
+	err = tlfJournal.flush(ctx)
+	require.NoError(t, err)
+	require.Equal(
+		t, PendingLocalSquashBranchID, tlfJournal.mdJournal.getBranchID())
+	requireJournalEntryCounts(t, tlfJournal, uint64(mdCount), uint64(mdCount))
+	tlfJournal.journalLock.RLock()
+	require.False(t, tlfJournal.squashRequested)
+	tlfJournal.journalLock.RUnlock()
+}
+
 // Test that the first revision of a TLF doesn't get squashed.
 func testTLFJournalFirstRevNoSquash(t *testing.T, ver MetadataVer) {
 	tempdir, config, ctx, cancel, tlfJournal, delegate :=
@@ -1690,6 +1821,8 @@ func TestTLFJournal(t *testing.T) {
 		testTLFJournalPauseResume,
 		testTLFJournalPauseShutdown,
 		testTLFJournalBlockOpBasic,
+		testTLFJournalFlushCachesBlocks,
+		testTLFJournalFlushMetered,
 		testTLFJournalBlockOpBusyPause,
 		testTLFJournalBlockOpBusyShutdown,
 		testTLFJournalSecondBlockOpWhileBusy,
@@ -1714,7 +1847,107 @@ func TestTLFJournal(t *testing.T) {
 		testTLFJournalFlushRetry,
 		testTLFJournalResolveBranch,
 		testTLFJournalSquashByBytes,
+		testTLFJournalSquashRequested,
 		testTLFJournalFirstRevNoSquash,
 	}
 	runTestsOverMetadataVers(t, "testTLFJournal", tests)
 }
+
+// TestRepairTLFJournalQuarantinesMDsWithLostBlocks checks that when
+// a torn block journal entry is repaired, the MDs whose blocks may
+// have been lost with it are quarantined too.
+func TestRepairTLFJournalQuarantinesMDsWithLostBlocks(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "tlf_journal")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+
+	codec := kbfscodec.NewMsgpack()
+	makeJournals := func() (blockJournal, mdJournal *diskJournal) {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+		blockJournal, err = makeDiskJournal(codec, blockJournalDir(tempdir),
+			reflect.TypeOf(blockJournalEntry{}))
+		require.NoError(t, err)
+		mdJournal, err = makeDiskJournal(codec, mdJournalPath(tempdir),
+			reflect.TypeOf(mdIDJournalEntry{}))
+		require.NoError(t, err)
+
+		// Each MD has a block, followed by its revision marker.
+		for rev := MetadataRevisionInitial; rev <= 3; rev++ {
+			_, err := blockJournal.appendJournalEntry(
+				nil, blockJournalEntry{Op: blockPutOp})
+			require.NoError(t, err)
+			_, err = blockJournal.appendJournalEntry(nil,
+				blockJournalEntry{Op: mdRevMarkerOp, Revision: rev})
+			require.NoError(t, err)
+			o, err := revisionToOrdinal(rev)
+			require.NoError(t, err)
+			_, err = mdJournal.appendJournalEntry(
+				&o, mdIDJournalEntry{ID: fakeMdID(byte(rev))})
+			require.NoError(t, err)
+		}
+		return blockJournal, mdJournal
+	}
+	tearEntry := func(j *diskJournal, o journalOrdinal) {
+		p := j.journalEntryPath(o)
+		buf, err := ioutil.ReadFile(p)
+		require.NoError(t, err)
+		err = ioutil.WriteFile(p, buf[:len(buf)/2], 0600)
+		require.NoError(t, err)
+	}
+	reopen := func() (blockCount, mdCount uint64) {
+		blockJournal, err := makeDiskJournal(codec,
+			blockJournalDir(tempdir), reflect.TypeOf(blockJournalEntry{}))
+		require.NoError(t, err)
+		mdJournal, err := makeMdIDJournal(codec, mdJournalPath(tempdir))
+		require.NoError(t, err)
+		return blockJournal.length(), mdJournal.length()
+	}
+
+	// A torn entry after the last marker doesn't affect the MDs.
+	blockJournal, _ := makeJournals()
+	_, err = blockJournal.appendJournalEntry(
+		nil, blockJournalEntry{Op: blockPutOp})
+	require.NoError(t, err)
+	tearEntry(blockJournal, blockJournal.latest)
+	repairs, quarantineDir, err := repairTLFJournal(
+		codec, wallClock{}, tempdir)
+	require.NoError(t, err)
+	require.Len(t, repairs, 1)
+	require.NotEqual(t, "", quarantineDir)
+	blockCount, mdCount := reopen()
+	require.Equal(t, uint64(6), blockCount)
+	require.Equal(t, uint64(3), mdCount)
+
+	// Tearing the block of revision 2 loses the MDs from revision
+	// 2 on.
+	blockJournal, mdJournal := makeJournals()
+	tearEntry(blockJournal, blockJournal.earliest+2)
+	repairs, quarantineDir, err = repairTLFJournal(
+		codec, wallClock{}, tempdir)
+	require.NoError(t, err)
+	require.Len(t, repairs, 2)
+	require.Equal(t, mdJournalPath(tempdir), repairs[1].Dir)
+	require.Equal(t, []journalOrdinal{mdJournal.latest - 1, mdJournal.latest},
+		repairs[1].Quarantined)
+	blockCount, mdCount = reopen()
+	require.Equal(t, uint64(2), blockCount)
+	require.Equal(t, uint64(1), mdCount)
+	_, err = ioutil.Stat(filepath.Join(quarantineDir,
+		filepath.Base(mdJournalPath(tempdir)), mdJournal.latest.String()))
+	require.NoError(t, err)
+
+	// If no marker is left, the MDs from the first quarantined
+	// marker on are lost.
+	blockJournal, _ = makeJournals()
+	tearEntry(blockJournal, blockJournal.earliest)
+	repairs, _, err = repairTLFJournal(codec, wallClock{}, tempdir)
+	require.NoError(t, err)
+	require.Len(t, repairs, 2)
+	blockCount, mdCount = reopen()
+	require.Equal(t, uint64(0), blockCount)
+	require.Equal(t, uint64(0), mdCount)
+}
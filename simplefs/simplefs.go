@@ -11,6 +11,7 @@ import (
 	stdpath "path"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"golang.org/x/net/context"
 
@@ -215,6 +216,11 @@ func (k *SimpleFS) SimpleFSCopy(ctx context.Context, arg keybase1.SimpleFSCopyAr
 func (k *SimpleFS) doCopy(ctx context.Context, srcPath, destPath keybase1.Path) error {
 	// Note this is also used by move, so if this changes update SimpleFSMove
 	// code also.
+	copied, err := k.copyFileByReference(ctx, srcPath, destPath)
+	if copied || err != nil {
+		return err
+	}
+
 	src, err := k.pathIO(ctx, srcPath, keybase1.OpenFlags_READ|keybase1.OpenFlags_EXISTING, nil)
 	if err != nil {
 		return err
@@ -252,6 +258,107 @@ func copyWithCancellation(ctx context.Context, dst io.Writer, src io.Reader) err
 	}
 }
 
+// copyFileByReference copies a file from srcPath to destPath within
+// the same KBFS folder by sharing its blocks, without reading or
+// re-uploading any data.  It returns false (and no error) if the
+// paths don't qualify, in which case the caller should fall back to
+// a byte-by-byte copy.  The copy is made under a temporary name and
+// then renamed over destPath, so an existing destination is only
+// replaced once the copy is complete.  Copies through a FUSE mount,
+// with FICLONE or copy_file_range, don't share blocks; see the
+// comment in libfuse/file.go for why.
+func (k *SimpleFS) copyFileByReference(
+	ctx context.Context, srcPath, destPath keybase1.Path) (bool, error) {
+	spt, err := srcPath.PathType()
+	if err != nil {
+		return false, err
+	}
+	dpt, err := destPath.PathType()
+	if err != nil {
+		return false, err
+	}
+	if spt != keybase1.PathType_KBFS || dpt != keybase1.PathType_KBFS {
+		return false, nil
+	}
+
+	snode, sleaf, err := k.getRemoteNodeParent(ctx, srcPath)
+	if err != nil {
+		return false, err
+	}
+	src, ei, err := k.config.KBFSOps().Lookup(ctx, snode, sleaf)
+	if err != nil {
+		return false, err
+	}
+	if ei.Type != libkbfs.File && ei.Type != libkbfs.Exec {
+		return false, nil
+	}
+
+	dnode, dleaf, err := k.getRemoteNodeParent(ctx, destPath)
+	if err != nil {
+		return false, err
+	}
+	if src.GetFolderBranch() != dnode.GetFolderBranch() {
+		return false, nil
+	}
+
+	// Keep the REPLACE semantics of the regular copy.
+	dest, dei, err := k.config.KBFSOps().Lookup(ctx, dnode, dleaf)
+	switch err.(type) {
+	case nil:
+		if dest.GetID() == src.GetID() {
+			return false, errCopyToSelf
+		}
+		if dei.Type == libkbfs.Dir {
+			return false, nil
+		}
+	case libkbfs.NoSuchNameError:
+	default:
+		return false, err
+	}
+
+	tmpName, err := copyTempName(dleaf, k.config.MaxNameBytes())
+	if err != nil {
+		return false, err
+	}
+	_, _, err = k.config.KBFSOps().CopyFile(ctx, src, dnode, tmpName)
+	if err != nil {
+		return false, err
+	}
+	err = k.config.KBFSOps().Rename(ctx, dnode, tmpName, dnode, dleaf)
+	if err != nil {
+		rmErr := k.config.KBFSOps().RemoveEntry(ctx, dnode, tmpName)
+		if rmErr != nil {
+			k.log.CDebugf(ctx, "Couldn't remove temporary copy %q: %v",
+				tmpName, rmErr)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// copyTempName returns a random hidden name to copy a file to, before
+// renaming it to the given name.  The name is shortened as needed to
+// keep the result within maxNameBytes.
+func copyTempName(name string, maxNameBytes uint32) (string, error) {
+	id, err := libkbfs.MakeRandomRequestID()
+	if err != nil {
+		return "", err
+	}
+	suffix := ".copy-" + id
+	keep := int(maxNameBytes) - len(".") - len(suffix)
+	if keep < 0 {
+		keep = 0
+	}
+	if len(name) > keep {
+		// Don't cut a multi-byte character in half.
+		for keep > 0 && !utf8.RuneStart(name[keep]) {
+			keep--
+		}
+		name = name[:keep]
+	}
+	return "." + name + suffix, nil
+}
+
 type pathPair struct {
 	src, dest keybase1.Path
 }
@@ -273,6 +380,12 @@ func (k *SimpleFS) SimpleFSCopyRecursive(ctx context.Context,
 					path := paths[len(paths)-1]
 					paths = paths[:len(paths)-1]
 
+					copied, err := k.copyFileByReference(
+						ctx, path.src, path.dest)
+					if copied || err != nil {
+						return err
+					}
+
 					src, err := k.pathIO(ctx, path.src, keybase1.OpenFlags_READ|keybase1.OpenFlags_EXISTING, nil)
 					if err != nil {
 						return err
@@ -967,6 +1080,7 @@ var errOnlyRemotePathSupported = simpleFSError{"Only remote paths are supported
 var errInvalidRemotePath = simpleFSError{"Invalid remote path"}
 var errNoSuchHandle = simpleFSError{"No such handle"}
 var errNoResult = simpleFSError{"Async result not found"}
+var errCopyToSelf = simpleFSError{"Source and destination are the same file"}
 
 // simpleFSError wraps errors for SimpleFS
 type simpleFSError struct {
@@ -10,7 +10,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -246,6 +248,91 @@ func TestCopyToRemote(t *testing.T) {
 		string(readRemoteFile(ctx, t, sfs, pathAppend(path2, "test1.txt"))))
 }
 
+func TestCopyRemoteToRemote(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	srcPath := pathAppend(path, "test1.txt")
+	destPath := pathAppend(path, "test2.txt")
+	writeRemoteFile(ctx, t, sfs, srcPath, []byte("foo"))
+	writeRemoteFile(ctx, t, sfs, destPath, []byte("overwritten"))
+
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSCopy(ctx, keybase1.SimpleFSCopyArg{
+		OpID: opid,
+		Src:  srcPath,
+		Dest: destPath,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+
+	require.Equal(t, `foo`, string(readRemoteFile(ctx, t, sfs, destPath)))
+
+	// Changing the copy must leave the original alone.
+	writeRemoteFile(ctx, t, sfs, destPath, []byte("bar"))
+	require.Equal(t, `foo`, string(readRemoteFile(ctx, t, sfs, srcPath)))
+	require.Equal(t, `bar`, string(readRemoteFile(ctx, t, sfs, destPath)))
+
+	// Copying a file onto itself must fail, and leave it alone.
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSCopy(ctx, keybase1.SimpleFSCopyArg{
+		OpID: opid,
+		Src:  srcPath,
+		Dest: srcPath,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.Equal(t, errCopyToSelf, err)
+	require.Equal(t, `foo`, string(readRemoteFile(ctx, t, sfs, srcPath)))
+
+	// No temporary copies are left behind.
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSList(ctx, keybase1.SimpleFSListArg{
+		OpID: opid,
+		Path: path,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	listResult, err := sfs.SimpleFSReadList(ctx, opid)
+	require.NoError(t, err)
+	require.Len(t, listResult.Entries, 2)
+
+	// Names up to the limit can be copied to, even though the
+	// temporary copy's name has extra bytes.
+	longPath := pathAppend(path, strings.Repeat(
+		"x", int(sfs.config.MaxNameBytes())))
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSCopy(ctx, keybase1.SimpleFSCopyArg{
+		OpID: opid,
+		Src:  srcPath,
+		Dest: longPath,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	require.Equal(t, `foo`, string(readRemoteFile(ctx, t, sfs, longPath)))
+}
+
+func TestCopyTempName(t *testing.T) {
+	name, err := copyTempName("a", 255)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(name, ".a.copy-"), name)
+
+	// Long names are shortened to fit, without splitting characters.
+	name, err = copyTempName(strings.Repeat("\u00e9", 200), 255)
+	require.NoError(t, err)
+	require.True(t, len(name) <= 255, name)
+	require.True(t, utf8.ValidString(name), name)
+}
+
 func writeRemoteFile(ctx context.Context, t *testing.T, sfs *SimpleFS, path keybase1.Path, data []byte) {
 	opid, err := sfs.SimpleFSMakeOpid(ctx)
 	require.NoError(t, err)
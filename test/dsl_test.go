@@ -826,6 +826,12 @@ func flushJournal() fileOp {
 	}, IsInit, "flushJournal()"}
 }
 
+func squashJournal() fileOp {
+	return fileOp{func(c *ctx) error {
+		return c.engine.SquashJournal(c.user, c.tlfName, c.tlfIsPublic)
+	}, IsInit, "squashJournal()"}
+}
+
 func checkUnflushedPaths(expectedPaths []string) fileOp {
 	return fileOp{func(c *ctx) error {
 		paths, err := c.engine.UnflushedPaths(c.user, c.tlfName, c.tlfIsPublic)
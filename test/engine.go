@@ -128,6 +128,9 @@ type Engine interface {
 	// FlushJournal is called by the test harness as the given
 	// user to wait for the journal to flush, if enabled.
 	FlushJournal(u User, tlfName string, isPublic bool) (err error)
+	// SquashJournal is called by the test harness as the given
+	// user to squash the unflushed revisions in the journal.
+	SquashJournal(u User, tlfName string, isPublic bool) (err error)
 	// UnflushedPaths called by the test harness to find out which
 	// paths haven't yet been flushed from the journal.
 	UnflushedPaths(u User, tlfName string, isPublic bool) (
@@ -373,6 +373,17 @@ func (*fsEngine) FlushJournal(user User, tlfName string,
 		[]byte("on"), 0644)
 }
 
+// SquashJournal is called by the test harness as the given user to
+// squash the unflushed revisions in the journal.
+func (*fsEngine) SquashJournal(user User, tlfName string,
+	isPublic bool) (err error) {
+	u := user.(*fsUser)
+	path := buildTlfPath(u, tlfName, isPublic)
+	return ioutil.WriteFile(
+		filepath.Join(path, libfs.SquashJournalFileName),
+		[]byte("on"), 0644)
+}
+
 // UnflushedPaths implements the Engine interface.
 func (*fsEngine) UnflushedPaths(user User, tlfName string, isPublic bool) (
 	[]string, error) {
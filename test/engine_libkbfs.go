@@ -682,6 +682,25 @@ func (k *LibKBFS) FlushJournal(u User, tlfName string, isPublic bool) error {
 	return jServer.Flush(ctx, dir.GetFolderBranch().Tlf)
 }
 
+// SquashJournal implements the Engine interface.
+func (k *LibKBFS) SquashJournal(u User, tlfName string, isPublic bool) error {
+	config := u.(*libkbfs.ConfigLocal)
+
+	ctx, cancel := k.newContext(u)
+	defer cancel()
+	dir, err := getRootNode(ctx, config, tlfName, isPublic)
+	if err != nil {
+		return err
+	}
+
+	jServer, err := libkbfs.GetJournalServer(config)
+	if err != nil {
+		return err
+	}
+
+	return jServer.Squash(ctx, dir.GetFolderBranch().Tlf)
+}
+
 // UnflushedPaths implements the Engine interface.
 func (k *LibKBFS) UnflushedPaths(u User, tlfName string, isPublic bool) (
 	[]string, error) {
@@ -427,6 +427,53 @@ func TestJournalCoalescingBasicCreates(t *testing.T) {
 	)
 }
 
+// bob creates a few files in a journal, not enough to cross the squash
+// threshold, and asks for the operations to be coalesced anyway.
+func TestJournalCoalescingRequested(t *testing.T) {
+	var busyWork []fileOp
+	var reads []fileOp
+	listing := m{"^a$": "DIR"}
+	iters := 3
+	unflushedPaths := []string{"alice,bob"}
+	for i := 0; i < iters; i++ {
+		name := fmt.Sprintf("a%d", i)
+		contents := fmt.Sprintf("hello%d", i)
+		busyWork = append(busyWork, mkfile(name, contents))
+		reads = append(reads, read(name, contents))
+		listing["^"+name+"$"] = "FILE"
+		unflushedPaths = append(unflushedPaths, "alice,bob/"+name)
+	}
+
+	test(t, journal(),
+		users("alice", "bob"),
+		as(alice,
+			mkdir("a"),
+		),
+		as(bob,
+			enableJournal(),
+			checkUnflushedPaths(nil),
+			pauseJournal(),
+		),
+		as(bob, busyWork...),
+		as(bob,
+			checkUnflushedPaths(unflushedPaths),
+			squashJournal(),
+			resumeJournal(),
+			// This should kick off conflict resolution.
+			flushJournal(),
+		),
+		as(bob,
+			lsdir("", listing),
+			checkUnflushedPaths(nil),
+		),
+		as(bob, reads...),
+		as(alice,
+			lsdir("", listing),
+		),
+		as(alice, reads...),
+	)
+}
+
 // bob creates a bunch of files in a journal and the operations get
 // coalesced together, multiple times.  Then alice writes something
 // non-conflicting, forcing CR to happen on top of the unmerged local